@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"github.com/pterodactyl/sftp-server/src/server"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// Runs the SFTP server until it is stopped.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	o := registerOptions(fs)
+	fs.Parse(args)
+
+	c, err := loadConfiguration(fs, o)
+	if err != nil {
+		logger.Get().Fatalw("invalid configuration", zap.Error(err))
+	}
+
+	watchLogLevelSignals()
+
+	s := server.New(c)
+	if err := s.Start(); err != nil {
+		logger.Get().Fatalw("could not start SFTP server", zap.Error(err))
+	}
+
+	watchRestartSignal(s)
+	s.Wait()
+}
+
+// Generates a new host key for the server, printing the path it was written to along with its
+// fingerprint so that it can be shared with users ahead of time.
+func runKeygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	configPath := fs.String("config-path", "./config/core.json", "the location of your Daemon configuration file")
+	keyType := fs.String("type", "ed25519", "the type of key to generate, one of ed25519, ecdsa or rsa")
+	fs.Parse(args)
+
+	p, err := server.GenerateHostKey(filepath.Dir(*configPath), *keyType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not generate host key: %s\n", err)
+		os.Exit(1)
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read host key: %s\n", err)
+		os.Exit(1)
+	}
+
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not parse host key: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("wrote %s host key to %s\n", *keyType, p)
+	fmt.Println(ssh.FingerprintSHA256(signer.PublicKey()))
+}
+
+// Validates the configuration, printing the settings the server would run with. Secrets are
+// never printed, only whether or not they are set.
+func runConfig(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	o := registerOptions(fs)
+	fs.Parse(args)
+
+	c, err := loadConfiguration(fs, o)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %s\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "User\t%d:%d\n", c.User.Uid, c.User.Gid)
+
+	v := reflect.ValueOf(c.Settings)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		value := formatSetting(v.Field(i).Interface())
+		if v.Field(i).Kind() == reflect.String && (strings.Contains(name, "Token") || strings.Contains(name, "Secret") || strings.Contains(name, "DSN")) {
+			if value != "" {
+				value = "(set)"
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\n", name, value)
+	}
+
+	w.Flush()
+}
+
+// Formats the value of a setting for display.
+func formatSetting(value interface{}) string {
+	switch v := value.(type) {
+	case os.FileMode:
+		if v == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%#o", uint32(v))
+	case time.Duration:
+		if v == 0 {
+			return ""
+		}
+		return v.String()
+	case *tls.Config:
+		if v == nil {
+			return ""
+		}
+		return "(set)"
+	case *template.Template:
+		if v == nil {
+			return ""
+		}
+		return "(set)"
+	case []ssh.PublicKey:
+		if len(v) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%d keys", len(v))
+	case []server.PanelRoute:
+		// The routes include the token for each Panel, so only their names are shown.
+		var names []string
+		for _, r := range v {
+			names = append(names, r.Name)
+		}
+		return strings.Join(names, ", ")
+	case []*net.IPNet:
+		var networks []string
+		for _, n := range v {
+			networks = append(networks, n.String())
+		}
+		return strings.Join(networks, ", ")
+	case []string:
+		return strings.Join(v, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Runs a series of checks against the configuration and the node, printing the result of each
+// and exiting with an error if any of them failed.
+func runDiagnose(args []string) {
+	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	o := registerOptions(fs)
+	fs.Parse(args)
+
+	c, err := loadConfiguration(fs, o)
+	if err != nil {
+		fmt.Printf("FAIL  configuration: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("ok    configuration")
+
+	failed := false
+	for _, d := range server.New(c).Diagnose() {
+		if d.Err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %s\n", d.Name, d.Err)
+			if d.Hint != "" {
+				fmt.Printf("      %s\n", d.Hint)
+			}
+			continue
+		}
+
+		if d.Detail != "" {
+			fmt.Printf("ok    %s: %s\n", d.Name, d.Detail)
+		} else {
+			fmt.Printf("ok    %s\n", d.Name)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
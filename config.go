@@ -0,0 +1,567 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/patrickmn/go-cache"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"github.com/pterodactyl/sftp-server/src/server"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// The flags shared by every command that loads the Daemon configuration.
+type options struct {
+	configPath       string
+	bindPort         int
+	bindAddress      string
+	readOnly         bool
+	debug            bool
+	disableDiskCheck bool
+	dev              bool
+	devDirectory     string
+}
+
+// Registers the flags shared by the commands that load the configuration on the flag set.
+func registerOptions(fs *flag.FlagSet) *options {
+	o := &options{}
+
+	fs.StringVar(&o.configPath, "config-path", "./config/core.json", "the location of your Daemon configuration file")
+	fs.IntVar(&o.bindPort, "port", 2022, "the port this server should bind to")
+	fs.StringVar(&o.bindAddress, "bind-addr", "0.0.0.0", "the address this server should bind to")
+	fs.BoolVar(&o.readOnly, "readonly", false, "determines if this server should run in read-only mode")
+	fs.BoolVar(&o.disableDiskCheck, "disable-disk-check", false, "determines if disk space checking should be disabled")
+	fs.BoolVar(&o.debug, "debug", false, "determines if the server should output debug information")
+	fs.BoolVar(&o.dev, "dev", false, "accepts the dev user and password without a Panel, serving the dev directory with every permission")
+	fs.StringVar(&o.devDirectory, "dev-directory", "./dev-data", "the directory served in development mode")
+
+	return o
+}
+
+// Reads the Daemon configuration from the path passed on the command line, and configures the
+// logger using the log file settings it defines.
+func loadConfiguration(fs *flag.FlagSet, o *options) (server.Configuration, error) {
+	logger.Initialize(o.debug)
+
+	logger.Get().Infow("reading configuration from path", zap.String("config-path", o.configPath))
+
+	// Development mode doesn't need a Daemon configuration, since nothing is read from it that
+	// can't be defaulted.
+	var config []byte
+	if _, err := os.Stat(o.configPath); o.dev && os.IsNotExist(err) {
+		logger.Get().Infow("no configuration file found, using the defaults for development mode")
+		config = []byte("{}")
+	} else if config, err = readConfiguration(o.configPath); err != nil {
+		return server.Configuration{}, fmt.Errorf("could not read configuration: %w", err)
+	}
+
+	// Now that the configuration is available, replace the logger with one using the log file
+	// settings it defines.
+	if err := logger.Configure(o.debug, logSettings(config)); err != nil {
+		return server.Configuration{}, fmt.Errorf("could not configure logger: %w", err)
+	}
+
+	return buildConfiguration(config, o, func(name string) bool {
+		return isFlagPassed(fs, name)
+	})
+}
+
+// Builds the configuration for the server from the Daemon configuration and the flags passed on
+// the command line, returning an error if any of the settings are invalid.
+func buildConfiguration(config []byte, o *options, passed func(string) bool) (server.Configuration, error) {
+	username, err := jsonparser.GetString(config, "docker", "container", "username")
+	if err != nil {
+		logger.Get().Debugw("could not find sftp user definition, falling back to \"pterodactyl\"", zap.Error(err))
+		username = "pterodactyl"
+	}
+
+	// Files on Windows are always owned by the user running the server, so there is no need
+	// to look up the daemon user there.
+	var uid, gid int
+	if runtime.GOOS != "windows" && o.dev {
+		// Files created in development mode belong to whoever is running the server, since the
+		// daemon user is unlikely to exist on the machine.
+		uid, gid = os.Getuid(), os.Getgid()
+	} else if runtime.GOOS != "windows" {
+		logger.Get().Infow("using system daemon user", zap.String("username", username))
+
+		u, err := user.Lookup(username)
+		if err != nil {
+			return server.Configuration{}, fmt.Errorf("failed to lookup sftp user: %w", err)
+		}
+
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+
+	var devDirectory string
+	if o.dev {
+		// Development mode accepts a fixed password, so it only listens on the loopback
+		// interface unless another address is passed or configured.
+		if !passed("bind-addr") {
+			o.bindAddress = "127.0.0.1"
+		}
+
+		// There is no server configuration for the dev directory to read a disk limit from.
+		o.disableDiskCheck = true
+
+		if devDirectory, err = filepath.Abs(o.devDirectory); err == nil {
+			err = os.MkdirAll(devDirectory, 0755)
+		}
+		if err != nil {
+			return server.Configuration{}, fmt.Errorf("could not create development directory: %w", err)
+		}
+
+		logger.Get().Warnw("running in development mode, logins are not validated against the panel",
+			zap.String("user", server.DevUser), zap.String("password", server.DevPassword), zap.String("directory", devDirectory))
+	}
+
+	// default to config port if the sftp was not passed.
+	if !passed("port") {
+		// get port form config
+		confPort, err := jsonparser.GetInt(config, "sftp", "port")
+		if err != nil {
+			// default to the o.bindPort default from the flag section
+			logger.Get().Debugw("could not find sftp port, falling back to \"2022\"", zap.Error(err))
+		} else {
+			// set o.bindPort to the confPort value
+			logger.Get().Infow("using config daemon port", zap.Int("port", int(confPort)))
+			o.bindPort = int(confPort)
+		}
+	}
+
+	// A list of addresses to listen on can be provided in place of the port, unless an address
+	// or port was explicitly passed on the command line.
+	var bindAddresses []string
+	if !passed("port") && !passed("bind-addr") {
+		jsonparser.ArrayEach(config, func(value []byte, t jsonparser.ValueType, _ int, _ error) {
+			if t == jsonparser.String {
+				bindAddresses = append(bindAddresses, string(value))
+			}
+		}, "sftp", "addresses")
+	}
+
+	unixSocket, _ := jsonparser.GetString(config, "sftp", "unix_socket", "path")
+	unixSocketMode, _ := jsonparser.GetString(config, "sftp", "unix_socket", "mode")
+
+	// Limits on the number of simultaneous connections are optional, anything less than one
+	// means there is no limit enforced.
+	maxPerIP, _ := jsonparser.GetInt(config, "sftp", "limits", "connections_per_ip")
+	maxPerUser, _ := jsonparser.GetInt(config, "sftp", "limits", "sessions_per_user")
+	idleTimeout, _ := jsonparser.GetInt(config, "sftp", "limits", "idle_timeout")
+	sessionLifetime, _ := jsonparser.GetInt(config, "sftp", "limits", "session_lifetime")
+	drainTimeout, err := jsonparser.GetInt(config, "sftp", "limits", "drain_timeout")
+	if err != nil {
+		drainTimeout = 3600
+	}
+	maxSessions, _ := jsonparser.GetInt(config, "sftp", "limits", "max_sessions")
+	queueTimeout, _ := jsonparser.GetInt(config, "sftp", "limits", "queue_timeout")
+	maxFileSize, _ := jsonparser.GetInt(config, "sftp", "limits", "max_file_size")
+	maxFiles, _ := jsonparser.GetInt(config, "sftp", "limits", "max_files")
+	maxOpenFiles, _ := jsonparser.GetInt(config, "sftp", "limits", "open_files")
+	sessionMemory, _ := jsonparser.GetInt(config, "sftp", "limits", "session_memory")
+
+	var protectedPaths []string
+	jsonparser.ArrayEach(config, func(value []byte, t jsonparser.ValueType, _ int, _ error) {
+		if t == jsonparser.String {
+			protectedPaths = append(protectedPaths, string(value))
+		}
+	}, "sftp", "protected_paths")
+
+	blockedFiles := readStrings(config, "sftp", "blocked_files")
+	managedFiles, _ := jsonparser.GetBoolean(config, "sftp", "managed_files")
+	permissionsRefresh, _ := jsonparser.GetInt(config, "sftp", "permissions_refresh")
+	permissionsTTL, _ := jsonparser.GetInt(config, "sftp", "permissions_ttl")
+
+	dropPrivileges, _ := jsonparser.GetBoolean(config, "sftp", "drop_privileges")
+
+	sandbox, _ := jsonparser.GetBoolean(config, "sftp", "sandbox", "enabled")
+	sandboxRead := readStrings(config, "sftp", "sandbox", "read_paths")
+	sandboxWrite := readStrings(config, "sftp", "sandbox", "write_paths")
+
+	// Log files are rotated by creating new ones next to the current file, so the directory
+	// they are in always needs to be writable from inside of the sandbox.
+	if p := logSettings(config).Path; p != "" {
+		sandboxWrite = append(sandboxWrite, filepath.Dir(p))
+	}
+
+	hideFiles, _ := jsonparser.GetString(config, "sftp", "hide_files")
+	switch hideFiles {
+	case "":
+		hideFiles = server.HideNone
+	case server.HideNone, server.HideInternal, server.HideDotfiles:
+	default:
+		return server.Configuration{}, fmt.Errorf("invalid hide_files mode %q", hideFiles)
+	}
+
+	fileMode, _ := jsonparser.GetString(config, "sftp", "file_mode")
+	directoryMode, _ := jsonparser.GetString(config, "sftp", "directory_mode")
+
+	atomicUploads, _ := jsonparser.GetBoolean(config, "sftp", "atomic_uploads")
+
+	transferBuffer, _ := jsonparser.GetInt(config, "sftp", "transfers", "buffer_size")
+	readAhead, _ := jsonparser.GetInt(config, "sftp", "transfers", "read_ahead")
+	if transferBuffer < 0 || transferBuffer > 16*1024*1024 {
+		return server.Configuration{}, fmt.Errorf("invalid transfer buffer size %d", transferBuffer)
+	}
+
+	partialUploads, _ := jsonparser.GetBoolean(config, "sftp", "partial_uploads", "enabled")
+	partialMaxAge, err := jsonparser.GetInt(config, "sftp", "partial_uploads", "max_age_hours")
+	if err != nil {
+		partialMaxAge = 24
+	}
+
+	trashEnabled, _ := jsonparser.GetBoolean(config, "sftp", "trash", "enabled")
+	trashRetention, err := jsonparser.GetInt(config, "sftp", "trash", "retention_days")
+	if err != nil {
+		trashRetention = 7
+	}
+
+	// Versioning is disabled unless it is turned on, and defaults to keeping five versions of a
+	// file when the maximum is not provided.
+	var maxVersions int64
+	if enabled, _ := jsonparser.GetBoolean(config, "sftp", "versioning", "enabled"); enabled {
+		if maxVersions, err = jsonparser.GetInt(config, "sftp", "versioning", "max_versions"); err != nil {
+			maxVersions = 5
+		}
+	}
+
+	restrictRecursiveDelete, _ := jsonparser.GetBoolean(config, "sftp", "restrict_recursive_delete")
+	restrictDownloads, _ := jsonparser.GetBoolean(config, "sftp", "restrict_downloads")
+
+	allowedOperations := readStrings(config, "sftp", "operations", "allow")
+	deniedOperations := readStrings(config, "sftp", "operations", "deny")
+	if err := server.ValidateOperations(append(append([]string{}, allowedOperations...), deniedOperations...)); err != nil {
+		return server.Configuration{}, fmt.Errorf("invalid sftp.operations: %w", err)
+	}
+
+	// The names of new files are left as they are sent by the client unless the node rejects or
+	// normalizes names that would break backups and the file manager.
+	fileNamePolicy, _ := jsonparser.GetString(config, "sftp", "file_names", "policy")
+	switch fileNamePolicy {
+	case "":
+		fileNamePolicy = server.FileNamesIgnore
+	case server.FileNamesIgnore, server.FileNamesReject, server.FileNamesNormalize:
+	default:
+		return server.Configuration{}, fmt.Errorf("invalid sftp.file_names.policy %q", fileNamePolicy)
+	}
+
+	adminAddress, _ := jsonparser.GetString(config, "sftp", "admin", "address")
+	adminToken, _ := jsonparser.GetString(config, "sftp", "admin", "token")
+
+	var pprofPort int64
+	if enabled, _ := jsonparser.GetBoolean(config, "sftp", "pprof", "enabled"); enabled {
+		if pprofPort, err = jsonparser.GetInt(config, "sftp", "pprof", "port"); err != nil {
+			pprofPort = 6060
+		}
+	}
+
+	var webhookURLs []string
+	jsonparser.ArrayEach(config, func(value []byte, t jsonparser.ValueType, _ int, _ error) {
+		if t == jsonparser.String {
+			webhookURLs = append(webhookURLs, string(value))
+		}
+	}, "sftp", "webhooks", "urls")
+	webhookSecret, _ := jsonparser.GetString(config, "sftp", "webhooks", "secret")
+
+	panelActivity, _ := jsonparser.GetBoolean(config, "sftp", "panel_activity")
+
+	malwareScanAddress, _ := jsonparser.GetString(config, "sftp", "malware_scan", "address")
+	malwareQuarantine, _ := jsonparser.GetString(config, "sftp", "malware_scan", "quarantine")
+
+	tracingEndpoint, _ := jsonparser.GetString(config, "sftp", "tracing", "endpoint")
+	tracingServiceName, _ := jsonparser.GetString(config, "sftp", "tracing", "service_name")
+	tracingHashPaths, _ := jsonparser.GetBoolean(config, "sftp", "tracing", "hash_paths")
+
+	errorReportingDSN, _ := jsonparser.GetString(config, "sftp", "error_reporting", "sentry_dsn")
+	errorReportingURL, _ := jsonparser.GetString(config, "sftp", "error_reporting", "webhook_url")
+	errorReportingEnvironment, _ := jsonparser.GetString(config, "sftp", "error_reporting", "environment")
+	if errorReportingDSN != "" {
+		if err := server.ValidateSentryDSN(errorReportingDSN); err != nil {
+			return server.Configuration{}, fmt.Errorf("invalid sftp.error_reporting.sentry_dsn: %w", err)
+		}
+	}
+
+	// Statistics are sent every minute once enabled unless a different interval is provided.
+	var statisticsInterval int64
+	if enabled, _ := jsonparser.GetBoolean(config, "sftp", "panel_statistics", "enabled"); enabled {
+		if statisticsInterval, err = jsonparser.GetInt(config, "sftp", "panel_statistics", "interval"); err != nil || statisticsInterval <= 0 {
+			statisticsInterval = 60
+		}
+	}
+
+	proxyProtocol, _ := jsonparser.GetBoolean(config, "sftp", "proxy_protocol", "enabled")
+	var proxyTrusted []*net.IPNet
+	for _, network := range readStrings(config, "sftp", "proxy_protocol", "trusted_networks") {
+		_, n, err := net.ParseCIDR(network)
+		if err != nil {
+			return server.Configuration{}, fmt.Errorf("invalid trusted proxy network %q: %w", network, err)
+		}
+		proxyTrusted = append(proxyTrusted, n)
+	}
+
+	// The system roots are used to verify the Panel certificate unless any of the TLS options
+	// have been provided.
+	caFile, _ := jsonparser.GetString(config, "sftp", "panel", "ca_file")
+	certFile, _ := jsonparser.GetString(config, "sftp", "panel", "cert_file")
+	keyFile, _ := jsonparser.GetString(config, "sftp", "panel", "key_file")
+	insecureSkipVerify, _ := jsonparser.GetBoolean(config, "sftp", "panel", "insecure_skip_verify")
+	panelHandshake, _ := jsonparser.GetBoolean(config, "sftp", "panel", "handshake")
+
+	var panelTLS *tls.Config
+	if caFile != "" || certFile != "" || keyFile != "" || insecureSkipVerify {
+		if panelTLS, err = server.LoadPanelTLS(caFile, certFile, keyFile, insecureSkipVerify); err != nil {
+			return server.Configuration{}, fmt.Errorf("could not configure tls for the panel: %w", err)
+		}
+
+		if insecureSkipVerify {
+			logger.Get().Warn("panel certificate verification is disabled, this should only be used for testing")
+		}
+	}
+
+	// Nodes attached to more than one Panel route the logins for each of the other Panels to it,
+	// by the suffix of the username or the address the user connected to.
+	var panelRoutes []server.PanelRoute
+	jsonparser.ArrayEach(config, func(value []byte, t jsonparser.ValueType, _ int, _ error) {
+		if t != jsonparser.Object {
+			return
+		}
+
+		var r server.PanelRoute
+		r.Name, _ = jsonparser.GetString(value, "name")
+		r.URL, _ = jsonparser.GetString(value, "url")
+		r.Token, _ = jsonparser.GetString(value, "token")
+		r.UserSuffix, _ = jsonparser.GetString(value, "user_suffix")
+		r.Addresses = readStrings(value, "addresses")
+		panelRoutes = append(panelRoutes, r)
+	}, "sftp", "panels")
+	if err := server.ValidatePanelRoutes(panelRoutes); err != nil {
+		return server.Configuration{}, fmt.Errorf("invalid sftp.panels: %w", err)
+	}
+
+	// Standalone mode validates logins against a local file instead of the Panel.
+	standaloneFile, _ := jsonparser.GetString(config, "sftp", "standalone", "file")
+	if standaloneFile != "" {
+		if err := server.ValidateStandaloneFile(standaloneFile); err != nil {
+			return server.Configuration{}, fmt.Errorf("invalid sftp.standalone.file: %w", err)
+		}
+	}
+
+	// Logging in while the Panel is down is disabled unless it is turned on, and defaults to
+	// allowing anyone who logged in within the last 15 minutes when no period is provided.
+	var offlineAuthTTL int64
+	if enabled, _ := jsonparser.GetBoolean(config, "sftp", "offline_auth", "enabled"); enabled {
+		if offlineAuthTTL, err = jsonparser.GetInt(config, "sftp", "offline_auth", "ttl"); err != nil {
+			offlineAuthTTL = 15
+		}
+	}
+
+	banner, _ := jsonparser.GetString(config, "sftp", "banner")
+
+	var motd *template.Template
+	if text, _ := jsonparser.GetString(config, "sftp", "motd"); text != "" {
+		if motd, err = server.ParseMOTD(text); err != nil {
+			return server.Configuration{}, fmt.Errorf("could not parse the message of the day: %w", err)
+		}
+	}
+
+	serverVersion, _ := jsonparser.GetString(config, "sftp", "server_version")
+	if serverVersion, err = server.ParseServerVersion(serverVersion); err != nil {
+		return server.Configuration{}, fmt.Errorf("invalid server version: %w", err)
+	}
+
+	// The ssh package picks which algorithms are allowed unless they have been restricted, or
+	// older ones have been enabled for clients that need them.
+	ciphers := readStrings(config, "sftp", "algorithms", "ciphers")
+	keyExchanges := readStrings(config, "sftp", "algorithms", "key_exchanges")
+	macs := readStrings(config, "sftp", "algorithms", "macs")
+	rekeyThreshold, _ := jsonparser.GetInt(config, "sftp", "algorithms", "rekey_threshold")
+	if rekeyThreshold < 0 {
+		return server.Configuration{}, fmt.Errorf("invalid sftp.algorithms.rekey_threshold %d", rekeyThreshold)
+	}
+	if err := server.ValidateAlgorithms(ciphers, keyExchanges, macs); err != nil {
+		return server.Configuration{}, fmt.Errorf("invalid algorithm configuration: %w", err)
+	}
+
+	hostKeyWatchInterval, _ := jsonparser.GetInt(config, "sftp", "host_keys", "watch_interval")
+	if hostKeyWatchInterval < 0 {
+		return server.Configuration{}, fmt.Errorf("invalid sftp.host_keys.watch_interval %d", hostKeyWatchInterval)
+	}
+
+	// Users can log in with a certificate signed by one of these authorities, such as one issued
+	// by an SSO system, rather than with their password.
+	var certificateAuthorities []ssh.PublicKey
+	if caKeys, _ := jsonparser.GetString(config, "sftp", "certificates", "ca_keys"); caKeys != "" {
+		if certificateAuthorities, err = server.LoadCertificateAuthorities(caKeys); err != nil {
+			return server.Configuration{}, err
+		}
+	}
+
+	loginTokens, _ := jsonparser.GetBoolean(config, "sftp", "login_tokens")
+
+	authLog, _ := jsonparser.GetString(config, "sftp", "auth_log")
+
+	geoIPDatabase, _ := jsonparser.GetString(config, "sftp", "geoip", "database")
+	allowCountries := readStrings(config, "sftp", "geoip", "allow_countries")
+	denyCountries := readStrings(config, "sftp", "geoip", "deny_countries")
+	if geoIPDatabase == "" && (len(allowCountries) > 0 || len(denyCountries) > 0) {
+		return server.Configuration{}, errors.New("a geoip database must be configured to allow or deny countries")
+	}
+
+	// Sessions for a server are disconnected as soon as it is suspended unless configured to
+	// just stop them from making changes instead.
+	suspensionAction, _ := jsonparser.GetString(config, "sftp", "suspension", "action")
+	switch suspensionAction {
+	case "":
+		suspensionAction = server.SuspensionDisconnect
+	case server.SuspensionDisconnect, server.SuspensionReadOnly, server.SuspensionIgnore:
+	default:
+		return server.Configuration{}, fmt.Errorf("invalid suspension action %q", suspensionAction)
+	}
+
+	return server.Configuration{
+		Data:  config,
+		Cache: cache.New(5*time.Minute, 10*time.Minute),
+		User: server.SftpUser{
+			Uid: uid,
+			Gid: gid,
+		},
+		Settings: server.Settings{
+			BasePath:         filepath.Dir(o.configPath),
+			ReadOnly:         o.readOnly,
+			BindAddress:      o.bindAddress,
+			BindPort:         o.bindPort,
+			BindAddresses:    bindAddresses,
+			UnixSocket:       unixSocket,
+			UnixSocketMode:   server.ParseFileMode(unixSocketMode, 0660),
+			ServerDataFolder: filepath.Join(filepath.Dir(o.configPath), "servers"),
+			DisableDiskCheck: o.disableDiskCheck,
+
+			MaxConnectionsPerIP:     int(maxPerIP),
+			MaxSessionsPerUser:      int(maxPerUser),
+			MaxSessions:             int(maxSessions),
+			SessionQueueTimeout:     time.Duration(queueTimeout) * time.Second,
+			IdleTimeout:             time.Duration(idleTimeout) * time.Second,
+			MaxSessionLifetime:      time.Duration(sessionLifetime) * time.Minute,
+			DrainTimeout:            time.Duration(drainTimeout) * time.Second,
+			MaxFileSize:             maxFileSize * 1024 * 1024,
+			MaxFiles:                maxFiles,
+			MaxOpenFiles:            maxOpenFiles,
+			MaxSessionMemory:        sessionMemory * 1024 * 1024,
+			ProtectedPaths:          protectedPaths,
+			BlockedFiles:            blockedFiles,
+			ManagedFiles:            managedFiles,
+			PermissionsRefresh:      time.Duration(permissionsRefresh) * time.Second,
+			PermissionsTTL:          time.Duration(permissionsTTL) * time.Second,
+			HideFiles:               hideFiles,
+			DropPrivileges:          dropPrivileges,
+			Sandbox:                 sandbox,
+			SandboxReadPaths:        sandboxRead,
+			SandboxWritePaths:       sandboxWrite,
+			FileMode:                server.ParseFileMode(fileMode, 0644),
+			DirectoryMode:           server.ParseFileMode(directoryMode, 0755),
+			AtomicUploads:           atomicUploads,
+			PartialUploads:          partialUploads,
+			PartialUploadMaxAge:     time.Duration(partialMaxAge) * time.Hour,
+			TransferBufferSize:      int(transferBuffer),
+			ReadAhead:               int(readAhead),
+			TrashEnabled:            trashEnabled,
+			TrashRetention:          time.Duration(trashRetention) * 24 * time.Hour,
+			MaxVersions:             int(maxVersions),
+			RestrictRecursiveDelete: restrictRecursiveDelete,
+			RestrictDownloads:       restrictDownloads,
+			AllowedOperations:       allowedOperations,
+			DeniedOperations:        deniedOperations,
+			FileNamePolicy:          fileNamePolicy,
+			AdminAddress:            adminAddress,
+			AdminToken:              adminToken,
+			PprofPort:               int(pprofPort),
+			WebhookURLs:             webhookURLs,
+			WebhookSecret:           webhookSecret,
+			PanelActivity:           panelActivity,
+			PanelStatisticsInterval: time.Duration(statisticsInterval) * time.Second,
+			TracingEndpoint:         tracingEndpoint,
+			TracingServiceName:      tracingServiceName,
+			TracingHashPaths:        tracingHashPaths,
+			MalwareScanAddress:      malwareScanAddress,
+			MalwareQuarantine:       malwareQuarantine,
+			ProxyProtocol:           proxyProtocol,
+			ProxyTrustedNetworks:    proxyTrusted,
+			PanelTLS:                panelTLS,
+			PanelHandshake:          panelHandshake,
+			PanelRoutes:             panelRoutes,
+			StandaloneFile:          standaloneFile,
+			DevDirectory:            devDirectory,
+			OfflineAuthTTL:          time.Duration(offlineAuthTTL) * time.Minute,
+			Banner:                  banner,
+			MOTD:                    motd,
+			ServerVersion:           serverVersion,
+			Ciphers:                 ciphers,
+			RekeyThreshold:          uint64(rekeyThreshold) * 1024 * 1024,
+			HostKeyWatchInterval:    time.Duration(hostKeyWatchInterval) * time.Second,
+			KeyExchanges:            keyExchanges,
+			MACs:                    macs,
+			CertificateAuthorities:  certificateAuthorities,
+			LoginTokens:             loginTokens,
+			AuthLogPath:             authLog,
+			GeoIPDatabase:           geoIPDatabase,
+			AllowCountries:          allowCountries,
+			DenyCountries:           denyCountries,
+			SuspensionAction:        suspensionAction,
+
+			ErrorReportingDSN:         errorReportingDSN,
+			ErrorReportingURL:         errorReportingURL,
+			ErrorReportingEnvironment: errorReportingEnvironment,
+			Version:                   version,
+		},
+	}, nil
+}
+
+// Returns the strings in the array at the given path of the configuration, or nil if there is no
+// array there.
+func readStrings(config []byte, keys ...string) []string {
+	var values []string
+	jsonparser.ArrayEach(config, func(value []byte, t jsonparser.ValueType, _ int, _ error) {
+		if t == jsonparser.String {
+			values = append(values, string(value))
+		}
+	}, keys...)
+
+	return values
+}
+
+func readConfiguration(path string) ([]byte, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, errors.New("could not locate a configuration file at the specified path")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func isFlagPassed(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -11,4 +11,5 @@ require (
 	go.uber.org/multierr v1.1.0 // indirect
 	go.uber.org/zap v1.9.1
 	golang.org/x/crypto v0.0.0-20181025213731-e84da0312774
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
@@ -1,128 +1,111 @@
 package main
 
 import (
-	"errors"
-	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/user"
-	"path"
 	"runtime"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/buger/jsonparser"
-	"github.com/patrickmn/go-cache"
 	"github.com/pterodactyl/sftp-server/src/logger"
-	"github.com/pterodactyl/sftp-server/src/server"
-	"go.uber.org/zap"
 )
 
+// The version of the server, which is set when building a release with
+// -ldflags "-X main.version=v1.0.5".
+var version = "dev"
+
+const usage = `Usage: sftp-server [command] [flags]
+
+Commands:
+  serve     run the SFTP server, the default when no command is given
+  keygen    generate a new host key for the server
+  config    validate the configuration and print the settings it results in
+  diagnose  check that the node is able to run the server
+  update    replace the binary with the latest release
+  version   print the version of the server
+
+Run "sftp-server <command> -h" for the flags each command accepts.
+`
+
 func main() {
-	if runtime.GOOS != "linux" {
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
 		fmt.Printf("This operating system (%s) is not supported.\n", runtime.GOOS)
 		os.Exit(1)
 	}
 
-	var (
-		configLocation   string
-		bindPort         int
-		bindAddress      string
-		readOnlyMode     bool
-		debugMode        bool
-		disableDiskCheck bool
-	)
-
-	flag.StringVar(&configLocation, "config-path", "./config/core.json", "the location of your Daemon configuration file")
-	flag.IntVar(&bindPort, "port", 2022, "the port this server should bind to")
-	flag.StringVar(&bindAddress, "bind-addr", "0.0.0.0", "the address this server should bind to")
-	flag.BoolVar(&readOnlyMode, "readonly", false, "determines if this server should run in read-only mode")
-	flag.BoolVar(&disableDiskCheck, "disable-disk-check", false, "determines if disk space checking should be disabled")
-	flag.BoolVar(&debugMode, "debug", false, "determines if the server should output debug information")
-	flag.Parse()
-
-	logger.Initialize(debugMode)
-
-	logger.Get().Infow("reading configuration from path", zap.String("config-path", configLocation))
-
-	config, err := readConfiguration(configLocation)
-	if err != nil {
-		logger.Get().Fatalw("could not read configuration", zap.Error(err))
+	// Running the binary without a command, including with only flags, serves so that existing
+	// service definitions continue to work.
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	username, err := jsonparser.GetString(config, "docker", "container", "username")
-	if err != nil {
-		logger.Get().Debugw("could not find sftp user definition, falling back to \"pterodactyl\"", zap.Error(err))
-		username = "pterodactyl"
+	switch command {
+	case "serve":
+		runServe(args)
+	case "keygen":
+		runKeygen(args)
+	case "config":
+		runConfig(args)
+	case "diagnose":
+		runDiagnose(args)
+	case "update":
+		runUpdate(args)
+	case "version":
+		fmt.Println(version)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(1)
 	}
+}
 
-	logger.Get().Infow("using system daemon user", zap.String("username", username))
+// Returns the log file settings defined in the configuration, using the defaults for anything
+// that is not set. Setting the path to an empty string disables logging to a file.
+func logSettings(config []byte) logger.Settings {
+	s := logger.DefaultSettings
 
-	u, err := user.Lookup(username)
-	if err != nil {
-		logger.Get().Fatalw("failed to lookup sftp user", zap.Error(err))
-		return
+	if v, err := jsonparser.GetString(config, "sftp", "logs", "path"); err == nil {
+		s.Path = v
 	}
 
-	// default to config port if the sftp was not passed.
-	if !isFlagPassed("port") {
-		// get port form config
-		confPort, err := jsonparser.GetInt(config, "sftp", "port")
-		if err != nil {
-			// default to the bindPort default from the flag section
-			logger.Get().Debugw("could not find sftp port, falling back to \"2022\"", zap.Error(err))
-		} else {
-			// set bindPort to the confPort value
-			logger.Get().Infow("using config daemon port", zap.Int("port", int(confPort)))
-			bindPort = int(confPort)
-		}
+	if v, err := jsonparser.GetInt(config, "sftp", "logs", "max_size"); err == nil {
+		s.MaxSize = int(v)
 	}
 
-	uid, _ := strconv.Atoi(u.Uid)
-	gid, _ := strconv.Atoi(u.Gid)
-
-	var s = server.Configuration{
-		Data:  config,
-		Cache: cache.New(5*time.Minute, 10*time.Minute),
-		User: server.SftpUser{
-			Uid: uid,
-			Gid: gid,
-		},
-		Settings: server.Settings{
-			BasePath:         path.Dir(configLocation),
-			ReadOnly:         readOnlyMode,
-			BindAddress:      bindAddress,
-			BindPort:         bindPort,
-			ServerDataFolder: path.Join(path.Dir(configLocation), "/servers"),
-			DisableDiskCheck: disableDiskCheck,
-		},
+	if v, err := jsonparser.GetInt(config, "sftp", "logs", "max_age"); err == nil {
+		s.MaxAge = int(v)
 	}
 
-	if err := s.Initalize(); err != nil {
-		logger.Get().Fatalw("could not start SFTP server", zap.Error(err))
+	if v, err := jsonparser.GetInt(config, "sftp", "logs", "max_backups"); err == nil {
+		s.MaxBackups = int(v)
 	}
-}
 
-func readConfiguration(path string) ([]byte, error) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, errors.New("could not locate a configuration file at the specified path")
+	if v, err := jsonparser.GetBoolean(config, "sftp", "logs", "compress"); err == nil {
+		s.Compress = v
 	}
 
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
+	s.Syslog, _ = jsonparser.GetBoolean(config, "sftp", "logs", "syslog", "enabled")
+	s.SyslogAddress, _ = jsonparser.GetString(config, "sftp", "logs", "syslog", "address")
+	if v, err := jsonparser.GetString(config, "sftp", "logs", "syslog", "tag"); err == nil && v != "" {
+		s.SyslogTag = v
 	}
 
-	return data, nil
-}
+	s.Journald, _ = jsonparser.GetBoolean(config, "sftp", "logs", "journald")
+
+	if v, err := jsonparser.GetInt(config, "sftp", "logs", "sampling", "initial"); err == nil {
+		s.SamplingInitial = int(v)
+	}
+
+	if v, err := jsonparser.GetInt(config, "sftp", "logs", "sampling", "thereafter"); err == nil {
+		s.SamplingThereafter = int(v)
+	}
+
+	if v, err := jsonparser.GetInt(config, "sftp", "logs", "dedupe_window"); err == nil {
+		s.DedupeWindow = time.Duration(v) * time.Second
+	}
 
-func isFlagPassed(name string) bool {
-	found := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == name {
-			found = true
-		}
-	})
-	return found
+	return s
 }
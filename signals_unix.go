@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"github.com/pterodactyl/sftp-server/src/server"
+	"go.uber.org/zap"
+)
+
+// Raises the log level to debug for the default debug window whenever SIGUSR1 is received,
+// and returns it to the configured level when SIGUSR2 is received. This allows a node to be
+// debugged without a restart even when the admin API is not enabled.
+func watchLogLevelSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR2 {
+				logger.ResetLevel()
+				logger.Get().Infow("reset log level after receiving signal", zap.Stringer("level", logger.ConfiguredLevel()))
+				continue
+			}
+
+			logger.SetLevelFor(zap.DebugLevel, logger.DefaultDebugWindow)
+			logger.Get().Infow("raised log level to debug after receiving signal", zap.Duration("duration", logger.DefaultDebugWindow))
+		}
+	}()
+}
+
+// Restarts the server without interrupting any sessions whenever SIGHUP is received, which allows
+// the binary to be upgraded in place. The signal is ignored while a restart is already underway.
+func watchRestartSignal(s *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			logger.Get().Infow("restarting after receiving signal")
+
+			if err := s.Restart(); err != nil {
+				logger.Get().Errorw("failed to restart server", zap.Error(err))
+			}
+		}
+	}()
+}
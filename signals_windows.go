@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "github.com/pterodactyl/sftp-server/src/server"
+
+// Windows has no equivalent of SIGUSR1 and SIGUSR2, so the log level can only be changed through
+// the admin API.
+func watchLogLevelSignals() {}
+
+// Windows has no equivalent of SIGHUP, and the server can't be restarted there anyways.
+func watchRestartSignal(s *server.Server) {}
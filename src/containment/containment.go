@@ -0,0 +1,84 @@
+// Package containment determines whether paths are contained within a root directory. It is
+// used to make sure that nothing a client sends can be resolved to a location outside of the
+// directory of the server it is connected to.
+package containment
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// Returned for paths containing a NUL byte, which would otherwise be silently truncated
+	// by some system calls and end up referring to a different file than the one checked.
+	ErrNulByte = errors.New("path contains a nul byte")
+
+	// Returned for paths that are not valid UTF-8.
+	ErrInvalidUTF8 = errors.New("path is not valid utf-8")
+
+	// Returned when a path resolves to somewhere outside of the root directory.
+	ErrOutsideRoot = errors.New("path is outside of the root directory")
+)
+
+// Validate checks that a path received from a client is something that can safely be resolved,
+// returning an error if it contains a NUL byte or is not valid UTF-8.
+func Validate(p string) error {
+	if strings.IndexByte(p, 0) != -1 {
+		return ErrNulByte
+	}
+
+	if !utf8.ValidString(p) {
+		return ErrInvalidUTF8
+	}
+
+	return nil
+}
+
+// Within determines if the path is the root directory itself or is contained somewhere beneath
+// it. Both paths are cleaned before being compared, and are compared using the rules of the
+// platform the server is running on, so this is not case-sensitive on Windows.
+//
+// A trailing separator is added to the root before checking if it is a prefix of the path, so a
+// root of "/srv/data" does not contain "/srv/data2". Paths that fail Validate are never within
+// the root.
+func Within(p string, root string) bool {
+	if Validate(p) != nil || Validate(root) != nil {
+		return false
+	}
+
+	p = Fold(filepath.Clean(p))
+	root = Fold(filepath.Clean(root))
+
+	if p == root {
+		return true
+	}
+
+	// The root of the filesystem, or of a drive on Windows, already ends with a separator
+	// once cleaned, so it must not be given a second one.
+	prefix := root
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+
+	return strings.HasPrefix(p, prefix)
+}
+
+// Join joins a path received from a client to the root directory, returning the cleaned result.
+// An error is returned if the path fails Validate, or if it uses ".." to climb out of the root.
+//
+// This only checks the path itself, so the result may still be a symlink that points outside of
+// the root. Callers that need to follow symlinks must check the resolved path with Within.
+func Join(root string, p string) (string, error) {
+	if err := Validate(p); err != nil {
+		return "", err
+	}
+
+	r := filepath.Clean(filepath.Join(root, p))
+	if !Within(r, root) {
+		return "", ErrOutsideRoot
+	}
+
+	return r, nil
+}
@@ -0,0 +1,166 @@
+package containment
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		path string
+		err  error
+	}{
+		{"", nil},
+		{"/", nil},
+		{"/server.properties", nil},
+		{"/plugins/Essentials/config.yml", nil},
+		{"/ünïcödé/文件.txt", nil},
+		{"/file\x00.txt", ErrNulByte},
+		{"\x00", ErrNulByte},
+		{"/world/\x00/../../etc/passwd", ErrNulByte},
+		{"/file.txt\x00.jpg", ErrNulByte},
+		{"/\xff", ErrInvalidUTF8},
+		{"/file\xc0\xaf.txt", ErrInvalidUTF8},
+		{"/\xc0\xae\xc0\xae/etc/passwd", ErrInvalidUTF8},
+		{"/\xed\xa0\x80", ErrInvalidUTF8},
+	}
+
+	for _, tt := range tests {
+		if err := Validate(tt.path); err != tt.err {
+			t.Errorf("Validate(%q) = %v, want %v", tt.path, err, tt.err)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		path   string
+		root   string
+		within bool
+	}{
+		// The root itself, however it is written.
+		{"/srv/data", "/srv/data", true},
+		{"/srv/data/", "/srv/data", true},
+		{"/srv/data", "/srv/data/", true},
+		{"/srv/data/.", "/srv/data", true},
+		{"/srv//data", "/srv/data", true},
+		{"/srv/data/sub/..", "/srv/data", true},
+
+		// Paths beneath the root.
+		{"/srv/data/a", "/srv/data", true},
+		{"/srv/data/a/b/c", "/srv/data", true},
+		{"/srv/data/a/../b", "/srv/data", true},
+		{"/srv/data/..a", "/srv/data", true},
+		{"/srv/data/...", "/srv/data", true},
+		{"/srv/data/a..", "/srv/data", true},
+		{"/srv/data//a", "/srv/data", true},
+		{"/srv/data/./a", "/srv/data", true},
+
+		// Siblings sharing a prefix with the root.
+		{"/srv/data2", "/srv/data", false},
+		{"/srv/data2/a", "/srv/data", false},
+		{"/srv/data-backup", "/srv/data", false},
+		{"/srv/data.old/a", "/srv/data", false},
+		{"/srv/dat", "/srv/data", false},
+		{"/srv/data2", "/srv/data/", false},
+
+		// Anything above or beside the root.
+		{"/srv", "/srv/data", false},
+		{"/", "/srv/data", false},
+		{"/etc/passwd", "/srv/data", false},
+		{"/srv/data/..", "/srv/data", false},
+		{"/srv/data/../data2", "/srv/data", false},
+		{"/srv/data/a/../../data2", "/srv/data", false},
+		{"/srv/data/../../../../etc/passwd", "/srv/data", false},
+
+		// Everything is within the root of the filesystem.
+		{"/", "/", true},
+		{"/etc", "/", true},
+		{"/srv/data/a", "/", true},
+
+		// Paths that could be truncated or misinterpreted are never within the root.
+		{"/srv/data/a\x00", "/srv/data", false},
+		{"/srv/data/\x00/../../etc", "/srv/data", false},
+		{"/srv/data/\xff", "/srv/data", false},
+		{"/srv/data/a", "/srv/data\x00", false},
+		{"/srv/data/a", "/srv/\xffdata", false},
+	}
+
+	for _, tt := range tests {
+		p, root := filepath.FromSlash(tt.path), filepath.FromSlash(tt.root)
+		if within := Within(p, root); within != tt.within {
+			t.Errorf("Within(%q, %q) = %v, want %v", p, root, within, tt.within)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	root := filepath.FromSlash("/srv/daemon-data/abcd")
+
+	tests := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{"", "/srv/daemon-data/abcd", nil},
+		{"/", "/srv/daemon-data/abcd", nil},
+		{".", "/srv/daemon-data/abcd", nil},
+		{"server.properties", "/srv/daemon-data/abcd/server.properties", nil},
+		{"/server.properties", "/srv/daemon-data/abcd/server.properties", nil},
+		{"//server.properties", "/srv/daemon-data/abcd/server.properties", nil},
+		{"/plugins/../server.properties", "/srv/daemon-data/abcd/server.properties", nil},
+		{"/plugins/./a/", "/srv/daemon-data/abcd/plugins/a", nil},
+		{"/a/b/c/../../..", "/srv/daemon-data/abcd", nil},
+		{"/..a", "/srv/daemon-data/abcd/..a", nil},
+		{"/...", "/srv/daemon-data/abcd/...", nil},
+		{"/a../b", "/srv/daemon-data/abcd/a../b", nil},
+		{"/%2e%2e/etc/passwd", "/srv/daemon-data/abcd/%2e%2e/etc/passwd", nil},
+		{"/..%2f..%2fetc", "/srv/daemon-data/abcd/..%2f..%2fetc", nil},
+
+		// Climbing out of the root however it is attempted.
+		{"..", "", ErrOutsideRoot},
+		{"/..", "", ErrOutsideRoot},
+		{"../", "", ErrOutsideRoot},
+		{"../abcd2", "", ErrOutsideRoot},
+		{"../abcd", "/srv/daemon-data/abcd", nil},
+		{"../abcd/file", "/srv/daemon-data/abcd/file", nil},
+		{"../../../../etc/passwd", "", ErrOutsideRoot},
+		{"/a/../../etc", "", ErrOutsideRoot},
+		{"/a/b/../../../", "", ErrOutsideRoot},
+		{"./../efgh/file", "", ErrOutsideRoot},
+		{"/a/./.././../x", "", ErrOutsideRoot},
+
+		// Paths that could be truncated or misinterpreted.
+		{"/server.properties\x00", "", ErrNulByte},
+		{"/a\x00/../../../etc/passwd", "", ErrNulByte},
+		{"\x00", "", ErrNulByte},
+		{"/\xc0\xae\xc0\xae/etc", "", ErrInvalidUTF8},
+		{"/file\xff", "", ErrInvalidUTF8},
+	}
+
+	for _, tt := range tests {
+		p := filepath.FromSlash(tt.path)
+		got, err := Join(root, p)
+		if err != tt.err {
+			t.Errorf("Join(%q, %q) error = %v, want %v", root, p, err, tt.err)
+			continue
+		}
+
+		if want := filepath.FromSlash(tt.want); got != want {
+			t.Errorf("Join(%q, %q) = %q, want %q", root, p, got, want)
+		}
+	}
+}
+
+func TestJoinFilesystemRoot(t *testing.T) {
+	root := string(filepath.Separator)
+
+	for _, p := range []string{"", "/", "..", "../..", "/etc/../..", "etc/passwd"} {
+		got, err := Join(root, filepath.FromSlash(p))
+		if err != nil {
+			t.Errorf("Join(%q, %q) error = %v, want nil", root, p, err)
+		} else if !Within(got, root) {
+			t.Errorf("Join(%q, %q) = %q, which is outside of the root", root, p, got)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package containment
+
+// Fold returns the path in the form it should be compared in. Paths are case-sensitive on Unix
+// systems, so it is returned unchanged.
+func Fold(p string) string {
+	return p
+}
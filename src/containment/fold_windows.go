@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package containment
+
+import "strings"
+
+// Fold returns the path in the form it should be compared in. Windows treats paths as
+// case-insensitive, so two paths that only differ by case refer to the same file and must be
+// treated as such when checking things like protected paths.
+func Fold(p string) string {
+	return strings.ToLower(p)
+}
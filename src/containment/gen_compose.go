@@ -0,0 +1,240 @@
+//go:build ignore
+// +build ignore
+
+// Generates compose.go, the tables NormalizeName uses to compose names into Unicode
+// Normalization Form C, from UnicodeData.txt and CompositionExclusions.txt of the Unicode
+// Character Database. Run it with go generate from this directory.
+//
+// The tables hold the full canonical decomposition and the canonical combining class of every
+// character that has one, along with every primary composite, so they cover NFC for all of
+// Unicode apart from the Hangul syllables, which are decomposed and composed algorithmically.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The version of Unicode the tables are generated from.
+const version = "14.0.0"
+
+var (
+	ucd    = flag.String("ucd", "https://www.unicode.org/Public/"+version+"/ucd/", "the URL or directory to read the Unicode Character Database from")
+	output = flag.String("output", "compose.go", "the file to write the tables to")
+)
+
+func main() {
+	flag.Parse()
+
+	classes := make(map[rune]int)
+	mappings := make(map[rune][]rune)
+	err := readFile("UnicodeData.txt", func(fields []string) error {
+		r, err := parseRune(fields[0])
+		if err != nil {
+			return err
+		}
+
+		if len(fields) < 6 {
+			return fmt.Errorf("%U: missing fields", r)
+		}
+
+		c, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return err
+		}
+		if c != 0 {
+			classes[r] = c
+		}
+
+		// Compatibility decompositions start with a tag, and aren't used by NFC.
+		if fields[5] == "" || strings.HasPrefix(fields[5], "<") {
+			return nil
+		}
+
+		for _, s := range strings.Fields(fields[5]) {
+			d, err := parseRune(s)
+			if err != nil {
+				return err
+			}
+
+			mappings[r] = append(mappings[r], d)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	excluded := make(map[rune]bool)
+	err = readFile("CompositionExclusions.txt", func(fields []string) error {
+		r, err := parseRune(fields[0])
+		if err != nil {
+			return err
+		}
+
+		excluded[r] = true
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Characters whose decomposition is a single character, or which decompose to (or are)
+	// a non-starter, are never composed, along with the ones listed as exclusions.
+	compositions := make(map[[2]rune]rune)
+	for r, m := range mappings {
+		if len(m) == 2 && !excluded[r] && classes[r] == 0 && classes[m[0]] == 0 {
+			compositions[[2]rune{m[0], m[1]}] = r
+		}
+	}
+
+	decompositions := make(map[rune][]rune)
+	for r := range mappings {
+		decompositions[r] = decompose(mappings, r)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gen_compose.go from the Unicode Character Database %s. DO NOT EDIT.\n\n", version)
+	fmt.Fprintf(&b, "package containment\n\n")
+
+	fmt.Fprintf(&b, "// The full canonical decomposition of every character that has one, apart from the Hangul\n")
+	fmt.Fprintf(&b, "// syllables.\n")
+	fmt.Fprintf(&b, "var decompositions = map[rune]string{\n")
+	for _, r := range sortedRunes(decompositions) {
+		fmt.Fprintf(&b, "0x%04X: %+q,\n", r, string(decompositions[r]))
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "// The canonical combining class of every character that isn't a starter.\n")
+	fmt.Fprintf(&b, "var combiningClasses = map[rune]uint8{\n")
+	for _, r := range sortedRunes(classes) {
+		fmt.Fprintf(&b, "0x%04X: %d,\n", r, classes[r])
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	pairs := make([][2]rune, 0, len(compositions))
+	for p := range compositions {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0] || (pairs[i][0] == pairs[j][0] && pairs[i][1] < pairs[j][1])
+	})
+
+	fmt.Fprintf(&b, "// The primary composite of every pair of characters that has one, apart from the Hangul\n")
+	fmt.Fprintf(&b, "// syllables.\n")
+	fmt.Fprintf(&b, "var compositions = map[[2]rune]rune{\n")
+	for _, p := range pairs {
+		fmt.Fprintf(&b, "{0x%04X, 0x%04X}: 0x%04X,\n", p[0], p[1], compositions[p])
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Calls fn with the fields of every line of a file in the Unicode Character Database, skipping
+// comments and blank lines.
+func readFile(name string, fn func(fields []string) error) error {
+	var r io.Reader
+	if strings.HasPrefix(*ucd, "http://") || strings.HasPrefix(*ucd, "https://") {
+		res, err := http.Get(strings.TrimSuffix(*ucd, "/") + "/" + name)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: unexpected status %s", name, res.Status)
+		}
+		r = res.Body
+	} else {
+		f, err := os.Open(filepath.Join(*ucd, name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, ";")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		if err := fn(fields); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	return s.Err()
+}
+
+func parseRune(s string) (rune, error) {
+	r, err := strconv.ParseUint(s, 16, 32)
+
+	return rune(r), err
+}
+
+// Applies the canonical decomposition mappings to a character until nothing is left to
+// decompose.
+func decompose(mappings map[rune][]rune, r rune) []rune {
+	m, ok := mappings[r]
+	if !ok {
+		return []rune{r}
+	}
+
+	var out []rune
+	for _, d := range m {
+		out = append(out, decompose(mappings, d)...)
+	}
+
+	return out
+}
+
+func sortedRunes(m interface{}) []rune {
+	var out []rune
+	switch m := m.(type) {
+	case map[rune][]rune:
+		for r := range m {
+			out = append(out, r)
+		}
+	case map[rune]int:
+		for r := range m {
+			out = append(out, r)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+
+	return out
+}
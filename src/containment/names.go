@@ -0,0 +1,156 @@
+package containment
+
+//go:generate go run gen_compose.go
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// Returned for names containing a control character, such as a newline or an escape, which
+	// break tools that list files one per line and can be used to mislead anyone reading them.
+	ErrControlCharacter = errors.New("name contains a control character")
+
+	// Returned for names ending with a space or a dot, which Windows silently strips, so the
+	// file can't be opened there once it has been copied or restored from a backup.
+	ErrTrailingCharacter = errors.New("name ends with a space or a dot")
+)
+
+// ValidateName checks that a single file name is one that can be used on any platform the files
+// of a server might end up on, returning an error if it contains a control character or ends
+// with a space or a dot. The "." and ".." names are left alone, and invalid UTF-8 is rejected
+// by Validate for the whole path.
+func ValidateName(name string) error {
+	if strings.IndexFunc(name, isControl) != -1 {
+		return ErrControlCharacter
+	}
+
+	if name != "." && name != ".." && (strings.HasSuffix(name, " ") || strings.HasSuffix(name, ".")) {
+		return ErrTrailingCharacter
+	}
+
+	return nil
+}
+
+// NormalizeName returns the file name with any control characters removed and any trailing
+// spaces and dots trimmed, in Unicode Normalization Form C so that the same name typed on macOS
+// and on Windows refers to the same file. An error is returned if nothing usable is left of the
+// name.
+func NormalizeName(name string) (string, error) {
+	if name == "." || name == ".." {
+		return name, nil
+	}
+
+	name = strings.TrimRight(strings.Map(func(r rune) rune {
+		if isControl(r) {
+			return -1
+		}
+
+		return r
+	}, name), " .")
+
+	if name == "" {
+		return "", ErrTrailingCharacter
+	}
+
+	return compose(name), nil
+}
+
+// Determines if the character is one of the C0 or C1 control characters, or DEL.
+func isControl(r rune) bool {
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f)
+}
+
+// Converts the string to Unicode Normalization Form C, using the tables in compose.go: every
+// character is canonically decomposed, combining marks are put in canonical order, and then
+// everything is composed again wherever it can be.
+func compose(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+
+	if ascii {
+		return s
+	}
+
+	var d []rune
+	for _, r := range s {
+		if r >= hangulBase && r < hangulBase+hangulLCount*hangulNCount {
+			i := r - hangulBase
+			d = append(d, hangulLBase+i/hangulNCount, hangulVBase+(i%hangulNCount)/hangulTCount)
+			if t := i % hangulTCount; t != 0 {
+				d = append(d, hangulTBase+t)
+			}
+		} else if m, ok := decompositions[r]; ok {
+			d = append(d, []rune(m)...)
+		} else {
+			d = append(d, r)
+		}
+	}
+
+	// Sort each run of combining marks by their combining class, keeping marks of the same class
+	// in the order they were in.
+	for i := 1; i < len(d); i++ {
+		c := combiningClasses[d[i]]
+		for j := i; j > 0 && c != 0 && combiningClasses[d[j-1]] > c; j-- {
+			d[j], d[j-1] = d[j-1], d[j]
+		}
+	}
+
+	// A mark can only be composed with the last starter before it if nothing in between has a
+	// combining class of zero or one at least as high as its own, and a starter can only be
+	// composed with a starter immediately before it.
+	out := make([]rune, 0, len(d))
+	starter := -1
+	for _, r := range d {
+		c := combiningClasses[r]
+		if n := len(out); starter != -1 && (n-1 == starter || combiningClasses[out[n-1]] < c) {
+			if composed, ok := composePair(out[starter], r); ok {
+				out[starter] = composed
+				continue
+			}
+		}
+
+		if c == 0 {
+			starter = len(out)
+		}
+		out = append(out, r)
+	}
+
+	return string(out)
+}
+
+// The ranges of the Hangul syllables and the jamo they are composed from, as defined by the
+// Unicode standard.
+const (
+	hangulBase   = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+)
+
+func composePair(a rune, b rune) (rune, bool) {
+	// A leading consonant and a vowel form a syllable, which can then take a trailing consonant.
+	if a >= hangulLBase && a < hangulLBase+hangulLCount && b >= hangulVBase && b < hangulVBase+hangulVCount {
+		return hangulBase + ((a-hangulLBase)*hangulVCount+(b-hangulVBase))*hangulTCount, true
+	}
+
+	if a >= hangulBase && a < hangulBase+hangulLCount*hangulNCount && (a-hangulBase)%hangulTCount == 0 &&
+		b > hangulTBase && b < hangulTBase+hangulTCount {
+		return a + (b - hangulTBase), true
+	}
+
+	c, ok := compositions[[2]rune{a, b}]
+
+	return c, ok
+}
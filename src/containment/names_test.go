@@ -0,0 +1,70 @@
+package containment
+
+import "testing"
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"server.properties", nil},
+		{".", nil},
+		{"..", nil},
+		{".env", nil},
+		{"文件.txt", nil},
+		{"café.txt", nil},
+		{"line\nbreak.txt", ErrControlCharacter},
+		{"\x1b[31mred.txt", ErrControlCharacter},
+		{"tab\t.txt", ErrControlCharacter},
+		{"del\x7f.txt", ErrControlCharacter},
+		{"c1\u0085.txt", ErrControlCharacter},
+		{"world ", ErrTrailingCharacter},
+		{"world.", ErrTrailingCharacter},
+		{"...", ErrTrailingCharacter},
+		{" ", ErrTrailingCharacter},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateName(tt.name); err != tt.err {
+			t.Errorf("ValidateName(%q) = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"server.properties", "server.properties", nil},
+		{"..", "..", nil},
+		{"line\nbreak.txt", "linebreak.txt", nil},
+		{"world. . ", "world", nil},
+		{"cafe\u0301.txt ", "caf\u00e9.txt", nil},
+		{"Ame\u0301lie\u0308", "Am\u00e9li\u00eb", nil},
+		{"A\u0300", "\u00c0", nil},
+		{"\u00e9", "\u00e9", nil},
+		{"e\u0323\u0302", "\u1ec7", nil},
+		{"\u03b9\u0308\u0301", "\u0390", nil},
+		{"\u0438\u0306", "\u0439", nil},
+		{"\u30ab\u3099", "\u30ac", nil},
+		{"\u1100\u1161", "\uac00", nil},
+		{"\u1112\u1161\u11ab", "\ud55c", nil},
+		{"\ud55c", "\ud55c", nil},
+		{"a\u0302\u0323", "\u1ead", nil},
+		{"q\u0307\u0323", "q\u0323\u0307", nil},
+		{"\u0928\u093c", "\u0929", nil},
+		{"\u0958", "\u0915\u093c", nil},
+		{"\u2126", "\u03a9", nil},
+		{"文件", "文件", nil},
+		{" . ", "", ErrTrailingCharacter},
+		{"\x01\x02", "", ErrTrailingCharacter},
+	}
+
+	for _, tt := range tests {
+		if got, err := NormalizeName(tt.name); got != tt.want || err != tt.err {
+			t.Errorf("NormalizeName(%q) = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+// Package geoip looks up the country of an IP address in a MaxMind DB file, such as the GeoLite2
+// or GeoIP2 Country databases. Only as much of the format as is needed to find the country of
+// an address is implemented.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// The marker the metadata section of a database begins after, which is at the end of the file.
+var metadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+// The number of zero bytes between the search tree and the data section.
+const dataSectionSeparator = 16
+
+// The types of the values in the data section, as given by the control byte of each value.
+const (
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+var errInvalidDatabase = errors.New("geoip: invalid database")
+
+// A Reader looks up addresses in a database that has been loaded into memory.
+type Reader struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+
+	// The node the search for an IPv4 address starts at in an IPv6 database, which is the node
+	// reached after the 96 zero bits IPv4 addresses are prefixed with.
+	ipv4Start uint
+}
+
+// Opens the database at the given path.
+func Open(path string) (*Reader, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := bytes.LastIndex(b, metadataStart)
+	if i == -1 {
+		return nil, errors.New("geoip: no metadata found, this is not a MaxMind DB file")
+	}
+
+	d := decoder{buf: b[i+len(metadataStart):]}
+	v, _, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errInvalidDatabase
+	}
+
+	r := &Reader{
+		nodeCount:  toUint(meta["node_count"]),
+		recordSize: toUint(meta["record_size"]),
+		ipVersion:  toUint(meta["ip_version"]),
+	}
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("geoip: unsupported record size %d", r.recordSize)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(i) {
+		return nil, errInvalidDatabase
+	}
+
+	r.tree = b[:treeSize]
+	r.data = b[treeSize+dataSectionSeparator : i]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for n := 0; n < 96 && node < r.nodeCount; n++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// Returns the ISO 3166-1 country code for the address, such as "US", or an empty string if the
+// country of the address is not known. The registered country is used for addresses that do not
+// have a country of their own, such as those belonging to some mobile networks.
+func (r *Reader) Country(ip net.IP) (string, error) {
+	v, err := r.lookup(ip)
+	if err != nil || v == nil {
+		return "", err
+	}
+
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := v[key].(map[string]interface{}); ok {
+			if code, ok := c["iso_code"].(string); ok && code != "" {
+				return code, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// Finds the record for an address, returning nil if there isn't one.
+func (r *Reader) lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+
+	if node == r.nodeCount {
+		return nil, nil
+	}
+
+	if node < r.nodeCount {
+		return nil, errInvalidDatabase
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, errInvalidDatabase
+	}
+
+	d := decoder{buf: r.data}
+	v, _, err := d.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	m, _ := v.(map[string]interface{})
+	return m, nil
+}
+
+// Returns the left (0) or right (1) record of a node in the search tree.
+func (r *Reader) record(node uint, bit uint) uint {
+	b := r.tree[node*r.recordSize/4:]
+
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		// The middle byte holds the high bits of both records, the left record in the upper
+		// half and the right record in the lower half.
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// How deeply maps and arrays can be nested, which stops a database with pointers that loop back
+// on themselves from being followed forever.
+const maxDepth = 32
+
+// Decodes values from the data section of a database, or from the metadata section.
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+// Decodes the value at the offset, returning it along with the offset of the next value.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+
+	if d.depth > maxDepth {
+		return nil, 0, errInvalidDatabase
+	}
+
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		p, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		v, _, err := d.decode(p)
+		return v, next, err
+	}
+
+	return d.value(typ, size, offset)
+}
+
+// Reads the control byte at the offset, returning the type and size of the value it describes
+// and the offset of the value itself.
+func (d *decoder) control(offset uint) (uint, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errInvalidDatabase
+	}
+
+	c := d.buf[offset]
+	offset++
+
+	typ := uint(c >> 5)
+	if typ == 0 {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errInvalidDatabase
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(c & 0x1f)
+	if typ == typePointer || size < 29 {
+		return typ, size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, 0, errInvalidDatabase
+	}
+
+	v := uint(0)
+	for _, b := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(b)
+	}
+
+	switch n {
+	case 1:
+		size = 29 + v
+	case 2:
+		size = 285 + v
+	default:
+		size = 65821 + v
+	}
+
+	return typ, size, offset + n, nil
+}
+
+// Reads a pointer, returning the offset it points to and the offset after the pointer. The size
+// bits of the control byte hold the length of the pointer and the first bits of its value.
+func (d *decoder) pointer(size uint, offset uint) (uint, uint, error) {
+	n := (size>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errInvalidDatabase
+	}
+
+	v := size & 0x7
+	if n == 4 {
+		v = 0
+	}
+
+	for _, b := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(b)
+	}
+
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+
+	return v, offset + n, nil
+}
+
+func (d *decoder) value(typ uint, size uint, offset uint) (interface{}, uint, error) {
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			v, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errInvalidDatabase
+			}
+
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEnd:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errInvalidDatabase
+	}
+
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte{}, b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errInvalidDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errInvalidDatabase
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+
+		if typ == typeInt32 {
+			return int32(v), next, nil
+		}
+		return v, next, nil
+	case typeUint128:
+		// Nothing we look at is ever this large, so the value is skipped over.
+		return nil, next, nil
+	}
+
+	return nil, 0, fmt.Errorf("geoip: unknown data type %d", typ)
+}
+
+func toUint(v interface{}) uint {
+	if n, ok := v.(uint64); ok {
+		return uint(n)
+	}
+
+	return 0
+}
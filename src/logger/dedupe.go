@@ -0,0 +1,197 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The most entries that are tracked at once for deduplication. Anything logged once this many
+// different entries have been seen within the window is written as it is, so a flood of unique
+// entries can't use an unbounded amount of memory.
+const maxDedupeEntries = 10000
+
+// Suppresses entries that are identical to one already written within the window, which stops
+// a misbehaving client, such as one repeatedly requesting a path that does not exist, from
+// filling the logs with the same line. Once the window for an entry ends it is written again
+// with the number of times it was suppressed, if it was repeated at all.
+type dedupe struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]*repeatedEntry
+	done chan struct{}
+}
+
+// An entry that has been written, along with the number of identical entries suppressed since.
+type repeatedEntry struct {
+	core     zapcore.Core
+	entry    zapcore.Entry
+	fields   []zapcore.Field
+	expires  time.Time
+	repeated int
+}
+
+func newDedupe(window time.Duration) *dedupe {
+	d := &dedupe{
+		window: window,
+		seen:   make(map[string]*repeatedEntry),
+		done:   make(chan struct{}),
+	}
+
+	go d.run()
+
+	return d
+}
+
+// Writes the number of times each entry was repeated once its window has ended, so that the
+// count is logged even if the entry is never logged again.
+func (d *dedupe) run() {
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.flush(false)
+		}
+	}
+}
+
+// Stops tracking entries, writing the counts for any that were repeated.
+func (d *dedupe) close() {
+	close(d.done)
+	d.flush(true)
+}
+
+// Writes the count for the entries that were repeated and removes them. Only entries whose
+// window has ended are flushed unless all is true.
+func (d *dedupe) flush(all bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for key, r := range d.seen {
+		if !all && now.Before(r.expires) {
+			continue
+		}
+
+		delete(d.seen, key)
+		if r.repeated > 0 {
+			r.entry.Time = now
+			r.core.Write(r.entry, append(r.fields, zap.Int("repeated", r.repeated)))
+		}
+	}
+}
+
+// Returns true if an identical entry has already been written within the window, otherwise the
+// entry is tracked and should be written.
+func (d *dedupe) suppress(key string, core zapcore.Core, e zapcore.Entry, fields []zapcore.Field) bool {
+	// Loggers created from an earlier configuration can still be in use, but nothing would
+	// write the counts for them once it has been replaced.
+	select {
+	case <-d.done:
+		return false
+	default:
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if r, ok := d.seen[key]; ok && now.Before(r.expires) {
+		r.repeated++
+		return true
+	}
+
+	if len(d.seen) < maxDedupeEntries {
+		d.seen[key] = &repeatedEntry{
+			core:    core,
+			entry:   e,
+			fields:  append([]zapcore.Field{}, fields...),
+			expires: now.Add(d.window),
+		}
+	}
+
+	return false
+}
+
+// A core that deduplicates the entries written to the core it wraps. Debug entries are never
+// deduplicated since debug logging is only enabled to see everything that happens.
+type dedupeCore struct {
+	zapcore.Core
+	dedupe  *dedupe
+	encoder zapcore.Encoder
+}
+
+func newDedupeCore(c zapcore.Core, d *dedupe) zapcore.Core {
+	// Entries are compared using their level, message and fields, so the encoder only includes
+	// those.
+	encoder := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		MessageKey:     "msg",
+		LevelKey:       "level",
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.EpochNanosTimeEncoder,
+		EncodeDuration: zapcore.NanosDurationEncoder,
+	})
+
+	return &dedupeCore{Core: c, dedupe: d, encoder: encoder}
+}
+
+func (c *dedupeCore) With(fields []zapcore.Field) zapcore.Core {
+	encoder := c.encoder.Clone()
+	for _, f := range fields {
+		f.AddTo(encoder)
+	}
+
+	return &dedupeCore{Core: c.Core.With(fields), dedupe: c.dedupe, encoder: encoder}
+}
+
+func (c *dedupeCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c *dedupeCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	if e.Level == zapcore.DebugLevel || e.Level > zapcore.ErrorLevel {
+		return c.Core.Write(e, fields)
+	}
+
+	buf, err := c.encoder.EncodeEntry(zapcore.Entry{Level: e.Level, Message: e.Message}, fields)
+	if err != nil {
+		return c.Core.Write(e, fields)
+	}
+	key := buf.String()
+	buf.Free()
+
+	if c.dedupe.suppress(key, c.Core, e, fields) {
+		return nil
+	}
+
+	// The wrapped core is checked again so that it can still sample the entry. If it isn't
+	// enabled for the level at all the entry was let through by a session being debugged, so
+	// it is written without being sampled.
+	if ce := c.Core.Check(e, nil); ce != nil {
+		ce.Write(fields...)
+		return nil
+	}
+
+	if !c.Core.Enabled(e.Level) {
+		return c.Core.Write(e, fields)
+	}
+
+	return nil
+}
+
+func (c *dedupeCore) Sync() error {
+	c.dedupe.flush(true)
+
+	return c.Core.Sync()
+}
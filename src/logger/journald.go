@@ -0,0 +1,140 @@
+//go:build !windows
+// +build !windows
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The socket the systemd journal accepts entries on using its native protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// A zap core that writes entries to the systemd journal using its native protocol. Every field
+// attached to an entry is sent as its own journal field, so they can be filtered on directly
+// with journalctl (e.g. "journalctl UUID=...").
+type journaldCore struct {
+	zapcore.LevelEnabler
+	conn   *net.UnixConn
+	fields []zapcore.Field
+}
+
+func newJournaldCore(level zapcore.LevelEnabler) (zapcore.Core, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &journaldCore{LevelEnabler: level, conn: conn}, nil
+}
+
+func (c *journaldCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field{}, c.fields...), fields...)
+
+	return &clone
+}
+
+func (c *journaldCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c *journaldCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", e.Message)
+	writeJournalField(&buf, "PRIORITY", strconv.Itoa(journalPriority(e.Level)))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", "sftp-server")
+	if e.Caller.Defined {
+		writeJournalField(&buf, "CODE_FILE", e.Caller.File)
+		writeJournalField(&buf, "CODE_LINE", strconv.Itoa(e.Caller.Line))
+	}
+
+	for k, v := range enc.Fields {
+		value, ok := v.(string)
+		if !ok {
+			b, _ := json.Marshal(v)
+			value = string(b)
+		}
+
+		writeJournalField(&buf, journalFieldName(k), value)
+	}
+
+	_, err := c.conn.Write(buf.Bytes())
+
+	return err
+}
+
+func (c *journaldCore) Sync() error {
+	return nil
+}
+
+// Writes a single field in the journal native format. Values containing a newline must be
+// written with an explicit length rather than being terminated by the newline.
+func writeJournalField(buf *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// Converts a zap field name into a valid journal field name, which may only contain uppercase
+// letters, numbers and underscores, and cannot begin with an underscore or a number.
+func journalFieldName(name string) string {
+	b := []byte(strings.ToUpper(name))
+	for i, c := range b {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			b[i] = '_'
+		}
+	}
+
+	n := strings.TrimLeft(string(b), "_0123456789")
+	if n == "" {
+		return "FIELD"
+	}
+
+	return n
+}
+
+// Returns the syslog priority for a log level, which is what the journal uses.
+func journalPriority(l zapcore.Level) int {
+	switch l {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel, zapcore.PanicLevel:
+		return 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The window the level is raised for when no duration is given.
+const DefaultDebugWindow = 15 * time.Minute
+
+var (
+	// The level shared by every logger that has been configured, which allows it to be changed
+	// while the server is running without replacing the loggers that already exist.
+	level = zap.NewAtomicLevel()
+
+	levelMu sync.Mutex
+	// The level the logger was configured with, which it returns to once a raised level
+	// expires.
+	configuredLevel = zapcore.InfoLevel
+	levelExpires    time.Time
+	levelTimer      *time.Timer
+)
+
+// Sets the level the logger was configured with, cancelling any level that was raised.
+func setConfiguredLevel(l zapcore.Level) {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	configuredLevel = l
+	resetLevel()
+}
+
+// Returns the current level of the logger, along with when it returns to the configured level.
+// The time is zero if the logger is at the level it was configured with.
+func Level() (zapcore.Level, time.Time) {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	return level.Level(), levelExpires
+}
+
+// Returns the level the logger was configured with.
+func ConfiguredLevel() zapcore.Level {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	return configuredLevel
+}
+
+// Changes the level of the logger for the given duration, after which it returns to the level
+// it was configured with. Changing the level again before then replaces the earlier change,
+// including when it expires. This returns the time the level will be reset.
+func SetLevelFor(l zapcore.Level, d time.Duration) time.Time {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	if levelTimer != nil {
+		levelTimer.Stop()
+	}
+
+	level.SetLevel(l)
+	levelExpires = time.Now().Add(d)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		levelMu.Lock()
+		defer levelMu.Unlock()
+
+		// The level could have been changed again just as this fired, in which case the
+		// timer for that change is responsible for resetting it.
+		if levelTimer == timer {
+			resetLevel()
+			Get().Infow("log level returned to the configured level", zap.Stringer("level", configuredLevel))
+		}
+	})
+	levelTimer = timer
+
+	return levelExpires
+}
+
+// Returns the logger to the level it was configured with straight away.
+func ResetLevel() {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	resetLevel()
+}
+
+func resetLevel() {
+	if levelTimer != nil {
+		levelTimer.Stop()
+		levelTimer = nil
+	}
+
+	level.SetLevel(configuredLevel)
+	levelExpires = time.Time{}
+}
+
+// Returns a copy of the logger that also writes debug entries while enabled returns true,
+// regardless of the level of the logger. This allows a single session to be debugged without
+// raising the level for everything else.
+func WithDebug(l *zap.SugaredLogger, enabled func() bool) *zap.SugaredLogger {
+	return l.Desugar().WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &debugCore{Core: c, enabled: enabled}
+	})).Sugar()
+}
+
+// A core that enables debug entries for the core it wraps while enabled returns true. The
+// cores used by the logger write every entry they are given without checking the level again,
+// so the entry only needs to be let through here.
+type debugCore struct {
+	zapcore.Core
+	enabled func() bool
+}
+
+func (c *debugCore) Enabled(l zapcore.Level) bool {
+	return c.Core.Enabled(l) || c.enabled()
+}
+
+func (c *debugCore) With(fields []zapcore.Field) zapcore.Core {
+	return &debugCore{Core: c.Core.With(fields), enabled: c.enabled}
+}
+
+func (c *debugCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Enabled(e.Level) {
+		return c.Core.Check(e, ce)
+	}
+
+	if c.enabled() {
+		return ce.AddCore(e, c.Core)
+	}
+
+	return ce
+}
@@ -1,13 +1,99 @@
 package logger
 
 import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var sugar *zap.SugaredLogger
 
+// Settings control where logs are written, and how log files are rotated.
+type Settings struct {
+	// The file logs are written to, in addition to stdout. If empty logs are only written
+	// to stdout.
+	Path string
+	// The size in megabytes a log file can reach before it is rotated.
+	MaxSize int
+	// The number of days to keep rotated log files for. Zero keeps them regardless of age.
+	MaxAge int
+	// The number of rotated log files to keep. Zero keeps all of them.
+	MaxBackups int
+	// Determines if rotated log files are compressed with gzip.
+	Compress bool
+
+	// When enabled logs are also sent to syslog. If no address is set the local syslog daemon
+	// is used, otherwise it should be in the form "udp://host:514" or "tcp://host:514".
+	Syslog        bool
+	SyslogAddress string
+	SyslogTag     string
+	// When enabled logs are also sent to the systemd journal.
+	Journald bool
+
+	// Every second the first SamplingInitial entries with the same level and message are
+	// written, and then only every SamplingThereafter entry after that. Setting either of them
+	// to zero disables sampling. Sampling is always disabled in debug mode.
+	SamplingInitial    int
+	SamplingThereafter int
+	// Entries identical to one written within this window are suppressed, and counted in the
+	// entry written once the window ends. Zero disables deduplication.
+	DedupeWindow time.Duration
+}
+
+// The settings used when no configuration has been provided.
+var DefaultSettings = Settings{
+	Path:       "./sftp-server.log",
+	MaxSize:    100,
+	MaxAge:     30,
+	MaxBackups: 5,
+	Compress:   true,
+	SyslogTag:  "sftp-server",
+
+	SamplingInitial:    100,
+	SamplingThereafter: 100,
+	DedupeWindow:       time.Minute,
+}
+
+var (
+	registerOnce sync.Once
+	rotation     *lumberjack.Logger
+	dedupes      *dedupe
+)
+
+// Wraps a rotating log file so that it can be used as a zap sink.
+type rotatingSink struct {
+	*lumberjack.Logger
+}
+
+// Sync is a no-op, lumberjack writes directly to the file without buffering.
+func (rotatingSink) Sync() error {
+	return nil
+}
+
 // Creates a logger instance.
-func Initialize(debug bool) (error) {
+func Initialize(debug bool) error {
+	return Configure(debug, DefaultSettings)
+}
+
+// Creates a logger instance that writes to the log file described by the settings, rotating
+// it once it becomes too large. This can be called again to replace an existing logger once
+// the configuration has been loaded.
+func Configure(debug bool, settings Settings) error {
+	var err error
+	registerOnce.Do(func() {
+		err = zap.RegisterSink("rotate", func(*url.URL) (zap.Sink, error) {
+			return rotatingSink{rotation}, nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
 	var cfg = zap.Config{}
 	if debug {
 		cfg = zap.NewDevelopmentConfig()
@@ -15,23 +101,116 @@ func Initialize(debug bool) (error) {
 		cfg = zap.NewProductionConfig()
 	}
 
+	// Every logger shares the same level so that it can be changed at runtime, including for
+	// loggers created from an earlier configuration.
+	setConfiguredLevel(cfg.Level.Level())
+	cfg.Level = level
+
 	cfg.Encoding = "console"
-	cfg.OutputPaths = []string{
-		"stdout",
-		"./sftp-server.log",
+	cfg.OutputPaths = []string{"stdout"}
+
+	// Sampling is applied to the logger once every sink has been added to it below, rather than
+	// only to the outputs built from the configuration.
+	cfg.Sampling = nil
+
+	if settings.Path != "" {
+		// Close the file from any earlier configuration, otherwise it would remain open for
+		// the lifetime of the process.
+		if rotation != nil {
+			rotation.Close()
+		}
+
+		rotation = &lumberjack.Logger{
+			Filename:   settings.Path,
+			MaxSize:    settings.MaxSize,
+			MaxAge:     settings.MaxAge,
+			MaxBackups: settings.MaxBackups,
+			Compress:   settings.Compress,
+		}
+
+		cfg.OutputPaths = append(cfg.OutputPaths, "rotate:")
+	}
+
+	// Any additional sinks are set up before building the logger since they need to be added to
+	// it, but can't be reported until it exists. Failing to connect to one of them is not fatal
+	// since logs are still written to the other outputs.
+	var cores []zapcore.Core
+	var sinkErrors []error
+	if settings.Syslog {
+		if c, err := newSyslogCore(settings.SyslogAddress, settings.SyslogTag, cfg.Level); err != nil {
+			sinkErrors = append(sinkErrors, fmt.Errorf("syslog: %s", err))
+		} else {
+			cores = append(cores, c)
+		}
+	}
+
+	if settings.Journald {
+		if c, err := newJournaldCore(cfg.Level); err != nil {
+			sinkErrors = append(sinkErrors, fmt.Errorf("journald: %s", err))
+		} else {
+			cores = append(cores, c)
+		}
 	}
 
-	logger, err := cfg.Build()
+	// Errors are always passed along to the reporter, which does nothing until one is set.
+	cores = append(cores, &reportCore{})
+
+	// Stop deduplicating entries for any earlier configuration, which writes the count of those
+	// that were repeated.
+	if dedupes != nil {
+		dedupes.close()
+		dedupes = nil
+	}
+
+	if settings.DedupeWindow > 0 {
+		dedupes = newDedupe(settings.DedupeWindow)
+	}
+
+	logger, err := cfg.Build(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		c = zapcore.NewTee(append([]zapcore.Core{c}, cores...)...)
+
+		if !debug && settings.SamplingInitial > 0 && settings.SamplingThereafter > 0 {
+			c = zapcore.NewSampler(c, time.Second, settings.SamplingInitial, settings.SamplingThereafter)
+		}
+
+		if dedupes != nil {
+			c = newDedupeCore(c, dedupes)
+		}
+
+		return c
+	}))
 	if err != nil {
 		return err
 	}
 	defer logger.Sync()
 
 	sugar = logger.Sugar()
+
+	for _, err := range sinkErrors {
+		sugar.Warnw("could not configure log sink", zap.Error(err))
+	}
+
 	return nil
 }
 
+// Returns the encoder configuration used for sinks that receive structured entries.
+func structuredEncoderConfig() zapcore.EncoderConfig {
+	return zap.NewProductionEncoderConfig()
+}
+
+// Replaces the logger used by the SFTP server, for when it is embedded in another process that
+// has its own logger.
+func Set(l *zap.SugaredLogger) {
+	sugar = l
+}
+
 // Returns an instance of the logger defined for the SFTP server.
 func Get() *zap.SugaredLogger {
+	// If the server is embedded without a logger being configured just discard everything,
+	// rather than panicking the first time something is logged.
+	if sugar == nil {
+		return zap.NewNop().Sugar()
+	}
+
 	return sugar
-}
\ No newline at end of file
+}
@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// An entry logged at the error level or above, along with every field attached to it, which is
+// passed to the reporter.
+type Report struct {
+	Entry  zapcore.Entry
+	Fields map[string]interface{}
+}
+
+// The function errors are reported to, which holds a func(Report).
+var reporter atomic.Value
+
+// Sets the function every entry logged at the error level or above is passed to, such as one
+// that sends them somewhere errors are aggregated. Passing nil stops reporting errors. This
+// applies to every logger that has been configured, but not to one replaced with Set.
+func SetReporter(r func(Report)) {
+	reporter.Store(r)
+}
+
+func currentReporter() func(Report) {
+	r, _ := reporter.Load().(func(Report))
+
+	return r
+}
+
+// A zap core that passes entries at the error level or above to the reporter.
+type reportCore struct {
+	fields []zapcore.Field
+}
+
+func (c *reportCore) Enabled(l zapcore.Level) bool {
+	return l >= zapcore.ErrorLevel && currentReporter() != nil
+}
+
+func (c *reportCore) With(fields []zapcore.Field) zapcore.Core {
+	return &reportCore{fields: append(append([]zapcore.Field{}, c.fields...), fields...)}
+}
+
+func (c *reportCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c *reportCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	r := currentReporter()
+	if r == nil || e.Level < zapcore.ErrorLevel {
+		return nil
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	r(Report{Entry: e, Fields: enc.Fields})
+
+	return nil
+}
+
+func (c *reportCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package logger
+
+import (
+	"errors"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func newSyslogCore(address string, tag string, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
+
+func newJournaldCore(level zapcore.LevelEnabler) (zapcore.Core, error) {
+	return nil, errors.New("journald is not supported on windows")
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package logger
+
+import (
+	"log/syslog"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// A zap core that writes entries to syslog. Each entry is encoded as JSON so that all of the
+// structured fields are preserved, and is sent with a priority matching its level.
+type syslogCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	writer  *syslog.Writer
+}
+
+// Creates a core that writes to syslog. If the address is empty the local syslog daemon is
+// used, otherwise the address should be in the form "udp://host:514" or "tcp://host:514".
+func newSyslogCore(address string, tag string, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	var network, raddr string
+	if address != "" {
+		if i := strings.Index(address, "://"); i != -1 {
+			network, raddr = address[:i], address[i+3:]
+		} else {
+			network, raddr = "udp", address
+		}
+	}
+
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogCore{LevelEnabler: level, encoder: zapcore.NewJSONEncoder(structuredEncoderConfig()), writer: w}, nil
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &syslogCore{LevelEnabler: c.LevelEnabler, encoder: c.encoder.Clone(), writer: c.writer}
+	for _, f := range fields {
+		f.AddTo(clone.encoder)
+	}
+
+	return clone
+}
+
+func (c *syslogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c *syslogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	msg := strings.TrimSuffix(buf.String(), "\n")
+	switch e.Level {
+	case zapcore.DebugLevel:
+		return c.writer.Debug(msg)
+	case zapcore.InfoLevel:
+		return c.writer.Info(msg)
+	case zapcore.WarnLevel:
+		return c.writer.Warning(msg)
+	case zapcore.ErrorLevel:
+		return c.writer.Err(msg)
+	case zapcore.DPanicLevel, zapcore.PanicLevel:
+		return c.writer.Crit(msg)
+	default:
+		return c.writer.Emerg(msg)
+	}
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// The first file descriptor systemd passes to an activated process, with any others following
+// on from it.
+const listenFdsStart = 3
+
+// Returns the listeners passed to the process by systemd socket activation, or nothing if the
+// process was not started that way. The environment variables are removed once read so that
+// they are not inherited by anything we go on to start.
+func activationListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	var listeners []net.Listener
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		l, err := fileListener(fd)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return nil, err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// Returns a listener for a socket inherited from the process that started us.
+func fileListener(fd int) (net.Listener, error) {
+	syscall.CloseOnExec(fd)
+
+	f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+	l, err := net.FileListener(f)
+	// The listener holds its own copy of the descriptor, so the original can be closed.
+	f.Close()
+
+	return l, err
+}
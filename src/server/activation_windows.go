@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package server
+
+import "net"
+
+// Socket activation is specific to systemd, so there are never any listeners passed to the
+// process on Windows.
+func activationListeners() ([]net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// How often recorded activity is sent to the Panel. Activity is sent in batches rather than as
+// it happens, since a single upload of a directory can easily be hundreds of operations.
+const activityInterval = 5 * time.Second
+
+// The most activity that is kept waiting to be sent to the Panel. If the Panel can't be reached
+// for long enough to fill this the oldest activity is dropped.
+const maxPendingActivity = 1000
+
+// The names the Panel uses for each type of file event in the activity log for a server.
+var activityEvents = map[string]string{
+	EventUpload: "server:sftp.write",
+	EventDelete: "server:sftp.delete",
+	EventRename: "server:sftp.rename",
+	EventMkdir:  "server:sftp.create-directory",
+}
+
+// A single entry in the activity log of a server, in the format expected by the Panel.
+type activityEntry struct {
+	User      string                 `json:"user,omitempty"`
+	Server    string                 `json:"server"`
+	Event     string                 `json:"event"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	IP        string                 `json:"ip"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// Records changes made to files so that they show up in the activity log for the server in
+// the Panel, the same way changes made through the file manager do.
+type activityReporter struct {
+	url     string
+	token   string
+	client  *http.Client
+	breaker *circuitBreaker
+
+	// Authenticates requests when the handshake with the Panel is enabled.
+	handshake *panelHandshake
+
+	mu      sync.Mutex
+	pending []activityEntry
+}
+
+// Creates a reporter for the Panel defined in the Daemon configuration.
+func newActivityReporter(config []byte, tlsConfig *tls.Config, handshake *panelHandshake) *activityReporter {
+	url, _ := jsonparser.GetString(config, "remote", "base")
+	token, _ := jsonparser.GetString(config, "keys", "[0]")
+
+	return &activityReporter{
+		url:       url,
+		token:     token,
+		client:    newPanelClient(tlsConfig, 10*time.Second),
+		breaker:   newCircuitBreaker(),
+		handshake: handshake,
+	}
+}
+
+// Records a file event that happened during a session, to be sent with the next batch.
+func (a *activityReporter) record(sess *session, e FileEvent) {
+	name, ok := activityEvents[e.Event]
+	if !ok {
+		return
+	}
+
+	var files []interface{}
+	if e.Event == EventRename {
+		files = append(files, map[string]string{"from": e.Path, "to": e.Target})
+	} else {
+		files = append(files, e.Path)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pending = append(a.pending, activityEntry{
+		User:      sess.userUUID,
+		Server:    e.Server,
+		Event:     name,
+		Metadata:  map[string]interface{}{"files": files},
+		IP:        sess.ip,
+		Timestamp: e.Timestamp,
+	})
+
+	if len(a.pending) > maxPendingActivity {
+		a.pending = a.pending[len(a.pending)-maxPendingActivity:]
+	}
+}
+
+// Sends recorded activity to the Panel periodically until the done channel is closed, at which
+// point anything that is still pending is sent.
+func (a *activityReporter) run(done <-chan struct{}) {
+	ticker := time.NewTicker(activityInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			a.flush()
+			return
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// Sends all of the pending activity to the Panel. If the request fails the activity is kept so
+// that it is sent along with the next batch.
+func (a *activityReporter) flush() {
+	a.mu.Lock()
+	entries := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := a.send(entries); err != nil {
+		logger.Get().Warnw("failed to send activity to the panel", zap.Int("entries", len(entries)), zap.Error(err))
+
+		a.mu.Lock()
+		a.pending = append(entries, a.pending...)
+		if len(a.pending) > maxPendingActivity {
+			a.pending = a.pending[len(a.pending)-maxPendingActivity:]
+		}
+		a.mu.Unlock()
+	}
+}
+
+func (a *activityReporter) send(entries []activityEntry) error {
+	if a.url == "" || a.token == "" {
+		return fmt.Errorf("no panel url or token is configured")
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"data": entries})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/remote/activity", a.url), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.pterodactyl.v1+json")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.handshake.do(a.client, a.breaker, req, data, a.token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error response from panel: %s", string(s))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// Starts the admin API, which allows the active sessions on the server to be listed and
+// terminated, individual servers to be made read-only, the traffic for each server to be
+// viewed, debug logging to be enabled for a time, and the host keys to be reloaded. Every request to those endpoints must include the configured admin token as a
+// bearer token. The health check endpoint does not require authentication so that it can be
+// used by monitoring tools.
+func (s *Server) startAdmin() error {
+	listener, err := s.listenTCP(handoverAdmin, s.config.Settings.AdminAddress)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+
+	if s.config.Settings.AdminToken != "" {
+		mux.Handle("/sessions", s.requireAdminToken(http.HandlerFunc(s.handleListSessions)))
+		mux.Handle("/sessions/", s.requireAdminToken(http.HandlerFunc(s.handleTerminateSession)))
+		mux.Handle("/servers/read-only", s.requireAdminToken(http.HandlerFunc(s.handleListReadOnly)))
+		mux.Handle("/servers/transfer", s.requireAdminToken(http.HandlerFunc(s.handleListTransfer)))
+		mux.Handle("/servers/", s.requireAdminToken(http.HandlerFunc(s.handleServer)))
+		mux.Handle("/metrics", s.requireAdminToken(http.HandlerFunc(s.handleMetrics)))
+		mux.Handle("/log-level", s.requireAdminToken(http.HandlerFunc(s.handleLogLevel)))
+		mux.Handle("/debug-targets", s.requireAdminToken(http.HandlerFunc(s.handleDebugTargets)))
+		mux.Handle("/debug-targets/", s.requireAdminToken(http.HandlerFunc(s.handleRemoveDebugTarget)))
+		mux.Handle("/host-keys", s.requireAdminToken(http.HandlerFunc(s.handleHostKeys)))
+	} else {
+		logger.Get().Warnw("session and server endpoints of the admin api are disabled since no token is configured")
+	}
+
+	srv := &http.Server{Handler: mux}
+
+	s.mu.Lock()
+	s.admin = srv
+	s.adminListener = listener
+	s.mu.Unlock()
+
+	logger.Get().Infow("admin api listener registered", zap.String("address", listener.Addr().String()))
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Get().Errorw("admin api closed with error", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// Rejects any request that does not include the admin token.
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.config.Settings.AdminToken)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid authorization token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handles GET /sessions, returning all of the active sessions.
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": s.activeSessions()})
+}
+
+// Handles DELETE /sessions/<id>, disconnecting the session.
+func (s *Server) handleTerminateSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/sessions/")
+	if !s.terminateSession(id) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+
+	logger.Get().Infow("terminated session through admin api", zap.String("session", id))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Routes requests for a single server to the handler for the endpoint.
+func (s *Server) handleServer(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/transfer"):
+		s.handleServerTransfer(w, r)
+	case strings.HasSuffix(r.URL.Path, "/permissions"):
+		s.handleServerPermissions(w, r)
+	default:
+		s.handleServerReadOnly(w, r)
+	}
+}
+
+// Handles GET /health, reporting if the server is accepting connections and is able to reach
+// the Panel. A 503 status is returned if anything is wrong.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	listening := len(s.listeners) > 0
+	sessions := len(s.sessions)
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		listening = false
+	default:
+	}
+
+	status := http.StatusOK
+	resp := map[string]interface{}{
+		"listener": "ok",
+		"panel":    "ok",
+		"sessions": sessions,
+	}
+
+	if !listening {
+		status = http.StatusServiceUnavailable
+		resp["listener"] = "down"
+	}
+
+	// Only authenticators that talk to something remote are able to be checked, anything else
+	// is assumed to always be available.
+	if p, ok := s.auth.(interface{ Ping() error }); ok {
+		if err := p.Ping(); err != nil {
+			status = http.StatusServiceUnavailable
+			resp["panel"] = err.Error()
+		}
+	}
+
+	resp["status"] = "ok"
+	if status != http.StatusOK {
+		resp["status"] = "unhealthy"
+	}
+
+	writeJSON(w, status, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The algorithms supported by the ssh package, which are the only ones that can be enabled. The
+// defaults chosen by the ssh package are used for any list that is not configured, which leave
+// out the CBC and RC4 ciphers.
+var (
+	supportedCiphers = []string{
+		"aes128-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc",
+		"arcfour256", "arcfour128", "arcfour",
+	}
+	supportedKeyExchanges = []string{
+		"curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	}
+	supportedMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+	}
+)
+
+// Checks that every configured cipher, key exchange and MAC algorithm is one that is supported,
+// since a typo would otherwise just leave clients unable to agree on an algorithm.
+func ValidateAlgorithms(ciphers []string, keyExchanges []string, macs []string) error {
+	if err := validateAlgorithms("cipher", ciphers, supportedCiphers); err != nil {
+		return err
+	}
+
+	if err := validateAlgorithms("key exchange", keyExchanges, supportedKeyExchanges); err != nil {
+		return err
+	}
+
+	return validateAlgorithms("mac", macs, supportedMACs)
+}
+
+func validateAlgorithms(kind string, names []string, supported []string) error {
+	for _, n := range names {
+		if !containsString(supported, n) {
+			return fmt.Errorf("unsupported %s algorithm %q, expected one of: %s", kind, n, strings.Join(supported, ", "))
+		}
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+)
+
+// An atomicUpload writes data to a hidden temporary file in the same directory as the file
+// being uploaded, and only moves it into place once the client has finished the upload. This
+// means a dropped connection never leaves a partially written file in place of the original.
+type atomicUpload struct {
+	File
+	fs     *FileSystem
+	key    string
+	target string
+
+	// Set once the client has closed the handle for this upload, indicating that the file
+	// was completely written. This is guarded by the FileSystem lock.
+	committed bool
+
+	// Set if the upload was rejected part way through, in which case it is never moved into
+	// place. This is guarded by the FileSystem lock.
+	discarded bool
+}
+
+// Creates a new temporary file for an atomic upload to the given target path. The mode is
+// applied to the temporary file so that it is correct once the file is moved into place.
+//
+// This must be called while holding the FileSystem lock.
+func (fs *FileSystem) createAtomicUpload(request *sftp.Request, target string, mode os.FileMode) (io.WriterAt, error) {
+	file, err := createTempFile(fs.backend(), filepath.Dir(target), "."+filepath.Base(target)+".sftp-upload-", 0600)
+	if err != nil {
+		fs.log().Errorw("error creating temporary upload file", zap.String("source", target), zap.Error(err))
+		return nil, translateError(err)
+	}
+
+	if err := fs.backend().Chmod(file.Name(), mode); err != nil {
+		fs.log().Warnw("error setting file mode", zap.String("file", file.Name()), zap.Error(err))
+	}
+
+	// Not failing here is intentional. We still made the file, it is just owned incorrectly
+	// and will likely cause some issues.
+	if err := fs.chown(file.Name()); err != nil {
+		fs.log().Warnw("error chowning file", zap.String("file", file.Name()), zap.Error(err))
+	}
+
+	// This upload is starting over, so anything left from an earlier attempt is of no use.
+	fs.removePartialUpload(target)
+
+	return fs.trackAtomicUpload(request, file, target), nil
+}
+
+// Keeps track of an atomic upload writing to the given file until it is closed.
+//
+// This must be called while holding the FileSystem lock.
+func (fs *FileSystem) trackAtomicUpload(request *sftp.Request, file File, target string) *atomicUpload {
+	u := &atomicUpload{
+		File:   file,
+		fs:     fs,
+		key:    uploadKey(request.Filepath),
+		target: target,
+	}
+
+	if fs.uploads == nil {
+		fs.uploads = make(map[string][]*atomicUpload)
+	}
+	fs.uploads[u.key] = append(fs.uploads[u.key], u)
+
+	return u
+}
+
+// Marks the oldest in-progress upload for the given path as complete, which causes the file
+// to be moved into place when it is closed. This is called when the client closes the handle
+// for a file, as opposed to the handle being closed because the connection was dropped.
+func (fs *FileSystem) commitUpload(rawPath string) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	for _, u := range fs.uploads[uploadKey(rawPath)] {
+		if !u.committed {
+			u.committed = true
+			return
+		}
+	}
+}
+
+// Close closes the temporary file and, if the upload was completed, moves it into place. If
+// the upload was not completed the temporary file is removed, unless partial uploads are kept
+// so that it can be resumed.
+func (u *atomicUpload) Close() error {
+	err := u.File.Close()
+
+	u.fs.lock.Lock()
+	committed := u.committed && !u.discarded
+	discarded := u.discarded
+	uploads := u.fs.uploads[u.key]
+	for i, v := range uploads {
+		if v == u {
+			u.fs.uploads[u.key] = append(uploads[:i], uploads[i+1:]...)
+			break
+		}
+	}
+	if len(u.fs.uploads[u.key]) == 0 {
+		delete(u.fs.uploads, u.key)
+	}
+	u.fs.lock.Unlock()
+
+	if err != nil || !committed {
+		if err == nil && !discarded && u.fs.keepPartialUpload(u) {
+			return nil
+		}
+
+		u.fs.log().Debugw("discarding incomplete upload", zap.String("source", u.target), zap.Error(err))
+		u.fs.backend().Remove(u.Name())
+		return err
+	}
+
+	size := u.fs.fileSize(u.Name()) - u.fs.fileSize(u.target)
+	_, statErr := u.fs.backend().Stat(u.target)
+	if err := u.fs.backend().Rename(u.Name(), u.target); err != nil {
+		u.fs.log().Errorw("failed to move completed upload into place",
+			zap.String("source", u.Name()),
+			zap.String("target", u.target),
+			zap.Error(err),
+		)
+		u.fs.backend().Remove(u.Name())
+		return err
+	}
+
+	u.fs.trackUsage(size)
+	if os.IsNotExist(statErr) {
+		u.fs.trackFiles(1)
+	}
+	u.fs.notify(EventUpload, u.target, "")
+	return nil
+}
+
+// Marks this upload as complete, for callers that are managing the writer directly rather
+// than through the SFTP request server.
+func (u *atomicUpload) commit() {
+	u.fs.lock.Lock()
+	u.committed = true
+	u.fs.lock.Unlock()
+}
+
+// Marks this upload as failed so that the temporary file is removed when it is closed, rather
+// than being moved into place.
+func (u *atomicUpload) discard() {
+	u.fs.lock.Lock()
+	u.discarded = true
+	u.fs.lock.Unlock()
+}
+
+// Sets the access and modification times of a file.
+func (fs *FileSystem) setTimes(rawPath string, atime time.Time, mtime time.Time) error {
+	p, err := fs.buildPath(rawPath)
+	if err != nil {
+		return err
+	}
+
+	return fs.applyAttributes(rawPath, p, func(name string) error {
+		return fs.backend().Chtimes(name, atime, mtime)
+	})
+}
+
+// Applies a change to the attributes of a file. If the file is being uploaded atomically the
+// change is applied to the temporary file as well, since it replaces the file once the upload
+// is finished and the change would otherwise be lost. The file itself doesn't need to exist
+// yet in that case.
+func (fs *FileSystem) applyAttributes(rawPath string, p string, apply func(name string) error) error {
+	fs.lock.Lock()
+	var pending []string
+	for _, u := range fs.uploads[uploadKey(rawPath)] {
+		pending = append(pending, u.Name())
+	}
+	fs.lock.Unlock()
+
+	for _, name := range pending {
+		if err := apply(name); err != nil {
+			return err
+		}
+	}
+
+	if err := apply(p); err != nil && !(len(pending) > 0 && os.IsNotExist(err)) {
+		return err
+	}
+
+	return nil
+}
+
+// Returns the key used to track uploads for a path, matching the cleaning pkg/sftp performs
+// on request paths.
+func uploadKey(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
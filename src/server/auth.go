@@ -0,0 +1,324 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"golang.org/x/crypto/ssh"
+)
+
+// An Authenticator validates the credentials a user provides when connecting and determines
+// which server they are connecting to, along with what they are able to do on it. The Panel is
+// used by default, but any other source of users can be used by providing an alternative
+// implementation.
+type Authenticator interface {
+	// Validates the username and password, returning an error if they are not valid.
+	Authenticate(user string, pass []byte) (*AuthenticationResponse, error)
+}
+
+// AuthenticatorFunc allows an ordinary function to be used as an Authenticator.
+type AuthenticatorFunc func(user string, pass []byte) (*AuthenticationResponse, error)
+
+// Authenticate calls f(user, pass).
+func (f AuthenticatorFunc) Authenticate(user string, pass []byte) (*AuthenticationResponse, error) {
+	return f(user, pass)
+}
+
+// Implemented by authenticators that define the servers they serve themselves, rather than them
+// being the directories within the data path, so that work done on the files of every server,
+// such as purging the trash, covers all of them.
+type serverDirectoryProvider interface {
+	// Returns the directory of every server by its UUID, which is empty for a server that uses
+	// the directory for it within the data path.
+	serverDirectories() map[string]string
+}
+
+// An UnavailableError is returned by an Authenticator when it was unable to determine if the
+// credentials are valid, such as when the Panel can't be reached, as opposed to the credentials
+// being rejected.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+type AuthenticationRequest struct {
+	User string `json:"username"`
+	Pass string `json:"password"`
+}
+
+type AuthenticationResponse struct {
+	Server         string   `json:"server"`
+	Token          string   `json:"token"`
+	Permissions    []string `json:"permissions"`
+	ProtectedPaths []string `json:"protected_paths"`
+	FileMode       string   `json:"file_mode"`
+	DirectoryMode  string   `json:"directory_mode"`
+	// The UUID of the Panel user the credentials belong to, if the Panel provides it.
+	User string `json:"user"`
+	// Set if files for the server should not be modified, such as while it is being
+	// transferred to another node.
+	ReadOnly bool `json:"read_only"`
+	// The maximum number of files and directories the server can contain, overriding the
+	// limit set for the node if it is greater than zero.
+	FileLimit int64 `json:"file_limit"`
+	// The maximum number of simultaneous sessions the user can have open, overriding the limit
+	// set for the node if it is greater than zero.
+	MaxSessions int `json:"max_sessions"`
+	// The name of the server, which can be shown to the user once they have logged in.
+	ServerName string `json:"server_name"`
+	// Set if the user has two factor authentication enabled, in which case they must also
+	// provide a code before they are logged in.
+	TwoFactor bool `json:"two_factor"`
+	// The addresses the server can be accessed from, as IP addresses or CIDR ranges. The server
+	// can be accessed from anywhere if there are none.
+	AllowedIPs []string `json:"allowed_ips"`
+	// Patterns of file names that cannot be uploaded to the server, such as those set by the
+	// egg for the server, in addition to those blocked for the node.
+	BlockedFiles []string `json:"blocked_files"`
+	// Which entries are hidden from directory listings for the server, overriding the mode set
+	// for the node if it is one of "none", "internal" or "dotfiles".
+	HideFiles string `json:"hide_files"`
+	// The user the container of the server runs as, as "uid:gid" or just "uid", which files
+	// created for the server are owned by.
+	ContainerUser string `json:"container_user"`
+	// The directory the files for the server are stored in, which defaults to the directory for
+	// the server within the data path. This can't be set by the Panel, only by authenticators
+	// that define the servers themselves.
+	Directory string `json:"-"`
+}
+
+// Determines if the server can be accessed from the given address. Anything that is not a valid
+// address or range is ignored, so a list containing nothing valid allows no addresses at all.
+func (r *AuthenticationResponse) allowsIP(ip string) bool {
+	if len(r.AllowedIPs) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, a := range r.AllowedIPs {
+		if !strings.Contains(a, "/") {
+			if allowed := net.ParseIP(a); allowed != nil && allowed.Equal(addr) {
+				return true
+			}
+			continue
+		}
+
+		if _, n, err := net.ParseCIDR(a); err == nil && n.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Converts the response into the permissions attached to the SSH connection, which are what
+// the handler for the connection is created from.
+func (r *AuthenticationResponse) permissions(user string) *ssh.Permissions {
+	p := &ssh.Permissions{}
+	p.Extensions = make(map[string]string)
+	p.Extensions["uuid"] = r.Server
+	p.Extensions["user"] = user
+	p.Extensions["user_uuid"] = r.User
+	p.Extensions["permissions"] = strings.Join(r.Permissions, ",")
+	p.Extensions["protected_paths"] = strings.Join(r.ProtectedPaths, "\n")
+	p.Extensions["blocked_files"] = strings.Join(r.BlockedFiles, "\n")
+	p.Extensions["hide_files"] = r.HideFiles
+	p.Extensions["container_user"] = r.ContainerUser
+	p.Extensions["file_mode"] = r.FileMode
+	p.Extensions["directory_mode"] = r.DirectoryMode
+	p.Extensions["read_only"] = strconv.FormatBool(r.ReadOnly)
+	p.Extensions["file_limit"] = strconv.FormatInt(r.FileLimit, 10)
+	p.Extensions["max_sessions"] = strconv.Itoa(r.MaxSessions)
+	p.Extensions["server_name"] = r.ServerName
+	p.Extensions["directory"] = r.Directory
+
+	return p
+}
+
+// Validates credentials against the Pterodactyl Panel.
+type PanelAuthenticator struct {
+	// The base URL of the Panel.
+	URL string
+	// The token used to authenticate this node with the Panel.
+	Token string
+	// The client used to make requests. If not set a client with a ten second timeout is used.
+	Client *http.Client
+
+	// Authenticates requests with the session token from a handshake with the Panel, rather
+	// than the static token. This is only set when the handshake is enabled.
+	handshake *panelHandshake
+
+	// Stops requests to the Panel while it is down. Authenticators that are not created by
+	// NewPanelAuthenticator do not have one, and always make the request.
+	breaker *circuitBreaker
+
+	// The correlation ID sent with every request, which is only set on the copies of the
+	// authenticator made for a connection.
+	correlationID string
+}
+
+// Creates an authenticator for the Panel defined in the Daemon configuration.
+func NewPanelAuthenticator(config []byte) *PanelAuthenticator {
+	url, _ := jsonparser.GetString(config, "remote", "base")
+	token, _ := jsonparser.GetString(config, "keys", "[0]")
+
+	return &PanelAuthenticator{URL: url, Token: token, breaker: newCircuitBreaker()}
+}
+
+// Creates the authenticator used when none is provided, which validates credentials against the
+// Panel using the TLS configuration from the settings.
+func newDefaultAuthenticator(c Configuration, handshake *panelHandshake) *PanelAuthenticator {
+	a := NewPanelAuthenticator(c.Data)
+	a.Client = newPanelClient(c.Settings.PanelTLS, 10*time.Second)
+	a.handshake = handshake
+
+	return a
+}
+
+// Determines if the Panel can be reached. Any response from the Panel is enough to know that it
+// is reachable, it does not need to be successful.
+func (a *PanelAuthenticator) Ping() error {
+	if a.URL == "" {
+		return fmt.Errorf("no panel url is configured")
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	if a.Client != nil {
+		client.Transport = a.Client.Transport
+	}
+
+	resp, err := client.Get(a.URL)
+	if err != nil {
+		return panelRequestError(err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Determines if the Panel accepts the node token, by attempting to validate credentials that
+// can't exist. The Panel rejects the token itself before looking at the credentials.
+func (a *PanelAuthenticator) VerifyToken() error {
+	if a.Token == "" {
+		return fmt.Errorf("no panel token is configured")
+	}
+
+	resp, err := a.post("/api/remote/sftp", AuthenticationRequest{User: "sftp-server-diagnose", Pass: ""})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errTokenRejected
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("error response from panel with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Determines if credentials are currently unable to be validated because the Panel has been
+// failing to respond.
+func (a *PanelAuthenticator) unavailable() bool {
+	return a.breaker.open()
+}
+
+// Validates a set of credentials for a SFTP login aganist Pterodactyl Panel and returns
+// the server's UUID if the credentials were valid.
+func (a *PanelAuthenticator) Authenticate(user string, pass []byte) (*AuthenticationResponse, error) {
+	if a.URL == "" || a.Token == "" {
+		return nil, fmt.Errorf("no panel url or token is configured")
+	}
+
+	resp, err := a.post("/api/remote/sftp", AuthenticationRequest{User: user, Pass: string(pass)})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return decodeAuthenticationResponse(resp)
+}
+
+// Reads the response from the Panel to a login, returning an error if the login was rejected.
+func decodeAuthenticationResponse(resp *http.Response) (*AuthenticationResponse, error) {
+	if resp.StatusCode != http.StatusOK {
+		s, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("bad credentials provided: %s", string(s))
+		}
+
+		if resp.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("server in bad state, SFTP denied, %s", string(s))
+		}
+
+		err := fmt.Errorf("error response from server: %s", string(s))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, &UnavailableError{Err: err}
+		}
+
+		return nil, err
+	}
+
+	j := &AuthenticationResponse{}
+	json.NewDecoder(resp.Body).Decode(j)
+
+	return j, nil
+}
+
+// Sends a request to an endpoint of the Panel with the body encoded as JSON. Errors returned
+// when the Panel could not be reached are an UnavailableError.
+func (a *PanelAuthenticator) post(path string, body interface{}) (*http.Response, error) {
+	data, _ := json.Marshal(body)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s%s", a.URL, path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.pterodactyl.v1+json")
+	req.Header.Set("Content-Type", "application/json")
+	if a.correlationID != "" {
+		req.Header.Set(correlationHeader, a.correlationID)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := a.handshake.do(client, a.breaker, req, data, a.Token)
+	if err != nil {
+		// The Panel rejecting the node, or a response failing verification, are not going to
+		// be fixed by falling back to cached credentials.
+		var rejected *handshakeError
+		if err == errInvalidSignature || errors.As(err, &rejected) {
+			return nil, err
+		}
+
+		return nil, &UnavailableError{Err: err}
+	}
+
+	return resp, nil
+}
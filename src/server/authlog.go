@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Writes a line for every failed login to a dedicated file, in a format that is meant to stay
+// the same between releases so that tools such as fail2ban can act on it:
+//
+//	2019-03-02T15:04:05Z authentication failure from 203.0.113.24 user="dane.d5a35a3e" method=password
+//
+// The username is quoted, since it is chosen by the client and could otherwise be used to
+// write something that looks like a failure from a different address.
+type authLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openAuthLog(path string) (*authLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("could not open authentication log: %w", err)
+	}
+
+	return &authLog{file: f}, nil
+}
+
+// Records a failed login. This does nothing if there is no authentication log.
+func (l *authLog) failure(ip string, user string, method string) {
+	if l == nil {
+		return
+	}
+
+	line := fmt.Sprintf("%s authentication failure from %s user=%s method=%s\n",
+		time.Now().UTC().Format(time.RFC3339), ip, strconv.QuoteToASCII(user), method)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.file.WriteString(line)
+}
+
+func (l *authLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// A Backend provides the storage that the files for a server live on. All of the paths passed
+// to a backend have already been resolved and validated by the FileSystem as being within the
+// server directory, so a backend does not need to perform any permission or containment checks
+// of its own.
+//
+// By default files are stored on the local disk, but any storage that can implement these
+// operations, such as object storage or a remote volume, can be used in its place.
+type Backend interface {
+	// Opens a file for reading.
+	Open(name string) (File, error)
+	// Opens a file with the given flags, creating it with the given mode if O_CREATE is passed.
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	// Returns information about a file, following any symlinks.
+	Stat(name string) (os.FileInfo, error)
+	// Returns information about a file without following symlinks.
+	Lstat(name string) (os.FileInfo, error)
+	// Returns the contents of a directory.
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Creates a single directory, failing if it already exists.
+	Mkdir(name string, perm os.FileMode) error
+	// Creates a directory along with any parents that do not exist.
+	MkdirAll(name string, perm os.FileMode) error
+	Rename(oldpath string, newpath string) error
+	// Removes a file or an empty directory.
+	Remove(name string) error
+	// Removes a file or directory along with everything in it.
+	RemoveAll(name string) error
+	Chmod(name string, mode os.FileMode) error
+	Chown(name string, uid int, gid int) error
+	// Sets the access and modification times of a file.
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Symlink(oldname string, newname string) error
+	Link(oldname string, newname string) error
+	// Returns the path after resolving any symlinks within it. If the path does not exist an
+	// error satisfying os.IsNotExist must be returned.
+	EvalSymlinks(name string) (string, error)
+}
+
+// A File is an open file returned by a Backend.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+}
+
+// A DirReader is implemented by backends that can read the entries of a directory a few at a
+// time, rather than all at once. Listing a directory over SFTP uses this when it is available,
+// so that directories with hundreds of thousands of files don't need to be held in memory.
+type DirReader interface {
+	OpenDir(name string) (Dir, error)
+}
+
+// A Dir is a directory opened for reading its entries.
+type Dir interface {
+	// Returns up to n entries of the directory, without following symlinks, or io.EOF once
+	// there are none left. Entries are returned in no particular order.
+	Readdir(n int) ([]os.FileInfo, error)
+	Close() error
+}
+
+// The default backend, which stores files on the local disk.
+type osBackend struct{}
+
+func (osBackend) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osBackend) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osBackend) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osBackend) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+func (osBackend) OpenDir(name string) (Dir, error) {
+	return os.Open(name)
+}
+
+func (osBackend) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osBackend) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osBackend) Rename(oldpath string, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osBackend) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osBackend) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (osBackend) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osBackend) Chown(name string, uid int, gid int) error {
+	return chownPath(name, uid, gid)
+}
+
+func (osBackend) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (osBackend) Symlink(oldname string, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (osBackend) Link(oldname string, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osBackend) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+// Returns the backend files for this server are stored on, defaulting to the local disk.
+func (fs *FileSystem) backend() Backend {
+	if fs.Backend == nil {
+		return defaultBackend(fs.Directory)
+	}
+
+	return fs.Backend
+}
+
+// Returns a random suffix for creating temporary files and directories.
+func tempSuffix() string {
+	return strconv.FormatUint(uint64(rand.Int63()), 36)
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// Creates a new file in the directory with a name beginning with the prefix and ending in a
+// random string, in the same manner as ioutil.TempFile.
+func createTempFile(b Backend, dir string, prefix string, perm os.FileMode) (File, error) {
+	var err error
+	for i := 0; i < 10000; i++ {
+		var f File
+		f, err = b.OpenFile(filepath.Join(dir, prefix+tempSuffix()), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+
+	return nil, err
+}
+
+// Creates a new directory in the parent with a name beginning with the prefix and ending in a
+// random string, in the same manner as ioutil.TempDir.
+func createTempDir(b Backend, dir string, prefix string, perm os.FileMode) (string, error) {
+	var err error
+	for i := 0; i < 10000; i++ {
+		name := filepath.Join(dir, prefix+tempSuffix())
+		if err = b.Mkdir(name, perm); !os.IsExist(err) {
+			return name, err
+		}
+	}
+
+	return "", err
+}
+
+// Walks the tree rooted at the given path, calling fn for every file and directory in it,
+// including the root. This behaves the same as filepath.Walk, except that it works with any
+// backend. Symlinks are not followed.
+func walk(b Backend, root string, fn filepath.WalkFunc) error {
+	info, err := b.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = walkPath(b, root, info, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+
+	return err
+}
+
+func walkPath(b Backend, p string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(p, info, nil)
+	}
+
+	files, err := b.ReadDir(p)
+	if err := fn(p, info, err); err != nil || files == nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := walkPath(b, filepath.Join(p, f.Name()), f, fn); err != nil {
+			if !f.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The number of the openat2 system call, which is the same on every architecture other than
+// MIPS since it was added after the system call numbers were unified. It is not defined by
+// the syscall package since that is frozen.
+const sysOpenat2 = 437
+
+// Stops openat2 from resolving a path to anywhere outside of the directory it is relative to,
+// whether that is through "..", an absolute symlink or a symlink that escapes the directory.
+const resolveBeneath = 0x08
+
+// Stops openat2 from following the magic links in /proc, which could otherwise point anywhere.
+const resolveNoMagiclinks = 0x02
+
+// The open_how struct passed to openat2.
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+var beneathOnce sync.Once
+var beneathOk bool
+
+// Determines if the kernel supports openat2, which was added in Linux 5.6. Containers running
+// under a seccomp profile that doesn't know about it may also refuse the call.
+func beneathSupported() bool {
+	beneathOnce.Do(func() {
+		fd, err := openat2(-100 /* AT_FDCWD */, ".", syscall.O_RDONLY|syscall.O_DIRECTORY, 0, resolveBeneath)
+		if err == nil {
+			syscall.Close(fd)
+			beneathOk = true
+		}
+	})
+
+	return beneathOk
+}
+
+func openat2(dirfd int, name string, flag int, mode uint32, resolve uint64) (int, error) {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return -1, err
+	}
+
+	how := openHow{flags: uint64(flag | syscall.O_CLOEXEC), resolve: resolve}
+	// The kernel rejects a mode unless a file could be created by the call.
+	if flag&syscall.O_CREAT != 0 {
+		how.mode = uint64(mode)
+	}
+
+	for {
+		fd, _, errno := syscall.Syscall6(sysOpenat2, uintptr(dirfd), uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+		// Resolution is retried by the kernel a few times if a rename happens while it is
+		// running, and fails with EAGAIN if it still could not be sure nothing escaped.
+		if errno == syscall.EINTR || errno == syscall.EAGAIN {
+			continue
+		} else if errno != 0 {
+			return -1, errno
+		}
+
+		return int(fd), nil
+	}
+}
+
+// A backend that stores files on the local disk the same as the default one does, except that
+// files are opened with openat2 relative to the server directory. The paths passed in have
+// already been checked, but a symlink could be swapped in between that check and the file
+// being opened. This makes the kernel itself refuse to open anything outside of the server
+// directory rather than relying on the check alone.
+type beneathBackend struct {
+	osBackend
+
+	root string
+}
+
+// Returns the backend used when no other one is configured, which confines files being opened
+// to the server directory if the kernel supports it.
+func defaultBackend(root string) Backend {
+	if root != "" && beneathSupported() {
+		return beneathBackend{root: root}
+	}
+
+	return osBackend{}
+}
+
+func (b beneathBackend) Open(name string) (File, error) {
+	return b.open(name, os.O_RDONLY, 0)
+}
+
+func (b beneathBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return b.open(name, flag, perm)
+}
+
+func (b beneathBackend) OpenDir(name string) (Dir, error) {
+	return b.open(name, os.O_RDONLY, 0)
+}
+
+func (b beneathBackend) ReadDir(name string) ([]os.FileInfo, error) {
+	f, err := b.open(name, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdir(-1)
+}
+
+func (b beneathBackend) open(name string, flag int, perm os.FileMode) (*os.File, error) {
+	rel, err := filepath.Rel(b.root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EXDEV}
+	}
+
+	root, err := syscall.Open(b.root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: b.root, Err: err}
+	}
+	defer syscall.Close(root)
+
+	fd, err := openat2(root, rel, flag, syscallMode(perm), resolveBeneath|resolveNoMagiclinks)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// Converts a file mode to the mode bits expected by the kernel.
+func syscallMode(perm os.FileMode) uint32 {
+	m := uint32(perm.Perm())
+	if perm&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if perm&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if perm&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+
+	return m
+}
@@ -0,0 +1,15 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+// +build !linux mips mipsle mips64 mips64le
+
+package server
+
+// Files can only be confined to the server directory by the kernel on Linux, everywhere else
+// relies on the paths being checked before they are used.
+func beneathSupported() bool {
+	return false
+}
+
+// Returns the backend used when no other one is configured.
+func defaultBackend(root string) Backend {
+	return osBackend{}
+}
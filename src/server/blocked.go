@@ -0,0 +1,51 @@
+package server
+
+import (
+	"path"
+	"strings"
+)
+
+// Normalizes a list of blocked file name patterns, dropping any empty entries. Patterns that
+// contain a slash are treated as paths relative to the root of the server directory, the same
+// as protected paths, while anything else is matched against the name of the file alone.
+func normalizeBlockedFiles(patterns []string) []string {
+	var out []string
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+
+		if strings.Contains(p, "/") {
+			p = path.Clean("/" + p)
+		}
+
+		out = append(out, p)
+	}
+
+	return out
+}
+
+// Determines if the given path (as returned by buildPath) matches one of the blocked file
+// patterns, in which case nothing can be uploaded or moved to it. Matching ignores case so that
+// a pattern like "*.sh" can't be avoided by uploading "install.SH" instead.
+func (fs *FileSystem) isBlocked(p string) bool {
+	if len(fs.BlockedFiles) == 0 {
+		return false
+	}
+
+	rel := strings.ToLower(fs.relativePath(p))
+	name := path.Base(rel)
+	for _, pattern := range fs.BlockedFiles {
+		try := name
+		if strings.HasPrefix(pattern, "/") {
+			try = rel
+		}
+
+		if ok, _ := path.Match(pattern, try); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+func TestBlockedFiles(t *testing.T) {
+	fs := newTestFileSystem(t)
+	fs.BlockedFiles = normalizeBlockedFiles([]string{"*.sh"})
+	writeTestFile(t, fs, "server.jar", 0)
+	writeTestFile(t, fs, "scripts/start.txt", 0)
+
+	symlink := sftp.NewRequest("Symlink", "/server.jar")
+	symlink.Target = "/start.sh"
+	assertCopyError(t, "Symlink", fs.Filecmd(symlink), errFileBlocked)
+	assertNotCopied(t, fs, "start.sh")
+
+	link := sftp.NewRequest("Link", "/server.jar")
+	link.Target = "/start.SH"
+	assertCopyError(t, "Link", fs.Filecmd(link), errFileBlocked)
+	assertNotCopied(t, fs, "start.SH")
+
+	assertCopyError(t, "copy file", fs.copyFile("/server.jar", "/start.sh", false), errFileBlocked)
+	assertNotCopied(t, fs, "start.sh")
+
+	// Blocked files within a directory are left out of the copy.
+	writeTestFile(t, fs, "scripts/install.sh", 0)
+	assertCopyError(t, "copy directory", fs.copyFile("/scripts", "/scripts-copy", false), sftp.ErrSshFxOk)
+	assertNotCopied(t, fs, "scripts-copy/install.sh")
+	if _, err := os.Stat(filepath.Join(fs.Directory, "scripts-copy", "start.txt")); err != nil {
+		t.Errorf("start.txt was not copied: %s", err)
+	}
+}
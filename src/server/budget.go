@@ -0,0 +1,170 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+)
+
+// How long a session that has gone over its budget is given to receive the error for the
+// request that did so before it is disconnected.
+const budgetDisconnectDelay = time.Second
+
+var (
+	errTooManyOpenFiles   = errors.New("too many files are open in this session")
+	errSessionMemoryLimit = errors.New("too much memory is in use by this session")
+)
+
+// Limits the number of files a session can have open at once, across all of its channels, and
+// the memory set aside for buffering them. Without this a single client opening thousands of
+// files could use up the file descriptors of the whole process. A session that goes over either
+// limit is disconnected, since no well behaved client needs anywhere near that many.
+type sessionBudget struct {
+	maxHandles int64
+	maxMemory  int64
+
+	// The files currently open and the memory reserved for them. These must be accessed
+	// atomically.
+	handles int64
+	memory  int64
+
+	exceeded sync.Once
+}
+
+// Returns the budget for a session, or nil if there are no limits to enforce.
+func newSessionBudget(maxHandles int64, maxMemory int64) *sessionBudget {
+	if maxHandles <= 0 && maxMemory <= 0 {
+		return nil
+	}
+
+	return &sessionBudget{maxHandles: maxHandles, maxMemory: maxMemory}
+}
+
+// Reserves a file handle and the given amount of memory for a file about to be opened. If this
+// puts the session over either limit nothing is reserved, and the session is disconnected.
+func (b *sessionBudget) acquire(sess *session, memory int64) error {
+	if b == nil {
+		return nil
+	}
+
+	handles := atomic.AddInt64(&b.handles, 1)
+	reserved := atomic.AddInt64(&b.memory, memory)
+
+	var err error
+	if b.maxHandles > 0 && handles > b.maxHandles {
+		err = errTooManyOpenFiles
+	} else if b.maxMemory > 0 && reserved > b.maxMemory {
+		err = errSessionMemoryLimit
+	}
+
+	if err != nil {
+		b.release(memory)
+		b.exceeded.Do(func() {
+			sess.log.Warnw("disconnecting session that exceeded its budget",
+				zap.Int64("open_files", handles),
+				zap.Int64("max_open_files", b.maxHandles),
+				zap.Int64("memory", reserved),
+				zap.Int64("max_memory", b.maxMemory),
+				zap.Error(err),
+			)
+
+			time.AfterFunc(budgetDisconnectDelay, func() {
+				sess.conn.Close()
+			})
+		})
+	}
+
+	return err
+}
+
+// Returns a file handle and its memory to the budget once the file has been closed.
+func (b *sessionBudget) release(memory int64) {
+	if b == nil {
+		return
+	}
+
+	atomic.AddInt64(&b.handles, -1)
+	atomic.AddInt64(&b.memory, -memory)
+}
+
+// Wraps the handlers for an SFTP channel so that every file opened is counted against the
+// budget of the session until the client closes it.
+type budgetedHandlers struct {
+	timedHandlers
+}
+
+func (h budgetedHandlers) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	memory := h.fs.readAheadMemory()
+	if err := h.sess.budget.acquire(h.sess, memory); err != nil {
+		return nil, err
+	}
+
+	r, err := h.timedHandlers.Fileread(request)
+	if err != nil {
+		h.sess.budget.release(memory)
+		return r, err
+	}
+
+	return &budgetedReader{ReaderAt: r, release: h.releaser(memory)}, nil
+}
+
+func (h budgetedHandlers) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	if err := h.sess.budget.acquire(h.sess, 0); err != nil {
+		return nil, err
+	}
+
+	w, err := h.timedHandlers.Filewrite(request)
+	if err != nil {
+		h.sess.budget.release(0)
+		return w, err
+	}
+
+	return &budgetedWriter{WriterAt: w, release: h.releaser(0)}, nil
+}
+
+// Returns a function that releases a file from the budget, no matter how many times it is
+// called.
+func (h budgetedHandlers) releaser(memory int64) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			h.sess.budget.release(memory)
+		})
+	}
+}
+
+type budgetedReader struct {
+	io.ReaderAt
+	release func()
+}
+
+func (r *budgetedReader) Close() error {
+	defer r.release()
+
+	if c, ok := r.ReaderAt.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+type budgetedWriter struct {
+	io.WriterAt
+	release func()
+}
+
+func (w *budgetedWriter) Close() error {
+	defer w.release()
+
+	if c, ok := w.WriterAt.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
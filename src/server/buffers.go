@@ -0,0 +1,34 @@
+package server
+
+import "sync"
+
+// Pools of the buffers used for transfers, keyed by their size. Every session on a node uses the
+// same buffer size, so in practice there is only one pool, but keying them means a buffer is
+// never handed out with a different size than the one asked for.
+var bufferPools sync.Map
+
+// Returns a buffer of the given size from the pool, allocating one if there are none free. The
+// buffer is passed around as a pointer so that putting it back doesn't allocate, and must be
+// returned with putBuffer once it is no longer being used.
+func getBuffer(size int) *[]byte {
+	p, ok := bufferPools.Load(size)
+	if !ok {
+		p, _ = bufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, size)
+				return &b
+			},
+		})
+	}
+
+	return p.(*sync.Pool).Get().(*[]byte)
+}
+
+// Returns a buffer to the pool it came from so that it can be used again. Nothing can use the
+// buffer after this is called.
+func putBuffer(b *[]byte) {
+	if p, ok := bufferPools.Load(cap(*b)); ok {
+		*b = (*b)[:cap(*b)]
+		p.(*sync.Pool).Put(b)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The amount of data copied by each iteration of the copy benchmarks, which is about the size
+// of a typical plugin or world region file.
+const benchmarkFileSize = 4 * 1024 * 1024
+
+// Copies a file the way transfers did before buffers were pooled, allocating a new buffer for
+// every copy.
+func BenchmarkCopyUnpooled(b *testing.B) {
+	fs := &FileSystem{TransferBufferSize: 256 * 1024}
+	data := make([]byte, benchmarkFileSize)
+
+	b.ReportAllocs()
+	b.SetBytes(benchmarkFileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := make([]byte, fs.transferBufferSize())
+		if _, err := io.CopyBuffer(ioutil.Discard, onlyReader{bytes.NewReader(data)}, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCopyPooled(b *testing.B) {
+	fs := &FileSystem{TransferBufferSize: 256 * 1024}
+	data := make([]byte, benchmarkFileSize)
+
+	b.ReportAllocs()
+	b.SetBytes(benchmarkFileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.copy(ioutil.Discard, onlyReader{bytes.NewReader(data)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Reads a file sequentially in the 32KB requests most SFTP clients send, with the blocks being
+// read ahead of them coming from the pool.
+func BenchmarkReadAhead(b *testing.B) {
+	p := filepath.Join(b.TempDir(), "file")
+	if err := ioutil.WriteFile(p, make([]byte, benchmarkFileSize), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	fs := &FileSystem{TransferBufferSize: 256 * 1024, ReadAhead: 4}
+	buf := make([]byte, 32*1024)
+
+	b.ReportAllocs()
+	b.SetBytes(benchmarkFileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(p)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		r := fs.readAhead(f)
+		for off := int64(0); off < benchmarkFileSize; off += int64(len(buf)) {
+			if _, err := r.ReadAt(buf, off); err != nil && err != io.EOF {
+				b.Fatal(err)
+			}
+		}
+		r.(io.Closer).Close()
+	}
+}
+
+// Hides any WriterTo implementation so that copies go through the buffer, the same as they do
+// when reading from a file or a channel.
+type onlyReader struct {
+	io.Reader
+}
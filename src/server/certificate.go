@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// A CertificateAuthenticator is implemented by authenticators that are able to log users in
+// with an SSH certificate signed by one of the trusted certificate authorities. The certificate
+// has already been validated by the time it is passed along, so the authenticator only needs to
+// determine what the user is able to access.
+type CertificateAuthenticator interface {
+	AuthenticateCertificate(user string, principal string, cert *ssh.Certificate) (*AuthenticationResponse, error)
+}
+
+type CertificateRequest struct {
+	User      string `json:"username"`
+	Principal string `json:"principal"`
+	KeyID     string `json:"key_id"`
+	Serial    uint64 `json:"serial"`
+}
+
+// Reads the public keys of the certificate authorities that are trusted to sign user
+// certificates from a file in the authorized_keys format.
+func LoadCertificateAuthorities(path string) ([]ssh.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read certificate authorities: %w", err)
+	}
+
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(b)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate authorities: %w", err)
+		}
+
+		keys = append(keys, key)
+		b = rest
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+
+	return keys, nil
+}
+
+// Validates the certificates presented by users against the trusted certificate authorities.
+func (s *Server) certChecker() *ssh.CertChecker {
+	return &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			for _, k := range s.config.Settings.CertificateAuthorities {
+				if bytes.Equal(k.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+
+			return false
+		},
+	}
+}
+
+// Handles public key authentication, which is only supported for users presenting a certificate
+// signed by one of the trusted certificate authorities. A certificate is accepted if one of its
+// principals is either the full username being logged in with, or the Panel username that comes
+// before the server identifier, such as "dane" for "dane.d5a35a3e". Certificates without any
+// principals are rejected, rather than being valid for every user.
+func (s *Server) authenticateCertificate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("only certificates are accepted for public key authentication")
+	}
+
+	if len(cert.ValidPrincipals) == 0 {
+		return nil, errors.New("certificate does not have any principals")
+	}
+
+	if !s.limiter.userAllowed(conn.User(), 0) {
+		return nil, errors.New("too many active sessions for user")
+	}
+
+	checker := s.certChecker()
+
+	var principal string
+	var err error
+	for _, p := range certificatePrincipals(conn.User()) {
+		if _, err = checker.Authenticate(principalConn{conn, p}, cert); err == nil {
+			principal = p
+			break
+		}
+	}
+
+	if principal == "" {
+		logger.Get().Debugw("failed to validate certificate", zap.String("session", correlationID(conn)), zap.String("user", conn.User()), zap.String("key_id", cert.KeyId), zap.Error(err))
+		s.loginFailed(conn, "certificate", err)
+		return nil, errors.New("could not validate certificate")
+	}
+
+	a, ok := s.authenticatorFor(conn).(CertificateAuthenticator)
+	if !ok {
+		return nil, errors.New("authenticator does not support certificates")
+	}
+
+	resp, err := a.AuthenticateCertificate(conn.User(), principal, cert)
+	if err != nil {
+		logger.Get().Debugw("failed to validate credentials", zap.String("session", correlationID(conn)), zap.String("user", conn.User()), zap.String("key_id", cert.KeyId), zap.Error(err))
+		return nil, errors.New("could not validate credentials")
+	}
+
+	if err := checkAllowedIPs(conn, resp); err != nil {
+		return nil, err
+	}
+
+	// The critical options are kept so that any source address the certificate is restricted
+	// to is enforced by the ssh package.
+	p := resp.permissions(conn.User())
+	p.CriticalOptions = cert.Permissions.CriticalOptions
+
+	return p, nil
+}
+
+// Returns the principals that a certificate can have for it to be used to log in as a user.
+func certificatePrincipals(user string) []string {
+	principals := []string{user}
+	if i := strings.LastIndex(user, "."); i > 0 {
+		principals = append(principals, user[:i])
+	}
+
+	return principals
+}
+
+// Reports a different username for a connection, so that the certificate checker validates
+// the certificate against that principal.
+type principalConn struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (c principalConn) User() string {
+	return c.user
+}
+
+// Asks the Panel which server a user logging in with a certificate has access to, and what they
+// are able to do on it.
+func (a *PanelAuthenticator) AuthenticateCertificate(user string, principal string, cert *ssh.Certificate) (*AuthenticationResponse, error) {
+	if a.URL == "" || a.Token == "" {
+		return nil, fmt.Errorf("no panel url or token is configured")
+	}
+
+	resp, err := a.post("/api/remote/sftp/certificate", CertificateRequest{
+		User:      user,
+		Principal: principal,
+		KeyID:     cert.KeyId,
+		Serial:    cert.Serial,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return decodeAuthenticationResponse(resp)
+}
+
+// Passes the certificate along to the wrapped authenticator. Certificates are short lived, so
+// they are never checked against the cache while the Panel is unavailable.
+func (o *offlineAuthenticator) AuthenticateCertificate(user string, principal string, cert *ssh.Certificate) (*AuthenticationResponse, error) {
+	a, ok := o.Authenticator.(CertificateAuthenticator)
+	if !ok {
+		return nil, errors.New("authenticator does not support certificates")
+	}
+
+	return a.AuthenticateCertificate(user, principal, cert)
+}
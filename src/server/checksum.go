@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+)
+
+// The smallest block size a client may request a hash for when using check-file, anything
+// smaller is rejected per the draft-ietf-secsh-filexfer-extensions specification.
+const minChecksumBlockSize = 256
+
+// The hash algorithms supported by the check-file extension. The client provides the list of
+// algorithms it would like in order of preference, and the first one supported is used.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+}
+
+const checksumAlgorithmList = "md5,sha1,sha224,sha256,sha384,sha512,crc32"
+
+// Handles a "check-file-name" extended request, which hashes the file at the given path.
+func handleCheckFileName(e *extendedChannel, id uint32, b []byte) []byte {
+	name, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	return checkFile(e, id, name, b)
+}
+
+// Handles a "check-file-handle" extended request, which hashes the file that an already
+// opened handle points to.
+func handleCheckFileHandle(e *extendedChannel, id uint32, b []byte) []byte {
+	h, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	name, ok := e.handlePath(h)
+	if !ok {
+		return statusPacket(id, sftp.ErrSshFxFailure)
+	}
+
+	return checkFile(e, id, name, b)
+}
+
+// Parses the remaining check-file request arguments and returns a response to the client
+// containing the hashes for the requested file.
+func checkFile(e *extendedChannel, id uint32, name string, b []byte) []byte {
+	algorithms, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	offset, b, err := unmarshalUint64(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	length, b, err := unmarshalUint64(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	blockSize, _, err := unmarshalUint32(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	algorithm, sums, err := e.fs.checksum(name, strings.Split(algorithms, ","), int64(offset), int64(length), int64(blockSize))
+	if err != nil {
+		return statusPacket(id, err)
+	}
+
+	return extendedReplyPacket(id, append(marshalString(nil, algorithm), sums...))
+}
+
+// Hashes a file on the server using the first algorithm in the list that is supported. If
+// a block size is provided a hash is created for each block of the file in the requested
+// range. Otherwise a single hash is created for the entire range. A length of zero means
+// everything from the offset to the end of the file.
+func (fs *FileSystem) checksum(rawPath string, algorithms []string, offset, length, blockSize int64) (string, []byte, error) {
+	// Generating a checksum requires reading the file contents, so this is subject to the same
+	// permission that is used to download a file.
+	if !fs.canDownload() {
+		return "", nil, errMissingPermission("download-files")
+	}
+
+	var algorithm string
+	var newHash func() hash.Hash
+	for _, a := range algorithms {
+		if h, ok := checksumAlgorithms[strings.TrimSpace(a)]; ok {
+			algorithm, newHash = strings.TrimSpace(a), h
+			break
+		}
+	}
+
+	if newHash == nil {
+		return "", nil, sftp.ErrSshFxOpUnsupported
+	}
+
+	if blockSize != 0 && blockSize < minChecksumBlockSize {
+		return "", nil, sftp.ErrSshFxBadMessage
+	}
+
+	p, err := fs.buildPath(rawPath)
+	if err != nil {
+		return "", nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	file, err := fs.openFile(p, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return "", nil, sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		fs.log().Errorw("could not open file for checksum", zap.String("source", p), zap.Error(err))
+		return "", nil, sftp.ErrSshFxFailure
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		fs.log().Errorw("error performing file stat", zap.String("source", p), zap.Error(err))
+		return "", nil, sftp.ErrSshFxFailure
+	}
+
+	if stat.IsDir() || offset < 0 || offset > stat.Size() {
+		return "", nil, sftp.ErrSshFxFailure
+	}
+
+	if length == 0 || offset+length > stat.Size() {
+		length = stat.Size() - offset
+	}
+
+	if blockSize == 0 {
+		blockSize = length
+	}
+
+	var sums []byte
+	h := newHash()
+	r := io.NewSectionReader(file, offset, length)
+	for {
+		h.Reset()
+
+		n, err := io.CopyN(h, r, blockSize)
+		if err != nil && err != io.EOF {
+			fs.log().Errorw("error reading file for checksum", zap.String("source", p), zap.Error(err))
+			return "", nil, sftp.ErrSshFxFailure
+		}
+
+		// Always return at least one hash, even if the range requested was empty.
+		if n > 0 || len(sums) == 0 {
+			sums = h.Sum(sums)
+		}
+
+		if err == io.EOF || n == 0 || n < blockSize {
+			break
+		}
+	}
+
+	return algorithm, sums, nil
+}
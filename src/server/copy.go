@@ -0,0 +1,397 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/containment"
+	"go.uber.org/zap"
+)
+
+// Handles a "copy-file" extended request, which copies a file (or an entire directory) to a
+// new location on the server without the client needing to download and upload it again.
+func handleCopyFile(e *extendedChannel, id uint32, b []byte) []byte {
+	source, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	target, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	if len(b) < 1 {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	return statusPacket(id, e.fs.copyFile(source, target, b[0] != 0))
+}
+
+// Handles a "copy-data" extended request, which copies a range of data from one open handle
+// into another open handle.
+func handleCopyData(e *extendedChannel, id uint32, b []byte) []byte {
+	rh, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	offset, b, err := unmarshalUint64(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	length, b, err := unmarshalUint64(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	wh, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	woffset, _, err := unmarshalUint64(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	source, ok := e.handlePath(rh)
+	if !ok {
+		return statusPacket(id, sftp.ErrSshFxFailure)
+	}
+
+	target, ok := e.handle(wh)
+	if !ok {
+		return statusPacket(id, sftp.ErrSshFxFailure)
+	}
+
+	// Only the handle being written to went through the checks for writing a file when it was
+	// opened, so data can't be copied into a handle that was opened for reading.
+	if target.flags&fxfWrite == 0 {
+		return statusPacket(id, sftp.ErrSshFxPermissionDenied)
+	}
+
+	return statusPacket(id, e.fs.copyData(source, int64(offset), int64(length), target.path, int64(woffset)))
+}
+
+// Copies a file from one location on the server to another. If the source is a directory the
+// entire tree is copied. Existing files at the target location are only replaced if overwrite
+// is true.
+func (fs *FileSystem) copyFile(rawSource string, rawTarget string, overwrite bool) error {
+	if fs.isReadOnly() {
+		return errReadOnly
+	}
+
+	if !fs.can("create-files") {
+		return errMissingPermission("create-files")
+	}
+
+	source, err := fs.buildPath(rawSource)
+	if err != nil {
+		return sftp.ErrSshFxNoSuchFile
+	}
+
+	target, err := fs.buildPath(rawTarget)
+	if err != nil {
+		return sftp.ErrSshFxOpUnsupported
+	}
+
+	if target, err = fs.newName(target); err != nil {
+		return err
+	}
+
+	if fs.containsProtected(target) {
+		return errFileProtected
+	}
+
+	if fs.isBlocked(target) {
+		fs.log().Infow("denying copy to blocked file name", zap.String("source", source), zap.String("target", target))
+		return errFileBlocked
+	}
+
+	stat, err := fs.backend().Stat(source)
+	if os.IsNotExist(err) {
+		return sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		fs.log().Errorw("error performing file stat", zap.String("source", source), zap.Error(err))
+		return translateError(err)
+	}
+
+	if info, err := fs.backend().Stat(target); err == nil {
+		// Copying a file over itself, or over a hard link to it, would truncate the source
+		// before anything was read from it and leave the user with an empty file.
+		if os.SameFile(stat, info) {
+			return sftp.ErrSshFxFailure
+		}
+
+		if !overwrite || stat.IsDir() {
+			return sftp.ErrSshFxFailure
+		}
+
+		// Replacing an existing file is no different than modifying it, so make sure the
+		// user is actually allowed to do that.
+		if !fs.can("save-files") {
+			return errMissingPermission("save-files")
+		}
+	}
+
+	if !stat.IsDir() {
+		if !fs.hasFilesFor(1) {
+			fs.log().Infow("denying file copy due to file limit")
+			return errFileLimitExceeded
+		}
+
+		// Take into account the size of the file being replaced, if there is one.
+		if !fs.hasSpaceFor(stat.Size() - fs.fileSize(target)) {
+			fs.log().Infow("denying file copy due to space limit")
+			return errQuotaExceeded
+		}
+
+		return fs.copyRegularFile(source, target)
+	}
+
+	// Copying a directory into itself would never finish, since we'd keep finding the files
+	// we just copied.
+	if containment.Within(target, source) {
+		return sftp.ErrSshFxFailure
+	}
+
+	size, count := fs.pathSize(source)
+	if !fs.hasFilesFor(count) {
+		fs.log().Infow("denying directory copy due to file limit")
+		return errFileLimitExceeded
+	}
+
+	if !fs.hasSpaceFor(size) {
+		fs.log().Infow("denying directory copy due to space limit")
+		return errQuotaExceeded
+	}
+
+	err = walk(fs.backend(), source, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+
+		// Everything copied has to follow the file name policy the same as the target, and
+		// anything with a name it rejects is left out of the copy.
+		if dest, err = fs.newName(dest); err != nil {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// Only regular files and directories are copied. Symlinks are skipped since we have no
+		// way of knowing where they'll end up pointing once they've been moved.
+		switch {
+		case info.IsDir():
+			if err := fs.backend().MkdirAll(dest, fs.DirectoryMode); err != nil {
+				return err
+			}
+			fs.trackFiles(1)
+
+			if err := fs.chown(dest); err != nil {
+				fs.log().Warnw("error chowning directory", zap.String("file", dest), zap.Error(err))
+			}
+		case info.Mode().IsRegular():
+			// Blocked files are left out of the copy, the same as they are when extracting an
+			// archive, rather than failing the copy of everything else.
+			if fs.isBlocked(dest) {
+				fs.log().Infow("skipping copy of blocked file", zap.String("source", p), zap.String("target", dest))
+				return nil
+			}
+
+			if err := fs.copyRegularFile(p, dest); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		fs.log().Errorw("failed to copy directory",
+			zap.String("source", source),
+			zap.String("target", target),
+			zap.Error(err),
+		)
+		return translateError(err)
+	}
+
+	return sftp.ErrSshFxOk
+}
+
+// Copies a single regular file from the source to the target, both of which should have
+// already been validated. The target is created if it doesn't exist, and truncated if it does.
+func (fs *FileSystem) copyRegularFile(source string, target string) error {
+	src, err := fs.openFile(source, os.O_RDONLY, 0)
+	if err != nil {
+		fs.log().Errorw("could not open file for copying", zap.String("source", source), zap.Error(err))
+		return translateError(err)
+	}
+	defer src.Close()
+
+	// Copies are written without going through the writer uploads use, so the maximum file size
+	// is checked against the size of the source up front instead.
+	info, err := src.Stat()
+	if err != nil {
+		return translateError(err)
+	}
+
+	if fs.MaxFileSize > 0 && info.Size() > fs.MaxFileSize {
+		fs.log().Infow("denying file copy due to file size limit", zap.String("source", source), zap.Int64("limit", fs.MaxFileSize))
+		return errFileTooLarge
+	}
+
+	if err := fs.backend().MkdirAll(filepath.Dir(target), fs.DirectoryMode); err != nil {
+		fs.log().Errorw("error making path for file", zap.String("path", filepath.Dir(target)), zap.Error(err))
+		return translateError(err)
+	}
+
+	before := fs.fileSize(target)
+	_, statErr := fs.backend().Stat(target)
+	dst, err := fs.openFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fs.FileMode)
+	if err != nil {
+		fs.log().Errorw("error creating file", zap.String("source", target), zap.Error(err))
+		return translateError(err)
+	}
+	defer dst.Close()
+	if os.IsNotExist(statErr) {
+		fs.trackFiles(1)
+	}
+	defer func() {
+		fs.trackUsage(fs.fileSize(target) - before)
+	}()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		fs.log().Errorw("failed to copy file",
+			zap.String("source", source),
+			zap.String("target", target),
+			zap.Error(err),
+		)
+		return translateError(err)
+	}
+
+	// Not failing here is intentional. We still made the file, it is just owned incorrectly
+	// and will likely cause some issues.
+	if err := fs.chown(target); err != nil {
+		fs.log().Warnw("error chowning file", zap.String("file", target), zap.Error(err))
+	}
+
+	return nil
+}
+
+// Copies a range of data from the source file into the target file at the given offset. A
+// length of zero copies everything until the end of the source file, as it was when the copy
+// started.
+func (fs *FileSystem) copyData(rawSource string, offset int64, length int64, rawTarget string, woffset int64) error {
+	if fs.isReadOnly() {
+		return errReadOnly
+	}
+
+	if !fs.can("create-files") {
+		return errMissingPermission("create-files")
+	}
+
+	source, err := fs.buildPath(rawSource)
+	if err != nil {
+		return sftp.ErrSshFxNoSuchFile
+	}
+
+	target, err := fs.buildPath(rawTarget)
+	if err != nil {
+		return sftp.ErrSshFxNoSuchFile
+	}
+
+	if target, err = fs.newName(target); err != nil {
+		return err
+	}
+
+	if fs.isProtected(target) {
+		return errFileProtected
+	}
+
+	if fs.isBlocked(target) {
+		return errFileBlocked
+	}
+
+	// Writing into an existing file is no different than modifying it, so make sure the user is
+	// actually allowed to do that.
+	if _, err := fs.backend().Stat(target); err == nil && !fs.can("save-files") {
+		return errMissingPermission("save-files")
+	}
+
+	src, err := fs.openFile(source, os.O_RDONLY, 0)
+	if err != nil {
+		fs.log().Errorw("could not open file for copying", zap.String("source", source), zap.Error(err))
+		return translateError(err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return translateError(err)
+	}
+
+	// Work out how much will be copied from the size of the source now, and never copy more
+	// than that, so the data written can be checked against the maximum file size up front.
+	if remaining := info.Size() - offset; length <= 0 || length > remaining {
+		length = remaining
+	}
+
+	if length <= 0 {
+		return sftp.ErrSshFxOk
+	}
+
+	if fs.MaxFileSize > 0 && woffset+length > fs.MaxFileSize {
+		fs.log().Infow("denying file copy due to file size limit", zap.String("source", source), zap.Int64("limit", fs.MaxFileSize))
+		return errFileTooLarge
+	}
+
+	// Only the part of the range that extends past the end of the target adds to the disk usage
+	// of the server.
+	before := fs.fileSize(target)
+	if growth := woffset + length - before; growth > 0 && !fs.hasSpaceFor(growth) {
+		fs.log().Infow("denying file copy due to space limit")
+		return errQuotaExceeded
+	}
+
+	dst, err := fs.openFile(target, os.O_WRONLY, 0)
+	if err != nil {
+		fs.log().Errorw("could not open file for copying", zap.String("source", target), zap.Error(err))
+		return translateError(err)
+	}
+	defer dst.Close()
+	defer func() {
+		fs.trackUsage(fs.fileSize(target) - before)
+	}()
+
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return translateError(err)
+	}
+
+	if _, err := dst.Seek(woffset, io.SeekStart); err != nil {
+		return translateError(err)
+	}
+
+	if _, err := io.Copy(dst, io.LimitReader(src, length)); err != nil {
+		fs.log().Errorw("failed to copy file data",
+			zap.String("source", source),
+			zap.String("target", target),
+			zap.Error(err),
+		)
+		return translateError(err)
+	}
+
+	return sftp.ErrSshFxOk
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cache "github.com/patrickmn/go-cache"
+	"github.com/pkg/sftp"
+)
+
+// Returns a handler for a new, empty server directory that the user has every permission on.
+func newTestFileSystem(t *testing.T) *FileSystem {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &FileSystem{
+		ServerConfig:     filepath.Join(dir, "server.json"),
+		Directory:        dir,
+		UUID:             "copy-test",
+		Permissions:      []string{"*"},
+		FileMode:         0644,
+		DirectoryMode:    0755,
+		DisableDiskCheck: true,
+		User:             SftpUser{Uid: os.Getuid(), Gid: os.Getgid()},
+		Cache:            cache.New(cache.NoExpiration, cache.NoExpiration),
+	}
+}
+
+func writeTestFile(t *testing.T, fs *FileSystem, name string, size int) {
+	p := filepath.Join(fs.Directory, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(p, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertCopyError(t *testing.T, op string, got error, want error) {
+	t.Helper()
+
+	if got != want {
+		t.Errorf("%s = %v, want %v", op, got, want)
+	}
+}
+
+func assertNotCopied(t *testing.T, fs *FileSystem, name string) {
+	t.Helper()
+
+	if _, err := os.Lstat(filepath.Join(fs.Directory, name)); !os.IsNotExist(err) {
+		t.Errorf("%s exists after a denied copy", name)
+	}
+}
+
+func TestCopyMaxFileSize(t *testing.T) {
+	fs := newTestFileSystem(t)
+	fs.MaxFileSize = 1024
+	writeTestFile(t, fs, "small.txt", 512)
+	writeTestFile(t, fs, "large.bin", 2048)
+	writeTestFile(t, fs, "world/region.mca", 2048)
+	writeTestFile(t, fs, "target.bin", 0)
+
+	assertCopyError(t, "copy small file", fs.copyFile("/small.txt", "/small-copy.txt", false), nil)
+	assertCopyError(t, "copy large file", fs.copyFile("/large.bin", "/large-copy.bin", false), errFileTooLarge)
+	assertNotCopied(t, fs, "large-copy.bin")
+
+	assertCopyError(t, "copy directory", fs.copyFile("/world", "/world-copy", false), errFileTooLarge)
+	assertNotCopied(t, fs, "world-copy/region.mca")
+
+	assertCopyError(t, "copy data", fs.copyData("/large.bin", 0, 0, "/target.bin", 0), errFileTooLarge)
+	assertCopyError(t, "copy data past limit", fs.copyData("/small.txt", 0, 0, "/target.bin", 768), errFileTooLarge)
+	assertCopyError(t, "copy data within limit", fs.copyData("/large.bin", 0, 1024, "/target.bin", 0), sftp.ErrSshFxOk)
+
+	if info, err := os.Stat(filepath.Join(fs.Directory, "target.bin")); err != nil || info.Size() != 1024 {
+		t.Errorf("target.bin = %v, %v, want 1024 bytes", info, err)
+	}
+}
+
+func TestCopySameFile(t *testing.T) {
+	fs := newTestFileSystem(t)
+	if err := ioutil.WriteFile(filepath.Join(fs.Directory, "server.jar"), []byte("jar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Link(filepath.Join(fs.Directory, "server.jar"), filepath.Join(fs.Directory, "server-link.jar")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCopyError(t, "copy file over itself", fs.copyFile("/server.jar", "/server.jar", true), sftp.ErrSshFxFailure)
+	assertCopyError(t, "copy file over hard link", fs.copyFile("/server.jar", "/server-link.jar", true), sftp.ErrSshFxFailure)
+
+	if b, err := ioutil.ReadFile(filepath.Join(fs.Directory, "server.jar")); err != nil || string(b) != "jar" {
+		t.Errorf("server.jar = %q, %v, want its original contents", b, err)
+	}
+}
+
+func TestCopyDiskLimit(t *testing.T) {
+	fs := newTestFileSystem(t)
+	fs.DisableDiskCheck = false
+	writeTestFile(t, fs, "a.bin", 200*1024)
+	writeTestFile(t, fs, "b.bin", 200*1024)
+	writeTestFile(t, fs, "world/region.mca", 150*1024)
+
+	// Leave the server with 124K of space.
+	fs.Cache.Set("disk:"+fs.UUID, int64(1), cache.NoExpiration)
+	fs.Cache.Set("used:"+fs.UUID, int64(900*1024), cache.NoExpiration)
+
+	// Replacing a file of the same size doesn't use any more space.
+	assertCopyError(t, "replace file", fs.copyFile("/a.bin", "/b.bin", true), nil)
+	assertCopyError(t, "copy file", fs.copyFile("/a.bin", "/c.bin", false), errQuotaExceeded)
+	assertNotCopied(t, fs, "c.bin")
+
+	assertCopyError(t, "copy directory", fs.copyFile("/world", "/world-copy", false), errQuotaExceeded)
+	assertNotCopied(t, fs, "world-copy")
+
+	assertCopyError(t, "copy data within file", fs.copyData("/a.bin", 0, 0, "/b.bin", 0), sftp.ErrSshFxOk)
+	assertCopyError(t, "copy data past limit", fs.copyData("/a.bin", 0, 200*1024, "/b.bin", 200*1024), errQuotaExceeded)
+	assertCopyError(t, "copy data within limit", fs.copyData("/a.bin", 0, 100*1024, "/b.bin", 200*1024), sftp.ErrSshFxOk)
+}
+
+func TestCopyDataPermissions(t *testing.T) {
+	fs := newTestFileSystem(t)
+	writeTestFile(t, fs, "a.bin", 1024)
+	writeTestFile(t, fs, "b.bin", 0)
+
+	e := &extendedChannel{fs: fs, handles: map[string]openHandle{
+		"read":  {path: "/b.bin", flags: fxfRead},
+		"write": {path: "/b.bin", flags: fxfWrite | fxfCreat},
+	}}
+
+	copyData := func(wh string) uint32 {
+		b := marshalString(nil, "read")
+		b = marshalUint64(b, 0)
+		b = marshalUint64(b, 0)
+		b = marshalString(b, wh)
+		b = marshalUint64(b, 0)
+
+		code, _, _ := unmarshalUint32(handleCopyData(e, 1, b)[5:])
+		return code
+	}
+
+	// Data can only be copied into a handle that was opened for writing.
+	if code := copyData("read"); code != uint32(sftp.ErrSshFxPermissionDenied) {
+		t.Errorf("copy data into read handle = %d, want permission denied", code)
+	}
+
+	if code := copyData("write"); code != uint32(sftp.ErrSshFxOk) {
+		t.Errorf("copy data into write handle = %d, want ok", code)
+	}
+
+	fs.Permissions = []string{"create-files"}
+	assertCopyError(t, "copy data without save-files", fs.copyData("/a.bin", 0, 0, "/b.bin", 0), errMissingPermission("save-files"))
+}
+
+func TestCopyFileLimit(t *testing.T) {
+	fs := newTestFileSystem(t)
+	fs.DisableDiskCheck = false
+	fs.MaxFiles = 4
+	writeTestFile(t, fs, "world/region.mca", 0)
+	writeTestFile(t, fs, "server.jar", 0)
+
+	assertCopyError(t, "copy file", fs.copyFile("/server.jar", "/server-copy.jar", false), nil)
+	assertCopyError(t, "copy file past limit", fs.copyFile("/server.jar", "/server-old.jar", false), errFileLimitExceeded)
+	assertCopyError(t, "copy directory", fs.copyFile("/world", "/world-copy", false), errFileLimitExceeded)
+	assertNotCopied(t, fs, "world-copy")
+}
+
+func TestCopyFileNamePolicy(t *testing.T) {
+	fs := newTestFileSystem(t)
+	writeTestFile(t, fs, "server.jar", 0)
+	writeTestFile(t, fs, "plugins./config.yml", 0)
+	writeTestFile(t, fs, "plugins./cache /data.bin", 0)
+
+	fs.FileNamePolicy = FileNamesReject
+	assertCopyError(t, "copy file", fs.copyFile("/server.jar", "/server.jar.", false), errNameTrailingCharacter)
+	assertNotCopied(t, fs, "server.jar.")
+
+	// Anything with a name that would be rejected is left out of a directory copy.
+	assertCopyError(t, "copy directory", fs.copyFile("/plugins.", "/plugins-copy", false), sftp.ErrSshFxOk)
+	assertNotCopied(t, fs, "plugins-copy/cache ")
+	if _, err := os.Stat(filepath.Join(fs.Directory, "plugins-copy", "config.yml")); err != nil {
+		t.Errorf("config.yml was not copied: %s", err)
+	}
+
+	fs.FileNamePolicy = FileNamesNormalize
+	assertCopyError(t, "copy file", fs.copyFile("/server.jar", "/server-old.jar.", false), nil)
+	assertNotCopied(t, fs, "server-old.jar.")
+	assertCopyError(t, "copy directory", fs.copyFile("/plugins.", "/plugins-old.", false), sftp.ErrSshFxOk)
+	for _, name := range []string{"server-old.jar", "plugins-old/config.yml", "plugins-old/cache/data.bin"} {
+		if _, err := os.Stat(filepath.Join(fs.Directory, name)); err != nil {
+			t.Errorf("%s was not copied: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The header the correlation ID of a request to the Panel is sent in. The Panel is asked to echo
+// it in its response and to include it in its own logs, so that a failed login can be found in
+// the logs of both the Panel and this server.
+const correlationHeader = "X-Correlation-ID"
+
+// Returns the correlation ID for a connection, which is the same for every authentication attempt
+// made on it and becomes the ID of the session once the user has logged in. It is derived from
+// the SSH session ID, which is unique to each connection, rather than including the session ID
+// itself.
+func correlationID(conn ssh.ConnMetadata) string {
+	h := sha256.Sum256(conn.SessionID())
+
+	return hex.EncodeToString(h[:8])
+}
+
+// Returns a random correlation ID, for requests to the Panel that are not made for a connection.
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Implemented by authenticators that are able to send a correlation ID with their requests.
+type correlator interface {
+	withCorrelationID(id string) Authenticator
+}
+
+// Returns a copy of the authenticator that sends the correlation ID with every request it makes,
+// or the authenticator itself if it can't.
+func correlate(a Authenticator, id string) Authenticator {
+	if c, ok := a.(correlator); ok {
+		return c.withCorrelationID(id)
+	}
+
+	return a
+}
+
+func (a *PanelAuthenticator) withCorrelationID(id string) Authenticator {
+	c := *a
+	c.correlationID = id
+
+	return &c
+}
+
+func (o *offlineAuthenticator) withCorrelationID(id string) Authenticator {
+	c := *o
+	c.Authenticator = correlate(o.Authenticator, id)
+
+	return &c
+}
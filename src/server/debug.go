@@ -0,0 +1,260 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The longest the log level can be raised for, or a debug target can last, through the admin
+// API. Debug logging is noisy enough that it should never be left enabled by accident.
+const maxDebugWindow = 24 * time.Hour
+
+// A debugTarget enables debug logging for the sessions matching it until it expires, without
+// raising the level of the logger for every other session. Every field that is set must match
+// the session, and sessions that connect after the target was added are matched as well, so a
+// user having intermittent problems can be debugged the next time they connect.
+type debugTarget struct {
+	ID        string    `json:"id"`
+	Session   string    `json:"session,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Server    string    `json:"server,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Returns true if every field set on the target matches the session.
+func (t *debugTarget) matches(sess *session) bool {
+	return (t.Session == "" || t.Session == sess.id) &&
+		(t.User == "" || t.User == sess.user) &&
+		(t.Server == "" || t.Server == sess.server) &&
+		(t.IP == "" || t.IP == sess.ip)
+}
+
+// Keeps track of the debug targets that have been added to the server.
+type debugTargets struct {
+	mu      sync.Mutex
+	targets map[string]*debugTarget
+
+	// The number of targets, which is checked before anything else since this is consulted for
+	// every debug entry logged by a session. It must be accessed atomically.
+	count int32
+}
+
+func newDebugTargets() *debugTargets {
+	return &debugTargets{targets: make(map[string]*debugTarget)}
+}
+
+// Adds a target, returning it once it has been given an ID.
+func (d *debugTargets) add(t debugTarget) *debugTarget {
+	b := make([]byte, 8)
+	rand.Read(b)
+	t.ID = hex.EncodeToString(b)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.targets[t.ID] = &t
+	atomic.StoreInt32(&d.count, int32(len(d.targets)))
+
+	return &t
+}
+
+// Removes a target, returning false if there is no target with the ID.
+func (d *debugTargets) remove(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.targets[id]; !ok {
+		return false
+	}
+
+	delete(d.targets, id)
+	atomic.StoreInt32(&d.count, int32(len(d.targets)))
+
+	return true
+}
+
+// Returns the targets that have not expired yet.
+func (d *debugTargets) list() []debugTarget {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire()
+
+	targets := make([]debugTarget, 0, len(d.targets))
+	for _, t := range d.targets {
+		targets = append(targets, *t)
+	}
+
+	return targets
+}
+
+// Returns true if debug logging is enabled for the session by any target.
+func (d *debugTargets) matches(sess *session) bool {
+	if atomic.LoadInt32(&d.count) == 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire()
+
+	for _, t := range d.targets {
+		if t.matches(sess) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Removes every target that has expired. The lock must be held when calling this.
+func (d *debugTargets) expire() {
+	now := time.Now()
+	for id, t := range d.targets {
+		if now.After(t.ExpiresAt) {
+			delete(d.targets, id)
+		}
+	}
+
+	atomic.StoreInt32(&d.count, int32(len(d.targets)))
+}
+
+// Returns the window to debug for from a number of seconds given through the admin API, which
+// defaults to the default debug window and is never longer than the maximum.
+func debugWindow(seconds int64) (time.Duration, bool) {
+	if seconds < 0 {
+		return 0, false
+	}
+
+	if seconds == 0 {
+		return logger.DefaultDebugWindow, true
+	}
+
+	d := time.Duration(seconds) * time.Second
+
+	return d, d <= maxDebugWindow
+}
+
+// Handles requests to /log-level. GET returns the current level of the logger, PUT changes it
+// for a number of seconds, and DELETE returns it to the configured level straight away.
+func (s *Server) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Level    string `json:"level"`
+			Duration int64  `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(body.Level)); err != nil || body.Level == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid log level"})
+			return
+		}
+
+		d, ok := debugWindow(body.Duration)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "duration must be between 0 and 86400 seconds"})
+			return
+		}
+
+		logger.SetLevelFor(l, d)
+		logger.Get().Infow("changed log level through admin api", zap.Stringer("level", l), zap.Duration("duration", d))
+	case http.MethodDelete:
+		logger.ResetLevel()
+		logger.Get().Infow("reset log level through admin api", zap.Stringer("level", logger.ConfiguredLevel()))
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	l, expires := logger.Level()
+	resp := map[string]interface{}{
+		"level":      l.String(),
+		"configured": logger.ConfiguredLevel().String(),
+	}
+	if !expires.IsZero() {
+		resp["expires_at"] = expires
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Handles requests to /debug-targets. GET returns the active targets and POST adds one, which
+// must match on at least one of the session, user, server or IP.
+func (s *Server) handleDebugTargets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"targets": s.debug.list()})
+	case http.MethodPost:
+		var body struct {
+			debugTarget
+			Duration int64 `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+
+		t := body.debugTarget
+		if t.Session == "" && t.User == "" && t.Server == "" && t.IP == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "one of session, user, server or ip is required"})
+			return
+		}
+
+		d, ok := debugWindow(body.Duration)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "duration must be between 0 and 86400 seconds"})
+			return
+		}
+		t.ExpiresAt = time.Now().Add(d)
+
+		added := s.debug.add(t)
+		logger.Get().Infow("added debug target through admin api",
+			zap.String("target", added.ID),
+			zap.String("session", added.Session),
+			zap.String("user", added.User),
+			zap.String("server", added.Server),
+			zap.String("ip", added.IP),
+			zap.Duration("duration", d),
+		)
+
+		writeJSON(w, http.StatusCreated, added)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// Handles DELETE /debug-targets/<id>, removing the target.
+func (s *Server) handleRemoveDebugTarget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/debug-targets/")
+	if !s.debug.remove(id) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "debug target not found"})
+		return
+	}
+
+	logger.Get().Infow("removed debug target through admin api", zap.String("target", id))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+// The credentials accepted in development mode, and the server they log in to.
+const (
+	DevUser     = "dev"
+	DevPassword = "dev"
+	devServer   = "dev"
+)
+
+// Accepts a fixed username and password, giving every permission on a local directory, so that
+// the server can be tried out with any SFTP client without a Panel. This is only used in
+// development mode, and should never be reachable from anywhere but the machine it runs on.
+type devAuthenticator struct {
+	directory string
+}
+
+func (a devAuthenticator) Authenticate(user string, pass []byte) (*AuthenticationResponse, error) {
+	if user != DevUser || subtle.ConstantTimeCompare(pass, []byte(DevPassword)) != 1 {
+		return nil, errors.New("bad credentials provided")
+	}
+
+	return &AuthenticationResponse{
+		Server:      devServer,
+		User:        DevUser,
+		Permissions: []string{"*"},
+		ServerName:  "Development",
+		Directory:   a.directory,
+	}, nil
+}
+
+func (a devAuthenticator) serverDirectories() map[string]string {
+	return map[string]string{devServer: a.directory}
+}
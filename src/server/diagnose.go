@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/buger/jsonparser"
+)
+
+// Returned when the Panel does not accept the token of the node.
+var errTokenRejected = errors.New("panel rejected the node token")
+
+// The result of one of the checks run when diagnosing a node. The detail describes what was
+// found, and when the check failed the hint describes what is most likely to fix it.
+type Diagnostic struct {
+	Name   string
+	Detail string
+	Err    error
+	Hint   string
+}
+
+// Runs a series of checks against the configuration of the server and the environment it is
+// running in, such as being able to reach the Panel and write to the server data directory,
+// returning the result of each. This allows problems with a node to be found without starting
+// the server and waiting for users to run into them.
+func (s *Server) Diagnose() []Diagnostic {
+	results := []Diagnostic{s.diagnoseHostKeys(), s.diagnoseDataDirectory()}
+
+	if p, ok := s.auth.(interface{ Ping() error }); ok {
+		d := s.diagnosePanel(p)
+		results = append(results, d)
+
+		// There is no point checking the token when the Panel can't be reached at all, it
+		// would only fail with the same error.
+		if d.Err == nil {
+			if s.handshake != nil {
+				results = append(results, s.diagnoseHandshake())
+			}
+
+			if v, ok := s.auth.(interface{ VerifyToken() error }); ok {
+				results = append(results, s.diagnoseToken(v))
+			}
+		}
+	}
+
+	for _, address := range s.addresses {
+		results = append(results, diagnoseListen(address))
+	}
+
+	if s.config.Settings.GeoIPDatabase != "" {
+		d := Diagnostic{Name: "geoip database", Detail: s.config.Settings.GeoIPDatabase}
+		if d.Err = s.loadGeoIP(); d.Err != nil {
+			d.Hint = "Check that sftp.geoip.database is the path of a MaxMind country database, or remove it along with the allowed and denied countries."
+		}
+		results = append(results, d)
+	}
+
+	return results
+}
+
+// Checks that the host keys of the server can be read.
+func (s *Server) diagnoseHostKeys() Diagnostic {
+	d := Diagnostic{Name: "host keys"}
+
+	keys, err := s.config.loadHostKeys()
+	if err != nil {
+		d.Err = err
+		d.Hint = fmt.Sprintf("Remove the unreadable key from %s, or replace it by running \"sftp-server keygen\". Clients will warn that the host key changed.", filepath.Join(s.config.Settings.BasePath, ".sftp"))
+		return d
+	}
+
+	if len(keys) == 0 {
+		d.Detail = "none exist yet, an rsa key will be generated when the server starts"
+		return d
+	}
+
+	d.Detail = strings.Join(hostKeyFingerprints(keys), ", ")
+
+	return d
+}
+
+// Checks that files can be created in the directory containing the data for every server, and
+// given to the user they are owned by.
+func (s *Server) diagnoseDataDirectory() Diagnostic {
+	dir := s.config.dataPath()
+	d := Diagnostic{Name: "server data directory", Detail: dir}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		d.Err = err
+		if os.IsNotExist(err) {
+			d.Hint = "Create the directory, or set sftp.path in the Daemon configuration to where the data for servers is kept."
+		}
+		return d
+	}
+
+	if !info.IsDir() {
+		d.Err = errors.New("not a directory")
+		d.Hint = "Set sftp.path in the Daemon configuration to the directory the data for servers is kept in."
+		return d
+	}
+
+	f, err := ioutil.TempFile(dir, ".sftp-diagnose-")
+	if err != nil {
+		d.Err = err
+		if os.IsPermission(err) {
+			d.Hint = "Run the server as root, or as a user that can write to the directory."
+		}
+		return d
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := chownPath(f.Name(), s.config.User.Uid, s.config.User.Gid); err != nil {
+		d.Err = fmt.Errorf("could not give files to the daemon user: %w", err)
+		d.Hint = fmt.Sprintf("Run the server as root, or as the user with uid %d, so that uploaded files can be owned by the daemon user.", s.config.User.Uid)
+	}
+
+	return d
+}
+
+// Checks that the Panel can be reached.
+func (s *Server) diagnosePanel(p interface{ Ping() error }) Diagnostic {
+	base, _ := jsonparser.GetString(s.config.Data, "remote", "base")
+	d := Diagnostic{Name: "panel", Detail: base}
+
+	if d.Err = p.Ping(); d.Err == nil {
+		return d
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var dnsError *net.DNSError
+	switch {
+	case base == "":
+		d.Hint = "Set remote.base in the Daemon configuration to the URL of the Panel."
+	case errors.As(d.Err, &unknownAuthority) || errors.As(d.Err, &hostname):
+		d.Hint = "The certificate of the Panel is not trusted, set sftp.panel.ca_file to the certificate authority that issued it."
+	case errors.As(d.Err, &dnsError):
+		d.Hint = "The hostname of the Panel could not be resolved, check remote.base in the Daemon configuration and the DNS settings of the node."
+	case errors.Is(d.Err, syscall.ECONNREFUSED):
+		d.Hint = "Nothing is listening at remote.base, check that it includes the right scheme and port and that the webserver of the Panel is running."
+	default:
+		if u, err := url.Parse(base); err != nil || u.Scheme == "" || u.Host == "" {
+			d.Hint = "remote.base must be a full URL, such as https://panel.example.com."
+		} else {
+			d.Hint = "Check that the Panel is reachable from this node, and that no firewall is blocking the connection."
+		}
+	}
+
+	return d
+}
+
+// Checks that the Panel completes a handshake with the node.
+func (s *Server) diagnoseHandshake() Diagnostic {
+	d := Diagnostic{Name: "panel handshake"}
+
+	if _, _, d.Err = s.handshake.credentials(nil); d.Err != nil {
+		d.Hint = "Check that the Panel supports node handshakes, or disable sftp.panel.handshake."
+	}
+
+	return d
+}
+
+// Checks that the Panel accepts the token of the node.
+func (s *Server) diagnoseToken(v interface{ VerifyToken() error }) Diagnostic {
+	d := Diagnostic{Name: "panel token"}
+
+	if d.Err = v.VerifyToken(); d.Err != nil {
+		switch {
+		case errors.Is(d.Err, errTokenRejected), errors.Is(d.Err, errInvalidSignature):
+			d.Hint = "The token in the Daemon configuration does not belong to this node, copy the configuration for the node from the Panel again."
+		default:
+			d.Hint = "Check the logs of the Panel for the cause of the error."
+		}
+	}
+
+	return d
+}
+
+// Checks that an address can be listened on.
+func diagnoseListen(address string) Diagnostic {
+	d := Diagnostic{Name: "listen on " + address}
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		d.Err = err
+		switch {
+		case errors.Is(err, syscall.EADDRINUSE):
+			d.Hint = "Another process is already listening on the port, such as a running copy of this server or the SFTP server built into the Daemon. Stop it or change sftp.port."
+		case errors.Is(err, syscall.EACCES):
+			d.Hint = "Ports below 1024 can only be listened on by root, or with the CAP_NET_BIND_SERVICE capability."
+		case errors.Is(err, syscall.EADDRNOTAVAIL):
+			d.Hint = "The address does not belong to this node, change --bind-addr or sftp.addresses."
+		}
+		return d
+	}
+
+	d.Err = l.Close()
+
+	return d
+}
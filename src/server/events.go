@@ -0,0 +1,93 @@
+package server
+
+import (
+	"time"
+)
+
+// The types of changes to the files of a server that events are sent for.
+const (
+	EventUpload = "upload"
+	EventDelete = "delete"
+	EventRename = "rename"
+	EventMkdir  = "mkdir"
+)
+
+// A FileEvent describes a change made to the files of a server over SFTP or SCP. Paths are
+// relative to the root of the server.
+type FileEvent struct {
+	Event     string    `json:"event"`
+	Server    string    `json:"server"`
+	User      string    `json:"user"`
+	IP        string    `json:"ip"`
+	Path      string    `json:"path"`
+	Target    string    `json:"target,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// The signature that matched and where the file was moved to, for malware events.
+	Signature  string `json:"signature,omitempty"`
+	Quarantine string `json:"quarantine,omitempty"`
+}
+
+// Sends an event for a change made to the given path, and the target path for events that
+// have one. This does nothing if the handler was not created for a session.
+func (fs *FileSystem) notify(event string, p string, target string) {
+	if event == EventUpload && fs.scanner != nil {
+		fs.scanner.submit(fs, p)
+	}
+
+	if fs.events == nil {
+		return
+	}
+
+	e := FileEvent{
+		Event:     event,
+		Server:    fs.UUID,
+		Path:      fs.relativePath(p),
+		Timestamp: time.Now().UTC(),
+	}
+
+	if target != "" {
+		e.Target = fs.relativePath(target)
+	}
+
+	fs.events(e)
+}
+
+// Passes an event that happened during a session along to everything on the server that is
+// interested in file events.
+func (s *Server) publish(sess *session, e FileEvent) {
+	e.User = sess.user
+	e.IP = sess.ip
+
+	for _, w := range s.webhooks {
+		w.send(e)
+	}
+
+	// Activity is only sent to the Panel in the Daemon configuration, which knows nothing about
+	// the servers belonging to other Panels.
+	if s.activity != nil && s.panelOf(sess.server) == "" {
+		s.activity.record(sess, e)
+	}
+}
+
+// Wraps a file that is being uploaded directly in place, sending an upload event once the file
+// has been closed. Atomic uploads send their own event once they have been moved into place.
+type notifyingFile struct {
+	File
+	fs   *FileSystem
+	path string
+	// The size of the file before it was opened, used to update the disk usage of the server
+	// once the upload is done.
+	size int64
+}
+
+func (f *notifyingFile) Close() error {
+	err := f.File.Close()
+	f.fs.trackUsage(f.fs.fileSize(f.path) - f.size)
+	if err != nil {
+		return err
+	}
+
+	f.fs.notify(EventUpload, f.path, "")
+	return nil
+}
@@ -0,0 +1,471 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+)
+
+// SFTP packet types and status codes that we need to be aware of when sitting in front of
+// the request server. These mirror the (unexported) values used by pkg/sftp.
+const (
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpLstat         = 7
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+
+	fxOk               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// The largest packet we will accept from a client, this matches the limit used by pkg/sftp.
+const maxPacketLength = 256 * 1024
+
+// An extension handler receives the request ID and the payload of an extended request (the
+// data following the extension name) and returns the response packet to send to the client.
+type extensionHandler func(e *extendedChannel, id uint32, data []byte) []byte
+
+// The extended requests that are handled by the server, keyed by the request name sent by
+// the client.
+var extensionHandlers = map[string]extensionHandler{
+	"check-file-name":        handleCheckFileName,
+	"check-file-handle":      handleCheckFileHandle,
+	"hardlink@openssh.com":   handleHardlink,
+	"fsync@openssh.com":      handleFsync,
+	"copy-file":              handleCopyFile,
+	"copy-data":              handleCopyData,
+	"extract@pterodactyl.io": handleExtract,
+	"search@pterodactyl.io":  handleSearch,
+}
+
+// The extensions advertised to the client in the SSH_FXP_VERSION response. Some clients will
+// only make use of an extension if the server has told them that it is supported.
+var advertisedExtensions = []struct {
+	Name, Data string
+}{
+	{"check-file", checksumAlgorithmList},
+	{"hardlink@openssh.com", "1"},
+	{"fsync@openssh.com", "1"},
+	{"copy-file", "1"},
+	{"copy-data", "1"},
+	{"extract@pterodactyl.io", "1"},
+	{"search@pterodactyl.io", "1"},
+}
+
+// The version of pkg/sftp in use does not support extended requests with the request server,
+// so this sits between the SSH channel and the request server, answering the extended requests
+// we know how to handle and passing everything else through untouched.
+//
+// Handles are also tracked here, since they're opaque to us otherwise and a number of the
+// extensions operate on an already open handle rather than a path.
+type extendedChannel struct {
+	channel io.ReadWriteCloser
+	fs      *FileSystem
+
+	pr *io.PipeReader
+	pw *io.PipeWriter
+
+	// Serializes writes to the channel between the request server and our own responses.
+	wlock sync.Mutex
+
+	mu      sync.Mutex
+	opens   map[uint32]openHandle
+	handles map[string]openHandle
+}
+
+// A file opened by the client, along with the flags it was opened with.
+type openHandle struct {
+	path  string
+	flags uint32
+}
+
+func newExtendedChannel(channel io.ReadWriteCloser, fs *FileSystem) *extendedChannel {
+	pr, pw := io.Pipe()
+
+	e := &extendedChannel{
+		channel: channel,
+		fs:      fs,
+		pr:      pr,
+		pw:      pw,
+		opens:   make(map[uint32]openHandle),
+		handles: make(map[string]openHandle),
+	}
+
+	go e.run()
+
+	return e
+}
+
+// Read returns the packets from the client that should be processed by the request server.
+func (e *extendedChannel) Read(p []byte) (int, error) {
+	return e.pr.Read(p)
+}
+
+// Write sends a packet from the request server to the client. The request server always
+// writes a single, complete packet per call, which allows us to inspect the response to keep
+// track of handles, and to add our extensions to the version response.
+func (e *extendedChannel) Write(p []byte) (int, error) {
+	if len(p) >= 5 && int(binary.BigEndian.Uint32(p)) == len(p)-4 {
+		switch p[4] {
+		case fxpVersion:
+			n := len(p)
+			b := append([]byte{}, p[4:]...)
+			for _, ext := range advertisedExtensions {
+				b = marshalString(b, ext.Name)
+				b = marshalString(b, ext.Data)
+			}
+
+			if _, err := e.writePacket(b); err != nil {
+				return 0, err
+			}
+
+			return n, nil
+		case fxpStatus:
+			e.trackResponse(p[4], p[5:])
+			p = correctStatus(p)
+		case fxpHandle:
+			e.trackResponse(p[4], p[5:])
+		}
+	}
+
+	e.wlock.Lock()
+	defer e.wlock.Unlock()
+
+	return e.channel.Write(p)
+}
+
+// Close closes the underlying channel and stops passing packets to the request server.
+func (e *extendedChannel) Close() error {
+	e.pw.Close()
+
+	return e.channel.Close()
+}
+
+// Writes a packet body to the client, prefixed with its length.
+func (e *extendedChannel) writePacket(b []byte) (int, error) {
+	packet := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(packet, uint32(len(b)))
+	packet = append(packet, b...)
+
+	e.wlock.Lock()
+	defer e.wlock.Unlock()
+
+	return e.channel.Write(packet)
+}
+
+// Reads packets off of the channel, handling any extended requests that we support and
+// passing everything else along to the request server.
+func (e *extendedChannel) run() {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(e.fs.log(), r)
+			e.Close()
+		}
+	}()
+
+	for {
+		packet, err := readPacket(e.channel)
+		if err != nil {
+			e.pw.CloseWithError(err)
+			return
+		}
+
+		switch packet[4] {
+		case fxpOpen:
+			e.trackOpen(packet[5:])
+		case fxpClose:
+			e.trackClose(packet[5:])
+		case fxpLstat:
+			e.handleLstat(packet[5:])
+			continue
+		case fxpExtended:
+			if e.handleExtended(packet[5:]) {
+				continue
+			}
+		}
+
+		if _, err := e.pw.Write(packet); err != nil {
+			return
+		}
+	}
+}
+
+// Attempts to handle an extended request, returning false if it is not one that we know about
+// and should be passed along to the request server to be rejected.
+func (e *extendedChannel) handleExtended(b []byte) bool {
+	id, b, err := unmarshalUint32(b)
+	if err != nil {
+		return false
+	}
+
+	name, data, err := unmarshalString(b)
+	if err != nil {
+		return false
+	}
+
+	handler, ok := extensionHandlers[name]
+	if !ok {
+		return false
+	}
+
+	// Extended requests can take a while to complete (hashing a large file, for example), so
+	// handle them in the background rather than holding up every other request.
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logPanic(e.fs.log().With(zap.String("extension", name)), r)
+				e.writePacket(statusPacket(id, sftp.ErrSshFxFailure))
+			}
+		}()
+
+		if _, err := e.writePacket(handler(e, id, data)); err != nil {
+			e.fs.log().Debugw("failed to send extended reply", zap.String("extension", name), zap.Error(err))
+		}
+	}()
+
+	return true
+}
+
+// Handles a "hardlink@openssh.com" extended request by passing it along to the file command
+// handler as a "Link" request, the same way a symlink request would be.
+func handleHardlink(e *extendedChannel, id uint32, b []byte) []byte {
+	oldpath, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	newpath, _, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	request := sftp.NewRequest("Link", oldpath)
+	request.Target = newpath
+
+	return statusPacket(id, e.fs.Filecmd(request))
+}
+
+// Handles a "fsync@openssh.com" extended request, flushing any data written to the file the
+// handle points to out to the disk.
+func handleFsync(e *extendedChannel, id uint32, b []byte) []byte {
+	h, _, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	name, ok := e.handlePath(h)
+	if !ok {
+		return statusPacket(id, sftp.ErrSshFxFailure)
+	}
+
+	return statusPacket(id, e.fs.fsync(name))
+}
+
+// Records the path and flags of a file being opened by a request so that they can be associated
+// with the handle returned by the request server.
+func (e *extendedChannel) trackOpen(b []byte) {
+	id, b, err := unmarshalUint32(b)
+	if err != nil {
+		return
+	}
+
+	p, b, err := unmarshalString(b)
+	if err != nil {
+		return
+	}
+
+	flags, _, err := unmarshalUint32(b)
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	e.opens[id] = openHandle{path: p, flags: flags}
+	e.mu.Unlock()
+}
+
+// Stops tracking a handle once the client has closed it. Since the client is explicitly closing
+// the handle, any upload to the file has been completed and can be committed.
+func (e *extendedChannel) trackClose(b []byte) {
+	if _, b, err := unmarshalUint32(b); err == nil {
+		if h, _, err := unmarshalString(b); err == nil {
+			e.mu.Lock()
+			o, ok := e.handles[h]
+			delete(e.handles, h)
+			e.mu.Unlock()
+
+			if ok {
+				e.fs.commitUpload(o.path)
+			}
+		}
+	}
+}
+
+// Associates a handle returned by the request server with the path that was opened. Status
+// responses mean the open failed, so there is nothing to track.
+func (e *extendedChannel) trackResponse(t byte, b []byte) {
+	id, b, err := unmarshalUint32(b)
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	o, ok := e.opens[id]
+	if !ok {
+		return
+	}
+	delete(e.opens, id)
+
+	if t == fxpHandle {
+		if h, _, err := unmarshalString(b); err == nil {
+			e.handles[h] = o
+		}
+	}
+}
+
+// Returns the path that a handle was opened for.
+func (e *extendedChannel) handlePath(h string) (string, bool) {
+	o, ok := e.handle(h)
+
+	return o.path, ok
+}
+
+// Returns the file that a handle was opened for.
+func (e *extendedChannel) handle(h string) (openHandle, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	o, ok := e.handles[h]
+	return o, ok
+}
+
+// Reads a single length prefixed packet, returning the full packet including the length.
+func readPacket(r io.Reader) ([]byte, error) {
+	l := make([]byte, 4)
+	if _, err := io.ReadFull(r, l); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(l)
+	if length == 0 || length > maxPacketLength {
+		return nil, errors.New("invalid packet length received")
+	}
+
+	packet := make([]byte, 4+length)
+	copy(packet, l)
+	if _, err := io.ReadFull(r, packet[4:]); err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+// Builds a SSH_FXP_STATUS packet for the given request ID and error.
+func statusPacket(id uint32, err error) []byte {
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+
+	b := []byte{fxpStatus}
+	b = marshalUint32(b, id)
+	b = marshalUint32(b, statusCode(err))
+	b = marshalString(b, msg)
+	b = marshalString(b, "")
+
+	return b
+}
+
+// Builds a SSH_FXP_EXTENDED_REPLY packet for the given request ID.
+func extendedReplyPacket(id uint32, data []byte) []byte {
+	b := []byte{fxpExtendedReply}
+	b = marshalUint32(b, id)
+
+	return append(b, data...)
+}
+
+// Returns the SFTP status code to respond with for a given error.
+func statusCode(err error) uint32 {
+	switch err {
+	case nil, sftp.ErrSshFxOk:
+		return fxOk
+	case io.EOF, sftp.ErrSshFxEof:
+		return fxEOF
+	case sftp.ErrSshFxNoSuchFile:
+		return fxNoSuchFile
+	case sftp.ErrSshFxPermissionDenied:
+		return fxPermissionDenied
+	case sftp.ErrSshFxBadMessage:
+		return fxBadMessage
+	case sftp.ErrSshFxOpUnsupported:
+		return fxOpUnsupported
+	}
+
+	if code, ok := statusErrorCode(err); ok {
+		return code
+	} else if os.IsNotExist(err) {
+		return fxNoSuchFile
+	} else if os.IsPermission(err) {
+		return fxPermissionDenied
+	}
+
+	return fxFailure
+}
+
+func marshalUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func marshalUint64(b []byte, v uint64) []byte {
+	return marshalUint32(marshalUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func marshalString(b []byte, v string) []byte {
+	return append(marshalUint32(b, uint32(len(v))), v...)
+}
+
+func unmarshalUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, errors.New("packet too short")
+	}
+
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+func unmarshalUint64(b []byte) (uint64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, errors.New("packet too short")
+	}
+
+	return binary.BigEndian.Uint64(b), b[8:], nil
+}
+
+func unmarshalString(b []byte) (string, []byte, error) {
+	l, b, err := unmarshalUint32(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if uint32(len(b)) < l {
+		return "", nil, errors.New("packet too short")
+	}
+
+	return string(b[:l]), b[l:], nil
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/containment"
+	"go.uber.org/zap"
+)
+
+// Handles an "extract@pterodactyl.io" extended request, which extracts an archive that has
+// already been uploaded into a directory on the server. This allows users to upload a single
+// archive rather than thousands of small files, which is painfully slow over SFTP.
+func handleExtract(e *extendedChannel, id uint32, b []byte) []byte {
+	source, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	target, _, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	return statusPacket(id, e.fs.extractArchive(source, target))
+}
+
+// A single file or directory read from an archive.
+type archiveEntry struct {
+	name    string
+	dir     bool
+	regular bool
+	size    int64
+	modTime time.Time
+	open    func() (io.ReadCloser, error)
+}
+
+// Extracts a zip, tar or gzipped tar archive into the target directory, which is created if it
+// does not exist. Entries that would end up outside of the target directory, are protected or
+// blocked, or are anything other than a regular file or directory are skipped. Running out of
+// space, or reaching the file limit for the server, stops the extraction with everything up to
+// that point left in place.
+func (fs *FileSystem) extractArchive(rawSource string, rawTarget string) error {
+	fs.countOperation()
+
+	if fs.isReadOnly() {
+		return errReadOnly
+	}
+
+	if !fs.can("create-files") {
+		return errMissingPermission("create-files")
+	}
+
+	source, err := fs.buildPath(rawSource)
+	if err != nil {
+		return sftp.ErrSshFxNoSuchFile
+	}
+
+	target, err := fs.buildPath(rawTarget)
+	if err != nil {
+		return sftp.ErrSshFxOpUnsupported
+	}
+
+	if fs.isProtected(target) {
+		return errFileProtected
+	}
+
+	if !fs.hasSpace() {
+		fs.log().Infow("denying archive extraction due to space limit")
+		return errQuotaExceeded
+	}
+
+	f, err := fs.openFile(source, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		fs.log().Errorw("could not open archive for extraction", zap.String("source", source), zap.Error(err))
+		return translateError(err)
+	}
+	defer f.Close()
+
+	each, err := archiveReader(f, source)
+	if err != nil {
+		fs.log().Infow("could not read archive", zap.String("source", source), zap.Error(err))
+		return translateError(err)
+	}
+
+	if err := fs.backend().MkdirAll(target, fs.DirectoryMode); err != nil {
+		fs.log().Errorw("error making path for archive", zap.String("path", target), zap.Error(err))
+		return translateError(err)
+	}
+
+	var extracted int
+	err = each(func(entry archiveEntry) error {
+		ok, err := fs.extractEntry(target, entry)
+		if ok {
+			extracted++
+		}
+
+		return err
+	})
+
+	fs.log().Infow("extracted archive",
+		zap.String("source", source),
+		zap.String("target", target),
+		zap.Int("entries", extracted),
+		zap.Error(err),
+	)
+
+	if err == errQuotaExceeded || err == errFileLimitExceeded {
+		return err
+	} else if err != nil {
+		return translateError(err)
+	}
+
+	return sftp.ErrSshFxOk
+}
+
+// Writes a single entry of an archive beneath the target directory, returning false if the
+// entry was skipped. Errors stop the extraction of any further entries.
+func (fs *FileSystem) extractEntry(target string, entry archiveEntry) (bool, error) {
+	// Archive entries always use forward slashes, but we still need to make sure that nothing
+	// like "../../etc/passwd" is able to escape the directory it is being extracted into.
+	name := path.Clean("/" + strings.Replace(entry.name, "\\", "/", -1))
+	if name == "/" {
+		return false, nil
+	}
+
+	// Resolving the path again handles any symlinks already in the target directory, which
+	// could otherwise point somewhere outside of the server.
+	dest, err := fs.buildPath(path.Join(fs.relativePath(target), name))
+	if err != nil || !containment.Within(dest, target) {
+		fs.log().Warnw("skipping archive entry outside of the target directory", zap.String("entry", entry.name))
+		return false, nil
+	}
+
+	if dest, err = fs.newName(dest); err != nil {
+		fs.log().Infow("skipping archive entry with an invalid name", zap.String("entry", entry.name))
+		return false, nil
+	}
+
+	if fs.isProtected(dest) || (!entry.dir && fs.isBlocked(dest)) {
+		fs.log().Infow("skipping protected archive entry", zap.String("entry", entry.name))
+		return false, nil
+	}
+
+	if entry.dir {
+		if _, err := fs.backend().Stat(dest); err == nil {
+			return true, nil
+		}
+
+		if !fs.hasFilesFor(1) {
+			return false, errFileLimitExceeded
+		}
+
+		if err := fs.backend().MkdirAll(dest, fs.DirectoryMode); err != nil {
+			return false, err
+		}
+		fs.trackFiles(1)
+
+		if err := fs.chown(dest); err != nil {
+			fs.log().Warnw("error chowning directory", zap.String("file", dest), zap.Error(err))
+		}
+
+		return true, nil
+	}
+
+	// Symlinks and anything else that isn't a regular file are skipped, since there is no way
+	// of knowing where they would end up pointing.
+	if !entry.regular {
+		return false, nil
+	}
+
+	if fs.MaxFileSize > 0 && entry.size > fs.MaxFileSize {
+		fs.log().Infow("skipping archive entry larger than the maximum file size", zap.String("entry", entry.name))
+		return false, nil
+	}
+
+	before := fs.fileSize(dest)
+	stat, statErr := fs.backend().Stat(dest)
+	if statErr == nil {
+		if stat.IsDir() || !fs.can("save-files") {
+			return false, nil
+		}
+	} else if !fs.hasFilesFor(1) {
+		return false, errFileLimitExceeded
+	}
+
+	if !fs.hasSpaceFor(entry.size - before) {
+		return false, errQuotaExceeded
+	}
+
+	r, err := entry.open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	// Extracted files are written the same way as uploads, so replacing a file keeps a version
+	// of it and is atomic when that is enabled, and the new file is scanned once it is written.
+	request := sftp.NewRequest("Put", fs.relativePath(dest))
+	request.Flags = fxfWrite | fxfCreat | fxfTrunc
+
+	w, err := fs.Filewrite(request)
+	if err != nil {
+		return false, err
+	}
+
+	// The size recorded in the archive is what the quota was checked against, so never write
+	// more than that no matter what the compressed data expands to.
+	_, err = fs.copy(&offsetWriter{w: w}, io.LimitReader(r, entry.size))
+	if u, ok := w.(interface{ commit() }); ok && err == nil {
+		u.commit()
+	}
+	closeWriter(w)
+	if err != nil {
+		return false, err
+	}
+
+	// Atomic uploads only replace the file once they are committed, so the times can't be set
+	// any earlier than this.
+	if !entry.modTime.IsZero() {
+		fs.backend().Chtimes(dest, entry.modTime, entry.modTime)
+	}
+
+	return true, nil
+}
+
+// Returns a function that calls fn for every entry in the archive, determining the format of
+// the archive from its name.
+func archiveReader(f File, name string) (func(fn func(archiveEntry) error) error, error) {
+	lower := strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+
+		z, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return nil, err
+		}
+
+		return func(fn func(archiveEntry) error) error {
+			for _, zf := range z.File {
+				err := fn(archiveEntry{
+					name:    zf.Name,
+					dir:     zf.FileInfo().IsDir(),
+					regular: zf.Mode().IsRegular(),
+					size:    int64(zf.UncompressedSize64),
+					modTime: zf.Modified,
+					open:    zf.Open,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+
+		return tarEntries(tar.NewReader(gz)), nil
+	case strings.HasSuffix(lower, ".tar"):
+		return tarEntries(tar.NewReader(f)), nil
+	}
+
+	return nil, errors.New("unsupported archive format")
+}
+
+func tarEntries(t *tar.Reader) func(fn func(archiveEntry) error) error {
+	return func(fn func(archiveEntry) error) error {
+		for {
+			hdr, err := t.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			err = fn(archiveEntry{
+				name:    hdr.Name,
+				dir:     hdr.Typeflag == tar.TypeDir,
+				regular: hdr.FileInfo().Mode().IsRegular(),
+				size:    hdr.Size,
+				modTime: hdr.ModTime,
+				open: func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(t), nil
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
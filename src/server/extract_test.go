@@ -0,0 +1,72 @@
+package server
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+func writeTestArchive(t *testing.T, fs *FileSystem, name string, files map[string]string, modTime time.Time) {
+	f, err := os.Create(filepath.Join(fs.Directory, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	z := zip.NewWriter(f)
+	for name, data := range files {
+		w, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractVersions(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		fs := newTestFileSystem(t)
+		fs.MaxVersions = 1
+		fs.AtomicUploads = atomic
+
+		modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		writeTestArchive(t, fs, "backup.zip", map[string]string{"server.properties": "motd=new\n"}, modTime)
+		if err := ioutil.WriteFile(filepath.Join(fs.Directory, "server.properties"), []byte("motd=old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		assertCopyError(t, "extract archive", fs.extractArchive("/backup.zip", "/"), sftp.ErrSshFxOk)
+
+		p := filepath.Join(fs.Directory, "server.properties")
+		if b, err := ioutil.ReadFile(p); err != nil || string(b) != "motd=new\n" {
+			t.Errorf("atomic=%v: server.properties = %q, %v, want the extracted contents", atomic, b, err)
+		}
+
+		if info, err := os.Stat(p); err != nil {
+			t.Error(err)
+		} else if !info.ModTime().Equal(modTime) {
+			t.Errorf("atomic=%v: server.properties modified at %v, want %v", atomic, info.ModTime(), modTime)
+		}
+
+		versions, _ := filepath.Glob(filepath.Join(fs.Directory, versionsDirectory, "server.properties.*"))
+		if len(versions) != 1 {
+			t.Fatalf("atomic=%v: versions = %v, want one version of server.properties", atomic, versions)
+		}
+
+		if b, err := ioutil.ReadFile(versions[0]); err != nil || string(b) != "motd=old\n" {
+			t.Errorf("atomic=%v: version = %q, %v, want the replaced contents", atomic, b, err)
+		}
+	}
+}
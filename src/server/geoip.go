@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pterodactyl/sftp-server/src/geoip"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// Loads the GeoIP database used to restrict which countries connections are accepted from,
+// if one has been configured.
+func (s *Server) loadGeoIP() error {
+	if s.config.Settings.GeoIPDatabase == "" {
+		return nil
+	}
+
+	db, err := geoip.Open(s.config.Settings.GeoIPDatabase)
+	if err != nil {
+		return err
+	}
+
+	s.geoip = db
+	return nil
+}
+
+// Determines if connections are accepted from an address based on the country it is in,
+// returning the country along with the result. When countries are allowed only connections from
+// them are accepted, otherwise connections are accepted from anywhere other than the countries
+// that are denied. Addresses that are not in the database, such as private addresses, are
+// always accepted.
+func (s *Server) countryAllowed(ip string) (string, bool) {
+	if s.geoip == nil {
+		return "", true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", true
+	}
+
+	country, err := s.geoip.Country(addr)
+	if err != nil {
+		logger.Get().Warnw("failed to look up the country of an address", zap.String("ip", ip), zap.Error(err))
+		return "", true
+	}
+
+	if country == "" {
+		return "", true
+	}
+
+	if len(s.config.Settings.AllowCountries) > 0 {
+		return country, containsCountry(s.config.Settings.AllowCountries, country)
+	}
+
+	return country, !containsCountry(s.config.Settings.DenyCountries, country)
+}
+
+func containsCountry(list []string, country string) bool {
+	for _, c := range list {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,38 +4,85 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/buger/jsonparser"
 	cache "github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/containment"
 	"github.com/pterodactyl/sftp-server/src/logger"
 	"go.uber.org/zap"
 )
 
 type FileSystem struct {
-	ServerConfig     string
-	Directory        string
-	UUID             string
-	Permissions      []string
-	ReadOnly         bool
-	DisableDiskCheck bool
-	User             SftpUser
-	Cache            *cache.Cache
-	lock             sync.Mutex
+	ServerConfig            string
+	Directory               string
+	Backend                 Backend
+	UUID                    string
+	Permissions             []string
+	ProtectedPaths          []string
+	BlockedFiles            []string
+	HideFiles               string
+	FileMode                os.FileMode
+	DirectoryMode           os.FileMode
+	AtomicUploads           bool
+	PartialUploads          bool
+	TransferBufferSize      int
+	ReadAhead               int
+	Trash                   bool
+	MaxFileSize             int64
+	MaxFiles                int64
+	RestrictRecursiveDelete bool
+	RestrictDownloads       bool
+	AllowedOperations       []string
+	DeniedOperations        []string
+	FileNamePolicy          string
+	MaxVersions             int
+	ReadOnly                bool
+	DisableDiskCheck        bool
+	User                    SftpUser
+	Cache                   *cache.Cache
+	lock                    sync.Mutex
+	uploads                 map[string][]*atomicUpload
+
+	// The logger for the session this handler belongs to, which includes the details of the
+	// session with every entry.
+	logger *zap.SugaredLogger
+	// Receives an event for every change made to the files of the server.
+	events func(e FileEvent)
+	// Reports if the server has been made read-only since the handler was created.
+	readOnly func() bool
+	// Returns the permissions of the user if they have changed since the handler was created.
+	permissions func() ([]string, bool)
+	// Called for every file operation performed with the handler, so that they can be counted
+	// in the statistics for the server.
+	operations func()
+	// Scans files for malware once they have been uploaded. Nothing is scanned if this is nil.
+	scanner *malwareScanner
+}
+
+// Returns the logger for this handler, falling back to the global logger if the handler was
+// not created for a specific session.
+func (fs *FileSystem) log() *zap.SugaredLogger {
+	if fs.logger == nil {
+		return logger.Get().With(zap.String("server", fs.UUID))
+	}
+
+	return fs.logger
 }
 
 // Fileread creates a reader for a file on the system and returns the reader back.
-func (fs FileSystem) Fileread(request *sftp.Request) (io.ReaderAt, error) {
-	// Check first if the user can actually open and view a file. This permission is named
-	// really poorly, but it is checking if they can read. There is an addition permission,
-	// "save-files" which determines if they can write that file.
-	if !fs.can("edit-files") {
-		return nil, sftp.ErrSshFxPermissionDenied
+func (fs *FileSystem) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	fs.countOperation()
+
+	// Check first if the user can actually open and view a file. There is an addition
+	// permission, "save-files" which determines if they can write that file.
+	if !fs.canDownload() {
+		return nil, errMissingPermission("download-files")
 	}
 
 	p, err := fs.buildPath(request.Filepath)
@@ -46,23 +93,25 @@ func (fs FileSystem) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	fs.lock.Lock()
 	defer fs.lock.Unlock()
 
-	if _, err := os.Stat(p); os.IsNotExist(err) {
+	if _, err := fs.backend().Stat(p); os.IsNotExist(err) {
 		return nil, sftp.ErrSshFxNoSuchFile
 	}
 
-	file, err := os.Open(p)
+	file, err := fs.openFile(p, os.O_RDONLY, 0)
 	if err != nil {
-		logger.Get().Errorw("could not open file for reading", zap.String("source", p), zap.Error(err))
-		return nil, sftp.ErrSshFxFailure
+		fs.log().Errorw("could not open file for reading", zap.String("source", p), zap.Error(err))
+		return nil, translateError(err)
 	}
 
-	return file, nil
+	return fs.readAhead(file), nil
 }
 
 // Filewrite handles the write actions for a file on the system.
-func (fs FileSystem) Filewrite(request *sftp.Request) (io.WriterAt, error) {
-	if fs.ReadOnly {
-		return nil, sftp.ErrSshFxOpUnsupported
+func (fs *FileSystem) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	fs.countOperation()
+
+	if fs.isReadOnly() {
+		return nil, errReadOnly
 	}
 
 	p, err := fs.buildPath(request.Filepath)
@@ -70,56 +119,103 @@ func (fs FileSystem) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 		return nil, sftp.ErrSshFxNoSuchFile
 	}
 
+	if p, err = fs.newName(p); err != nil {
+		return nil, err
+	}
+
+	// Protected paths can never be written to, regardless of the permissions the user has.
+	if fs.isProtected(p) {
+		return nil, errFileProtected
+	}
+
+	if fs.isBlocked(p) {
+		fs.log().Infow("denying write to blocked file name", zap.String("source", p))
+		return nil, errFileBlocked
+	}
+
 	// If the user doesn't have enough space left on the server it should respond with an
 	// error since we won't be letting them write this file to the disk.
 	if !fs.hasSpace() {
-		logger.Get().Infow("denying file write due to space limit", zap.String("server", fs.UUID))
-		return nil, sftp.ErrSshFxFailure
+		fs.log().Infow("denying file write due to space limit")
+		return nil, errQuotaExceeded
 	}
 
 	fs.lock.Lock()
 	defer fs.lock.Unlock()
 
-	stat, statErr := os.Stat(p)
+	stat, statErr := fs.backend().Stat(p)
 	// If the file doesn't exist we need to create it, as well as the directory pathway
 	// leading up to where that file will be created.
 	if os.IsNotExist(statErr) {
+		// Clients opening a file that must already exist, such as to append to it, don't expect
+		// it to be created for them.
+		if request.Flags&fxfCreat == 0 {
+			return nil, sftp.ErrSshFxNoSuchFile
+		}
+
 		// This is a different pathway than just editing an existing file. If it doesn't exist already
 		// we need to determine if this user has permission to create files.
 		if !fs.can("create-files") {
-			return nil, sftp.ErrSshFxPermissionDenied
+			return nil, errMissingPermission("create-files")
+		}
+
+		if !fs.hasFilesFor(1) {
+			fs.log().Infow("denying file creation due to file limit", zap.String("source", p))
+			return nil, errFileLimitExceeded
 		}
 
 		// Create all of the directories leading up to the location where this file is being created.
-		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
-			logger.Get().Errorw("error making path for file",
+		if err := fs.backend().MkdirAll(filepath.Dir(p), fs.DirectoryMode); err != nil {
+			fs.log().Errorw("error making path for file",
 				zap.String("source", p),
 				zap.String("path", filepath.Dir(p)),
 				zap.Error(err),
 			)
-			return nil, sftp.ErrSshFxFailure
+			return nil, translateError(err)
 		}
 
-		file, err := os.Create(p)
+		if fs.AtomicUploads {
+			if request.Flags&fxfTrunc == 0 {
+				if w, ok := fs.resumeAtomicUpload(request, p); ok {
+					return fs.limitWriter(w, p, 0), nil
+				}
+			}
+
+			w, err := fs.createAtomicUpload(request, p, fs.FileMode)
+			if err != nil {
+				return nil, err
+			}
+
+			return fs.limitWriter(w, p, 0), nil
+		}
+
+		file, err := fs.openFile(p, openFlags(request.Flags), fs.FileMode)
 		if err != nil {
-			logger.Get().Errorw("error creating file", zap.String("source", p), zap.Error(err))
-			return nil, sftp.ErrSshFxFailure
+			fs.log().Errorw("error creating file", zap.String("source", p), zap.Error(err))
+			return nil, translateError(err)
+		}
+		fs.trackFiles(1)
+
+		// The mode passed when creating the file is subject to the umask of the process, so
+		// explicitly set it to make sure the file ends up with the configured mode.
+		if err := fs.backend().Chmod(p, fs.FileMode); err != nil {
+			fs.log().Warnw("error setting file mode", zap.String("file", p), zap.Error(err))
 		}
 
 		// Not failing here is intentional. We still made the file, it is just owned incorrectly
 		// and will likely cause some issues.
-		if err := os.Chown(p, fs.User.Uid, fs.User.Gid); err != nil {
-			logger.Get().Warnw("error chowning file", zap.String("file", p), zap.Error(err))
+		if err := fs.chown(p); err != nil {
+			fs.log().Warnw("error chowning file", zap.String("file", p), zap.Error(err))
 		}
 
-		return file, nil
+		return fs.limitWriter(&notifyingFile{File: appendIfRequested(request, file), fs: fs, path: p}, p, 0), nil
 	}
 
 	// If the stat error isn't about the file not existing, there is some other issue
 	// at play and we need to go ahead and bail out of the process.
 	if statErr != nil {
-		logger.Get().Errorw("error performing file stat", zap.String("source", p), zap.Error(statErr))
-		return nil, sftp.ErrSshFxFailure
+		fs.log().Errorw("error performing file stat", zap.String("source", p), zap.Error(statErr))
+		return nil, translateError(statErr)
 	}
 
 	// If we've made it here it means the file already exists and we don't need to do anything
@@ -128,39 +224,86 @@ func (fs FileSystem) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	//
 	// But first, check that the user has permission to save modified files.
 	if !fs.can("save-files") {
-		return nil, sftp.ErrSshFxPermissionDenied
+		return nil, errMissingPermission("save-files")
 	}
 
 	// Not sure this would ever happen, but lets not find out.
 	if stat.IsDir() {
-		logger.Get().Warnw("attempted to open a directory for writing to", zap.String("source", p))
+		fs.log().Warnw("attempted to open a directory for writing to", zap.String("source", p))
 		return nil, sftp.ErrSshFxOpUnsupported
 	}
 
-	file, err := os.Create(p)
+	if isSpecialFile(stat) {
+		fs.log().Warnw("attempted to open a special file for writing to", zap.String("source", p))
+		return nil, errSpecialFile
+	}
+
+	// The client asked for the file to be created and for that to fail if it already exists.
+	if request.Flags&fxfCreat != 0 && request.Flags&fxfExcl != 0 {
+		return nil, errFileExists
+	}
+
+	// Only a file that is being replaced has its current contents saved and is written
+	// atomically. Anything else is appending to or modifying the file in place, which needs to
+	// happen to the file itself.
+	truncate := request.Flags&fxfTrunc != 0
+
+	// Keep a copy of the file as it is right now before anything is written over it.
+	if truncate {
+		fs.saveVersion(p)
+	}
+
+	// An interrupted upload replacing this file is continued rather than modifying the file
+	// that it is going to replace.
+	if fs.AtomicUploads && !truncate {
+		if w, ok := fs.resumeAtomicUpload(request, p); ok {
+			return fs.limitWriter(w, p, stat.Size()), nil
+		}
+	}
+
+	// When uploads are atomic the existing file is left untouched until the new one has been
+	// completely written, at which point it replaces the existing file (keeping its mode).
+	if fs.AtomicUploads && truncate {
+		w, err := fs.createAtomicUpload(request, p, stat.Mode().Perm())
+		if err != nil {
+			return nil, err
+		}
+
+		return fs.limitWriter(w, p, stat.Size()), nil
+	}
+
+	file, err := fs.openFile(p, openFlags(request.Flags), 0666)
 	if err != nil {
-		logger.Get().Errorw("error opening existing file",
+		fs.log().Errorw("error opening existing file",
 			zap.Uint32("flags", request.Flags),
 			zap.String("source", p),
 			zap.Error(err),
 		)
-		return nil, sftp.ErrSshFxFailure
+		return nil, translateError(err)
 	}
 
 	// Not failing here is intentional. We still made the file, it is just owned incorrectly
 	// and will likely cause some issues.
-	if err := os.Chown(p, fs.User.Uid, fs.User.Gid); err != nil {
-		logger.Get().Warnw("error chowning file", zap.String("file", p), zap.Error(err))
+	if err := fs.chown(p); err != nil {
+		fs.log().Warnw("error chowning file", zap.String("file", p), zap.Error(err))
 	}
 
-	return file, nil
+	return fs.limitWriter(&notifyingFile{File: appendIfRequested(request, file), fs: fs, path: p, size: stat.Size()}, p, stat.Size()), nil
 }
 
 // Filecmd hander for basic SFTP system calls related to files, but not anything to do with reading
 // or writing to those files.
-func (fs FileSystem) Filecmd(request *sftp.Request) error {
-	if fs.ReadOnly {
-		return sftp.ErrSshFxOpUnsupported
+func (fs *FileSystem) Filecmd(request *sftp.Request) error {
+	fs.countOperation()
+
+	// Operations the node has disabled can't be used by anyone, whatever their permissions.
+	if !fs.operationAllowed(request.Method) {
+		fs.log().Debugw("denying disabled operation", zap.String("method", request.Method), zap.String("source", request.Filepath))
+		return operationErrors[operationName(request.Method)]
+	}
+
+	if fs.isReadOnly() {
+		return errReadOnly
 	}
 
 	p, err := fs.buildPath(request.Filepath)
@@ -179,88 +322,234 @@ func (fs FileSystem) Filecmd(request *sftp.Request) error {
 		}
 	}
 
+	// The path being created by the command has to follow the file name policy of the node,
+	// which may change the name it is created with. For symlinks this is the link itself.
+	switch request.Method {
+	case "Mkdir":
+		p, err = fs.newName(p)
+	case "Rename", "Symlink", "Link":
+		if target != "" {
+			target, err = fs.newName(target)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Protected paths (and anything containing them) can never be modified, moved or removed,
+	// regardless of the permissions the user has. Creating a symlink that points to a protected
+	// path is fine, since anything done through the link still resolves to the protected path.
+	if (request.Method != "Symlink" && fs.containsProtected(p)) || (target != "" && fs.containsProtected(target)) {
+		return errFileProtected
+	}
+
 	switch request.Method {
 	case "Setstat":
-		var mode os.FileMode = 0644
+		attrs := request.Attributes()
 
-		// If the client passed a valid file permission use that, otherwise use the
-		// default of 0644 set above.
-		if request.Attributes().FileMode().Perm() != 0000 {
-			mode = request.Attributes().FileMode().Perm()
-		}
+		// Clients that only want to change the times of a file, such as after an upload, don't
+		// send any permissions, so leave the mode alone rather than resetting it.
+		if request.AttrFlags().Permissions {
+			var mode = fs.FileMode
+
+			// If the client passed a valid file permission use that, otherwise use the
+			// default file mode set above.
+			if attrs.FileMode().Perm() != 0000 {
+				mode = attrs.FileMode().Perm()
+			}
 
-		// Force directories to be 0755
-		if request.Attributes().FileMode().IsDir() {
-			mode = 0755
+			// Force directories to use the default directory mode.
+			if attrs.FileMode().IsDir() {
+				mode = fs.DirectoryMode
+			}
+
+			err := fs.applyAttributes(request.Filepath, p, func(name string) error {
+				return fs.backend().Chmod(name, mode)
+			})
+			if err != nil {
+				fs.log().Errorw("failed to perform setstat", zap.Error(err))
+				return translateError(err)
+			}
 		}
 
-		if err := os.Chmod(p, mode); err != nil {
-			logger.Get().Errorw("failed to perform setstat", zap.Error(err))
-			return sftp.ErrSshFxFailure
+		if request.AttrFlags().Acmodtime {
+			if err := fs.setTimes(request.Filepath, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+				fs.log().Errorw("failed to set file times", zap.String("source", p), zap.Error(err))
+				return translateError(err)
+			}
 		}
 		return nil
 	case "Rename":
-		if !fs.can("move-files") {
-			return sftp.ErrSshFxPermissionDenied
+		if missing := fs.missingRenamePermission(p, target); missing != "" {
+			return errMissingPermission(missing)
+		}
+
+		// Otherwise a blocked file could be uploaded under a different name and then renamed
+		// into place.
+		if fs.isBlocked(target) {
+			fs.log().Infow("denying rename to blocked file name", zap.String("source", p), zap.String("target", target))
+			return errFileBlocked
 		}
 
-		if err := os.Rename(p, target); err != nil {
-			logger.Get().Errorw("failed to rename file",
+		if err := fs.backend().Rename(p, target); err != nil {
+			fs.log().Errorw("failed to rename file",
 				zap.String("source", p),
 				zap.String("target", target),
 				zap.Error(err),
 			)
-			return sftp.ErrSshFxFailure
+			return translateError(err)
 		}
 
+		fs.notify(EventRename, p, target)
 		break
 	case "Rmdir":
 		if !fs.can("delete-files") {
-			return sftp.ErrSshFxPermissionDenied
+			return errMissingPermission("delete-files")
 		}
 
-		if err := os.RemoveAll(p); err != nil {
-			logger.Get().Errorw("failed to remove directory", zap.String("source", p), zap.Error(err))
-			return sftp.ErrSshFxFailure
+		// Removing a directory along with everything in it can require its own permission, in
+		// which case users without it can only remove directories that are already empty.
+		remove := fs.backend().RemoveAll
+		if !fs.canDeleteRecursive() {
+			remove = fs.backend().Remove
+
+			// Moving a directory into the trash would otherwise remove everything in it, so
+			// make sure it is empty first.
+			if empty, err := fs.isEmptyDirectory(p); err != nil {
+				fs.log().Errorw("failed to check if directory is empty", zap.String("source", p), zap.Error(err))
+				return translateError(err)
+			} else if !empty {
+				fs.log().Debugw("denying removal of non-empty directory", zap.String("source", p))
+				return errMissingPermission("delete-files-recursive")
+			}
+		}
+
+		// Anything already in the trash is removed for good, otherwise we'd never be able to
+		// actually get rid of anything.
+		if fs.Trash && !fs.inTrash(p) {
+			return fs.moveToTrash(p)
 		}
 
+		size, count := fs.pathSize(p)
+		if err := remove(p); err != nil {
+			fs.log().Errorw("failed to remove directory", zap.String("source", p), zap.Error(err))
+			return translateError(err)
+		}
+
+		fs.trackUsage(-size)
+		fs.trackFiles(-count)
+		fs.notify(EventDelete, p, "")
 		return sftp.ErrSshFxOk
 	case "Mkdir":
 		if !fs.can("create-files") {
-			return sftp.ErrSshFxPermissionDenied
+			return errMissingPermission("create-files")
+		}
+
+		if !fs.hasSpace() {
+			fs.log().Infow("denying directory creation due to space limit", zap.String("source", p))
+			return errQuotaExceeded
 		}
 
-		if err := os.MkdirAll(p, 0755); err != nil {
-			logger.Get().Errorw("failed to create directory", zap.String("source", p), zap.Error(err))
-			return sftp.ErrSshFxFailure
+		if !fs.hasFilesFor(1) {
+			fs.log().Infow("denying directory creation due to file limit", zap.String("source", p))
+			return errFileLimitExceeded
 		}
 
+		_, statErr := fs.backend().Stat(p)
+		if err := fs.backend().MkdirAll(p, fs.DirectoryMode); err != nil {
+			fs.log().Errorw("failed to create directory", zap.String("source", p), zap.Error(err))
+			return translateError(err)
+		}
+
+		if os.IsNotExist(statErr) {
+			fs.trackFiles(1)
+		}
+
+		fs.notify(EventMkdir, p, "")
 		break
 	case "Symlink":
 		if !fs.can("create-files") {
-			return sftp.ErrSshFxPermissionDenied
+			return errMissingPermission("create-files")
+		}
+
+		if !fs.hasFilesFor(1) {
+			fs.log().Infow("denying symlink creation due to file limit", zap.String("source", p))
+			return errFileLimitExceeded
 		}
 
-		if err := os.Symlink(p, target); err != nil {
-			logger.Get().Errorw("failed to create symlink",
+		// A link with a blocked name gives the game server the same file to load as uploading
+		// it would.
+		if fs.isBlocked(target) {
+			fs.log().Infow("denying symlink to blocked file name", zap.String("source", p), zap.String("target", target))
+			return errFileBlocked
+		}
+
+		if err := fs.backend().Symlink(p, target); err != nil {
+			fs.log().Errorw("failed to create symlink",
 				zap.String("source", p),
 				zap.String("target", target),
 				zap.Error(err),
 			)
-			return sftp.ErrSshFxFailure
+			return translateError(err)
 		}
 
+		fs.trackFiles(1)
+		break
+	case "Link":
+		if !fs.can("create-files") {
+			return errMissingPermission("create-files")
+		}
+
+		if !fs.hasFilesFor(1) {
+			fs.log().Infow("denying hardlink creation due to file limit", zap.String("source", p))
+			return errFileLimitExceeded
+		}
+
+		// Both the source and the target have already been validated as being within the
+		// server directory above, so a hard link cannot be used to reach outside of it.
+		if target == "" {
+			return sftp.ErrSshFxBadMessage
+		}
+
+		if fs.isBlocked(target) {
+			fs.log().Infow("denying hardlink to blocked file name", zap.String("source", p), zap.String("target", target))
+			return errFileBlocked
+		}
+
+		if err := fs.backend().Link(p, target); err != nil {
+			fs.log().Errorw("failed to create hardlink",
+				zap.String("source", p),
+				zap.String("target", target),
+				zap.Error(err),
+			)
+			return translateError(err)
+		}
+
+		// The disk usage counts the size of every link to a file, the same as walking the
+		// server directory does.
+		fs.trackUsage(fs.fileSize(target))
+		fs.trackFiles(1)
+
 		break
 	case "Remove":
 		if !fs.can("delete-files") {
-			return sftp.ErrSshFxPermissionDenied
+			return errMissingPermission("delete-files")
 		}
 
-		if err := os.Remove(p); err != nil {
-			logger.Get().Errorw("failed to remove a file", zap.String("source", p), zap.Error(err))
-			return sftp.ErrSshFxFailure
+		if fs.Trash && !fs.inTrash(p) {
+			return fs.moveToTrash(p)
 		}
 
+		size := fs.fileSize(p)
+		if err := fs.backend().Remove(p); err != nil {
+			fs.log().Errorw("failed to remove a file", zap.String("source", p), zap.Error(err))
+			return translateError(err)
+		}
+
+		fs.trackUsage(-size)
+		fs.trackFiles(-1)
+		fs.notify(EventDelete, p, "")
 		return sftp.ErrSshFxOk
 	default:
 		return sftp.ErrSshFxOpUnsupported
@@ -274,8 +563,8 @@ func (fs FileSystem) Filecmd(request *sftp.Request) error {
 	// Not failing here is intentional. We still made the file, it is just owned incorrectly
 	// and will likely cause some issues. There is no logical check for if the file was removed
 	// because both of those cases (Rmdir, Remove) have an explicit return rather than break.
-	if err := os.Chown(fileLocation, fs.User.Uid, fs.User.Gid); err != nil {
-		logger.Get().Warnw("error chowning file", zap.String("file", fileLocation), zap.Error(err))
+	if err := fs.chown(fileLocation); err != nil {
+		fs.log().Warnw("error chowning file", zap.String("file", fileLocation), zap.Error(err))
 	}
 
 	return sftp.ErrSshFxOk
@@ -283,8 +572,16 @@ func (fs FileSystem) Filecmd(request *sftp.Request) error {
 
 // Filelist is the handler for SFTP filesystem list calls. This will handle calls to list the contents of
 // a directory as well as perform file/folder stat calls.
-func (fs FileSystem) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
-	p, err := fs.buildPath(request.Filepath)
+func (fs *FileSystem) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	fs.countOperation()
+
+	// Lstat needs the path of a symlink itself, rather than wherever it points to.
+	resolve := fs.buildPath
+	if request.Method == "Lstat" {
+		resolve = fs.buildLinkPath
+	}
+
+	p, err := resolve(request.Filepath)
 	if err != nil {
 		return nil, sftp.ErrSshFxNoSuchFile
 	}
@@ -292,27 +589,65 @@ func (fs FileSystem) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	switch request.Method {
 	case "List":
 		if !fs.can("list-files") {
-			return nil, sftp.ErrSshFxPermissionDenied
+			return nil, errMissingPermission("list-files")
 		}
 
-		files, err := ioutil.ReadDir(p)
+		if r, ok := fs.backend().(DirReader); ok {
+			d, err := r.OpenDir(p)
+			if err != nil {
+				fs.log().Error("error listing directory", zap.Error(err))
+				return nil, translateError(err)
+			}
+
+			return newDirLister(d, func(files []os.FileInfo) []os.FileInfo {
+				return filterSpecial(fs.filterHidden(p, files))
+			}), nil
+		}
+
+		files, err := fs.backend().ReadDir(p)
 		if err != nil {
-			logger.Get().Error("error listing directory", zap.Error(err))
-			return nil, sftp.ErrSshFxFailure
+			fs.log().Error("error listing directory", zap.Error(err))
+			return nil, translateError(err)
 		}
 
-		return ListerAt(files), nil
+		// Special files are left out entirely, since there is nothing a client could do with
+		// them other than try to open them.
+		return ListerAt(filterSpecial(fs.filterHidden(p, files))), nil
 	case "Stat":
 		if !fs.can("list-files") {
-			return nil, sftp.ErrSshFxPermissionDenied
+			return nil, errMissingPermission("list-files")
+		}
+
+		// Clients work out where to resume an upload from using the size of the file, so while
+		// there is a partial upload for it that is what gets reported.
+		if info, ok := fs.partialUpload(p); ok {
+			return ListerAt([]os.FileInfo{renamedFileInfo{FileInfo: info, name: filepath.Base(p)}}), nil
+		}
+
+		s, err := fs.backend().Stat(p)
+		if os.IsNotExist(err) {
+			return nil, sftp.ErrSshFxNoSuchFile
+		} else if err != nil {
+			fs.log().Error("error running STAT on file", zap.Error(err))
+			return nil, translateError(err)
+		} else if isSpecialFile(s) {
+			return nil, errSpecialFile
+		}
+
+		return ListerAt([]os.FileInfo{s}), nil
+	case "Lstat":
+		if !fs.can("list-files") {
+			return nil, errMissingPermission("list-files")
 		}
 
-		s, err := os.Stat(p)
+		s, err := fs.backend().Lstat(p)
 		if os.IsNotExist(err) {
 			return nil, sftp.ErrSshFxNoSuchFile
 		} else if err != nil {
-			logger.Get().Error("error running STAT on file", zap.Error(err))
-			return nil, sftp.ErrSshFxFailure
+			fs.log().Error("error running LSTAT on file", zap.Error(err))
+			return nil, translateError(err)
+		} else if isSpecialFile(s) {
+			return nil, errSpecialFile
 		}
 
 		return ListerAt([]os.FileInfo{s}), nil
@@ -326,38 +661,62 @@ func (fs FileSystem) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	}
 }
 
+// Flushes any data written to a file out to the disk. The file handle used by the request
+// server isn't available to us here, so the file is opened again; calling fsync on any
+// descriptor for a file flushes all of the data written to it.
+func (fs *FileSystem) fsync(rawPath string) error {
+	p, err := fs.buildPath(rawPath)
+	if err != nil {
+		return sftp.ErrSshFxNoSuchFile
+	}
+
+	file, err := fs.openFile(p, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		fs.log().Errorw("could not open file for fsync", zap.String("source", p), zap.Error(err))
+		return translateError(err)
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		fs.log().Errorw("failed to fsync file", zap.String("source", p), zap.Error(err))
+		return translateError(err)
+	}
+
+	return sftp.ErrSshFxOk
+}
+
 // Normalizes a directory we get from the SFTP request to ensure the user is not able to escape
 // from their data directory. After normalization if the directory is still within their home
 // path it is returned. If they managed to "escape" an error will be returned.
-func (fs FileSystem) buildPath(rawPath string) (string, error) {
+func (fs *FileSystem) buildPath(rawPath string) (string, error) {
 	var nonExistentPathResolution string
-	// Calling filepath.Clean on the joined directory will resolve it to the absolute path,
-	// removing any ../ type of path resolution, and leaving us with a direct path link.
-	r := filepath.Clean(filepath.Join(fs.Directory, rawPath))
+	// Joining the path to the server directory resolves it to the absolute path, removing any
+	// ../ type of path resolution and leaving us with a direct path link. Paths that would climb
+	// out of the server directory, or that contain NUL bytes or invalid UTF-8, are rejected.
+	r, err := containment.Join(fs.Directory, rawPath)
+	if err != nil {
+		return "", err
+	}
 
 	// At the same time, evaluate the symlink status and determine where this file or folder
 	// is truly pointing to.
-	p, err := filepath.EvalSymlinks(r)
+	p, err := fs.backend().EvalSymlinks(r)
 	if err != nil && !os.IsNotExist(err) {
 		return "", err
 	} else if os.IsNotExist(err) {
 		// The requested directory doesn't exist, so at this point we need to iterate up the
-		// path chain until we hit a directory that _does_ exist and can be validated.
-		parts := strings.Split(filepath.Dir(r), "/")
-
-		var try string
-		// Range over all of the path parts and form directory pathings from the end
-		// moving up until we have a valid resolution or we run out of paths to try.
-		for k := range parts {
-			try = strings.Join(parts[:(len(parts) - k)], "/")
-
-			if !strings.HasPrefix(try, fs.Directory) {
+		// path chain until we hit a directory that _does_ exist and can be validated, stopping
+		// if we leave the server directory and run out of paths to try.
+		for try := filepath.Dir(r); containment.Within(try, fs.Directory); try = filepath.Dir(try) {
+			t, err := fs.backend().EvalSymlinks(try)
+			if err == nil {
+				nonExistentPathResolution = t
 				break
 			}
 
-			t, err := filepath.EvalSymlinks(try)
-			if err == nil {
-				nonExistentPathResolution = t
+			if try == filepath.Dir(try) {
 				break
 			}
 		}
@@ -366,7 +725,7 @@ func (fs FileSystem) buildPath(rawPath string) (string, error) {
 	// If the new path doesn't start with their root directory there is clearly an escape
 	// attempt going on, and we should NOT resolve this path for them.
 	if nonExistentPathResolution != "" {
-		if !strings.HasPrefix(nonExistentPathResolution, fs.Directory) {
+		if !containment.Within(nonExistentPathResolution, fs.Directory) {
 			return "", errors.New("invalid path resolution")
 		}
 
@@ -380,27 +739,57 @@ func (fs FileSystem) buildPath(rawPath string) (string, error) {
 	// If the requested directory from EvalSymlinks begins with the server root directory go
 	// ahead and return it. If not we'll return an error which will block any further action
 	// on the file.
-	if strings.HasPrefix(p, fs.Directory) {
+	if containment.Within(p, fs.Directory) {
 		return p, nil
 	}
 
 	return "", errors.New("invalid path resolution")
 }
 
+// Normalizes a path the same way as buildPath, except that if the path is a symlink it is not
+// followed. Only the directory containing the path is resolved, so this returns the location of
+// the link itself even if it points somewhere outside of the server directory.
+func (fs *FileSystem) buildLinkPath(rawPath string) (string, error) {
+	r, err := containment.Join(fs.Directory, rawPath)
+	if err != nil {
+		return "", err
+	}
+
+	if r == filepath.Clean(fs.Directory) {
+		return r, nil
+	}
+
+	dir, err := fs.buildPath(filepath.Dir(filepath.Clean("/" + rawPath)))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, filepath.Base(r)), nil
+}
+
 // Determines if a user has permission to perform a specific action on the SFTP server. These
 // permissions are defined and returned by the Panel API.
-func (fs FileSystem) can(permission string) bool {
+func (fs *FileSystem) can(permission string) bool {
+	granted := fs.Permissions
+	if fs.permissions != nil {
+		if p, ok := fs.permissions(); ok {
+			granted = p
+		}
+	}
+
 	// Server owners and super admins have their permissions returned as '[*]' via the Panel
 	// API, so for the sake of speed do an initial check for that before iterating over the
 	// entire array of permissions.
-	if len(fs.Permissions) == 1 && fs.Permissions[0] == "*" {
+	if len(granted) == 1 && granted[0] == "*" {
 		return true
 	}
 
 	// Not the owner or an admin, loop over the permissions that were returned to determine
-	// if they have the passed permission.
-	for _, p := range fs.Permissions {
-		if p == permission {
+	// if they have the passed permission, either by its original name or by its name in the
+	// namespaced scheme.
+	scoped := scopedPermissions[permission]
+	for _, p := range granted {
+		if permissionMatches(p, permission) || (scoped != "" && permissionMatches(p, scoped)) {
 			return true
 		}
 	}
@@ -408,12 +797,74 @@ func (fs FileSystem) can(permission string) bool {
 	return false
 }
 
+// Determines if the user is allowed to read the contents of files. This is the "download-files"
+// permission, but unless the node requires it anyone able to edit files in the Panel can also
+// download them, since that was previously the only permission checked.
+func (fs *FileSystem) canDownload() bool {
+	if fs.can("download-files") {
+		return true
+	}
+
+	return !fs.RestrictDownloads && fs.can("edit-files")
+}
+
+// Determines if the user is allowed to remove a directory that still has files in it. Unless
+// the node requires a separate permission for this, anyone able to delete files can do so.
+func (fs *FileSystem) canDeleteRecursive() bool {
+	if !fs.RestrictRecursiveDelete {
+		return true
+	}
+
+	return fs.can("delete-files-recursive")
+}
+
+// Returns the permission the user is missing to rename the source to the target, or an empty
+// string if they can. Renaming a file within the directory it is in only needs "rename-files",
+// so it can be granted without letting files be moved anywhere. Moving a file to another
+// directory needs "move-files", along with "create-files" since it adds a file to the target
+// directory. Anyone able to move files can rename them.
+func (fs *FileSystem) missingRenamePermission(source string, target string) string {
+	if filepath.Dir(source) == filepath.Dir(target) {
+		if fs.can("rename-files") || fs.can("move-files") {
+			return ""
+		}
+
+		return "rename-files"
+	}
+
+	if !fs.can("move-files") {
+		return "move-files"
+	}
+
+	if !fs.can("create-files") {
+		return "create-files"
+	}
+
+	return ""
+}
+
+// Determines if the given directory has nothing in it.
+func (fs *FileSystem) isEmptyDirectory(p string) (bool, error) {
+	files, err := fs.backend().ReadDir(p)
+	if err != nil {
+		return false, err
+	}
+
+	return len(files) == 0, nil
+}
+
 // Determines if the directory a file is trying to be added to has enough space available
 // for the file to be written to.
+func (fs *FileSystem) hasSpace() bool {
+	return fs.hasSpaceFor(0)
+}
+
+// Determines if the server has enough space available for the given number of bytes to be
+// added to it without going over its disk limit.
 //
 // Because determining the amount of space being used by a server is a taxing operation we
 // will load it all up into a cache and pull from that as long as the key is not expired.
-func (fs FileSystem) hasSpace() bool {
+func (fs *FileSystem) hasSpaceFor(n int64) bool {
 	// This is a safety measure to ensure that users who encounter FS related issues can
 	// quickly disable this feature to allow me time to look into what is going wrong and
 	// hopefully address it.
@@ -423,6 +874,22 @@ func (fs FileSystem) hasSpace() bool {
 		return true
 	}
 
+	space := fs.diskLimit()
+
+	// If space is -1 or 0 just return true, means they're allowed unlimited.
+	if space <= 0 {
+		fs.log().Debugw("server marked as not having space limit")
+		return true
+	}
+
+	// Determine if their folder size, in bytes, is smaller than the amount of space they've
+	// been allocated.
+	return fs.diskUsage()+n <= space*1024*1024
+}
+
+// Returns the amount of disk space in megabytes the server has been allocated, where a value of
+// zero or less means there is no limit.
+func (fs *FileSystem) diskLimit() int64 {
 	var space int64 = -2
 	if x, exists := fs.Cache.Get("disk:" + fs.UUID); exists {
 		space = x.(int64)
@@ -430,17 +897,16 @@ func (fs FileSystem) hasSpace() bool {
 
 	// If the value is still -2 it means we didn't manage to grab anything out of the cache.
 	// In that case, read the server configuration and then plop that value into the cache.
-	// If there is an error reading the configuration just return true, can't do anything more
-	// until the error gets resolved.
+	// If there is an error reading the configuration treat the server as unlimited, can't do
+	// anything more until the error gets resolved.
 	if space == -2 {
 		b, err := ioutil.ReadFile(fs.ServerConfig)
 		if err != nil {
-			logger.Get().Errorf(
+			fs.log().Errorw(
 				"error reading server configuration, cannot determine disk limit",
-				zap.String("server", fs.UUID),
 				zap.Error(err),
 			)
-			return true
+			return 0
 		}
 
 		s, err := jsonparser.GetInt(b, "build", "disk")
@@ -452,57 +918,38 @@ func (fs FileSystem) hasSpace() bool {
 		space = s
 	}
 
-	// If space is -1 or 0 just return true, means they're allowed unlimited.
-	if space <= 0 {
-		logger.Get().Debugw("server marked as not having space limit", zap.String("server", fs.UUID))
-		return true
-	}
-
-	var size int64
-	if x, exists := fs.Cache.Get("used:" + fs.UUID); exists {
-		size = x.(int64)
-	}
-
-	// If there is no size its either because there is no data (in which case running this function
-	// will have effectively no impact), or there is nothing in the cache, in which case we need to
-	// grab the size of their data directory. This is a taxing operation, so we want to store it in
-	// the cache once we've gotten it.
-	if size == 0 {
-		size = fs.directorySize(fs.Directory)
-		logger.Get().Debugw("got directory size from taxing operation", zap.String("server", fs.UUID), zap.Int64("size", size))
-		fs.Cache.Set("used:"+fs.UUID, size, cache.DefaultExpiration)
-	}
-
-	// Determine if their folder size, in bytes, is smaller than the amount of space they've
-	// been allocated.
-	return (size / 1024.0 / 1024.0) <= space
+	return space
 }
 
 // Determines the directory size of a given location by running parallel tasks to iterate
-// through all of the folders. Returns the size in bytes.
-func (fs FileSystem) directorySize(dir string) int64 {
-	var size int64
+// through all of the folders. Returns the size in bytes, along with the number of files and
+// directories within it.
+func (fs *FileSystem) directorySize(dir string) (int64, int64) {
+	var size, count int64
 	var wg sync.WaitGroup
 
-	files, err := ioutil.ReadDir(dir)
+	files, err := fs.backend().ReadDir(dir)
 	if err != nil {
-		logger.Get().Errorw("error reading directory", zap.String("directory", dir), zap.Error(err))
-		return 0
+		fs.log().Errorw("error reading directory", zap.String("directory", dir), zap.Error(err))
+		return 0, 0
 	}
 
+	atomic.AddInt64(&count, int64(len(files)))
 	for _, f := range files {
 		if f.IsDir() {
 			wg.Add(1)
 			go func(p string) {
 				defer wg.Done()
-				size += fs.directorySize(p)
-			}(path.Join(dir, f.Name()))
+				s, c := fs.directorySize(p)
+				atomic.AddInt64(&size, s)
+				atomic.AddInt64(&count, c)
+			}(filepath.Join(dir, f.Name()))
 		} else {
-			size += f.Size()
+			atomic.AddInt64(&size, f.Size())
 		}
 	}
 
 	wg.Wait()
 
-	return size
+	return size, count
 }
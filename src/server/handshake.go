@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// The headers containing the nonce sent with each request to the Panel, and the signature
+	// of the response to it.
+	nonceHeader     = "X-Pterodactyl-Nonce"
+	signatureHeader = "X-Pterodactyl-Signature"
+
+	// How long before the session token from a handshake expires that a new handshake is
+	// performed, so that requests in flight don't use a token that is about to expire.
+	handshakeRenewal = time.Minute
+)
+
+// Returned when the signature of a response from the Panel is missing or does not match, which
+// means the response did not come from the Panel or was replayed from another one.
+var errInvalidSignature = errors.New("response from panel has an invalid signature")
+
+// A handshakeError is returned when the Panel rejects the handshake, as opposed to the Panel
+// being unreachable.
+type handshakeError struct {
+	status int
+	body   string
+}
+
+func (e *handshakeError) Error() string {
+	return fmt.Sprintf("panel rejected the node handshake with status %d: %s", e.status, e.body)
+}
+
+// Authenticates requests to the Panel using a short lived session token rather than the static
+// node token. The node token is only ever sent to perform a handshake, in which the Panel
+// returns the details of the node along with a session token and a signing key, both of which
+// are rotated with each handshake. Every request includes a random nonce, and the Panel signs
+// each response along with the nonce using the signing key, so a response can't be forged or
+// replayed in place of another one.
+//
+// A nil handshake sends the static node token with every request, and does not verify the
+// responses.
+type panelHandshake struct {
+	url    string
+	token  string
+	client *http.Client
+
+	mu      sync.Mutex
+	session string
+	key     []byte
+	expires time.Time
+}
+
+// The response from the Panel to a handshake.
+type handshakeResponse struct {
+	Token      string `json:"token"`
+	SigningKey string `json:"signing_key"`
+	ExpiresIn  int64  `json:"expires_in"`
+	Node       struct {
+		UUID string `json:"uuid"`
+		Name string `json:"name"`
+	} `json:"node"`
+}
+
+// Creates the handshake for the Panel defined in the Daemon configuration.
+func newPanelHandshake(config []byte, tlsConfig *tls.Config) *panelHandshake {
+	url, _ := jsonparser.GetString(config, "remote", "base")
+	token, _ := jsonparser.GetString(config, "keys", "[0]")
+
+	return &panelHandshake{url: url, token: token, client: newPanelClient(tlsConfig, 10*time.Second)}
+}
+
+// Returns the session token and signing key to use for a request, performing a handshake first
+// if there is no session or it is about to expire.
+func (h *panelHandshake) credentials(breaker *circuitBreaker) (string, []byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.session != "" && time.Now().Before(h.expires.Add(-handshakeRenewal)) {
+		return h.session, h.key, nil
+	}
+
+	if h.url == "" || h.token == "" {
+		return "", nil, fmt.Errorf("no panel url or token is configured")
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/remote/sftp/handshake", h.url), nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.pterodactyl.v1+json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", h.token))
+
+	resp, err := doPanelRequest(h.client, breaker, req, []byte("{}"))
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	// Server errors mean the Panel, or the proxy in front of it, is down rather than that the
+	// node was rejected, so they are treated the same as the Panel being unreachable.
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return "", nil, fmt.Errorf("panel returned status %d for the node handshake", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		s, _ := ioutil.ReadAll(resp.Body)
+		return "", nil, &handshakeError{status: resp.StatusCode, body: string(s)}
+	}
+
+	var body handshakeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(body.SigningKey)
+	if err != nil || len(key) == 0 || body.Token == "" || body.ExpiresIn <= 0 {
+		return "", nil, errors.New("panel returned an invalid handshake response")
+	}
+
+	logger.Get().Infow("completed handshake with panel",
+		zap.String("node", body.Node.UUID),
+		zap.String("name", body.Node.Name),
+		zap.Int64("expires_in", body.ExpiresIn),
+	)
+
+	h.session = body.Token
+	h.key = key
+	h.expires = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+
+	return h.session, h.key, nil
+}
+
+// Forgets the session token so that the next request performs a new handshake, unless another
+// request has already replaced it.
+func (h *panelHandshake) expire(session string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.session == session {
+		h.session = ""
+	}
+}
+
+// Sends a request to the Panel, authenticating it with the session token from the handshake and
+// verifying the signature of the response. If the Panel no longer accepts the session token a
+// new handshake is performed and the request is sent again. Handshakes rejected by the Panel
+// return a handshakeError, and responses that fail verification return errInvalidSignature.
+//
+// When the handshake is nil the request is sent with the static node token instead.
+func (h *panelHandshake) do(client *http.Client, breaker *circuitBreaker, req *http.Request, body []byte, token string) (*http.Response, error) {
+	if h == nil {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		return doPanelRequest(client, breaker, req, body)
+	}
+
+	for attempt := 1; ; attempt++ {
+		session, key, err := h.credentials(breaker)
+		if err != nil {
+			return nil, err
+		}
+
+		n := make([]byte, 16)
+		rand.Read(n)
+		nonce := hex.EncodeToString(n)
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session))
+		req.Header.Set(nonceHeader, nonce)
+
+		resp, err := doPanelRequest(client, breaker, req, body)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 1 {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			h.expire(session)
+			continue
+		}
+
+		// Errors from the Panel being down usually come from a proxy in front of it, and are
+		// never signed. Nothing in them is trusted anyways, since they are only ever treated
+		// as the Panel being unavailable.
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return resp, nil
+		}
+
+		if err := verifyResponse(resp, key, nonce); err != nil {
+			logger.Get().Warnw("rejecting response from panel", zap.String("path", req.URL.Path), zap.Error(err))
+			return nil, err
+		}
+
+		return resp, nil
+	}
+}
+
+// Checks the signature of a response from the Panel, which is a hex encoded HMAC-SHA256 of the
+// nonce sent with the request, a newline, and the body of the response. The body is read in
+// full to do so, and replaced so that it can still be read by the caller.
+func verifyResponse(resp *http.Response, key []byte, nonce string) error {
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nonce + "\n"))
+	mac.Write(b)
+
+	signature, err := hex.DecodeString(resp.Header.Get(signatureHeader))
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return errInvalidSignature
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandshakeUnavailable(t *testing.T) {
+	tests := []struct {
+		status      int
+		unavailable bool
+	}{
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		panel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		defer panel.Close()
+
+		a := &PanelAuthenticator{URL: panel.URL, Token: "node-token", breaker: newCircuitBreaker()}
+		a.handshake = &panelHandshake{url: panel.URL, token: "node-token", client: panel.Client()}
+
+		// Only the Panel being down should allow falling back to cached credentials, not the
+		// Panel rejecting the node.
+		_, err := a.post("/api/remote/sftp/auth", map[string]string{})
+
+		var unavailable *UnavailableError
+		if errors.As(err, &unavailable) != tt.unavailable {
+			t.Errorf("handshake with status %d: post = %v, want unavailable %v", tt.status, err, tt.unavailable)
+		}
+	}
+}
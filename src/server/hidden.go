@@ -0,0 +1,70 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The entries that are hidden from directory listings. Hiding internal entries hides the trash,
+// versions and partial upload directories along with any uploads that are still in progress,
+// while hiding dotfiles hides everything with a name beginning with a dot, which includes all
+// of those.
+const (
+	HideNone     = "none"
+	HideInternal = "internal"
+	HideDotfiles = "dotfiles"
+)
+
+// Returns the mode entries are hidden with, preferring the given mode if it is valid and
+// falling back to the default otherwise. This is used for the mode returned by the Panel for
+// a server, which takes priority over the mode set for the node.
+func parseHideFiles(mode string, def string) string {
+	switch mode {
+	case HideNone, HideInternal, HideDotfiles:
+		return mode
+	}
+
+	return def
+}
+
+// Determines if an entry in the given directory should be left out of listings. Hidden entries
+// can still be accessed directly by their path, so the trash can still be used to restore files
+// without it getting in the way of everything else.
+func (fs *FileSystem) isHidden(dir string, info os.FileInfo) bool {
+	name := info.Name()
+	if !strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	switch fs.HideFiles {
+	case HideDotfiles:
+		return true
+	case HideInternal:
+		if strings.Contains(name, ".sftp-upload-") {
+			return true
+		}
+
+		if filepath.Clean(dir) == filepath.Clean(fs.Directory) {
+			return name == trashDirectory || name == versionsDirectory || name == partialDirectory
+		}
+	}
+
+	return false
+}
+
+// Returns the entries of a directory that are not hidden.
+func (fs *FileSystem) filterHidden(dir string, files []os.FileInfo) []os.FileInfo {
+	if fs.HideFiles == "" || fs.HideFiles == HideNone {
+		return files
+	}
+
+	visible := files[:0]
+	for _, f := range files {
+		if !fs.isHidden(dir, f) {
+			visible = append(visible, f)
+		}
+	}
+
+	return visible
+}
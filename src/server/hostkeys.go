@@ -0,0 +1,309 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// The types of host key that can be generated, along with the file each one is kept in within
+// the .sftp directory next to the Daemon configuration. Every key that exists is offered to
+// clients, in this order.
+var hostKeyTypes = []struct {
+	name string
+	file string
+}{
+	{"ed25519", "id_ed25519"},
+	{"ecdsa", "id_ecdsa"},
+	{"rsa", "id_rsa"},
+}
+
+// Returns the path the host key of the given type is kept at.
+func hostKeyPath(basePath string, keyType string) (string, error) {
+	for _, t := range hostKeyTypes {
+		if t.name == keyType {
+			return filepath.Join(basePath, ".sftp", t.file), nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported host key type %q, must be one of ed25519, ecdsa or rsa", keyType)
+}
+
+// Generates a new host key of the given type for the server, replacing any existing key of the
+// same type, and returns the path it was written to. Clients that have connected before will
+// warn that the host key has changed once it is replaced.
+func GenerateHostKey(basePath string, keyType string) (string, error) {
+	p, err := hostKeyPath(basePath, keyType)
+	if err != nil {
+		return "", err
+	}
+
+	var block *pem.Block
+	switch keyType {
+	case "ed25519":
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: marshalED25519Key(pub, key)}
+	case "ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", err
+		}
+
+		b, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+	case "rsa":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+
+	if err := ioutil.WriteFile(p, pem.EncodeToMemory(block), 0600); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+// Encodes an ed25519 key in the unencrypted OpenSSH private key format, which is the only format
+// ed25519 keys can be parsed from by the ssh package.
+func marshalED25519Key(pub ed25519.PublicKey, key ed25519.PrivateKey) []byte {
+	check := make([]byte, 4)
+	rand.Read(check)
+
+	private := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+	}{
+		Check1:  binary.BigEndian.Uint32(check),
+		Check2:  binary.BigEndian.Uint32(check),
+		Keytype: ssh.KeyAlgoED25519,
+		Pub:     pub,
+		Priv:    key,
+	})
+
+	// The private key block is padded to a multiple of the cipher block size, which is 8 when
+	// it is not encrypted.
+	for i := byte(1); len(private)%8 != 0; i++ {
+		private = append(private, i)
+	}
+
+	return append([]byte("openssh-key-v1\x00"), ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{
+		CipherName: "none",
+		KdfName:    "none",
+		NumKeys:    1,
+		PubKey: ssh.Marshal(struct {
+			Keytype string
+			Pub     []byte
+		}{ssh.KeyAlgoED25519, pub}),
+		PrivKeyBlock: private,
+	})...)
+}
+
+// Loads every host key the server has. When there are none an RSA key is generated, which is
+// the only type of key older versions of the server used.
+func (c Configuration) hostKeys() ([]ssh.Signer, error) {
+	signers, err := c.loadHostKeys()
+	if err != nil || len(signers) > 0 {
+		return signers, err
+	}
+
+	logger.Get().Info("creating new private key for server")
+	if _, err := GenerateHostKey(c.Settings.BasePath, "rsa"); err != nil {
+		return nil, err
+	}
+
+	return c.loadHostKeys()
+}
+
+// Loads the host keys that exist for the server, without generating one if there are none.
+func (c Configuration) loadHostKeys() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, t := range hostKeyTypes {
+		b, err := ioutil.ReadFile(filepath.Join(c.Settings.BasePath, ".sftp", t.file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s host key: %w", t.name, err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+// Uses the keys as the host keys for every connection accepted from now on. Connections that
+// have already been accepted keep using the keys they were accepted with.
+func (s *Server) setHostKeys(keys []ssh.Signer) {
+	config := *s.sshBase
+	for _, key := range keys {
+		config.AddHostKey(key)
+	}
+
+	s.mu.Lock()
+	s.hostKeys = keys
+	s.mu.Unlock()
+
+	s.sshConfig.Store(&config)
+}
+
+// Returns the configuration used to accept new connections.
+func (s *Server) currentSSHConfig() *ssh.ServerConfig {
+	return s.sshConfig.Load().(*ssh.ServerConfig)
+}
+
+// Reads the host keys of the server again, and uses them for every connection accepted from
+// then on without affecting the sessions that are already connected. This allows the keys to
+// be rotated without a restart. The keys already in use are kept if any of the new ones can't
+// be read, or if there are none left.
+func (s *Server) ReloadHostKeys() error {
+	keys, err := s.config.loadHostKeys()
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return errors.New("no host keys exist")
+	}
+
+	s.setHostKeys(keys)
+
+	logger.Get().Infow("reloaded host keys", zap.Strings("fingerprints", hostKeyFingerprints(keys)))
+
+	return nil
+}
+
+// Returns the type and SHA-256 fingerprint of each key.
+func hostKeyFingerprints(keys []ssh.Signer) []string {
+	fingerprints := make([]string, 0, len(keys))
+	for _, k := range keys {
+		fingerprints = append(fingerprints, fmt.Sprintf("%s %s", k.PublicKey().Type(), ssh.FingerprintSHA256(k.PublicKey())))
+	}
+
+	return fingerprints
+}
+
+// The size and modification time of a host key file, which are compared to tell when it has
+// been replaced.
+type hostKeyStat struct {
+	size    int64
+	modTime time.Time
+}
+
+// Returns the size and modification time of each host key file that exists.
+func (c Configuration) statHostKeys() map[string]hostKeyStat {
+	stats := make(map[string]hostKeyStat)
+	for _, t := range hostKeyTypes {
+		if info, err := os.Stat(filepath.Join(c.Settings.BasePath, ".sftp", t.file)); err == nil {
+			stats[t.file] = hostKeyStat{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+
+	return stats
+}
+
+// Periodically checks if any of the host key files have been created, replaced or removed,
+// reloading the keys when they have. This runs until the done channel is closed.
+func (s *Server) watchHostKeys(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := s.config.statHostKeys()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		// A key that can't be read could still be in the middle of being written, in which
+		// case it is changed again once it has been and is reloaded then.
+		current := s.config.statHostKeys()
+		if hostKeysChanged(last, current) {
+			if err := s.ReloadHostKeys(); err != nil {
+				logger.Get().Errorw("failed to reload host keys, continuing to use the previous keys", zap.Error(err))
+			}
+		}
+		last = current
+	}
+}
+
+func hostKeysChanged(a, b map[string]hostKeyStat) bool {
+	if len(a) != len(b) {
+		return true
+	}
+
+	for file, stat := range a {
+		if other, ok := b[file]; !ok || other.size != stat.size || !other.modTime.Equal(stat.modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handles requests to /host-keys. GET returns the fingerprints of the host keys in use, and POST
+// reloads them.
+func (s *Server) handleHostKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := s.ReloadHostKeys(); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	s.mu.Lock()
+	keys := s.hostKeys
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"fingerprints": hostKeyFingerprints(keys)})
+}
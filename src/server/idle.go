@@ -0,0 +1,97 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// Keeps track of the last time any SFTP traffic was received from the client for a single
+// connection. Every SFTP operation (including each chunk of a read or write) is a packet sent
+// by the client, so tracking inbound data on the channel is enough to know if it is idle.
+type activityTracker struct {
+	last int64
+}
+
+func newActivityTracker() *activityTracker {
+	a := &activityTracker{}
+	a.touch()
+
+	return a
+}
+
+// Marks the connection as having been active right now.
+func (a *activityTracker) touch() {
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+// Returns the last time the connection was active.
+func (a *activityTracker) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&a.last))
+}
+
+// Returns the amount of time that has passed since the connection was last active.
+func (a *activityTracker) idleFor() time.Duration {
+	return time.Since(a.lastActive())
+}
+
+// Wraps a SSH channel and marks the connection as active whenever data is received on it, as
+// well as keeping count of the data transferred over it.
+type trackedChannel struct {
+	ssh.Channel
+	session *session
+}
+
+func (t trackedChannel) Read(p []byte) (int, error) {
+	n, err := t.Channel.Read(p)
+	if n > 0 {
+		t.session.activity.touch()
+		atomic.AddInt64(&t.session.received, int64(n))
+		if t.session.transfer != nil {
+			atomic.AddInt64(&t.session.transfer.received, int64(n))
+		}
+	}
+
+	return n, err
+}
+
+func (t trackedChannel) Write(p []byte) (int, error) {
+	n, err := t.Channel.Write(p)
+	atomic.AddInt64(&t.session.sent, int64(n))
+	if t.session.transfer != nil {
+		atomic.AddInt64(&t.session.transfer.sent, int64(n))
+	}
+
+	return n, err
+}
+
+// Disconnects the session once it has gone longer than the configured timeout without
+// performing any SFTP operations. Returns once the connection is closed, or the done channel
+// is closed.
+func watchIdle(sess *session, timeout time.Duration, done <-chan struct{}) {
+	interval := timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if sess.activity.idleFor() < timeout {
+				continue
+			}
+
+			sess.log.Infow("disconnecting idle session", zap.Duration("timeout", timeout))
+
+			sess.conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package server_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/pterodactyl/sftp-server/src/server"
+	"github.com/pterodactyl/sftp-server/src/server/servertest"
+)
+
+// Set for the processes started by TestRestartSandboxed to the directory the server keeps
+// everything in.
+const sandboxTestEnv = "SFTP_TEST_SANDBOX_DIR"
+
+func TestRestartSandboxed(t *testing.T) {
+	if dir := os.Getenv(sandboxTestEnv); dir != "" {
+		restartSandboxed(t, dir)
+		return
+	}
+
+	// The sandbox can't be lifted once it is applied, so the server is run in a new process of
+	// the test binary, which then restarts into another.
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRestartSandboxed$")
+	cmd.Env = append(os.Environ(), sandboxTestEnv+"="+dir)
+
+	out, err := cmd.CombinedOutput()
+	for _, unsupported := range []string{"landlock is not supported", "landlock version", "CGO_ENABLED=0"} {
+		if bytes.Contains(out, []byte(unsupported)) {
+			t.Skipf("the sandbox can't be used here: %s", out)
+		}
+	}
+
+	if err != nil {
+		t.Fatalf("restarting the sandboxed server failed: %s\n%s", err, out)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "data", "restarted")); err != nil {
+		t.Fatalf("the new process did not start: %s\n%s", err, out)
+	}
+}
+
+// Starts a sandboxed server and restarts it, or just starts it if this is the new process it
+// restarted into, which marks that it started by writing a file in the data directory.
+func restartSandboxed(t *testing.T, dir string) {
+	restarted := os.Getenv("SFTP_HANDOVER_LISTENERS") != ""
+
+	c := servertest.Configuration(dir)
+	c.Settings.Sandbox = true
+	s := servertest.Start(t, c, server.WithAuthenticator(testAuthenticator(map[string][]string{"dane": {"*"}})))
+
+	if restarted {
+		if err := ioutil.WriteFile(filepath.Join(dir, "data", "restarted"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	if err := s.Restart(); err != nil {
+		t.Fatalf("failed to restart: %s", err)
+	}
+}
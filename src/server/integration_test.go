@@ -0,0 +1,535 @@
+package server_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/server"
+	"github.com/pterodactyl/sftp-server/src/server/servertest"
+)
+
+const (
+	testServer   = "d5a35a3e-7b6b-4d57-9f6e-30b061ac244c"
+	testPassword = "password"
+)
+
+// Accepts the test password for each of the users, giving them the permissions listed for them
+// on the test server.
+func testAuthenticator(users map[string][]string) server.Authenticator {
+	return server.AuthenticatorFunc(func(user string, pass []byte) (*server.AuthenticationResponse, error) {
+		permissions, ok := users[user]
+		if !ok || string(pass) != testPassword {
+			return nil, errors.New("bad credentials provided")
+		}
+
+		return &server.AuthenticationResponse{Server: testServer, User: user, Permissions: permissions}, nil
+	})
+}
+
+// Starts a server for the users, returning it along with the directory of the test server.
+func startServer(t *testing.T, users map[string][]string) (*servertest.Server, string) {
+	s := servertest.NewServer(t, testAuthenticator(users))
+
+	dir := s.ServerDirectory(testServer)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, dir
+}
+
+func assertPermissionDenied(t *testing.T, op string, err error) {
+	t.Helper()
+
+	var status *sftp.StatusError
+	if !errors.As(err, &status) || status.Code != uint32(sftp.ErrSshFxPermissionDenied) {
+		t.Errorf("%s = %v, want permission denied", op, err)
+	}
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	s, dir := startServer(t, map[string][]string{"dane": {"*"}})
+	client := s.Client(t, "dane", testPassword)
+
+	data := bytes.Repeat([]byte("server.properties\n"), 10000)
+
+	// Directories leading up to an uploaded file are created for it.
+	f, err := client.Create("/config/server.properties")
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "config", "server.properties"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, data) {
+		t.Errorf("uploaded file has %d bytes, want %d", len(b), len(data))
+	}
+
+	if f, err = client.Open("/config/server.properties"); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+
+	if b, err = ioutil.ReadAll(f); err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+
+	if !bytes.Equal(b, data) {
+		t.Errorf("downloaded file has %d bytes, want %d", len(b), len(data))
+	}
+
+	info, err := client.Stat("/config/server.properties")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+
+	if info.Size() != int64(len(data)) || info.Mode().Perm() != 0644 {
+		t.Errorf("Stat = %d bytes with mode %s, want %d bytes with mode -rw-r--r--", info.Size(), info.Mode().Perm(), len(data))
+	}
+}
+
+func TestRename(t *testing.T) {
+	s, dir := startServer(t, map[string][]string{"dane": {"*"}})
+	client := s.Client(t, "dane", testPassword)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "world.zip"), []byte("world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Mkdir("/backups"); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+
+	if err := client.Rename("/world.zip", "/backups/world.zip"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "world.zip")); !os.IsNotExist(err) {
+		t.Errorf("renamed file still exists at the old path: %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "backups", "world.zip")); err != nil || string(b) != "world" {
+		t.Errorf("renamed file = %q, %v, want %q", b, err, "world")
+	}
+
+	files, err := client.ReadDir("/backups")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+
+	if len(files) != 1 || files[0].Name() != "world.zip" {
+		t.Errorf("ReadDir = %v, want only world.zip", files)
+	}
+
+	if err := client.Rename("/missing.zip", "/backups/missing.zip"); err == nil {
+		t.Error("Rename of a missing file succeeded")
+	}
+}
+
+func TestRenameWithoutMove(t *testing.T) {
+	s, dir := startServer(t, map[string][]string{
+		"renamer": {"file.read", "file.rename"},
+		"mover":   {"file.read", "file.update"},
+	})
+
+	if err := os.MkdirAll(filepath.Join(dir, "plugins"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "plugins", "Example.jar"), []byte("jar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	renamer := s.Client(t, "renamer", testPassword)
+	if err := renamer.Rename("/plugins/Example.jar", "/plugins/Example.jar.disabled"); err != nil {
+		t.Fatalf("Rename within a directory: %s", err)
+	}
+
+	assertPermissionDenied(t, "Rename to another directory", renamer.Rename("/plugins/Example.jar.disabled", "/Example.jar"))
+
+	// Moving a file to another directory also needs the permission to create files there.
+	mover := s.Client(t, "mover", testPassword)
+	if err := mover.Rename("/plugins/Example.jar.disabled", "/plugins/Example.jar"); err != nil {
+		t.Fatalf("Rename within a directory: %s", err)
+	}
+
+	assertPermissionDenied(t, "Rename to another directory", mover.Rename("/plugins/Example.jar", "/Example.jar"))
+
+	if _, err := os.Stat(filepath.Join(dir, "plugins", "Example.jar")); err != nil {
+		t.Errorf("file was moved: %v", err)
+	}
+}
+
+func TestPermissionDenied(t *testing.T) {
+	s, dir := startServer(t, map[string][]string{"viewer": {"file.read", "file.read-content"}})
+	client := s.Client(t, "viewer", testPassword)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "server.properties"), []byte("motd=hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := client.Open("/server.properties")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != "motd=hello" {
+		t.Errorf("Read = %q, %v, want %q", b, err, "motd=hello")
+	}
+	f.Close()
+
+	_, err = client.Create("/new.txt")
+	assertPermissionDenied(t, "Create", err)
+
+	// Files opened without being created are only opened by the handler once they are first
+	// written to.
+	if f, err = client.OpenFile("/server.properties", os.O_WRONLY); err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+
+	_, err = f.Write([]byte("motd=changed"))
+	assertPermissionDenied(t, "Write", err)
+	f.Close()
+
+	assertPermissionDenied(t, "Mkdir", client.Mkdir("/plugins"))
+	assertPermissionDenied(t, "Rename", client.Rename("/server.properties", "/renamed.properties"))
+	assertPermissionDenied(t, "Remove", client.Remove("/server.properties"))
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "server.properties")); err != nil || string(b) != "motd=hello" {
+		t.Errorf("file was modified: %q, %v", b, err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("server directory has %d entries, want 1", len(files))
+	}
+}
+
+func TestDisabledOperations(t *testing.T) {
+	c := servertest.Configuration(t.TempDir())
+	c.Settings.AllowedOperations = []string{"mkdir", "rename", "remove", "setstat"}
+	c.Settings.DeniedOperations = []string{"remove"}
+
+	s := servertest.Start(t, c, server.WithAuthenticator(testAuthenticator(map[string][]string{"dane": {"*"}})))
+	dir := s.ServerDirectory(testServer)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "server.jar"), []byte("jar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := s.Client(t, "dane", testPassword)
+	if err := client.Mkdir("/plugins"); err != nil {
+		t.Errorf("Mkdir: %s", err)
+	}
+
+	for op, err := range map[string]error{
+		"Remove":  client.Remove("/server.jar"),
+		"Symlink": client.Symlink("/server.jar", "/link.jar"),
+	} {
+		var status *sftp.StatusError
+		if !errors.As(err, &status) || status.Code != uint32(sftp.ErrSshFxOpUnsupported) {
+			t.Errorf("%s = %v, want operation unsupported", op, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "server.jar")); err != nil {
+		t.Errorf("file was removed: %v", err)
+	}
+}
+
+func TestFileNamePolicy(t *testing.T) {
+	for _, policy := range []string{server.FileNamesReject, server.FileNamesNormalize} {
+		t.Run(policy, func(t *testing.T) {
+			c := servertest.Configuration(t.TempDir())
+			c.Settings.FileNamePolicy = policy
+
+			s := servertest.Start(t, c, server.WithAuthenticator(testAuthenticator(map[string][]string{"dane": {"*"}})))
+			dir := s.ServerDirectory(testServer)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			// Existing files are left alone even if their names wouldn't be allowed.
+			if err := ioutil.WriteFile(filepath.Join(dir, "old. "), []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			client := s.Client(t, "dane", testPassword)
+			if f, err := client.Create("/old. "); err != nil {
+				t.Errorf("Create of an existing file: %s", err)
+			} else {
+				f.Close()
+			}
+
+			f, err := client.Create("/logs\x1b/cafe\u0301.txt ")
+			if policy == server.FileNamesReject {
+				if err == nil {
+					f.Close()
+					t.Fatal("Create of a file with an invalid name succeeded")
+				}
+
+				if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+					t.Errorf("server directory has %d entries, want 1", len(files))
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Create: %s", err)
+			}
+			f.Close()
+
+			if _, err := os.Stat(filepath.Join(dir, "logs", "caf\u00e9.txt")); err != nil {
+				t.Errorf("file was not created with the normalized name: %v", err)
+			}
+		})
+	}
+}
+
+// Accepts the test password for any user, giving them the permissions currently set, and counts
+// the number of times the permissions are looked up.
+type lookupAuthenticator struct {
+	mu          sync.Mutex
+	permissions []string
+	lookups     int
+}
+
+func (a *lookupAuthenticator) Authenticate(user string, pass []byte) (*server.AuthenticationResponse, error) {
+	if string(pass) != testPassword {
+		return nil, errors.New("bad credentials provided")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return &server.AuthenticationResponse{Server: testServer, User: user, Permissions: a.permissions}, nil
+}
+
+func (a *lookupAuthenticator) Permissions(user string, server string) ([]string, error) {
+	// Slow enough that checks made at the same time have to wait for the same lookup.
+	time.Sleep(20 * time.Millisecond)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lookups++
+	return a.permissions, nil
+}
+
+func (a *lookupAuthenticator) set(permissions []string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.permissions = permissions
+	return a.lookups
+}
+
+func TestPermissionLookups(t *testing.T) {
+	c := servertest.Configuration(t.TempDir())
+	c.Settings.PermissionsTTL = 500 * time.Millisecond
+
+	auth := &lookupAuthenticator{permissions: []string{"*"}}
+	s := servertest.Start(t, c, server.WithAuthenticator(auth))
+	if err := os.MkdirAll(s.ServerDirectory(testServer), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	client := s.Client(t, "dane", testPassword)
+
+	// The permissions returned for the login are used until they expire.
+	if err := client.Mkdir("/plugins"); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+
+	if lookups := auth.set([]string{"file.read"}); lookups != 0 {
+		t.Errorf("permissions were looked up %d times straight after logging in", lookups)
+	}
+
+	time.Sleep(c.Settings.PermissionsTTL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Stat("/plugins"); err != nil {
+				t.Errorf("Stat: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assertPermissionDenied(t, "Mkdir", client.Mkdir("/config"))
+
+	if lookups := auth.set(nil); lookups != 1 {
+		t.Errorf("permissions were looked up %d times, want 1", lookups)
+	}
+}
+
+func TestSessionLimitFromPanel(t *testing.T) {
+	limits := map[string]int{"single": 1, "multi": 2}
+	s := servertest.NewServer(t, server.AuthenticatorFunc(func(user string, pass []byte) (*server.AuthenticationResponse, error) {
+		if string(pass) != testPassword {
+			return nil, errors.New("bad credentials provided")
+		}
+
+		return &server.AuthenticationResponse{Server: testServer, User: user, Permissions: []string{"*"}, MaxSessions: limits[user]}, nil
+	}))
+
+	for user, limit := range limits {
+		var clients []*servertest.Client
+		for i := 0; i < limit; i++ {
+			client, err := s.Dial(user, testPassword)
+			if err != nil {
+				t.Fatalf("session %d for %s: %s", i+1, user, err)
+			}
+			clients = append(clients, client)
+		}
+
+		if client, err := s.Dial(user, testPassword); err == nil {
+			client.Close()
+			t.Errorf("%s opened more than %d sessions", user, limit)
+		}
+
+		// Closing a session makes room for another, once the server has noticed it is gone.
+		clients[0].Close()
+
+		var err error
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			var client *servertest.Client
+			if client, err = s.Dial(user, testPassword); err == nil {
+				clients[0] = client
+				break
+			}
+		}
+
+		if err != nil {
+			t.Errorf("%s could not open a session after closing one: %s", user, err)
+		}
+
+		for _, client := range clients {
+			client.Close()
+		}
+	}
+}
+
+// Validates tokens for a single Panel user, who can only have one session open.
+type tokenAuthenticator struct {
+	user string
+}
+
+func (a tokenAuthenticator) Authenticate(user string, pass []byte) (*server.AuthenticationResponse, error) {
+	return nil, errors.New("bad credentials provided")
+}
+
+func (a tokenAuthenticator) AuthenticateToken(user string, token string) (*server.AuthenticationResponse, error) {
+	if token != "ptsftp_token" {
+		return nil, errors.New("bad credentials provided")
+	}
+
+	return &server.AuthenticationResponse{Server: testServer, User: a.user, Permissions: []string{"*"}, MaxSessions: 1}, nil
+}
+
+func TestTokenLoginSessionLimit(t *testing.T) {
+	c := servertest.Configuration(t.TempDir())
+	c.Settings.LoginTokens = true
+
+	s := servertest.Start(t, c, server.WithAuthenticator(tokenAuthenticator{user: "e2d1f3a0-4b5c-4d6e-8f70-812345678901"}))
+	client, err := s.Dial("dane", "ptsftp_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The limit belongs to the Panel user, so it can't be avoided by using another username.
+	if client, err := s.Dial("someone-else", "ptsftp_token"); err == nil {
+		client.Close()
+		t.Error("opened a second session for the user of the token with a different username")
+	}
+
+	client.Close()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if client, err = s.Dial("someone-else", "ptsftp_token"); err == nil {
+			client.Close()
+			break
+		}
+	}
+
+	if err != nil {
+		t.Errorf("could not log in with a different username after closing the session: %s", err)
+	}
+
+	// Tokens for an unknown user can't be limited, so they are rejected.
+	s = servertest.Start(t, c, server.WithAuthenticator(tokenAuthenticator{}))
+	if client, err := s.Dial("dane", "ptsftp_token"); err == nil {
+		client.Close()
+		t.Error("logged in with a token the Panel did not return a user for")
+	}
+}
+
+func TestListDenied(t *testing.T) {
+	s, _ := startServer(t, map[string][]string{"uploader": {"file.create"}})
+	client := s.Client(t, "uploader", testPassword)
+
+	_, err := client.ReadDir("/")
+	assertPermissionDenied(t, "ReadDir", err)
+}
+
+func TestOutsideServerDirectory(t *testing.T) {
+	s, dir := startServer(t, map[string][]string{"dane": {"*"}})
+	client := s.Client(t, "dane", testPassword)
+
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(dir), "other.txt"), []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err := client.Open("/../other.txt"); err == nil {
+		if b, err := ioutil.ReadAll(f); err == nil {
+			t.Errorf("read %q from a file outside the server directory", b)
+		}
+		f.Close()
+	}
+
+	if f, err := client.Create("/../../escaped.txt"); err == nil {
+		f.Close()
+	}
+
+	if _, err := os.Stat(filepath.Join(s.Dir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("file was created outside the server directory: %v", err)
+	}
+}
+
+func TestBadCredentials(t *testing.T) {
+	s, _ := startServer(t, map[string][]string{"dane": {"*"}})
+
+	if client, err := s.Dial("dane", "wrong"); err == nil {
+		client.Close()
+		t.Error("login with the wrong password succeeded")
+	}
+
+	if client, err := s.Dial("nobody", testPassword); err == nil {
+		client.Close()
+		t.Error("login for an unknown user succeeded")
+	}
+}
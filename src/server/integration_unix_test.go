@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package server_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSpecialFiles(t *testing.T) {
+	s, dir := startServer(t, map[string][]string{"dane": {"*"}})
+	client := s.Client(t, "dane", testPassword)
+
+	if err := syscall.Mkfifo(filepath.Join(dir, "console.pipe"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "server.jar"), []byte("jar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := client.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+
+	if len(files) != 1 || files[0].Name() != "server.jar" {
+		t.Errorf("ReadDir = %v, want only server.jar", files)
+	}
+
+	_, err = client.Stat("/console.pipe")
+	assertPermissionDenied(t, "Stat", err)
+
+	_, err = client.Lstat("/console.pipe")
+	assertPermissionDenied(t, "Lstat", err)
+
+	// Nothing is writing to the FIFO, so opening it would block forever if it were ever opened.
+	f, err := client.Open("/console.pipe")
+	if err == nil {
+		_, err = ioutil.ReadAll(f)
+		f.Close()
+	}
+	assertPermissionDenied(t, "Read", err)
+
+	if f, err = client.OpenFile("/console.pipe", os.O_WRONLY); err == nil {
+		_, err = f.Write([]byte("stop\n"))
+		f.Close()
+	}
+	assertPermissionDenied(t, "Write", err)
+
+	// Special files can still be removed.
+	if err := client.Remove("/console.pipe"); err != nil {
+		t.Errorf("Remove: %s", err)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+)
+
+// The upper bounds, in seconds, of the buckets that the time taken by SFTP operations is
+// counted in.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Keeps a histogram of how long SFTP operations take for each method and result, such as how
+// long it takes to open files for reading or to rename them. Only the time spent in the handler
+// is measured, so reading and writing the contents of a file once it has been opened is not.
+type latencyMetrics struct {
+	mu         sync.Mutex
+	histograms map[latencyKey]*histogram
+}
+
+type latencyKey struct {
+	method string
+	result string
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newLatencyMetrics() *latencyMetrics {
+	return &latencyMetrics{histograms: make(map[latencyKey]*histogram)}
+}
+
+// Records how long an operation took.
+func (m *latencyMetrics) observe(method string, err error, d time.Duration) {
+	key := latencyKey{method: method, result: operationResult(err)}
+	v := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.histograms[key] = h
+	}
+
+	for i, b := range latencyBuckets {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Writes the histograms in the Prometheus text format.
+func (m *latencyMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]latencyKey, 0, len(m.histograms))
+	for k := range m.histograms {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].result < keys[j].result
+	})
+
+	fmt.Fprint(w, "# HELP sftp_operation_duration_seconds The time taken to handle SFTP operations.\n")
+	fmt.Fprint(w, "# TYPE sftp_operation_duration_seconds histogram\n")
+	for _, k := range keys {
+		h := m.histograms[k]
+		labels := fmt.Sprintf("method=%q,result=%q", k.method, k.result)
+
+		for i, b := range latencyBuckets {
+			fmt.Fprintf(w, "sftp_operation_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, b, h.buckets[i])
+		}
+		fmt.Fprintf(w, "sftp_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "sftp_operation_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "sftp_operation_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+// Returns the name of the result of an operation that it is counted under.
+func operationResult(err error) string {
+	switch err {
+	case nil, sftp.ErrSshFxOk:
+		return "ok"
+	case sftp.ErrSshFxNoSuchFile:
+		return "no_such_file"
+	case sftp.ErrSshFxPermissionDenied:
+		return "permission_denied"
+	case sftp.ErrSshFxOpUnsupported:
+		return "unsupported"
+	case sftp.ErrSshFxFailure:
+		return "failure"
+	}
+
+	if code, ok := statusErrorCode(err); ok {
+		switch code {
+		case fxPermissionDenied:
+			return "permission_denied"
+		case fxOpUnsupported:
+			return "unsupported"
+		case fxFailure:
+			return "failure"
+		case fxQuotaExceeded:
+			return "quota_exceeded"
+		}
+	}
+
+	return "error"
+}
+
+// Wraps the handlers for an SFTP channel, recording how long each operation takes and tracing
+// each request when tracing is enabled. The spans for files that are opened cover the whole
+// transfer, and are only finished once the file is closed.
+type timedHandlers struct {
+	fs      *FileSystem
+	sess    *session
+	metrics *latencyMetrics
+}
+
+// Records how long a request took, and logs it when debug logging is enabled for the session so
+// that the requests leading up to a problem can be seen.
+func (t timedHandlers) observe(request *sftp.Request, err error, d time.Duration) {
+	t.metrics.observe(strings.ToLower(request.Method), err, d)
+
+	t.sess.log.Debugw("handled request",
+		zap.String("method", request.Method),
+		zap.String("source", request.Filepath),
+		zap.String("target", request.Target),
+		zap.Duration("duration", d),
+		zap.String("result", operationResult(err)),
+		zap.NamedError("error", err),
+	)
+}
+
+// Fails a request when the handler for it panics, rather than the panic taking down the process
+// along with every other session on the node.
+func (t timedHandlers) recoverPanic(request *sftp.Request, err *error) {
+	if r := recover(); r != nil {
+		logPanic(t.sess.log.With(zap.String("method", request.Method), zap.String("source", request.Filepath)), r)
+		*err = sftp.ErrSshFxFailure
+	}
+}
+
+func (t timedHandlers) Fileread(request *sftp.Request) (_ io.ReaderAt, err error) {
+	defer t.recoverPanic(request, &err)
+
+	span := t.sess.requestSpan(request)
+	start := time.Now()
+	r, err := t.fs.Fileread(request)
+	t.observe(request, err, time.Since(start))
+
+	if err != nil || span == nil {
+		span.finish(err)
+		return translateReader(r), err
+	}
+
+	return &tracedReader{ReaderAt: translateReader(r), span: span}, nil
+}
+
+func (t timedHandlers) Filewrite(request *sftp.Request) (_ io.WriterAt, err error) {
+	defer t.recoverPanic(request, &err)
+
+	span := t.sess.requestSpan(request)
+	start := time.Now()
+	w, err := t.fs.Filewrite(request)
+	t.observe(request, err, time.Since(start))
+
+	if err != nil || span == nil {
+		span.finish(err)
+		return translateWriter(w), err
+	}
+
+	return &tracedWriter{WriterAt: translateWriter(w), span: span}, nil
+}
+
+func (t timedHandlers) Filecmd(request *sftp.Request) (err error) {
+	defer t.recoverPanic(request, &err)
+
+	span := t.sess.requestSpan(request)
+	start := time.Now()
+	err = t.fs.Filecmd(request)
+	t.observe(request, err, time.Since(start))
+	span.finish(err)
+
+	return err
+}
+
+func (t timedHandlers) Filelist(request *sftp.Request) (_ sftp.ListerAt, err error) {
+	defer t.recoverPanic(request, &err)
+
+	span := t.sess.requestSpan(request)
+	start := time.Now()
+	l, err := t.fs.Filelist(request)
+	t.observe(request, err, time.Since(start))
+	span.finish(err)
+
+	return l, err
+}
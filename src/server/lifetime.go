@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// How long before the maximum lifetime of a session is reached that the client is warned that
+// it is about to be disconnected. Sessions with a short lifetime are warned halfway through.
+const lifetimeWarning = 5 * time.Minute
+
+// Disconnects the session once it has been connected for the maximum lifetime, warning the
+// client shortly before doing so. Returns once the connection is closed, or the done channel is
+// closed.
+func watchLifetime(sess *session, lifetime time.Duration, done <-chan struct{}) {
+	warning := lifetimeWarning
+	if warning > lifetime/2 {
+		warning = lifetime / 2
+	}
+
+	warn := time.NewTimer(time.Until(sess.connected.Add(lifetime - warning)))
+	defer warn.Stop()
+
+	select {
+	case <-done:
+		return
+	case <-warn.C:
+	}
+
+	sess.log.Debugw("warning session that it is about to reach its maximum lifetime", zap.Duration("lifetime", lifetime))
+	sess.writeStderr(fmt.Sprintf("This session has almost reached the maximum session length and will be disconnected in %s, please reconnect to continue.\r\n", warning.Round(time.Second)))
+
+	expire := time.NewTimer(time.Until(sess.connected.Add(lifetime)))
+	defer expire.Stop()
+
+	select {
+	case <-done:
+		return
+	case <-expire.C:
+	}
+
+	sess.log.Infow("disconnecting session that reached its maximum lifetime", zap.Duration("lifetime", lifetime))
+
+	sess.conn.Close()
+}
+
+// Writes a message to the stderr stream of every channel open on the session, which clients
+// such as OpenSSH show to the user.
+func (sess *session) writeStderr(message string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	for w := range sess.stderr {
+		io.WriteString(w, message)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// A limitedWriter rejects any write that would cause the file being written to grow beyond
+// the maximum allowed file size. This is enforced as the data arrives rather than relying on
+// the disk usage checks, which only notice a large upload once it has already been written.
+//
+// When the server has a disk limit writes are also rejected once the file has grown enough to
+// put the server over its limit, so a single large upload can't be used to get around it.
+type limitedWriter struct {
+	io.WriterAt
+	source string
+	limit  int64
+	log    *zap.SugaredLogger
+
+	// The handler used to check the disk usage of the server, and the size of the file before
+	// it was written to. This is nil if the server has no disk limit.
+	fs   *FileSystem
+	size int64
+
+	// Clients tend to send many writes at once, so only the first one rejected for being over
+	// the disk limit is logged.
+	quotaLog sync.Once
+}
+
+// Wraps the writer so that writes beyond the maximum file size, or beyond the disk limit of
+// the server, are rejected. The size is that of the file being replaced, if there is one. If
+// there are no limits the writer is returned as is.
+func (fs *FileSystem) limitWriter(w io.WriterAt, source string, size int64) io.WriterAt {
+	quota := !fs.DisableDiskCheck && fs.diskLimit() > 0
+	if fs.MaxFileSize <= 0 && !quota {
+		return w
+	}
+
+	lw := &limitedWriter{WriterAt: w, source: source, limit: fs.MaxFileSize, log: fs.log()}
+	if quota {
+		lw.fs = fs
+		lw.size = size
+	}
+
+	return lw
+}
+
+// WriteAt writes the data to the underlying writer as long as it does not extend the file
+// past the limit. Once a write has been rejected an atomic upload will never be moved into
+// place, even if the client goes on to close the handle normally.
+func (w *limitedWriter) WriteAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if w.limit > 0 && end > w.limit {
+		w.log.Infow("denying file write due to file size limit",
+			zap.String("source", w.source),
+			zap.Int64("limit", w.limit),
+		)
+		w.discard()
+
+		return 0, errFileTooLarge
+	}
+
+	// The disk usage of the server isn't updated until the file is closed, so compare how much
+	// the file has grown so far against the space left.
+	if w.fs != nil && end > w.size && !w.fs.hasSpaceFor(end-w.size) {
+		w.quotaLog.Do(func() {
+			w.log.Infow("denying file write due to space limit", zap.String("source", w.source))
+		})
+		w.discard()
+
+		return 0, errQuotaExceeded
+	}
+
+	return w.WriterAt.WriteAt(p, off)
+}
+
+// Close closes the underlying writer if it supports being closed.
+func (w *limitedWriter) Close() error {
+	if c, ok := w.WriterAt.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// Marks the underlying upload as complete, if it is an atomic upload.
+func (w *limitedWriter) commit() {
+	if u, ok := w.WriterAt.(*atomicUpload); ok {
+		u.commit()
+	}
+}
+
+// Marks the underlying upload as failed, if it is an atomic upload.
+func (w *limitedWriter) discard() {
+	if u, ok := w.WriterAt.(*atomicUpload); ok {
+		u.discard()
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracks the number of open connections from each remote IP address and the number of active
+// sessions for each Panel user so that a single client cannot exhaust the resources available
+// to everyone else on the node, along with the total number of connections to the node. A limit
+// of zero (or less) means that limit is not enforced.
+type connectionLimiter struct {
+	sync.Mutex
+	maxPerIP   int
+	maxPerUser int
+	ips        map[string]int
+	users      map[string]int
+
+	// The session limits the Panel returned for users with sessions open, which replace the
+	// limit for the node, so that they can be checked before a new login reaches the Panel.
+	userLimits map[string]int
+
+	// Holds a value for every open connection when there is a limit on the total number of
+	// connections, so that new connections block once it is full.
+	slots chan struct{}
+}
+
+func newConnectionLimiter(maxPerIP int, maxPerUser int, maxTotal int) *connectionLimiter {
+	l := &connectionLimiter{
+		maxPerIP:   maxPerIP,
+		maxPerUser: maxPerUser,
+		ips:        make(map[string]int),
+		users:      make(map[string]int),
+		userLimits: make(map[string]int),
+	}
+
+	if maxTotal > 0 {
+		l.slots = make(chan struct{}, maxTotal)
+	}
+
+	return l
+}
+
+// Registers a new connection to the node, returning false if the node is at the maximum number
+// of connections. If a timeout is given the connection waits in line for up to that long for an
+// existing connection to close, otherwise it is rejected straight away.
+func (l *connectionLimiter) acquireSlot(timeout time.Duration, done <-chan struct{}) bool {
+	if l.slots == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if timeout <= 0 {
+		return false
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-done:
+		return false
+	}
+}
+
+// Releases a connection previously registered with acquireSlot.
+func (l *connectionLimiter) releaseSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// Registers a new connection from the given IP address, returning false if doing so would
+// exceed the number of simultaneous connections allowed from that address.
+func (l *connectionLimiter) acquireIP(ip string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.maxPerIP > 0 && l.ips[ip] >= l.maxPerIP {
+		return false
+	}
+
+	l.ips[ip]++
+	return true
+}
+
+// Releases a connection previously registered with acquireIP.
+func (l *connectionLimiter) releaseIP(ip string) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.ips[ip] <= 1 {
+		delete(l.ips, ip)
+	} else {
+		l.ips[ip]--
+	}
+}
+
+// Determines if the given SFTP username is able to open another session without actually
+// registering one. This is used during authentication so we can fail early, before a request
+// is ever made to the Panel. The limit is the one returned by the Panel for the user, or zero
+// to use the last limit returned for them while they have sessions open, or the limit for the
+// node otherwise.
+func (l *connectionLimiter) userAllowed(username string, limit int) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	u := panelUser(username)
+	if limit <= 0 {
+		limit = l.userLimits[u]
+	}
+
+	return l.allows(u, limit)
+}
+
+// Registers a new session for the given SFTP username, returning false if doing so would
+// exceed the number of simultaneous sessions allowed for the Panel user. The limit is the one
+// returned by the Panel for the user, falling back to the limit for the node if it is zero.
+func (l *connectionLimiter) acquireUser(username string, limit int) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	u := panelUser(username)
+	if !l.allows(u, limit) {
+		return false
+	}
+
+	l.users[u]++
+	if limit > 0 {
+		l.userLimits[u] = limit
+	} else {
+		delete(l.userLimits, u)
+	}
+
+	return true
+}
+
+// Determines if the Panel user has fewer sessions open than the limit, or than the limit for
+// the node if it is zero.
+//
+// This must be called while holding the lock.
+func (l *connectionLimiter) allows(user string, limit int) bool {
+	if limit <= 0 {
+		limit = l.maxPerUser
+	}
+
+	return limit <= 0 || l.users[user] < limit
+}
+
+// Releases a session previously registered with acquireUser.
+func (l *connectionLimiter) releaseUser(username string) {
+	l.Lock()
+	defer l.Unlock()
+
+	u := panelUser(username)
+	if l.users[u] <= 1 {
+		delete(l.users, u)
+		delete(l.userLimits, u)
+	} else {
+		l.users[u]--
+	}
+}
+
+// SFTP usernames are in the format of "username.server", so strip off the server identifier
+// to get the Panel user the session belongs to. Everything after the final period is the
+// server, which allows Panel usernames that contain periods themselves.
+func panelUser(username string) string {
+	if i := strings.LastIndex(username, "."); i > 0 {
+		return username[:i]
+	}
+
+	return username
+}
+
+// Returns the IP address portion of a remote address, falling back to the full address
+// string if it cannot be split into a host and port.
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"io"
 	"os"
+	"sync"
 )
 
 type ListerAt []os.FileInfo
@@ -20,3 +21,58 @@ func (l ListerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
 		return n, nil
 	}
 }
+
+// Lists a directory by reading its entries as the client asks for them, rather than reading the
+// whole directory up front. Only the entries for the batch being sent to the client are held in
+// memory at a time.
+//
+// The request server asks for entries in order and never goes back to an earlier offset, so the
+// directory is read straight through and closed once the last entry has been read. If the client
+// stops listing early the directory is closed when it is garbage collected.
+type dirLister struct {
+	mu     sync.Mutex
+	dir    Dir
+	offset int64
+	done   bool
+
+	// Removes any entries that should not be sent to the client.
+	filter func(files []os.FileInfo) []os.FileInfo
+}
+
+func newDirLister(d Dir, filter func(files []os.FileInfo) []os.FileInfo) *dirLister {
+	return &dirLister{dir: d, filter: filter}
+}
+
+func (l *dirLister) ListAt(f []os.FileInfo, offset int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.done || offset != l.offset {
+		return 0, io.EOF
+	}
+
+	var n int
+	var err error
+	for n < len(f) && err == nil {
+		var files []os.FileInfo
+		files, err = l.dir.Readdir(len(f) - n)
+		if l.filter != nil {
+			files = l.filter(files)
+		}
+		n += copy(f[n:], files)
+	}
+	l.offset += int64(n)
+
+	if err != nil {
+		l.done = true
+		l.dir.Close()
+
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+
+		return n, io.EOF
+	}
+
+	return n, nil
+}
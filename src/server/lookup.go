@@ -0,0 +1,132 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Looks up the permissions of users whenever they are checked, rather than only using the ones
+// returned when they logged in, so that changes made in the Panel apply within the TTL. Lookups
+// are kept for the TTL so that a burst of operations only needs a single request to the Panel,
+// and checks made while a lookup is in progress wait for it rather than making their own. Failed
+// lookups are kept for the TTL as well, so the Panel being unavailable doesn't mean a request
+// for every operation.
+type permissionsCache struct {
+	provider PermissionsProvider
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[permissionsKey]permissionsEntry
+	calls   map[permissionsKey]*permissionsCall
+}
+
+type permissionsKey struct {
+	user   string
+	server string
+}
+
+type permissionsEntry struct {
+	permissions []string
+	err         error
+	expires     time.Time
+}
+
+// A lookup in progress for a user and server.
+type permissionsCall struct {
+	done        chan struct{}
+	permissions []string
+	err         error
+}
+
+func newPermissionsCache(provider PermissionsProvider, ttl time.Duration) *permissionsCache {
+	return &permissionsCache{
+		provider: provider,
+		ttl:      ttl,
+		entries:  make(map[permissionsKey]permissionsEntry),
+		calls:    make(map[permissionsKey]*permissionsCall),
+	}
+}
+
+// Returns the permissions the user of the session has on its server, looking them up if they
+// have not been looked up within the TTL.
+func (c *permissionsCache) get(sess *session) ([]string, error) {
+	key := permissionsKey{user: sess.userUUID, server: sess.server}
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.permissions, e.err
+	}
+
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+
+		return call.permissions, call.err
+	}
+
+	call := &permissionsCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.permissions, call.err = correlatedPermissions(c.provider, sess.id).Permissions(key.user, key.server)
+	switch call.err {
+	case nil, errAccessRevoked:
+	case errPermissionsUnsupported:
+		sess.log.Debugw("not looking up permissions since the panel does not support it")
+	default:
+		sess.log.Warnw("failed to look up permissions for session", zap.Error(call.err))
+	}
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.store(key, permissionsEntry{permissions: call.permissions, err: call.err})
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.permissions, call.err
+}
+
+// Replaces the permissions of a user on a server, such as when they are pushed by the Panel or
+// have just been returned for a login.
+func (c *permissionsCache) set(user string, server string, permissions []string) {
+	c.mu.Lock()
+	c.store(permissionsKey{user: user, server: server}, permissionsEntry{permissions: permissions})
+	c.mu.Unlock()
+}
+
+// Stores the entry until the TTL passes, dropping any entries that have already expired so that
+// users who have stopped connecting don't stay in memory.
+//
+// This must be called while holding the lock.
+func (c *permissionsCache) store(key permissionsKey, e permissionsEntry) {
+	now := time.Now()
+	for k, old := range c.entries {
+		if !now.Before(old.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	e.expires = now.Add(c.ttl)
+	c.entries[key] = e
+}
+
+// Returns the current permissions of the user for the session, to be checked by its handlers.
+// If the user no longer has access to the server the session is disconnected and nothing is
+// allowed, while failing to look the permissions up leaves the last ones known in place.
+func (s *Server) lookupPermissions(sess *session) ([]string, bool) {
+	permissions, err := s.lookups.get(sess)
+	switch err {
+	case nil:
+		sess.setPermissions(permissions)
+		return permissions, true
+	case errAccessRevoked:
+		sess.log.Infow("disconnecting session since the user no longer has access to the server")
+		sess.conn.Close()
+		return []string{}, true
+	}
+
+	return sess.refreshedPermissions()
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+// The attribute flags sent along with file attributes.
+const (
+	attrSize        = 0x1
+	attrUIDGID      = 0x2
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+)
+
+// Handles an SSH_FXP_LSTAT request ourselves, since the request server in the version of
+// pkg/sftp in use treats it the same as a stat and follows symlinks. Clients need the details
+// of the link itself to tell symlinks apart when listing a directory, such as those created by
+// install scripts.
+func (e *extendedChannel) handleLstat(b []byte) {
+	id, b, err := unmarshalUint32(b)
+	if err != nil {
+		return
+	}
+
+	p, _, err := unmarshalString(b)
+	if err != nil {
+		e.writePacket(statusPacket(id, sftp.ErrSshFxBadMessage))
+		return
+	}
+
+	info, err := e.fs.lstat(p)
+	if err != nil {
+		e.writePacket(statusPacket(id, err))
+		return
+	}
+
+	e.writePacket(marshalAttrs(marshalUint32([]byte{fxpAttrs}, id), info))
+}
+
+// Returns the details of the file at the path without following it if it is a symlink.
+func (fs *FileSystem) lstat(p string) (os.FileInfo, error) {
+	l, err := fs.Filelist(sftp.NewRequest("Lstat", p))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]os.FileInfo, 1)
+	if n, _ := l.ListAt(files, 0); n == 0 {
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	return files[0], nil
+}
+
+// Appends the attributes of a file, in the format used by version 3 of the protocol.
+func marshalAttrs(b []byte, info os.FileInfo) []byte {
+	flags := uint32(attrSize | attrPermissions | attrACModTime)
+	uid, gid, ok := fileOwner(info)
+	if ok {
+		flags |= attrUIDGID
+	}
+
+	b = marshalUint32(b, flags)
+	b = marshalUint64(b, uint64(info.Size()))
+	if ok {
+		b = marshalUint32(b, uid)
+		b = marshalUint32(b, gid)
+	}
+	b = marshalUint32(b, unixFileMode(info.Mode()))
+
+	mtime := uint32(info.ModTime().Unix())
+	b = marshalUint32(b, mtime)
+	b = marshalUint32(b, mtime)
+
+	return b
+}
+
+// Converts a file mode to the Unix mode bits sent to clients, which include the type of file.
+func unixFileMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+
+	switch {
+	case mode&os.ModeDir != 0:
+		m |= 0040000
+	case mode&os.ModeSymlink != 0:
+		m |= 0120000
+	case mode&os.ModeNamedPipe != 0:
+		m |= 0010000
+	case mode&os.ModeSocket != 0:
+		m |= 0140000
+	case mode&os.ModeCharDevice != 0:
+		m |= 0020000
+	case mode&os.ModeDevice != 0:
+		m |= 0060000
+	default:
+		m |= 0100000
+	}
+
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+
+	return m
+}
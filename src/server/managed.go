@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// How long the managed files fetched for a server are used before they are fetched again.
+const managedFilesTTL = 5 * time.Minute
+
+// A ManagedFilesProvider is implemented by authenticators that can return the files of a server
+// that are managed by its egg, such as install scripts and startup wrappers. Managed files are
+// protected in the same way as protected paths, so they can't be modified or removed.
+type ManagedFilesProvider interface {
+	// Returns the managed paths for a server, relative to the root of the server directory.
+	// Paths support the same globbing as protected paths.
+	ManagedFiles(server string) ([]string, error)
+}
+
+type ManagedFilesRequest struct {
+	Server string `json:"server"`
+}
+
+// Fetches the files managed by the egg of a server from the Panel. Panels that don't know about
+// managed files respond with a 404, in which case the server has none.
+func (a *PanelAuthenticator) ManagedFiles(server string) ([]string, error) {
+	if a.URL == "" || a.Token == "" {
+		return nil, fmt.Errorf("no panel url or token is configured")
+	}
+
+	resp, err := a.post("/api/remote/sftp/managed-files", ManagedFilesRequest{Server: server})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s, _ := ioutil.ReadAll(resp.Body)
+
+		err := fmt.Errorf("error response from server: %s", string(s))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, &UnavailableError{Err: err}
+		}
+
+		return nil, err
+	}
+
+	var body struct {
+		Data []string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body.Data, nil
+}
+
+// Keeps the managed files fetched for each server so that they are not fetched again for every
+// session. If fetching them fails the last list fetched for the server is used, so that the
+// Panel being unavailable doesn't leave managed files open to modification.
+type managedFiles struct {
+	provider ManagedFilesProvider
+
+	mu      sync.Mutex
+	servers map[string]managedEntry
+}
+
+type managedEntry struct {
+	paths   []string
+	expires time.Time
+}
+
+func newManagedFiles(provider ManagedFilesProvider) *managedFiles {
+	return &managedFiles{provider: provider, servers: make(map[string]managedEntry)}
+}
+
+// Returns the managed files for a server, fetching them if they have not been fetched recently.
+func (m *managedFiles) forServer(sess *session, server string) []string {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	entry, ok := m.servers[server]
+	m.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.paths
+	}
+
+	paths, err := m.provider.ManagedFiles(server)
+	if err != nil {
+		sess.log.Warnw("failed to fetch managed files for server", zap.Bool("using_previous", ok), zap.Error(err))
+		return entry.paths
+	}
+
+	m.mu.Lock()
+	m.servers[server] = managedEntry{paths: paths, expires: time.Now().Add(managedFilesTTL)}
+	m.mu.Unlock()
+
+	return paths
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// The details of a session that can be used in the message shown to users once they have
+// logged in. The disk usage is only calculated if the message actually uses it.
+type motdContext struct {
+	// The name of the server as returned by the Panel, or the UUID if no name was returned.
+	Name string
+	UUID string
+	User string
+
+	fs *FileSystem
+}
+
+// Returns the disk space allocated to the server, or "unlimited" if it has no disk limit.
+func (c motdContext) DiskLimit() string {
+	limit := c.fs.diskLimit()
+	if limit <= 0 {
+		return "unlimited"
+	}
+
+	return formatBytes(limit * 1024 * 1024)
+}
+
+// Returns the disk space being used by the server.
+func (c motdContext) DiskUsed() string {
+	return formatBytes(c.fs.diskUsage())
+}
+
+// Returns the disk space the server has left before reaching its limit, or "unlimited" if it
+// has no disk limit.
+func (c motdContext) DiskRemaining() string {
+	limit := c.fs.diskLimit()
+	if limit <= 0 {
+		return "unlimited"
+	}
+
+	remaining := limit*1024*1024 - c.fs.diskUsage()
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return formatBytes(remaining)
+}
+
+// Parses the message shown to users once they have logged in. The message is a text/template
+// that can use the fields and methods of motdContext, such as "{{.Name}}" or
+// "{{.DiskRemaining}}".
+func ParseMOTD(text string) (*template.Template, error) {
+	return template.New("motd").Parse(text)
+}
+
+// Renders the message shown to a user once they have logged in, returning an empty string if
+// no message has been configured.
+func (s *Server) motd(sess *session, fs *FileSystem) string {
+	if s.config.Settings.MOTD == nil {
+		return ""
+	}
+
+	name := sess.conn.Permissions.Extensions["server_name"]
+	if name == "" {
+		name = sess.server
+	}
+
+	var b bytes.Buffer
+	err := s.config.Settings.MOTD.Execute(&b, motdContext{Name: name, UUID: sess.server, User: sess.user, fs: fs})
+	if err != nil {
+		sess.log.Warnw("failed to render message of the day", zap.Error(err))
+		return ""
+	}
+
+	return withTrailingNewline(b.String())
+}
+
+// Writes the message shown to a user once they have logged in. SFTP clients such as OpenSSH
+// display anything written to the standard error of the channel, while other clients quietly
+// ignore it.
+func (s *Server) writeMOTD(sess *session, fs *FileSystem, w io.Writer) {
+	if m := s.motd(sess, fs); m != "" {
+		io.WriteString(w, strings.Replace(m, "\n", "\r\n", -1))
+	}
+}
+
+// Handles a user connecting with an interactive SSH client rather than SFTP or SCP, showing
+// them the message of the day along with a note that a shell is not available.
+func (s *Server) serveShell(sess *session, channel ssh.Channel) {
+	s.writeMOTD(sess, s.sessionHandler(sess), channel)
+	io.WriteString(channel, "This server only supports SFTP and SCP connections.\r\n")
+
+	channel.SendRequest("exit-status", false, marshalUint32(nil, 1))
+}
+
+// Formats a number of bytes using the largest unit that keeps the value above one.
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	return fmt.Sprintf("%.2f %s", v, units[i])
+}
+
+// Makes sure a message shown to the user ends with a newline, so that whatever the client
+// shows next starts on its own line.
+func withTrailingNewline(m string) string {
+	if m == "" || strings.HasSuffix(m, "\n") {
+		return m
+	}
+
+	return m + "\n"
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pterodactyl/sftp-server/src/containment"
+	"go.uber.org/zap"
+)
+
+// The ways the names of new files can be handled when they contain control characters or end
+// with a space or a dot, which break backups and the file manager of the Panel.
+const (
+	FileNamesIgnore    = "none"
+	FileNamesReject    = "reject"
+	FileNamesNormalize = "normalize"
+)
+
+var (
+	errNameControlCharacter  = newStatusError(fxFailure, "file name contains a control character")
+	errNameTrailingCharacter = newStatusError(fxFailure, "file name ends with a space or a dot")
+)
+
+// Applies the file name policy of the node to a path (as returned by buildPath) that is about
+// to be created, returning the path it should be created at instead. Only the parts of the path
+// that don't exist yet are checked, including any directories that will be created leading up
+// to it, so existing files with names that wouldn't be allowed can still be used, moved or
+// removed.
+func (fs *FileSystem) newName(p string) (string, error) {
+	if fs.FileNamePolicy != FileNamesReject && fs.FileNamePolicy != FileNamesNormalize {
+		return p, nil
+	}
+
+	root := filepath.Clean(fs.Directory)
+
+	var names []string
+	dir := p
+	for dir != root && containment.Within(dir, root) {
+		if _, err := fs.backend().Lstat(dir); !os.IsNotExist(err) {
+			break
+		}
+
+		names = append(names, filepath.Base(dir))
+		dir = filepath.Dir(dir)
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+
+		var err error
+		if fs.FileNamePolicy == FileNamesReject {
+			err = containment.ValidateName(name)
+		} else {
+			name, err = containment.NormalizeName(name)
+		}
+
+		if err != nil {
+			fs.log().Infow("denying file with an invalid name", zap.String("source", p), zap.Error(err))
+			if err == containment.ErrControlCharacter {
+				return "", errNameControlCharacter
+			}
+
+			return "", errNameTrailingCharacter
+		}
+
+		dir = filepath.Join(dir, name)
+	}
+
+	return dir, nil
+}
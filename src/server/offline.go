@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// Allows users to keep logging in while the Panel is unreachable, as long as they have logged
+// in successfully with the same credentials recently. Only a salted hash of each password is
+// kept, and only in memory, so the cache is lost when the server is restarted.
+//
+// Credentials are only ever checked against the cache when the wrapped authenticator returns an
+// UnavailableError. If the Panel rejects a set of credentials the cached entry for that user is
+// removed straight away.
+type offlineAuthenticator struct {
+	Authenticator
+	ttl time.Duration
+
+	// The cache is shared with the copies of the authenticator made for each connection.
+	mu      *sync.Mutex
+	entries map[string]offlineEntry
+}
+
+type offlineEntry struct {
+	salt     []byte
+	hash     [sha256.Size]byte
+	response *AuthenticationResponse
+	at       time.Time
+}
+
+func newOfflineAuthenticator(a Authenticator, ttl time.Duration) *offlineAuthenticator {
+	return &offlineAuthenticator{
+		Authenticator: a,
+		ttl:           ttl,
+		mu:            &sync.Mutex{},
+		entries:       make(map[string]offlineEntry),
+	}
+}
+
+func (o *offlineAuthenticator) Authenticate(user string, pass []byte) (*AuthenticationResponse, error) {
+	resp, err := o.Authenticator.Authenticate(user, pass)
+	if err == nil {
+		o.store(user, pass, resp)
+		return resp, nil
+	}
+
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) {
+		o.mu.Lock()
+		delete(o.entries, user)
+		o.mu.Unlock()
+
+		return nil, err
+	}
+
+	if cached, ok := o.lookup(user, pass); ok {
+		logger.Get().Infow("authenticated user using cached credentials since the panel is unavailable",
+			zap.String("user", user),
+			zap.Error(err),
+		)
+		return cached, nil
+	}
+
+	return nil, err
+}
+
+// Passes the health check along to the wrapped authenticator, if it supports one.
+func (o *offlineAuthenticator) Ping() error {
+	if p, ok := o.Authenticator.(interface{ Ping() error }); ok {
+		return p.Ping()
+	}
+
+	return nil
+}
+
+// Passes the token check along to the wrapped authenticator, if it supports one.
+func (o *offlineAuthenticator) VerifyToken() error {
+	if v, ok := o.Authenticator.(interface{ VerifyToken() error }); ok {
+		return v.VerifyToken()
+	}
+
+	return nil
+}
+
+// Remembers a set of credentials that were just validated.
+func (o *offlineAuthenticator) store(user string, pass []byte, resp *AuthenticationResponse) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.entries[user] = offlineEntry{
+		salt:     salt,
+		hash:     hashCredential(salt, pass),
+		response: resp,
+		at:       time.Now(),
+	}
+
+	// Drop anything that has expired so that users who never come back are not kept around
+	// for the lifetime of the process.
+	for u, e := range o.entries {
+		if time.Since(e.at) > o.ttl {
+			delete(o.entries, u)
+		}
+	}
+}
+
+// Returns the response from the last successful login for the user, if the password matches
+// and it happened within the cache period.
+func (o *offlineAuthenticator) lookup(user string, pass []byte) (*AuthenticationResponse, bool) {
+	o.mu.Lock()
+	e, ok := o.entries[user]
+	o.mu.Unlock()
+
+	if !ok || time.Since(e.at) > o.ttl {
+		return nil, false
+	}
+
+	h := hashCredential(e.salt, pass)
+	if subtle.ConstantTimeCompare(h[:], e.hash[:]) != 1 {
+		return nil, false
+	}
+
+	return e.response, true
+}
+
+func hashCredential(salt []byte, pass []byte) [sha256.Size]byte {
+	return sha256.Sum256(append(append([]byte{}, salt...), pass...))
+}
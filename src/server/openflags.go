@@ -0,0 +1,74 @@
+package server
+
+import (
+	"os"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// The SSH_FXF_* flags a client sends when opening a file, describing how the file should be
+// opened.
+const (
+	fxfRead   = 0x00000001
+	fxfWrite  = 0x00000002
+	fxfAppend = 0x00000004
+	fxfCreat  = 0x00000008
+	fxfTrunc  = 0x00000010
+	fxfExcl   = 0x00000020
+)
+
+// Converts the flags sent by the client into the flags used to open the file on the disk. Files
+// are always opened for reading and writing, since clients that resume uploads read back what
+// they've already written.
+//
+// Appending is not passed along as O_APPEND since files opened in that mode can't be written to
+// at an offset, so appends are handled by an appendingFile instead.
+func openFlags(flags uint32) int {
+	f := os.O_RDWR
+	if flags&fxfCreat != 0 {
+		f |= os.O_CREATE
+	}
+
+	if flags&fxfTrunc != 0 {
+		f |= os.O_TRUNC
+	}
+
+	if flags&fxfExcl != 0 {
+		f |= os.O_EXCL
+	}
+
+	return f
+}
+
+// Wraps the file so that all writes go to the end of it if the client opened it for appending.
+func appendIfRequested(request *sftp.Request, f File) File {
+	if request.Flags&fxfAppend == 0 {
+		return f
+	}
+
+	return &appendingFile{File: f}
+}
+
+// An appendingFile writes everything to the end of the file, regardless of the offset the
+// client sends the data with.
+type appendingFile struct {
+	File
+	mu sync.Mutex
+}
+
+func (f *appendingFile) Write(p []byte) (int, error) {
+	return f.WriteAt(p, 0)
+}
+
+func (f *appendingFile) WriteAt(p []byte, _ int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	st, err := f.File.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return f.File.WriteAt(p, st.Size())
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The file commands that can be disabled for the node, by the names pkg/sftp gives their
+// requests. "Link" is a hard link created through the hardlink@openssh.com extension.
+var fileOperations = []string{"Setstat", "Rename", "Rmdir", "Mkdir", "Symlink", "Link", "Remove"}
+
+// The errors returned when an operation is disabled, keyed by the operation.
+var operationErrors = make(map[string]*statusError)
+
+func init() {
+	for _, op := range fileOperations {
+		operationErrors[op] = newStatusError(fxOpUnsupported, "the "+strings.ToLower(op)+" operation is disabled")
+	}
+}
+
+// Checks that each of the operations is one that can be disabled, ignoring their case.
+func ValidateOperations(operations []string) error {
+	for _, op := range operations {
+		if operationName(op) == "" {
+			return fmt.Errorf("unknown operation %q, expected one of %s", op, strings.Join(fileOperations, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Returns the name pkg/sftp uses for the operation, or an empty string if it isn't one.
+func operationName(op string) string {
+	for _, name := range fileOperations {
+		if strings.EqualFold(op, name) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// Determines if the node allows the file command to be used at all. If any operations are
+// allowed only those can be used, and any that are denied can't be used even if they are
+// allowed. Requests other than file commands are never disabled.
+func (fs *FileSystem) operationAllowed(method string) bool {
+	if operationName(method) == "" {
+		return true
+	}
+
+	for _, op := range fs.DeniedOperations {
+		if strings.EqualFold(op, method) {
+			return false
+		}
+	}
+
+	if len(fs.AllowedOperations) == 0 {
+		return true
+	}
+
+	for _, op := range fs.AllowedOperations {
+		if strings.EqualFold(op, method) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	cache "github.com/patrickmn/go-cache"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// Parses the user a container runs as, given the same way as to Docker as "uid:gid" or just
+// "uid". Only numeric IDs are supported since the names only exist inside of the container.
+// If no group is given the group of the default user is kept.
+func parseContainerUser(s string, def SftpUser) (SftpUser, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def, false
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil || uid < 0 {
+		return def, false
+	}
+
+	u := SftpUser{Uid: uid, Gid: def.Gid}
+	if len(parts) == 2 {
+		gid, err := strconv.Atoi(parts[1])
+		if err != nil || gid < 0 {
+			return def, false
+		}
+		u.Gid = gid
+	}
+
+	return u, true
+}
+
+// Returns the user and group that files created for a server are owned by, which should be
+// the ones its container runs as so that the server is able to use them. Once privileges have
+// been dropped files can't be given to another user, so they are left owned by the daemon user
+// rather than failing to change the owner of every one of them.
+func (c Configuration) serverUser(perm *ssh.Permissions) SftpUser {
+	u := c.containerUser(perm)
+	if !c.Settings.DropPrivileges || u == c.User {
+		return u
+	}
+
+	uuid := perm.Extensions["uuid"]
+	if c.Cache == nil || c.Cache.Add("owner:"+uuid, true, cache.DefaultExpiration) == nil {
+		logger.Get().Warnw("files for server are owned by the daemon user instead of the container user since privileges have been dropped",
+			zap.String("server", uuid),
+			zap.Int("uid", u.Uid),
+			zap.Int("gid", u.Gid),
+		)
+	}
+
+	return c.User
+}
+
+// Returns the user the container of a server runs as. This is the user returned by the Panel
+// if there is one, otherwise the user set in the configuration of the server on the node,
+// otherwise the daemon user.
+func (c Configuration) containerUser(perm *ssh.Permissions) SftpUser {
+	if u, ok := parseContainerUser(perm.Extensions["container_user"], c.User); ok {
+		return u
+	}
+
+	uuid := perm.Extensions["uuid"]
+	if c.Cache != nil {
+		if x, exists := c.Cache.Get("user:" + uuid); exists {
+			return x.(SftpUser)
+		}
+	}
+
+	u := c.User
+	if b, err := ioutil.ReadFile(c.serverConfigPath(uuid)); err == nil {
+		s, _ := jsonparser.GetString(b, "container", "user")
+		u, _ = parseContainerUser(s, c.User)
+	}
+
+	if c.Cache != nil {
+		c.Cache.Set("user:"+uuid, u, cache.DefaultExpiration)
+	}
+
+	return u
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServerUser(t *testing.T) {
+	daemon := SftpUser{Uid: 998, Gid: 998}
+	perm := &ssh.Permissions{Extensions: map[string]string{"uuid": "owner-test", "container_user": "1000:1000"}}
+
+	c := Configuration{User: daemon}
+	if u := c.serverUser(perm); u != (SftpUser{Uid: 1000, Gid: 1000}) {
+		t.Errorf("serverUser = %v, want the container user", u)
+	}
+
+	// Files can't be given to the container user once privileges have been dropped.
+	c.Settings.DropPrivileges = true
+	if u := c.serverUser(perm); u != daemon {
+		t.Errorf("serverUser with dropped privileges = %v, want the daemon user", u)
+	}
+}
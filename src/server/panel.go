@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// Returned in place of making a request when the Panel has recently been failing, rather than
+// making every login wait for a request that is going to fail anyways.
+var ErrPanelUnavailable = errors.New("authentication is temporarily unavailable")
+
+const (
+	// The number of times a request to the Panel is attempted, and the delay before the first
+	// retry. The delay doubles after each attempt.
+	panelAttempts   = 3
+	panelRetryDelay = 250 * time.Millisecond
+
+	// The number of requests in a row that must fail before requests to the Panel are stopped,
+	// and how long they are stopped for before trying again.
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
+// A circuitBreaker stops requests from being made to the Panel once enough of them have failed
+// in a row. After the cooldown a single request is let through to check if the Panel is back,
+// and requests resume as normal once one of them succeeds. A nil breaker allows every request.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{}
+}
+
+// Determines if a request should be made right now.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < breakerThreshold {
+		return true
+	}
+
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+
+	b.trial = true
+	return true
+}
+
+// Determines if requests are currently being stopped, without letting a trial request through.
+func (b *circuitBreaker) open() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= breakerThreshold && (b.trial || time.Now().Before(b.openUntil))
+}
+
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= breakerThreshold {
+		logger.Get().Infow("panel is reachable again, resuming requests")
+	}
+
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+
+	if b.failures >= breakerThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+
+		if b.failures == breakerThreshold {
+			logger.Get().Warnw("panel requests are failing, pausing requests to the panel", zap.Duration("cooldown", breakerCooldown))
+		}
+	}
+}
+
+// Sends a request to the Panel, retrying with an increasing delay if the Panel could not be
+// reached or responded with a server error. Errors that retrying won't fix, such as an
+// untrusted certificate or the request timing out, are returned straight away.
+//
+// If the Panel still responds with a server error after the last attempt that response is
+// returned, so that it can be handled by the caller.
+func doPanelRequest(client *http.Client, breaker *circuitBreaker, req *http.Request, body []byte) (*http.Response, error) {
+	if !breaker.allow() {
+		return nil, ErrPanelUnavailable
+	}
+
+	// Every attempt is sent with the same correlation ID, so that retries of a request can be
+	// told apart from new ones in the logs of the Panel.
+	if req.Header.Get(correlationHeader) == "" {
+		req.Header.Set(correlationHeader, newCorrelationID())
+	}
+
+	delay := panelRetryDelay
+	for attempt := 1; ; attempt++ {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			breaker.success()
+			return resp, nil
+		}
+
+		if attempt == panelAttempts || (err != nil && !retryablePanelError(err)) {
+			breaker.failure()
+			if err != nil {
+				return nil, panelRequestError(err)
+			}
+
+			return resp, nil
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Determines if a failed request is worth trying again. Timeouts are not retried since the
+// request has already taken as long as we're willing to wait, and certificate errors will not
+// go away on their own.
+func retryablePanelError(err error) bool {
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return false
+	}
+
+	return !isCertificateError(err)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// The directory, relative to the root of a server, that interrupted atomic uploads are kept in
+// so that they can be resumed.
+const partialDirectory = ".sftp-partial"
+
+// How often the partial uploads for all servers are checked for ones that have been abandoned.
+const partialPurgeInterval = time.Hour
+
+// Returns the path an interrupted upload to the target is kept at. Partial uploads are named
+// after a hash of the path of the file being uploaded, so there is only ever one for each file
+// and it can be found again without keeping track of anything else.
+func (fs *FileSystem) partialPath(target string) string {
+	sum := sha1.Sum([]byte(fs.relativePath(target)))
+
+	return filepath.Join(fs.Directory, partialDirectory, hex.EncodeToString(sum[:]))
+}
+
+// Returns the partial upload for the target if there is one. The size of the partial upload is
+// the offset the client needs to continue uploading from.
+func (fs *FileSystem) partialUpload(target string) (os.FileInfo, bool) {
+	if !fs.AtomicUploads || !fs.PartialUploads {
+		return nil, false
+	}
+
+	info, err := fs.backend().Stat(fs.partialPath(target))
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+
+	return info, true
+}
+
+// Continues an interrupted upload to the target if there is one, returning false if there is
+// nothing to resume. Clients resume uploads by opening the file without truncating it, and
+// then either appending to it or writing from the offset they were at.
+//
+// This must be called while holding the FileSystem lock.
+func (fs *FileSystem) resumeAtomicUpload(request *sftp.Request, target string) (io.WriterAt, bool) {
+	if _, ok := fs.partialUpload(target); !ok {
+		return nil, false
+	}
+
+	p := fs.partialPath(target)
+	file, err := fs.backend().OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		fs.log().Warnw("error opening partial upload", zap.String("source", target), zap.Error(err))
+		return nil, false
+	}
+
+	fs.log().Infow("resuming partial upload", zap.String("source", target), zap.Int64("offset", fs.fileSize(p)))
+
+	return fs.trackAtomicUpload(request, appendIfRequested(request, file), target), true
+}
+
+// Removes the partial upload for the target, if there is one. This is done when a new upload of
+// the file is started from scratch, since the old one will never be resumed.
+func (fs *FileSystem) removePartialUpload(target string) {
+	if fs.PartialUploads {
+		fs.backend().Remove(fs.partialPath(target))
+	}
+}
+
+// Keeps the temporary file of an upload that was interrupted so that it can be resumed,
+// returning false if it should be removed instead. Uploads that never had anything written to
+// them aren't worth keeping.
+func (fs *FileSystem) keepPartialUpload(u *atomicUpload) bool {
+	if !fs.PartialUploads || fs.fileSize(u.Name()) == 0 {
+		return false
+	}
+
+	p := fs.partialPath(u.target)
+	if u.Name() == p {
+		return true
+	}
+
+	root := filepath.Join(fs.Directory, partialDirectory)
+	if err := fs.backend().MkdirAll(root, fs.DirectoryMode); err != nil {
+		fs.log().Errorw("failed to create partial upload directory", zap.String("path", root), zap.Error(err))
+		return false
+	}
+
+	if err := fs.chown(root); err != nil {
+		fs.log().Warnw("error chowning file", zap.String("file", root), zap.Error(err))
+	}
+
+	if err := fs.backend().Rename(u.Name(), p); err != nil {
+		fs.log().Errorw("failed to keep partial upload", zap.String("source", u.target), zap.Error(err))
+		return false
+	}
+
+	fs.log().Debugw("keeping partial upload", zap.String("source", u.target), zap.String("path", p))
+	return true
+}
+
+// Periodically removes partial uploads that have not been written to within the maximum age
+// for every server on the node. This runs until the done channel is closed.
+func (c Configuration) purgePartialUploads(done <-chan struct{}) {
+	ticker := time.NewTicker(partialPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		c.purgeAbandonedPartialUploads()
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Removes all of the partial uploads for all servers that are older than the maximum age.
+func (c Configuration) purgeAbandonedPartialUploads() {
+	servers, err := ioutil.ReadDir(c.dataPath())
+	if err != nil {
+		logger.Get().Errorw("error reading server data directory", zap.String("directory", c.dataPath()), zap.Error(err))
+		return
+	}
+
+	cutoff := time.Now().Add(-c.Settings.PartialUploadMaxAge)
+	for _, s := range servers {
+		if !s.IsDir() {
+			continue
+		}
+
+		root := filepath.Join(c.dataPath(), s.Name(), partialDirectory)
+		entries, err := ioutil.ReadDir(root)
+		if err != nil {
+			continue
+		}
+
+		purged := false
+		for _, e := range entries {
+			if !e.Mode().IsRegular() || e.ModTime().After(cutoff) {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(root, e.Name())); err != nil {
+				logger.Get().Warnw("failed to remove partial upload", zap.String("path", filepath.Join(root, e.Name())), zap.Error(err))
+				continue
+			}
+
+			logger.Get().Debugw("removed abandoned partial upload", zap.String("server", s.Name()), zap.String("entry", e.Name()))
+			purged = true
+		}
+
+		if purged {
+			c.Cache.Delete("used:" + s.Name())
+			c.Cache.Delete("files:" + s.Name())
+		}
+	}
+}
+
+// A file reported with a different name than the one it has on the disk.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (f renamedFileInfo) Name() string {
+	return f.name
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"strings"
+)
+
+// The names the Panel uses for each permission in its newer, namespaced permission scheme.
+// Permissions are checked using the original names, and a user holding either name for a
+// permission is allowed to perform the action.
+var scopedPermissions = map[string]string{
+	"list-files":             "file.read",
+	"edit-files":             "file.read-content",
+	"download-files":         "file.read-content",
+	"create-files":           "file.create",
+	"save-files":             "file.update",
+	"rename-files":           "file.rename",
+	"move-files":             "file.update",
+	"delete-files":           "file.delete",
+	"delete-files-recursive": "file.delete-recursive",
+}
+
+// Determines if a permission granted by the Panel covers the requested permission. Granted
+// permissions ending in ".*" cover everything within that namespace, so "file.*" allows any
+// of the file permissions.
+func permissionMatches(granted string, permission string) bool {
+	if granted == permission || granted == "*" {
+		return true
+	}
+
+	if strings.HasSuffix(granted, ".*") {
+		return strings.HasPrefix(permission, strings.TrimSuffix(granted, "*"))
+	}
+
+	return false
+}
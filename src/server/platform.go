@@ -0,0 +1,17 @@
+package server
+
+import (
+	"github.com/pterodactyl/sftp-server/src/containment"
+)
+
+// Returns the path in the form it should be compared in, which is not case-sensitive on
+// Windows.
+func foldPath(p string) string {
+	return containment.Fold(p)
+}
+
+// Changes the ownership of a file or directory to the user the server is configured to run
+// files as. This does nothing on platforms without Unix style file ownership.
+func (fs *FileSystem) chown(p string) error {
+	return fs.backend().Chown(p, fs.User.Uid, fs.User.Gid)
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// Passed when opening files so that opening a FIFO fails or returns straight away, rather than
+// waiting for something to open the other end of it.
+const openNonblock = syscall.O_NONBLOCK
+
+// Changes the ownership of the path to the given user and group.
+func chownPath(p string, uid int, gid int) error {
+	return os.Chown(p, uid, gid)
+}
+
+// Returns the user and group that own a file, if they are known.
+func fileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid, true
+	}
+
+	return 0, 0, false
+}
+
+// Returns the status to respond with for an errno that has one of its own.
+func errnoStatus(errno syscall.Errno) (error, bool) {
+	switch errno {
+	case syscall.ENOSPC:
+		return errNoSpace, true
+	case syscall.EDQUOT:
+		return errQuotaExceeded, true
+	case syscall.ENOTEMPTY:
+		return errDirectoryNotEmpty, true
+	case syscall.ENAMETOOLONG:
+		return errNameTooLong, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows error codes that syscall does not define.
+const (
+	errorHandleDiskFull     syscall.Errno = 39
+	errorDiskFull           syscall.Errno = 112
+	errorFilenameExcedRange syscall.Errno = 206
+	errorDiskQuotaExceeded  syscall.Errno = 1295
+)
+
+// Windows has no FIFOs that can be opened through the file system, so files are opened as usual.
+const openNonblock = 0
+
+// Files on Windows are owned by the user running the server, there is no equivalent of
+// changing the owner to the daemon user.
+func chownPath(p string, uid int, gid int) error {
+	return nil
+}
+
+// Files on Windows don't have a user and group that can be sent to clients.
+func fileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+// Returns the status to respond with for an error code that has one of its own.
+func errnoStatus(errno syscall.Errno) (error, bool) {
+	switch errno {
+	case errorHandleDiskFull, errorDiskFull:
+		return errNoSpace, true
+	case errorDiskQuotaExceeded:
+		return errQuotaExceeded, true
+	case syscall.ERROR_DIR_NOT_EMPTY:
+		return errDirectoryNotEmpty, true
+	case errorFilenameExcedRange:
+		return errNameTooLong, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// Starts the pprof debug endpoints on the configured port. These are only ever bound to the
+// loopback interface since they expose details about the internals of the process, and
+// collecting some profiles can be expensive.
+func (s *Server) startPprof() error {
+	listener, err := s.listenTCP(handoverPprof, fmt.Sprintf("127.0.0.1:%d", s.config.Settings.PprofPort))
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{Handler: mux}
+
+	s.mu.Lock()
+	s.pprof = srv
+	s.pprofListener = listener
+	s.mu.Unlock()
+
+	logger.Get().Infow("pprof listener registered", zap.String("address", listener.Addr().String()))
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Get().Errorw("pprof server closed with error", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
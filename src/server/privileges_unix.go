@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Switches the process to running as the given user and group, dropping any supplementary
+// groups it was started with. Once this returns nothing the process does, including a bug in
+// a handler, is able to touch files the daemon user couldn't have touched anyway. This cannot
+// be undone, so it must only be called after everything that needs root has been done.
+func dropPrivileges(u SftpUser) error {
+	if u.Uid == 0 {
+		return errors.New("cannot drop privileges to the root user")
+	}
+
+	// There is nothing to drop when we weren't started as root in the first place, but make
+	// sure we are actually running as the user files are expected to be owned by.
+	if os.Geteuid() != 0 {
+		if os.Geteuid() != u.Uid {
+			return errors.New("not running as root or as the configured daemon user")
+		}
+
+		return nil
+	}
+
+	// The order matters here, since once the user has been changed we no longer have
+	// permission to change the groups.
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return err
+	}
+
+	if err := syscall.Setgid(u.Gid); err != nil {
+		return err
+	}
+
+	if err := syscall.Setuid(u.Uid); err != nil {
+		return err
+	}
+
+	// Make certain that there is no way back, in case the platform only changed the effective
+	// user and left root as the saved one.
+	if syscall.Setuid(0) == nil {
+		return errors.New("privileges were not dropped")
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package server
+
+import "errors"
+
+// Windows has no equivalent of switching the user a process runs as, so the server needs to be
+// started as the user it should run as instead.
+func dropPrivileges(u SftpUser) error {
+	return errors.New("dropping privileges is not supported on windows")
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Normalizes a list of protected path patterns so that they are all absolute paths relative
+// to the root of the server directory, dropping any empty entries.
+func normalizeProtectedPaths(patterns []string) []string {
+	var out []string
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		out = append(out, path.Clean("/"+p))
+	}
+
+	return out
+}
+
+// Determines if the given path (as returned by buildPath) is protected from modification. A
+// path is protected if it, or any of the directories it is contained within, matches one of
+// the protected patterns. Patterns support the same globbing as path.Match.
+func (fs *FileSystem) isProtected(p string) bool {
+	if len(fs.ProtectedPaths) == 0 {
+		return false
+	}
+
+	rel := foldPath(fs.relativePath(p))
+	for _, pattern := range fs.ProtectedPaths {
+		for try := rel; ; try = path.Dir(try) {
+			if ok, _ := path.Match(foldPath(pattern), try); ok {
+				return true
+			}
+
+			if try == "/" {
+				break
+			}
+		}
+	}
+
+	return false
+}
+
+// Determines if the given directory contains a protected path, or is protected itself. This
+// is used for operations that affect everything within a directory, such as removing or moving
+// it, where we need to make sure nothing protected is caught up in the operation.
+func (fs *FileSystem) containsProtected(p string) bool {
+	if fs.isProtected(p) {
+		return true
+	}
+
+	rel := foldPath(fs.relativePath(p))
+	if rel != "/" {
+		rel += "/"
+	}
+
+	for _, pattern := range fs.ProtectedPaths {
+		if strings.HasPrefix(foldPath(pattern), rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the path relative to the server root, always beginning with a slash.
+func (fs *FileSystem) relativePath(p string) string {
+	rel, err := filepath.Rel(fs.Directory, p)
+	if err != nil {
+		return "/"
+	}
+
+	return path.Clean("/" + filepath.ToSlash(rel))
+}
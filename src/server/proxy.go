@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The signature at the start of every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// The longest a PROXY protocol v1 header can be, including the trailing CRLF.
+const maxProxyV1Length = 107
+
+// How long a proxy has to send the header after connecting before the connection is dropped.
+const proxyHeaderTimeout = 10 * time.Second
+
+// A connection received through a proxy, which reports the address of the client that
+// connected to the proxy rather than the address of the proxy itself.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Determines if the PROXY protocol header should be read from a connection. When trusted
+// networks are configured only connections from those networks are expected to come through a
+// proxy, everything else is treated as connecting directly.
+func (s *Server) expectsProxyHeader(conn net.Conn) bool {
+	if !s.config.Settings.ProxyProtocol {
+		return false
+	}
+
+	if len(s.config.Settings.ProxyTrustedNetworks) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(remoteIP(conn.RemoteAddr()))
+	for _, n := range s.config.Settings.ProxyTrustedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads the PROXY protocol header sent by a proxy at the start of a connection, returning a
+// connection that reports the real address of the client. Both version 1 (text) and version 2
+// (binary) headers are supported. If the proxy does not know the client address, such as for
+// health checks, the address of the proxy is kept.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(conn)
+	c := &proxyConn{Conn: conn, reader: r, remote: conn.RemoteAddr()}
+
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	var addr net.Addr
+	if bytes.Equal(sig, proxyV2Signature) {
+		addr, err = readProxyV2(r)
+	} else if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		addr, err = readProxyV1(r)
+	} else {
+		return nil, fmt.Errorf("connection did not begin with a proxy protocol header")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if addr != nil {
+		c.remote = addr
+	}
+
+	return c, nil
+}
+
+// Parses a version 1 header, such as "PROXY TCP4 203.0.113.24 192.0.2.10 56324 2022\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxProxyV1Length {
+			return nil, fmt.Errorf("proxy protocol header is too long")
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid proxy protocol header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid source address in proxy protocol header")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// Parses a version 2 header, which is the signature followed by the version and command, the
+// address family, the length of the remaining data and then the addresses themselves.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	ver, family := header[12], header[13]
+	if ver>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy protocol version %d", ver>>4)
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	// A LOCAL command is sent by the proxy itself, such as for a health check, so there is no
+	// client address to use.
+	if ver&0x0f == 0 {
+		return nil, nil
+	}
+
+	switch family {
+	case 0x11:
+		if len(data) < 12 {
+			return nil, fmt.Errorf("invalid address in proxy protocol header")
+		}
+
+		return &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:10]))}, nil
+	case 0x21:
+		if len(data) < 36 {
+			return nil, fmt.Errorf("invalid address in proxy protocol header")
+		}
+
+		return &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:34]))}, nil
+	}
+
+	// Anything other than TCP over IPv4 or IPv6 is not something we can make use of, so just
+	// keep the address of the proxy.
+	return nil, nil
+}
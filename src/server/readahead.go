@@ -0,0 +1,235 @@
+package server
+
+import (
+	"io"
+	"sync"
+)
+
+// The size of the buffer used when copying files for a transfer if none is configured, which
+// is the same as the buffer io.Copy uses.
+const defaultTransferBufferSize = 32 * 1024
+
+// Returns the size of the buffers used for copying the contents of files during transfers.
+func (fs *FileSystem) transferBufferSize() int {
+	if fs.TransferBufferSize <= 0 {
+		return defaultTransferBufferSize
+	}
+
+	return fs.TransferBufferSize
+}
+
+// Copies everything from the reader to the writer using a pooled transfer buffer, in the same
+// manner as io.Copy.
+func (fs *FileSystem) copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := getBuffer(fs.transferBufferSize())
+	defer putBuffer(buf)
+
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// Copies exactly n bytes from the reader to the writer using a pooled transfer buffer, in the
+// same manner as io.CopyN.
+func (fs *FileSystem) copyN(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	written, err := fs.copy(dst, io.LimitReader(src, n))
+	if written == n {
+		return n, nil
+	}
+
+	if written < n && err == nil {
+		err = io.EOF
+	}
+
+	return written, err
+}
+
+// Wraps a file that was opened for reading so that, once it is being read sequentially, the
+// blocks following the one being read are read from the disk in the background. SFTP clients
+// request files a few kilobytes at a time, so without this every request has to wait on the
+// disk, which adds up quickly on high latency links where only a few requests are in flight.
+//
+// Nothing is read ahead if read-ahead is disabled for the server.
+func (fs *FileSystem) readAhead(f File) io.ReaderAt {
+	if fs.ReadAhead <= 0 {
+		return f
+	}
+
+	return &readAheadFile{File: f, size: int64(fs.transferBufferSize()), depth: fs.ReadAhead, blocks: make(map[int64]*readAheadBlock)}
+}
+
+// Returns the most memory that reading ahead can use for a single file, which covers the block
+// before the one being read, the block itself and the blocks ahead of it.
+func (fs *FileSystem) readAheadMemory() int64 {
+	if fs.ReadAhead <= 0 {
+		return 0
+	}
+
+	return int64(fs.ReadAhead+2) * int64(fs.transferBufferSize())
+}
+
+// A file that reads the blocks ahead of those being read. Clients usually have several reads in
+// flight at once, so these can arrive out of order and from several goroutines.
+type readAheadFile struct {
+	File
+
+	// The size of each block that is read, and the number of blocks that are read ahead of
+	// the last one requested.
+	size  int64
+	depth int
+
+	mu     sync.Mutex
+	blocks map[int64]*readAheadBlock
+	last   int64
+	closed bool
+}
+
+// A block of the file that has been, or is being, read. The buffer the block is read into comes
+// from the pool, and is returned to it once the block has been dropped and nothing is still
+// using it. The references and dropped flag are guarded by the lock of the file.
+type readAheadBlock struct {
+	done chan struct{}
+	buf  *[]byte
+	data []byte
+	err  error
+
+	refs    int
+	dropped bool
+}
+
+func (f *readAheadFile) ReadAt(p []byte, off int64) (int, error) {
+	// Reads larger than a block gain nothing from going through the blocks.
+	if int64(len(p)) > f.size {
+		return f.File.ReadAt(p, off)
+	}
+
+	var n int
+	for n < len(p) {
+		index := (off + int64(n)) / f.size
+		b := f.block(index)
+		<-b.done
+
+		start := off + int64(n) - index*f.size
+		if start < int64(len(b.data)) {
+			n += copy(p[n:], b.data[start:])
+		}
+
+		short, err := int64(len(b.data)) < f.size, b.err
+		f.release(b)
+
+		if n < len(p) && short {
+			if err == nil {
+				err = io.EOF
+			}
+
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Returns the block with the given index, starting to read it if it hasn't been already. If
+// the block follows on from the last block requested the blocks after it are read as well, and
+// blocks before the last one are dropped since the client has already moved past them.
+//
+// The block must be released once the caller is done with it.
+func (f *readAheadFile) block(index int64) *readAheadBlock {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b := f.fetch(index)
+	b.refs++
+
+	if index == f.last || index == f.last+1 {
+		for i := index + 1; i <= index+int64(f.depth); i++ {
+			f.fetch(i)
+		}
+	}
+	f.last = index
+
+	for i, old := range f.blocks {
+		if i < index-1 || i > index+int64(f.depth) {
+			f.drop(i, old)
+		}
+	}
+
+	return b
+}
+
+// Releases a block returned by block, returning its buffer to the pool if it has been dropped.
+func (f *readAheadFile) release(b *readAheadBlock) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b.refs--
+	if b.dropped && b.refs == 0 {
+		f.free(b)
+	}
+}
+
+// Drops a block so that it is no longer used for reads, returning its buffer to the pool if
+// nothing is still using it.
+//
+// This must be called while holding the lock.
+func (f *readAheadFile) drop(index int64, b *readAheadBlock) {
+	delete(f.blocks, index)
+
+	b.dropped = true
+	if b.refs == 0 {
+		f.free(b)
+	}
+}
+
+// This must be called while holding the lock.
+func (f *readAheadFile) free(b *readAheadBlock) {
+	if b.buf != nil {
+		putBuffer(b.buf)
+		b.buf = nil
+		b.data = nil
+	}
+}
+
+// Starts reading the block with the given index, unless it has already been started.
+//
+// This must be called while holding the lock.
+func (f *readAheadFile) fetch(index int64) *readAheadBlock {
+	if b, ok := f.blocks[index]; ok {
+		return b
+	}
+
+	b := &readAheadBlock{done: make(chan struct{})}
+	f.blocks[index] = b
+
+	if f.closed {
+		b.err = io.ErrClosedPipe
+		close(b.done)
+		return b
+	}
+
+	// The read holds a reference of its own, so the buffer isn't returned to the pool while
+	// it is still being read into.
+	b.refs++
+	b.buf = getBuffer(int(f.size))
+	go func() {
+		n, err := f.File.ReadAt(*b.buf, index*f.size)
+		b.data = (*b.buf)[:n]
+		if err != io.EOF {
+			b.err = err
+		}
+		close(b.done)
+
+		f.release(b)
+	}()
+
+	return b
+}
+
+func (f *readAheadFile) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	for i, b := range f.blocks {
+		f.drop(i, b)
+	}
+	f.mu.Unlock()
+
+	return f.File.Close()
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// Determines if files for the server are currently unable to be modified. This is the case if
+// the whole node is read-only, the Panel marked the server as read-only when the user logged
+// in, or the server was marked as read-only through the admin API or suspended while the
+// session was open.
+func (fs *FileSystem) isReadOnly() bool {
+	return fs.ReadOnly || (fs.readOnly != nil && fs.readOnly())
+}
+
+// Marks a server as read-only, or allows it to be written to again. This applies to every open
+// session for the server straight away, as well as any future sessions.
+func (s *Server) setServerReadOnly(uuid string, readOnly bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if readOnly {
+		s.readOnly[uuid] = true
+	} else {
+		delete(s.readOnly, uuid)
+	}
+}
+
+// Determines if a server has been marked as read-only through the admin API, or has been
+// suspended while sessions were open for it.
+func (s *Server) serverReadOnly(uuid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readOnly[uuid] || s.suspended[uuid]
+}
+
+// Handles GET /servers/read-only, returning the servers that have been marked read-only.
+func (s *Server) handleListReadOnly(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	s.mu.Lock()
+	servers := make([]string, 0, len(s.readOnly))
+	for uuid := range s.readOnly {
+		servers = append(servers, uuid)
+	}
+	s.mu.Unlock()
+
+	sort.Strings(servers)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"servers": servers})
+}
+
+// Handles PUT and DELETE /servers/<uuid>/read-only, marking the server as read-only or allowing
+// it to be written to again.
+func (s *Server) handleServerReadOnly(w http.ResponseWriter, r *http.Request) {
+	uuid := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/servers/"), "/read-only")
+	if uuid == "" || strings.Contains(uuid, "/") || !strings.HasSuffix(r.URL.Path, "/read-only") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.setServerReadOnly(uuid, true)
+	case http.MethodDelete:
+		s.setServerReadOnly(uuid, false)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	logger.Get().Infow("changed server read-only mode through admin api",
+		zap.String("server", uuid),
+		zap.Bool("read_only", r.Method == http.MethodPut),
+	)
+
+	w.WriteHeader(http.StatusNoContent)
+}
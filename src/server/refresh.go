@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	// Returned by a PermissionsProvider when it is unable to look up permissions at all, such
+	// as when the Panel is too old to know about them, in which case they are not refreshed
+	// again for the session.
+	errPermissionsUnsupported = errors.New("permissions cannot be refreshed")
+
+	// Returned by a PermissionsProvider when the user no longer has access to the server.
+	errAccessRevoked = errors.New("user no longer has access to the server")
+)
+
+// A PermissionsProvider is implemented by authenticators that can look up the permissions a
+// user currently has on a server, so that changes made to a subuser in the Panel apply to the
+// sessions they already have open rather than only once they reconnect.
+type PermissionsProvider interface {
+	// Returns the permissions the user, identified by their UUID, has on the server.
+	Permissions(user string, server string) ([]string, error)
+}
+
+type PermissionsRequest struct {
+	User   string `json:"user"`
+	Server string `json:"server"`
+}
+
+// Fetches the permissions a user has on a server from the Panel. The Panel responds with a 403
+// if the user no longer has access to the server, while Panels that don't support refreshing
+// permissions respond with a 404.
+func (a *PanelAuthenticator) Permissions(user string, server string) ([]string, error) {
+	if a.URL == "" || a.Token == "" {
+		return nil, fmt.Errorf("no panel url or token is configured")
+	}
+
+	resp, err := a.post("/api/remote/sftp/permissions", PermissionsRequest{User: user, Server: server})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, errPermissionsUnsupported
+	case http.StatusForbidden:
+		return nil, errAccessRevoked
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s, _ := ioutil.ReadAll(resp.Body)
+
+		err := fmt.Errorf("error response from server: %s", string(s))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, &UnavailableError{Err: err}
+		}
+
+		return nil, err
+	}
+
+	var body struct {
+		Data []string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body.Data, nil
+}
+
+// Returns the provider to look up permissions for a session with, which sends the correlation ID
+// of the session along with any requests to the Panel.
+func correlatedPermissions(provider PermissionsProvider, id string) PermissionsProvider {
+	if a, ok := provider.(Authenticator); ok {
+		if p, ok := correlate(a, id).(PermissionsProvider); ok {
+			return p
+		}
+	}
+
+	return provider
+}
+
+// Returns the permissions of the user for the session, and false if they have not changed
+// since the user logged in.
+func (sess *session) refreshedPermissions() ([]string, bool) {
+	p, ok := sess.permissions.Load().([]string)
+
+	return p, ok
+}
+
+// Replaces the permissions of the user for the session, which applies to every channel open on
+// it straight away.
+func (sess *session) setPermissions(permissions []string) {
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	if old, ok := sess.refreshedPermissions(); !ok || strings.Join(old, ",") != strings.Join(permissions, ",") {
+		sess.log.Infow("permissions for session changed", zap.Strings("permissions", permissions))
+	}
+
+	sess.permissions.Store(permissions)
+}
+
+// Periodically fetches the permissions of the user for the session until it is closed. If the
+// user no longer has access to the server the session is disconnected. Failing to fetch the
+// permissions leaves the last ones fetched in place.
+func (s *Server) refreshPermissions(sess *session, every time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	provider := correlatedPermissions(s.permissions, sess.id)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		permissions, err := provider.Permissions(sess.userUUID, sess.server)
+		switch err {
+		case nil:
+			sess.setPermissions(permissions)
+		case errPermissionsUnsupported:
+			sess.log.Debugw("not refreshing permissions since the panel does not support it")
+			return
+		case errAccessRevoked:
+			sess.log.Infow("disconnecting session since the user no longer has access to the server")
+			sess.conn.Close()
+			return
+		default:
+			sess.log.Warnw("failed to refresh permissions for session", zap.Error(err))
+		}
+	}
+}
+
+// Replaces the permissions of a user for every session they have open for a server, returning
+// the number of sessions that were updated.
+func (s *Server) pushPermissions(server string, user string, permissions []string) int {
+	s.mu.Lock()
+	var sessions []*session
+	for _, sess := range s.sessions {
+		if sess.server == server && sess.userUUID == user {
+			sessions = append(sessions, sess)
+		}
+	}
+	s.mu.Unlock()
+
+	if s.lookups != nil {
+		s.lookups.set(user, server, permissions)
+	}
+
+	for _, sess := range sessions {
+		sess.setPermissions(permissions)
+	}
+
+	return len(sessions)
+}
+
+// Handles POST /servers/<uuid>/permissions, which allows the Panel to push the new permissions
+// of a user to any sessions they have open for the server as soon as they are changed. The body
+// contains the UUID of the user and their permissions.
+func (s *Server) handleServerPermissions(w http.ResponseWriter, r *http.Request) {
+	uuid := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/servers/"), "/permissions")
+	if uuid == "" || strings.Contains(uuid, "/") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	var body struct {
+		User        string   `json:"user"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.User == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	updated := s.pushPermissions(uuid, body.User, body.Permissions)
+
+	logger.Get().Infow("changed user permissions through admin api",
+		zap.String("server", uuid),
+		zap.String("user_uuid", body.User),
+		zap.Int("sessions", updated),
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": updated})
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// The number of errors that can be waiting to be reported. Once the queue is full new errors
+// are dropped, since something that fails that often is going to be reported anyways.
+const errorQueueSize = 64
+
+// An unexpected error, as sent to the error reporting webhook. The server, session, user and
+// IP are included when the error happened during a session.
+type errorReport struct {
+	Level       string                 `json:"level"`
+	Message     string                 `json:"message"`
+	Error       string                 `json:"error,omitempty"`
+	Server      string                 `json:"server,omitempty"`
+	Session     string                 `json:"session,omitempty"`
+	User        string                 `json:"user,omitempty"`
+	IP          string                 `json:"ip,omitempty"`
+	Caller      string                 `json:"caller,omitempty"`
+	Stacktrace  string                 `json:"stacktrace,omitempty"`
+	Node        string                 `json:"node"`
+	Environment string                 `json:"environment,omitempty"`
+	Version     string                 `json:"version,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Timestamp   time.Time              `json:"timestamp"`
+}
+
+// Sends every error logged by the server to Sentry, a webhook, or both, so that problems across
+// a large number of nodes can be seen without searching the logs of each one. Errors are sent in
+// the background, and reporting an error never waits for it to be sent.
+type errorReporter struct {
+	sentry      *sentryDSN
+	url         string
+	secret      string
+	environment string
+	version     string
+	node        string
+	client      *http.Client
+	queue       chan errorReport
+}
+
+func newErrorReporter(settings Settings) *errorReporter {
+	node, _ := os.Hostname()
+
+	r := &errorReporter{
+		url:         settings.ErrorReportingURL,
+		secret:      settings.WebhookSecret,
+		environment: settings.ErrorReportingEnvironment,
+		version:     settings.Version,
+		node:        node,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan errorReport, errorQueueSize),
+	}
+
+	// The DSN has already been validated along with the rest of the configuration.
+	if settings.ErrorReportingDSN != "" {
+		r.sentry, _ = parseSentryDSN(settings.ErrorReportingDSN)
+	}
+
+	return r
+}
+
+// Queues an error logged by the server to be reported. This is called while the error is being
+// logged, so it must not log anything itself.
+func (r *errorReporter) report(l logger.Report) {
+	e := errorReport{
+		Level:       l.Entry.Level.String(),
+		Message:     l.Entry.Message,
+		Stacktrace:  l.Entry.Stack,
+		Node:        r.node,
+		Environment: r.environment,
+		Version:     r.version,
+		Fields:      make(map[string]interface{}),
+		Timestamp:   l.Entry.Time,
+	}
+
+	if l.Entry.Caller.Defined {
+		e.Caller = l.Entry.Caller.TrimmedPath()
+	}
+
+	for k, v := range l.Fields {
+		s, _ := v.(string)
+		switch k {
+		case "error":
+			e.Error = s
+		case "server":
+			e.Server = s
+		case "session":
+			e.Session = s
+		case "user":
+			e.User = s
+		case "ip":
+			e.IP = s
+		default:
+			e.Fields[k] = v
+		}
+	}
+
+	select {
+	case r.queue <- e:
+	default:
+	}
+}
+
+// Sends queued errors until the done channel is closed, at which point anything still waiting
+// is sent before returning.
+func (r *errorReporter) run(done <-chan struct{}) {
+	for {
+		select {
+		case e := <-r.queue:
+			r.send(e)
+		case <-done:
+			for {
+				select {
+				case e := <-r.queue:
+					r.send(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Sends an error to everywhere errors are reported. Errors that fail to send are dropped.
+func (r *errorReporter) send(e errorReport) {
+	if r.sentry != nil {
+		if err := r.post(r.sentry.endpoint, r.sentry.event(e), map[string]string{"X-Sentry-Auth": r.sentry.auth()}); err != nil {
+			logger.Get().Warnw("failed to report error to sentry", zap.Error(err))
+		}
+	}
+
+	if r.url != "" {
+		body, _ := json.Marshal(e)
+
+		headers := map[string]string{"X-Sftp-Event": "error"}
+		if r.secret != "" {
+			headers["X-Sftp-Signature"] = "sha256=" + signPayload(r.secret, body)
+		}
+
+		if err := r.post(r.url, body, headers); err != nil {
+			logger.Get().Warnw("failed to report error to webhook", zap.String("url", r.url), zap.Error(err))
+		}
+	}
+}
+
+func (r *errorReporter) post(url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pterodactyl-sftp-server/"+r.version)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("received unexpected status code %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// The parts of a Sentry DSN, which is in the form "https://<key>@<host>/<project>".
+type sentryDSN struct {
+	endpoint string
+	key      string
+	secret   string
+}
+
+// Checks that a Sentry DSN is valid.
+func ValidateSentryDSN(dsn string) error {
+	_, err := parseSentryDSN(dsn)
+
+	return err
+}
+
+func parseSentryDSN(dsn string) (*sentryDSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	i := strings.LastIndex(u.Path, "/")
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User == nil || u.User.Username() == "" || i < 0 || u.Path[i+1:] == "" {
+		return nil, errors.New("sentry dsn must be in the form https://<key>@<host>/<project>")
+	}
+
+	secret, _ := u.User.Password()
+
+	return &sentryDSN{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], u.Path[i+1:]),
+		key:      u.User.Username(),
+		secret:   secret,
+	}, nil
+}
+
+// Returns the X-Sentry-Auth header that authenticates an event.
+func (d *sentryDSN) auth() string {
+	h := fmt.Sprintf("Sentry sentry_version=7, sentry_client=pterodactyl-sftp-server, sentry_timestamp=%d, sentry_key=%s", time.Now().Unix(), d.key)
+	if d.secret != "" {
+		h += ", sentry_secret=" + d.secret
+	}
+
+	return h
+}
+
+// Encodes an error as a Sentry event. The server and session are sent as tags so that errors can
+// be searched by them, and the user as the user the event affected.
+func (d *sentryDSN) event(e errorReport) []byte {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	// Sentry has no equivalent of the levels zap has above error, which are all more severe.
+	level := e.Level
+	if level != "error" {
+		level = "fatal"
+	}
+
+	extra := map[string]interface{}{}
+	for k, v := range e.Fields {
+		extra[k] = v
+	}
+	if e.Error != "" {
+		extra["error"] = e.Error
+	}
+	if e.Stacktrace != "" {
+		extra["stacktrace"] = e.Stacktrace
+	}
+
+	tags := map[string]string{}
+	if e.Server != "" {
+		tags["server"] = e.Server
+	}
+	if e.Session != "" {
+		tags["session"] = e.Session
+	}
+
+	event := map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   e.Timestamp.UTC().Format("2006-01-02T15:04:05"),
+		"level":       level,
+		"logger":      "sftp-server",
+		"platform":    "go",
+		"message":     e.Message,
+		"culprit":     e.Caller,
+		"server_name": e.Node,
+		"tags":        tags,
+		"extra":       extra,
+	}
+
+	if e.Environment != "" {
+		event["environment"] = e.Environment
+	}
+
+	if e.Version != "" {
+		event["release"] = e.Version
+	}
+
+	if e.User != "" || e.IP != "" {
+		event["user"] = map[string]string{"username": e.User, "ip_address": e.IP}
+	}
+
+	b, _ := json.Marshal(event)
+
+	return b
+}
+
+// Logs a panic that was recovered while handling a session, which reports it. This must be
+// called from the deferred function that recovered the panic, so that the stack logged with the
+// error is still that of where the panic happened. Panics are recovered so that a single bad
+// request can't bring down every other session on the node.
+func logPanic(log *zap.SugaredLogger, r interface{}) {
+	log.Desugar().WithOptions(zap.AddCallerSkip(1)).Sugar().Errorw("recovered from panic", zap.String("panic", fmt.Sprint(r)))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+)
+
+// The names listeners are handed over to a new process under when restarting, which tell it what
+// each of them is for.
+const (
+	handoverSFTP  = "sftp"
+	handoverAdmin = "admin"
+	handoverPprof = "pprof"
+)
+
+// A listener being handed over to a new process.
+type handover struct {
+	name     string
+	listener net.Listener
+}
+
+// Returns the listeners that would be handed over to a new process if the server was restarted
+// now. The lock must be held when calling this.
+func (s *Server) handovers() []handover {
+	var handovers []handover
+	for _, l := range s.listeners {
+		handovers = append(handovers, handover{name: handoverSFTP, listener: l})
+	}
+
+	if s.adminListener != nil {
+		handovers = append(handovers, handover{name: handoverAdmin, listener: s.adminListener})
+	}
+
+	if s.pprofListener != nil {
+		handovers = append(handovers, handover{name: handoverPprof, listener: s.pprofListener})
+	}
+
+	return handovers
+}
+
+// Returns the listener handed over by the previous process under the name, or listens on the
+// address if there isn't one. A listener that was handed over is used even if the address has
+// since been changed, so changes to the address only take effect once the server is stopped and
+// started again.
+func (s *Server) listenTCP(name string, address string) (net.Listener, error) {
+	if l := s.inherited[name]; len(l) > 0 {
+		s.inherited[name] = l[1:]
+		return l[0], nil
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// Tells the process that handed its listeners over to us that the server has started, so that it
+// can stop accepting connections itself. Any listeners that were handed over but are no longer
+// needed, such as the admin API once it has been disabled, are closed.
+func (s *Server) finishHandover() {
+	for _, listeners := range s.inherited {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	s.inherited = nil
+
+	if s.ready != nil {
+		s.ready.Write([]byte("ready\n"))
+		s.ready.Close()
+		s.ready = nil
+	}
+}
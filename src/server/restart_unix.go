@@ -0,0 +1,202 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// The environment variable listing the names of the listeners handed over to a new process when
+// restarting, in the order of their file descriptors, which start from the same descriptor as
+// with socket activation. The descriptor after the last listener is a pipe the new process
+// writes to once it has started.
+const handoverEnv = "SFTP_HANDOVER_LISTENERS"
+
+// How long a new process has to start before the restart is abandoned.
+const handoverTimeout = 30 * time.Second
+
+// Returns the listeners handed over by the process that restarted into this one by their names,
+// along with the pipe used to tell it once the server has started. Nothing is returned if the
+// process was not started by a restart.
+func inheritListeners() (map[string][]net.Listener, *os.File, error) {
+	names := os.Getenv(handoverEnv)
+	os.Unsetenv(handoverEnv)
+
+	if names == "" {
+		return nil, nil, nil
+	}
+
+	listeners := make(map[string][]net.Listener)
+	fd := listenFdsStart
+	for _, name := range strings.Split(names, ",") {
+		l, err := fileListener(fd)
+		if err != nil {
+			for _, inherited := range listeners {
+				for _, l := range inherited {
+					l.Close()
+				}
+			}
+
+			return nil, nil, err
+		}
+
+		listeners[name] = append(listeners[name], l)
+		fd++
+	}
+
+	syscall.CloseOnExec(fd)
+
+	return listeners, os.NewFile(uintptr(fd), "handover"), nil
+}
+
+// Restarts the server without interrupting any of its sessions. A new process is started from the
+// same executable and arguments and handed the listeners of this one, so it accepts every
+// connection from then on, while the sessions already connected here are given until the drain
+// timeout to end. If the new process fails to start this one carries on as if nothing happened.
+// This only returns once the server has stopped, unless the restart fails.
+func (s *Server) Restart() error {
+	s.mu.Lock()
+	select {
+	case <-s.draining:
+		s.mu.Unlock()
+		return errors.New("server is already stopping")
+	default:
+	}
+	handovers := s.handovers()
+	s.mu.Unlock()
+
+	pid, err := handOver(handovers)
+	if err != nil {
+		return errors.Wrap(err, "failed to start new process")
+	}
+
+	logger.Get().Infow("handed listeners over to new process", zap.Int("pid", pid))
+	notifyMainPID(pid)
+
+	// The socket file belongs to the new process now, so it has to be left in place when our
+	// copy of the listener is closed.
+	for _, h := range handovers {
+		if l, ok := h.listener.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+	}
+
+	return s.Shutdown(s.config.Settings.DrainTimeout)
+}
+
+// Starts a new process that is handed the listeners, returning its PID once it has started.
+func handOver(handovers []handover) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	names := make([]string, 0, len(handovers))
+	files := make([]*os.File, 0, len(handovers)+1)
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+		w.Close()
+	}
+
+	for _, h := range handovers {
+		f, err := h.listener.(interface{ File() (*os.File, error) }).File()
+		if err != nil {
+			closeFiles()
+			return 0, err
+		}
+
+		names = append(names, h.name)
+		files = append(files, f)
+	}
+
+	// The descriptors are passed to the new process as they are, rather than through os/exec,
+	// which would switch them to blocking mode. They share that mode with the listeners, which
+	// could then never be closed while waiting to accept a connection.
+	fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, f := range append(files, w) {
+		raw, err := f.SyscallConn()
+		if err != nil {
+			closeFiles()
+			return 0, err
+		}
+
+		raw.Control(func(fd uintptr) {
+			fds = append(fds, fd)
+		})
+	}
+
+	pid, err := syscall.ForkExec(executable, append([]string{executable}, os.Args[1:]...), &syscall.ProcAttr{
+		Env:   append(os.Environ(), handoverEnv+"="+strings.Join(names, ",")),
+		Files: fds,
+	})
+	// The new process has its own copies of the descriptors, if it was started at all.
+	closeFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	// The new process writes to the pipe once it has started, and closes it by exiting if it
+	// fails to.
+	r.SetReadDeadline(time.Now().Add(handoverTimeout))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		process.Kill()
+		process.Wait()
+
+		if err == io.EOF {
+			err = errors.New("new process exited before it started")
+		}
+
+		return 0, err
+	}
+
+	// Nothing waits for the new process once we have exited, but it still has to be reaped if it
+	// happens to exit first.
+	go process.Wait()
+
+	return pid, nil
+}
+
+// Tells systemd that the new process is now the main process of the service, so that the service
+// isn't considered to have stopped once this process exits. This does nothing when not running
+// under systemd.
+func notifyMainPID(pid int) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		logger.Get().Warnw("failed to notify systemd of the new main process", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "MAINPID=%d\n", pid); err != nil {
+		logger.Get().Warnw("failed to notify systemd of the new main process", zap.Error(err))
+	}
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Listeners are never handed over on Windows, since the server can't be restarted there.
+func inheritListeners() (map[string][]net.Listener, *os.File, error) {
+	return nil, nil, nil
+}
+
+// Restarting relies on a new process inheriting the listening sockets of this one, which can't be
+// done on Windows, so the server has to be stopped and started again instead.
+func (s *Server) Restart() error {
+	return errors.New("restarting is not supported on windows")
+}
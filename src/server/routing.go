@@ -0,0 +1,311 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// A PanelRoute sends the logins matching it to another Panel than the one in the Daemon
+// configuration, which allows a single node to serve servers belonging to several Panel
+// installations. Every rule that is set must match a login for it to be routed to the Panel.
+type PanelRoute struct {
+	// The name of the Panel, which is included when logging the logins routed to it.
+	Name string
+	// The base URL of the Panel, and the token used to authenticate this node with it.
+	URL   string
+	Token string
+	// Matches users whose username ends with the suffix. The suffix is removed from the
+	// username before it is sent to the Panel, since the Panel does not know about it.
+	UserSuffix string
+	// Matches connections made to one of the local IP addresses, such as when each Panel has
+	// its own address on the node.
+	Addresses []string
+}
+
+// Checks that each route has a unique name, a Panel to send logins to, and at least one rule.
+func ValidatePanelRoutes(routes []PanelRoute) error {
+	names := make(map[string]bool)
+	for _, r := range routes {
+		if r.Name == "" {
+			return errors.New("every panel must have a name")
+		}
+
+		if names[r.Name] {
+			return fmt.Errorf("panel %q is defined more than once", r.Name)
+		}
+		names[r.Name] = true
+
+		if r.URL == "" || r.Token == "" {
+			return fmt.Errorf("panel %q must have a url and token", r.Name)
+		}
+
+		if r.UserSuffix == "" && len(r.Addresses) == 0 {
+			return fmt.Errorf("panel %q must have a user_suffix or addresses to route logins by", r.Name)
+		}
+
+		for _, address := range r.Addresses {
+			if net.ParseIP(address) == nil {
+				return fmt.Errorf("panel %q has an invalid address %q", r.Name, address)
+			}
+		}
+	}
+
+	return nil
+}
+
+// A route along with the authenticator for its Panel.
+type panelRoute struct {
+	PanelRoute
+	auth *PanelAuthenticator
+}
+
+// Routes logins to the Panel they belong to, falling back to the Panel in the Daemon
+// configuration when no route matches. The Panel each server was authenticated by is remembered,
+// so that anything later requested for the server, such as the permissions of its users, is
+// requested from the same Panel.
+type panelRouter struct {
+	fallback *PanelAuthenticator
+	routes   []panelRoute
+
+	// The local IP address of the connection, which is only set on the copies of the router made
+	// for a connection.
+	localIP string
+
+	// The name of the Panel each server was authenticated by, which is shared with the copies of
+	// the router. Servers belonging to the fallback Panel are not included.
+	mu      *sync.Mutex
+	servers map[string]string
+}
+
+func newPanelRouter(fallback *PanelAuthenticator, routes []PanelRoute, c Configuration) *panelRouter {
+	r := &panelRouter{
+		fallback: fallback,
+		mu:       &sync.Mutex{},
+		servers:  make(map[string]string),
+	}
+
+	for _, route := range routes {
+		r.routes = append(r.routes, panelRoute{
+			PanelRoute: route,
+			auth: &PanelAuthenticator{
+				URL:     strings.TrimSuffix(route.URL, "/"),
+				Token:   route.Token,
+				Client:  newPanelClient(c.Settings.PanelTLS, 10*time.Second),
+				breaker: newCircuitBreaker(),
+			},
+		})
+	}
+
+	return r
+}
+
+// Returns the route matching a login, or nil if it belongs to the fallback Panel, along with the
+// username to send to the Panel.
+func (r *panelRouter) match(user string) (*panelRoute, string) {
+	for i := range r.routes {
+		route := &r.routes[i]
+		if route.UserSuffix != "" && (!strings.HasSuffix(user, route.UserSuffix) || len(user) == len(route.UserSuffix)) {
+			continue
+		}
+
+		if len(route.Addresses) > 0 && !containsString(route.Addresses, r.localIP) {
+			continue
+		}
+
+		return route, strings.TrimSuffix(user, route.UserSuffix)
+	}
+
+	return nil, user
+}
+
+// Returns the authenticator for the Panel a login belongs to, along with the name of the Panel,
+// which is empty for the fallback Panel, and the username to send to it.
+func (r *panelRouter) panelFor(user string) (*PanelAuthenticator, string, string) {
+	route, name := r.match(user)
+	if route == nil {
+		return r.fallback, "", name
+	}
+
+	logger.Get().Debugw("routing login to panel", zap.String("user", user), zap.String("panel", route.Name))
+
+	return route.auth, route.Name, name
+}
+
+// Returns the authenticator for the Panel a server was authenticated by.
+func (r *panelRouter) panelForServer(server string) *PanelAuthenticator {
+	name := r.panelOf(server)
+	for i := range r.routes {
+		if r.routes[i].Name == name {
+			return r.routes[i].auth
+		}
+	}
+
+	return r.fallback
+}
+
+// Returns the name of the Panel a server was authenticated by, which is empty for the fallback
+// Panel or a server that has not been logged in to yet.
+func (r *panelRouter) panelOf(server string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.servers[server]
+}
+
+// Logs in using the Panel the user belongs to, remembering which one it was for the server.
+func (r *panelRouter) login(user string, fn func(a *PanelAuthenticator, user string) (*AuthenticationResponse, error)) (*AuthenticationResponse, error) {
+	a, panel, name := r.panelFor(user)
+
+	resp, err := fn(a, name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if panel == "" {
+		delete(r.servers, resp.Server)
+	} else {
+		r.servers[resp.Server] = panel
+	}
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+func (r *panelRouter) Authenticate(user string, pass []byte) (*AuthenticationResponse, error) {
+	return r.login(user, func(a *PanelAuthenticator, user string) (*AuthenticationResponse, error) {
+		return a.Authenticate(user, pass)
+	})
+}
+
+func (r *panelRouter) AuthenticateToken(user string, token string) (*AuthenticationResponse, error) {
+	return r.login(user, func(a *PanelAuthenticator, user string) (*AuthenticationResponse, error) {
+		return a.AuthenticateToken(user, token)
+	})
+}
+
+func (r *panelRouter) AuthenticateCertificate(user string, principal string, cert *ssh.Certificate) (*AuthenticationResponse, error) {
+	return r.login(user, func(a *PanelAuthenticator, user string) (*AuthenticationResponse, error) {
+		return a.AuthenticateCertificate(user, principal, cert)
+	})
+}
+
+func (r *panelRouter) VerifyTwoFactor(user string, code string) error {
+	a, _, name := r.panelFor(user)
+
+	return a.VerifyTwoFactor(name, code)
+}
+
+func (r *panelRouter) Permissions(user string, server string) ([]string, error) {
+	return r.panelForServer(server).Permissions(user, server)
+}
+
+func (r *panelRouter) ManagedFiles(server string) ([]string, error) {
+	return r.panelForServer(server).ManagedFiles(server)
+}
+
+// Checks that every Panel can be reached, returning the first error along with the Panel it was
+// for.
+func (r *panelRouter) Ping() error {
+	return r.each(func(a *PanelAuthenticator) error { return a.Ping() })
+}
+
+// Checks that every Panel accepts the token for it.
+func (r *panelRouter) VerifyToken() error {
+	return r.each(func(a *PanelAuthenticator) error { return a.VerifyToken() })
+}
+
+func (r *panelRouter) each(fn func(a *PanelAuthenticator) error) error {
+	if err := fn(r.fallback); err != nil {
+		return err
+	}
+
+	for _, route := range r.routes {
+		if err := fn(route.auth); err != nil {
+			return fmt.Errorf("panel %q: %w", route.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Determines if credentials are unable to be validated at all, which is only the case when every
+// Panel has been failing to respond, since it isn't known which Panel a user belongs to until
+// they log in.
+func (r *panelRouter) unavailable() bool {
+	if !r.fallback.unavailable() {
+		return false
+	}
+
+	for _, route := range r.routes {
+		if !route.auth.unavailable() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *panelRouter) withCorrelationID(id string) Authenticator {
+	c := *r
+	c.fallback = r.fallback.withCorrelationID(id).(*PanelAuthenticator)
+	c.routes = make([]panelRoute, len(r.routes))
+	for i, route := range r.routes {
+		c.routes[i] = panelRoute{PanelRoute: route.PanelRoute, auth: route.auth.withCorrelationID(id).(*PanelAuthenticator)}
+	}
+
+	return &c
+}
+
+func (r *panelRouter) forConnection(conn ssh.ConnMetadata) Authenticator {
+	c := *r
+	c.localIP = remoteIP(conn.LocalAddr())
+
+	return &c
+}
+
+// Implemented by authenticators whose logins depend on the connection they are made on.
+type connectionRouter interface {
+	forConnection(conn ssh.ConnMetadata) Authenticator
+}
+
+// Returns a copy of the authenticator for the connection, or the authenticator itself if it does
+// not depend on the connection.
+func routeConnection(a Authenticator, conn ssh.ConnMetadata) Authenticator {
+	if r, ok := a.(connectionRouter); ok {
+		return r.forConnection(conn)
+	}
+
+	return a
+}
+
+func (o *offlineAuthenticator) forConnection(conn ssh.ConnMetadata) Authenticator {
+	c := *o
+	c.Authenticator = routeConnection(o.Authenticator, conn)
+
+	return &c
+}
+
+// Returns the authenticator to use for the logins on a connection, which sends the correlation
+// ID of the connection with each request and is routed to the Panel the connection belongs to.
+func (s *Server) authenticatorFor(conn ssh.ConnMetadata) Authenticator {
+	return correlate(routeConnection(s.auth, conn), correlationID(conn))
+}
+
+// Returns the name of the Panel a server was authenticated by, which is empty when it belongs to
+// the Panel in the Daemon configuration.
+func (s *Server) panelOf(server string) string {
+	if s.panels == nil {
+		return ""
+	}
+
+	return s.panels.panelOf(server)
+}
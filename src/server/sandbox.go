@@ -0,0 +1,59 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// The paths the sandbox allows to be read no matter how the server is configured. These are
+// what the Go runtime and standard library read while running, such as the certificates used
+// to verify the Panel, DNS configuration and time zones.
+var defaultSandboxReadPaths = []string{
+	"/etc/ssl",
+	"/etc/pki",
+	"/etc/ca-certificates",
+	"/usr/share/ca-certificates",
+	"/etc/resolv.conf",
+	"/etc/hosts",
+	"/etc/nsswitch.conf",
+	"/etc/gai.conf",
+	"/etc/localtime",
+	"/usr/share/zoneinfo",
+	"/proc/self",
+	"/sys/fs/cgroup",
+}
+
+// Returns the paths the sandbox allows to be read, read and written, and executed. Along with
+// anything configured, the server directories, configuration, quarantine, auth log and unix
+// socket are always allowed. The directory of the executable can be executed from so that the
+// server can restart, which starts a new process from the executable, even once it has been
+// replaced by an update.
+func (s *Server) sandboxPaths() ([]string, []string, []string) {
+	c := s.config
+
+	read := append([]string{c.Settings.BasePath}, defaultSandboxReadPaths...)
+	read = append(read, c.Settings.SandboxReadPaths...)
+
+	write := []string{c.dataPath()}
+	if s.scanner != nil {
+		// The quarantine is only created once something has been found, but it needs to exist
+		// by the time the sandbox is applied for it to be allowed.
+		os.MkdirAll(s.scanner.quarantine, 0700)
+		write = append(write, s.scanner.quarantine)
+	}
+
+	if c.Settings.AuthLogPath != "" {
+		write = append(write, filepath.Dir(c.Settings.AuthLogPath))
+	}
+
+	if c.Settings.UnixSocket != "" {
+		write = append(write, filepath.Dir(c.Settings.UnixSocket))
+	}
+
+	var execute []string
+	if executable, err := os.Executable(); err == nil {
+		execute = append(execute, filepath.Dir(executable))
+	}
+
+	return read, append(write, c.Settings.SandboxWritePaths...), execute
+}
@@ -0,0 +1,163 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// The numbers of the Landlock system calls, which like openat2 are the same on every
+// architecture other than MIPS.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+)
+
+const (
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+	prSetNoNewPrivs              = 38
+)
+
+// The filesystem access rights Landlock is able to restrict.
+const (
+	accessExecute    = 1 << 0
+	accessWriteFile  = 1 << 1
+	accessReadFile   = 1 << 2
+	accessReadDir    = 1 << 3
+	accessRemoveDir  = 1 << 4
+	accessRemoveFile = 1 << 5
+	accessMakeChar   = 1 << 6
+	accessMakeDir    = 1 << 7
+	accessMakeReg    = 1 << 8
+	accessMakeSock   = 1 << 9
+	accessMakeFifo   = 1 << 10
+	accessMakeBlock  = 1 << 11
+	accessMakeSym    = 1 << 12
+	accessRefer      = 1 << 13
+	accessTruncate   = 1 << 14
+	accessIoctlDev   = 1 << 15
+)
+
+// The rights that can be granted on a file rather than a directory.
+const accessFile = accessExecute | accessWriteFile | accessReadFile | accessTruncate | accessIoctlDev
+
+const (
+	accessRead  = accessReadFile | accessReadDir
+	accessWrite = accessRead | accessWriteFile | accessRemoveDir | accessRemoveFile | accessMakeDir |
+		accessMakeReg | accessMakeSym | accessRefer | accessTruncate
+)
+
+// Restricts the process to only being able to read and write files beneath the given paths, and
+// run programs beneath the executable paths, using Landlock. Everything else, including creating
+// device files, is denied. Paths that don't exist are skipped. The restriction applies to every
+// thread, and every process started from them, and cannot be lifted once it is in place.
+func applySandbox(read []string, write []string, execute []string) error {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("landlock is not supported by the kernel: %v", errno)
+	}
+
+	// Before version 2 files could never be moved between directories once Landlock was in
+	// use, which would break renames and the trash.
+	if abi < 2 {
+		return fmt.Errorf("landlock version %d is too old, at least version 2 is required", abi)
+	}
+
+	handled := uint64(accessRefer<<1 - 1)
+	if abi >= 3 {
+		handled |= accessTruncate
+	}
+	if abi >= 5 {
+		handled |= accessIoctlDev
+	}
+
+	ruleset, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&handled)), unsafe.Sizeof(handled), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %v", errno)
+	}
+	defer syscall.Close(int(ruleset))
+
+	for _, p := range read {
+		if err := addSandboxRule(int(ruleset), p, accessRead&handled); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range write {
+		if err := addSandboxRule(int(ruleset), p, accessWrite&handled); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range execute {
+		if err := addSandboxRule(int(ruleset), p, (accessExecute|accessReadFile)&handled); err != nil {
+			return err
+		}
+	}
+
+	// Landlock only applies to the thread that asks for it, so it needs to be applied to every
+	// thread the runtime has started. This isn't possible from a binary using cgo.
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("the sandbox requires a binary built with CGO_ENABLED=0")
+		}
+
+		return fmt.Errorf("failed to set no_new_privs: %v", errno)
+	}
+
+	if _, _, errno := syscall.AllThreadsSyscall(sysLandlockRestrictSelf, ruleset, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to apply landlock ruleset: %v", errno)
+	}
+
+	return nil
+}
+
+// The landlock_path_beneath_attr struct. The kernel's struct is packed, so only the first 12
+// bytes of this are read, but the fields are at the same offsets either way.
+type pathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+	_             int32
+}
+
+func addSandboxRule(ruleset int, p string, access uint64) error {
+	fd, err := syscall.Open(p, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if os.IsNotExist(err) {
+		logger.Get().Debugw("skipping sandbox path that does not exist", zap.String("path", p))
+		return nil
+	} else if err == syscall.EACCES {
+		// Nothing is gained by allowing a path the process can't open anyway, such as one
+		// denied by the sandbox a process started by a restart inherits from the one before it.
+		logger.Get().Debugw("skipping sandbox path that cannot be opened", zap.String("path", p))
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not open sandbox path %s: %v", p, err)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return err
+	}
+
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= accessFile
+	}
+
+	attr := pathBeneathAttr{allowedAccess: access, parentFd: int32(fd)}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("could not add sandbox path %s: %v", p, errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+// +build !linux mips mipsle mips64 mips64le
+
+package server
+
+import "errors"
+
+// Landlock is only available on Linux, so there is no way of sandboxing the process anywhere
+// else.
+func applySandbox(read []string, write []string, execute []string) error {
+	return errors.New("the sandbox is only supported on linux")
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The event sent to webhooks when an uploaded file is found to contain malware.
+const EventMalware = "malware"
+
+const (
+	// The number of uploads that can be waiting to be scanned. Once the queue is full new
+	// uploads are not scanned, rather than holding up the clients uploading them.
+	scanQueueSize = 256
+
+	// How long a single scan can take before it is given up on, including sending the file.
+	scanTimeout = 2 * time.Minute
+
+	// The size of each chunk of a file streamed to clamd.
+	scanChunkSize = 32 * 1024
+)
+
+type scanJob struct {
+	fs   *FileSystem
+	path string
+}
+
+// Scans uploaded files for malware using a ClamAV daemon. Files are scanned in the background
+// once they have been completely uploaded, and anything that is found to be infected is moved
+// out of the server directory into the quarantine and reported to the webhooks.
+type malwareScanner struct {
+	network    string
+	address    string
+	quarantine string
+	queue      chan scanJob
+}
+
+// Creates a scanner for the clamd socket at the given address, which is either the path to a
+// unix socket or a "host:port" TCP address.
+func newMalwareScanner(address string, quarantine string) *malwareScanner {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+
+	return &malwareScanner{
+		network:    network,
+		address:    address,
+		quarantine: quarantine,
+		queue:      make(chan scanJob, scanQueueSize),
+	}
+}
+
+// Queues a file that was just uploaded to be scanned.
+func (m *malwareScanner) submit(fs *FileSystem, p string) {
+	select {
+	case m.queue <- scanJob{fs: fs, path: p}:
+	default:
+		fs.log().Warnw("not scanning uploaded file since the scan queue is full", zap.String("source", p))
+	}
+}
+
+// Scans queued files until the done channel is closed.
+func (m *malwareScanner) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case j := <-m.queue:
+			m.check(j.fs, j.path)
+		}
+	}
+}
+
+// Scans a single file, quarantining it if it is infected. Files that could not be scanned are
+// left where they are, since clamd being unavailable should not stop anyone from uploading.
+func (m *malwareScanner) check(fs *FileSystem, p string) {
+	// The file may have been replaced since it was uploaded, and only regular files are worth
+	// scanning. Anything else, such as a symlink to somewhere outside of the server directory,
+	// is skipped.
+	info, err := fs.backend().Lstat(p)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	f, err := fs.openFile(p, os.O_RDONLY, 0)
+	if err != nil {
+		fs.log().Warnw("failed to open uploaded file for scanning", zap.String("source", p), zap.Error(err))
+		return
+	}
+
+	signature, err := m.scan(f)
+	f.Close()
+	if err != nil {
+		fs.log().Warnw("failed to scan uploaded file", zap.String("source", p), zap.Error(err))
+		return
+	}
+
+	if signature == "" {
+		return
+	}
+
+	fs.log().Warnw("malware detected in uploaded file", zap.String("source", p), zap.String("signature", signature))
+
+	target, err := m.moveToQuarantine(fs, p)
+	if err != nil {
+		fs.log().Errorw("failed to quarantine infected file", zap.String("source", p), zap.Error(err))
+	} else {
+		fs.trackUsage(-info.Size())
+		fs.trackFiles(-1)
+	}
+
+	if fs.events != nil {
+		fs.events(FileEvent{
+			Event:      EventMalware,
+			Server:     fs.UUID,
+			Path:       fs.relativePath(p),
+			Signature:  signature,
+			Quarantine: target,
+			Timestamp:  time.Now().UTC(),
+		})
+	}
+}
+
+// Streams the contents of a file to clamd, returning the name of the signature that matched
+// or an empty string if the file is clean.
+func (m *malwareScanner) scan(r io.Reader) (string, error) {
+	conn, err := net.DialTimeout(m.network, m.address, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(scanTimeout))
+
+	// Each chunk of the file is sent prefixed with its length, and a chunk with a length of
+	// zero marks the end of the stream.
+	w := bufio.NewWriter(conn)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, scanChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			var size [4]byte
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			w.Write(size[:])
+			if _, err := w.Write(buf[:n]); err != nil {
+				return "", err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	w.Write([]byte{0, 0, 0, 0})
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return parseScanReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// Parses the reply to a scan, which is "stream: OK" for a clean file or "stream: <signature>
+// FOUND" for an infected one. Anything else is an error, such as the file being larger than
+// the stream limit clamd is configured with.
+func parseScanReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+
+	return "", fmt.Errorf("unexpected reply from clamd: %s", reply)
+}
+
+// Moves an infected file out of the server directory so that it can no longer be downloaded or
+// run by the server, but can still be looked at by an administrator. Files are kept in a
+// directory for each server, prefixed with the time they were quarantined.
+func (m *malwareScanner) moveToQuarantine(fs *FileSystem, p string) (string, error) {
+	dir := filepath.Join(m.quarantine, fs.UUID)
+	if err := fs.backend().MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(dir, fmt.Sprintf("%d-%s", time.Now().Unix(), filepath.Base(p)))
+	if err := fs.backend().Rename(p, target); err != nil {
+		return "", err
+	}
+
+	// Make sure nothing can be run from the quarantine, even by the server user.
+	if err := fs.backend().Chmod(target, 0400); err != nil && !os.IsNotExist(err) {
+		fs.log().Warnw("failed to change mode of quarantined file", zap.String("file", target), zap.Error(err))
+	}
+
+	return target, nil
+}
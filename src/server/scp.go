@@ -0,0 +1,560 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// An scpCommand is a parsed "scp -t" (sink, the client is uploading) or "scp -f" (source, the
+// client is downloading) command received on an exec channel. All of the file operations are
+// performed through the same FileSystem handlers used for SFTP, so SCP is subject to exactly
+// the same path validation and permission checks.
+type scpCommand struct {
+	sink      bool
+	recursive bool
+	times     bool
+	targetDir bool
+	paths     []string
+}
+
+// Parses the command sent with an exec request, returning false if it is not an scp command
+// that we're able to handle.
+func parseSCPCommand(command string) (*scpCommand, bool) {
+	args := splitCommand(command)
+	if len(args) < 2 || args[0] != "scp" {
+		return nil, false
+	}
+
+	cmd := &scpCommand{}
+	var source bool
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			cmd.paths = append(cmd.paths, args[i+1:]...)
+			break
+		}
+
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			cmd.paths = append(cmd.paths, a)
+			continue
+		}
+
+		for _, f := range a[1:] {
+			switch f {
+			case 't':
+				cmd.sink = true
+			case 'f':
+				source = true
+			case 'r':
+				cmd.recursive = true
+			case 'p':
+				cmd.times = true
+			case 'd':
+				cmd.targetDir = true
+			case 'v':
+			default:
+				return nil, false
+			}
+		}
+	}
+
+	// Exactly one of the modes must be provided, and we need to have something to work on.
+	if cmd.sink == source || len(cmd.paths) == 0 {
+		return nil, false
+	}
+
+	return cmd, true
+}
+
+// Splits a command string into its arguments, handling the basic shell quoting that clients
+// apply to paths containing spaces.
+func splitCommand(command string) []string {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	var inArg, escaped bool
+
+	for _, r := range command {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args
+}
+
+// Runs the scp command against the channel, returning the exit status to send back to the
+// client once complete.
+func (cmd *scpCommand) run(channel ssh.Channel, fs *FileSystem) uint32 {
+	s := &scpSession{
+		channel: channel,
+		reader:  bufio.NewReader(channel),
+		fs:      fs,
+		cmd:     cmd,
+	}
+
+	var err error
+	if cmd.sink {
+		err = s.receive(cmd.paths[0])
+	} else {
+		err = s.send()
+	}
+
+	if err != nil {
+		if err != io.EOF {
+			s.fs.log().Debugw("scp session ended with error", zap.Error(err))
+		}
+
+		return 1
+	}
+
+	if s.failed {
+		return 1
+	}
+
+	return 0
+}
+
+type scpSession struct {
+	channel ssh.Channel
+	reader  *bufio.Reader
+	fs      *FileSystem
+	cmd     *scpCommand
+
+	// Set if any of the individual files failed to transfer, which determines the exit status
+	// of the command even though the session as a whole completed.
+	failed bool
+}
+
+// Sends a successful acknowledgement to the client.
+func (s *scpSession) ack() error {
+	_, err := s.channel.Write([]byte{0})
+	return err
+}
+
+// Sends a non-fatal error message to the client, the transfer will continue.
+func (s *scpSession) warn(message string) error {
+	s.failed = true
+	_, err := s.channel.Write([]byte("\x01scp: " + message + "\n"))
+	return err
+}
+
+// Waits for the client to acknowledge the last message that was sent.
+func (s *scpSession) waitAck() error {
+	b, err := s.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if b == 0 {
+		return nil
+	}
+
+	msg, _ := s.reader.ReadString('\n')
+	return errors.New(strings.TrimSpace(msg))
+}
+
+// Handles an upload from the client into the target path.
+func (s *scpSession) receive(target string) error {
+	// When the target is an existing directory (or the client told us it should be one) the
+	// files are written into it, otherwise the target is the name of the file itself.
+	dir := target
+	targetIsDir := s.cmd.targetDir
+	if info, err := s.stat(target); err == nil && info.IsDir() {
+		targetIsDir = true
+	}
+
+	if !targetIsDir {
+		dir = path.Dir(target)
+	}
+
+	if err := s.ack(); err != nil {
+		return err
+	}
+
+	// The times sent by the client for the next file or directory, and the times for each of
+	// the directories currently being received. Directory times are applied once everything
+	// within them has been written, since writing the files would change them again.
+	var times *scpTimes
+	var dirTimes []*scpTimes
+
+	var depth int
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch line[0] {
+		case 'T':
+			if times, err = parseSCPTimes(line); err != nil {
+				return err
+			}
+
+			if err := s.ack(); err != nil {
+				return err
+			}
+		case 'C', 'D':
+			_, size, name, err := parseSCPHeader(line)
+			if err != nil {
+				return err
+			}
+
+			p := path.Join(dir, name)
+			if !targetIsDir && depth == 0 {
+				p = target
+			}
+
+			if line[0] == 'D' {
+				if err := s.fs.Filecmd(sftp.NewRequest("Mkdir", p)); err != nil && err != sftp.ErrSshFxOk {
+					if err := s.warn(fmt.Sprintf("%s: %s", name, err)); err != nil {
+						return err
+					}
+					continue
+				}
+
+				dir = p
+				depth++
+				dirTimes = append(dirTimes, times)
+				times = nil
+				if err := s.ack(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := s.receiveFile(p, name, size, times); err != nil {
+				return err
+			}
+			times = nil
+		case 'E':
+			if depth == 0 {
+				return errors.New("unexpected end of directory")
+			}
+
+			s.setTimes(dir, dirTimes[len(dirTimes)-1])
+			dirTimes = dirTimes[:len(dirTimes)-1]
+
+			dir = path.Dir(dir)
+			depth--
+			if err := s.ack(); err != nil {
+				return err
+			}
+		case '\x01', '\x02':
+			s.fs.log().Debugw("scp client reported an error", zap.String("message", strings.TrimSpace(line[1:])))
+			if line[0] == '\x02' {
+				return errors.New(strings.TrimSpace(line[1:]))
+			}
+		default:
+			return errors.New("received an invalid scp protocol message")
+		}
+	}
+}
+
+// Receives the contents of a single file from the client and writes it to the given path.
+func (s *scpSession) receiveFile(p string, name string, size int64, times *scpTimes) error {
+	s.fs.countOperation()
+
+	// We know the size of the file up front, so reject anything too large before the client
+	// starts sending it.
+	if s.fs.MaxFileSize > 0 && size > s.fs.MaxFileSize {
+		return s.warn(fmt.Sprintf("%s: file exceeds the maximum allowed size", name))
+	}
+
+	// Likewise for anything that would put the server over its disk limit, taking into account
+	// the size of the file being replaced.
+	if target, err := s.fs.buildPath(p); err == nil && !s.fs.hasSpaceFor(size-s.fs.fileSize(target)) {
+		return s.warn(fmt.Sprintf("%s: disk quota exceeded", name))
+	}
+
+	request := sftp.NewRequest("Put", p)
+	request.Flags = fxfWrite | fxfCreat | fxfTrunc
+
+	w, err := s.fs.Filewrite(request)
+	if err != nil {
+		// The client won't send the file contents if we respond with an error here, so we can
+		// just pick back up with the next file.
+		return s.warn(fmt.Sprintf("%s: %s", name, err))
+	}
+
+	if err := s.ack(); err != nil {
+		closeWriter(w)
+		return err
+	}
+
+	_, err = s.fs.copyN(&offsetWriter{w: w}, s.reader, size)
+	if u, ok := w.(interface{ commit() }); ok && err == nil {
+		u.commit()
+	}
+	closeWriter(w)
+	if err != nil {
+		return err
+	}
+
+	s.setTimes(p, times)
+
+	// Once the file contents have been sent the client sends a single null byte, which we then
+	// acknowledge to indicate the file was written.
+	if err := s.waitAck(); err != nil {
+		return err
+	}
+
+	return s.ack()
+}
+
+// Handles a download of the requested paths by the client.
+func (s *scpSession) send() error {
+	if err := s.waitAck(); err != nil {
+		return err
+	}
+
+	for _, p := range s.cmd.paths {
+		if err := s.sendPath(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sends a single file, or a directory and all of its contents, to the client.
+func (s *scpSession) sendPath(p string) error {
+	s.fs.countOperation()
+
+	info, err := s.stat(p)
+	if err != nil {
+		return s.warn(fmt.Sprintf("%s: %s", p, err))
+	}
+
+	if s.cmd.times {
+		mtime := info.ModTime().Unix()
+		if _, err := fmt.Fprintf(s.channel, "T%d 0 %d 0\n", mtime, mtime); err != nil {
+			return err
+		}
+
+		if err := s.waitAck(); err != nil {
+			return err
+		}
+	}
+
+	if info.IsDir() {
+		if !s.cmd.recursive {
+			return s.warn(fmt.Sprintf("%s: not a regular file", p))
+		}
+
+		return s.sendDirectory(p, info)
+	}
+
+	if !info.Mode().IsRegular() {
+		return s.warn(fmt.Sprintf("%s: not a regular file", p))
+	}
+
+	r, err := s.fs.Fileread(sftp.NewRequest("Get", p))
+	if err != nil {
+		return s.warn(fmt.Sprintf("%s: %s", p, err))
+	}
+	defer closeReader(r)
+
+	if _, err := fmt.Fprintf(s.channel, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), info.Name()); err != nil {
+		return err
+	}
+
+	if err := s.waitAck(); err != nil {
+		return err
+	}
+
+	if _, err := s.fs.copy(s.channel, io.NewSectionReader(r, 0, info.Size())); err != nil {
+		return err
+	}
+
+	if err := s.ack(); err != nil {
+		return err
+	}
+
+	return s.waitAck()
+}
+
+// Sends a directory and its contents to the client.
+func (s *scpSession) sendDirectory(p string, info os.FileInfo) error {
+	lister, err := s.fs.Filelist(sftp.NewRequest("List", p))
+	if err != nil {
+		return s.warn(fmt.Sprintf("%s: %s", p, err))
+	}
+
+	if _, err := fmt.Fprintf(s.channel, "D%04o 0 %s\n", info.Mode().Perm(), info.Name()); err != nil {
+		return err
+	}
+
+	if err := s.waitAck(); err != nil {
+		return err
+	}
+
+	files := make([]os.FileInfo, 100)
+	for offset := int64(0); ; {
+		n, err := lister.ListAt(files, offset)
+		for _, f := range files[:n] {
+			if err := s.sendPath(path.Join(p, f.Name())); err != nil {
+				return err
+			}
+		}
+		offset += int64(n)
+
+		if err == io.EOF || n == 0 {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.channel.Write([]byte("E\n")); err != nil {
+		return err
+	}
+
+	return s.waitAck()
+}
+
+// Returns the file information for a path using the FileSystem stat handler.
+func (s *scpSession) stat(p string) (os.FileInfo, error) {
+	lister, err := s.fs.Filelist(sftp.NewRequest("Stat", p))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]os.FileInfo, 1)
+	if n, _ := lister.ListAt(files, 0); n == 0 {
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	return files[0], nil
+}
+
+// Parses a "C" or "D" header line in the format of "C<mode> <size> <name>".
+func parseSCPHeader(line string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(strings.TrimSuffix(line[1:], "\n"), " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", errors.New("invalid scp header received")
+	}
+
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", errors.Wrap(err, "invalid scp file mode")
+	}
+
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", errors.New("invalid scp file size")
+	}
+
+	// The name should only ever be a single path component, anything else is the client
+	// trying to write somewhere other than where it asked to.
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", errors.New("invalid scp file name")
+	}
+
+	return os.FileMode(mode), size, name, nil
+}
+
+// The modification and access times sent by the client in a "T" message before a file or
+// directory when it is preserving times.
+type scpTimes struct {
+	mtime time.Time
+	atime time.Time
+}
+
+// Parses a times message, such as "T1551539045 0 1551539045 0".
+func parseSCPTimes(line string) (*scpTimes, error) {
+	parts := strings.Fields(line[1:])
+	if len(parts) != 4 {
+		return nil, errors.New("invalid scp times received")
+	}
+
+	var v [4]int64
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || n < 0 {
+			return nil, errors.New("invalid scp times received")
+		}
+		v[i] = n
+	}
+
+	return &scpTimes{
+		mtime: time.Unix(v[0], v[1]*int64(time.Microsecond)),
+		atime: time.Unix(v[2], v[3]*int64(time.Microsecond)),
+	}, nil
+}
+
+// Applies the times sent by the client to a file or directory that was just written. Failing
+// to do so is logged, but the transfer itself still succeeded so it is not reported.
+func (s *scpSession) setTimes(p string, times *scpTimes) {
+	if times == nil {
+		return
+	}
+
+	if err := s.fs.setTimes(p, times.atime, times.mtime); err != nil {
+		s.fs.log().Warnw("failed to set file times", zap.String("source", p), zap.Error(err))
+	}
+}
+
+// Presents an io.WriterAt as a sequential io.Writer.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+
+	return n, err
+}
+
+func closeWriter(w io.WriterAt) {
+	if c, ok := w.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func closeReader(r io.ReaderAt) {
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+}
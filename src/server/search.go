@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+)
+
+const (
+	// The number of matches returned when the client doesn't ask for a specific number, and the
+	// most that can be asked for.
+	defaultSearchResults = 100
+	maxSearchResults     = 1000
+
+	// How long a single search can run for before the matches found so far are returned.
+	searchTimeout = 30 * time.Second
+
+	// Files larger than this are not searched for content, since reading them would take far
+	// too long for what is meant to be an interactive search.
+	maxSearchFileSize = 16 * 1024 * 1024
+)
+
+// Returned from the walk function to stop searching once enough matches have been found.
+var errSearchDone = errors.New("search done")
+
+// A file found by a search.
+type searchMatch struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Handles a "search@pterodactyl.io" extended request, which recursively searches a directory
+// for files with names matching a pattern and, optionally, containing some text. This allows
+// clients to find files without listing every directory on the server themselves.
+//
+// The request contains the directory to search, a glob pattern matched against file names
+// (an empty pattern matches everything), the text files must contain (or an empty string to
+// only match on names) and the most matches to return. The reply contains the number of
+// matches, followed by the path, size and modification time of each one, followed by a byte
+// that is set if there were more matches than could be returned.
+func handleSearch(e *extendedChannel, id uint32, b []byte) []byte {
+	dir, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	pattern, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	content, b, err := unmarshalString(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	limit, _, err := unmarshalUint32(b)
+	if err != nil {
+		return statusPacket(id, sftp.ErrSshFxBadMessage)
+	}
+
+	matches, truncated, err := e.fs.search(dir, pattern, content, int(limit))
+	if err != nil {
+		return statusPacket(id, err)
+	}
+
+	reply := marshalUint32(nil, uint32(len(matches)))
+	for _, m := range matches {
+		reply = marshalString(reply, m.path)
+		reply = marshalUint64(reply, uint64(m.size))
+		reply = marshalUint32(reply, uint32(m.modTime.Unix()))
+	}
+
+	if truncated {
+		reply = append(reply, 1)
+	} else {
+		reply = append(reply, 0)
+	}
+
+	return extendedReplyPacket(id, reply)
+}
+
+// Searches the directory for files with names matching the pattern and containing the given
+// content, returning the matches along with whether or not there were more than the limit.
+// Searching stops early if it takes too long, or the matches would not fit in a reply.
+func (fs *FileSystem) search(rawPath string, pattern string, content string, limit int) ([]searchMatch, bool, error) {
+	fs.countOperation()
+
+	if !fs.can("list-files") {
+		return nil, false, errMissingPermission("list-files")
+	}
+
+	// Searching the contents of files is no different than downloading them.
+	if content != "" && !fs.canDownload() {
+		return nil, false, sftp.ErrSshFxPermissionDenied
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, false, sftp.ErrSshFxBadMessage
+	}
+
+	if limit <= 0 {
+		limit = defaultSearchResults
+	} else if limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	root, err := fs.buildPath(rawPath)
+	if err != nil {
+		return nil, false, sftp.ErrSshFxNoSuchFile
+	}
+
+	if _, err := fs.backend().Stat(root); os.IsNotExist(err) {
+		return nil, false, sftp.ErrSshFxNoSuchFile
+	}
+
+	pattern = strings.ToLower(pattern)
+	deadline := time.Now().Add(searchTimeout)
+
+	var matches []searchMatch
+	var size int
+	var truncated bool
+	err = walk(fs.backend(), root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Directories that can't be read are skipped rather than failing the search.
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			truncated = true
+			return errSearchDone
+		}
+
+		// Anything hidden from listings is left out of searches too, along with everything
+		// inside of it.
+		if p != root && fs.isHidden(filepath.Dir(p), info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Only regular files are matched, and symlinks are never followed since they could
+		// point anywhere.
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, strings.ToLower(info.Name())); !ok {
+				return nil
+			}
+		}
+
+		if content != "" && !fs.fileContains(p, info, content) {
+			return nil
+		}
+
+		rel := fs.relativePath(p)
+		if len(matches) == limit || size+len(rel)+16 > maxPacketLength-1024 {
+			truncated = true
+			return errSearchDone
+		}
+
+		matches = append(matches, searchMatch{path: rel, size: info.Size(), modTime: info.ModTime()})
+		size += len(rel) + 16
+
+		return nil
+	})
+
+	if err != nil && err != errSearchDone {
+		fs.log().Errorw("failed to search directory", zap.String("source", root), zap.Error(err))
+		return nil, false, sftp.ErrSshFxFailure
+	}
+
+	return matches, truncated, nil
+}
+
+// Determines if the file contains the given text. The file is read in chunks, with the end of
+// each chunk kept around so that matches spanning two chunks are still found.
+func (fs *FileSystem) fileContains(p string, info os.FileInfo, content string) bool {
+	if info.Size() > maxSearchFileSize || int64(len(content)) > info.Size() {
+		return false
+	}
+
+	f, err := fs.openFile(p, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	needle := []byte(content)
+	buf := make([]byte, 32*1024+len(needle))
+	carry := 0
+	for {
+		n, err := f.Read(buf[carry:])
+		if n > 0 {
+			if bytes.Contains(buf[:carry+n], needle) {
+				return true
+			}
+
+			keep := len(needle) - 1
+			if keep > carry+n {
+				keep = carry + n
+			}
+			copy(buf, buf[carry+n-keep:carry+n])
+			carry = keep
+		}
+
+		if err == io.EOF {
+			return false
+		} else if err != nil {
+			fs.log().Debugw("failed to read file while searching", zap.String("source", p), zap.Error(err))
+			return false
+		}
+	}
+}
@@ -1,17 +1,13 @@
 package server
 
 import (
-	"bytes"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/json"
-	"encoding/pem"
+	"crypto/tls"
 	"fmt"
 	"github.com/buger/jsonparser"
 	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/geoip"
 	"github.com/pterodactyl/sftp-server/src/logger"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
@@ -20,16 +16,15 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 )
 
-type AuthenticationRequest struct {
-	User string `json:"username"`
-	Pass string `json:"password"`
-}
-
 type Settings struct {
 	BasePath         string
 	ReadOnly         bool
@@ -37,6 +32,207 @@ type Settings struct {
 	BindAddress      string
 	ServerDataFolder string
 	DisableDiskCheck bool
+	// The addresses to listen on, in "host:port" form. If any are set these are used instead of
+	// the bind address and port, which allows the server to listen on several IP addresses, or
+	// on both IPv4 and IPv6, at once.
+	BindAddresses []string
+	// The path of a unix socket to listen on in addition to the TCP addresses, along with the
+	// permissions applied to it. No socket is created if the path is empty.
+	UnixSocket     string
+	UnixSocketMode os.FileMode
+	// The maximum number of simultaneous connections allowed from a single IP address, and
+	// the maximum number of simultaneous sessions for a single Panel user. A value of zero
+	// disables the limit.
+	MaxConnectionsPerIP int
+	MaxSessionsPerUser  int
+	// The maximum number of simultaneous connections to the node as a whole, and how long a
+	// connection waits for one of the others to close when there are too many. Connections are
+	// rejected straight away if there is no queue timeout.
+	MaxSessions         int
+	SessionQueueTimeout time.Duration
+	// The amount of time a session can go without performing any SFTP operations before
+	// it is disconnected. A value of zero disables the timeout.
+	IdleTimeout time.Duration
+	// The longest a session can stay connected for before it is disconnected, with the client
+	// warned shortly beforehand. A value of zero means there is no limit.
+	MaxSessionLifetime time.Duration
+	// How long sessions are given to end by themselves when the server is restarted or shut down
+	// gracefully, before the ones still connected are disconnected. A value of zero waits for as
+	// long as it takes.
+	DrainTimeout time.Duration
+	// The maximum size in bytes of a single file written over SFTP or SCP. Zero means there
+	// is no limit.
+	MaxFileSize int64
+	// The maximum number of files and directories a server can contain. This can be overridden
+	// for an individual server by the Panel. Zero means there is no limit.
+	MaxFiles int64
+	// The maximum number of files a single session can have open at once, and the most memory
+	// in bytes that can be set aside for buffering them. Sessions that go over either limit are
+	// disconnected. Zero means there is no limit.
+	MaxOpenFiles     int64
+	MaxSessionMemory int64
+	// Paths, relative to the root of each server, that can never be modified or removed over
+	// SFTP. These are combined with any protected paths returned by the Panel for a server.
+	ProtectedPaths []string
+	// Patterns of file names that can never be uploaded, or have files renamed to them, such as
+	// "*.sh". These are combined with any blocked files returned by the Panel for a server.
+	BlockedFiles []string
+	// When enabled the files managed by the egg of each server are fetched from the Panel, and
+	// protected in the same way as protected paths.
+	ManagedFiles bool
+	// How often the permissions of each user are fetched from the Panel while they have a
+	// session open, so that changes apply without them reconnecting. Permissions are only
+	// fetched when the user logs in if this is zero.
+	PermissionsRefresh time.Duration
+	// When set the permissions of each user are looked up whenever they are checked, and kept
+	// for this long before being looked up again. This replaces refreshing them periodically.
+	PermissionsTTL time.Duration
+	// Which entries are hidden from directory listings, one of HideNone, HideInternal or
+	// HideDotfiles. This can be overridden for an individual server by the Panel.
+	HideFiles string
+	// The modes assigned to files and directories created over SFTP. These can be overridden
+	// for an individual server by the Panel.
+	FileMode      os.FileMode
+	DirectoryMode os.FileMode
+	// When enabled files are uploaded to a temporary file and only moved into place once the
+	// upload has completed.
+	AtomicUploads bool
+	// When enabled atomic uploads that are interrupted are kept so that the client can resume
+	// them, until they have gone without being written to for the maximum age.
+	PartialUploads      bool
+	PartialUploadMaxAge time.Duration
+	// The size of the buffers used to copy files during transfers, and the number of buffers
+	// that are read ahead of a client downloading a file. Read-ahead is disabled when zero.
+	TransferBufferSize int
+	ReadAhead          int
+	// When enabled deleted files are moved into a trash directory within the server and kept
+	// for the retention period before being permanently removed.
+	TrashEnabled   bool
+	TrashRetention time.Duration
+	// The number of previous versions of a file to keep when it is overwritten. Zero disables
+	// file versioning entirely.
+	MaxVersions int
+	// When enabled removing a directory that is not empty requires the "delete-files-recursive"
+	// permission, rather than just "delete-files".
+	RestrictRecursiveDelete bool
+	// When enabled downloading files requires the "download-files" permission, rather than
+	// being allowed for anyone with "edit-files".
+	RestrictDownloads bool
+	// The file commands, such as "Symlink" or "Remove", that can be used on the node. If any
+	// are allowed no others can be used, and any that are denied can't be used at all. These
+	// are checked before the permissions of the user.
+	AllowedOperations []string
+	DeniedOperations  []string
+	// How the names of new files containing control characters or ending with a space or a dot
+	// are handled, as one of the FileNames policies. Such names are allowed by default.
+	FileNamePolicy string
+	// The address the admin API listens on, and the token that must be provided to use it. The
+	// admin API is disabled if no address is set.
+	AdminAddress string
+	AdminToken   string
+	// The port the pprof debug endpoints are served on, bound to the loopback interface. These
+	// are disabled if no port is set.
+	PprofPort int
+	// The URLs that file events are sent to, and the secret used to sign each request. No
+	// events are sent if there are no URLs.
+	WebhookURLs   []string
+	WebhookSecret string
+	// When enabled changes made to files are sent to the Panel so that they show up in the
+	// activity log for the server.
+	PanelActivity bool
+	// How often the SFTP usage of each server is sent to the Panel. Usage is not sent when
+	// this is zero.
+	PanelStatisticsInterval time.Duration
+	// The OTLP HTTP endpoint traces are exported to, such as "http://localhost:4318/v1/traces".
+	// Tracing is disabled when this is empty. Paths are hashed before being added to spans when
+	// TracingHashPaths is set.
+	TracingEndpoint    string
+	TracingServiceName string
+	TracingHashPaths   bool
+	// The clamd socket uploaded files are scanned with, either a unix socket path or a TCP
+	// address, and the directory infected files are moved into. Uploads are not scanned if no
+	// address is set.
+	MalwareScanAddress string
+	MalwareQuarantine  string
+	// When enabled connections are expected to begin with a PROXY protocol header, which is
+	// used to determine the real address of the client. If any trusted networks are set only
+	// connections from them are expected to have the header.
+	ProxyProtocol        bool
+	ProxyTrustedNetworks []*net.IPNet
+	// The TLS configuration used for requests made to the Panel. The default configuration is
+	// used if this is not set.
+	PanelTLS *tls.Config
+	// When enabled a handshake is performed with the Panel to get a short lived session token
+	// that is used for requests in place of the node token, and the responses from the Panel
+	// are verified using the signing key it returns.
+	PanelHandshake bool
+	// Other Panels that logins are routed to in place of the Panel in the Daemon configuration,
+	// such as when the node is attached to more than one Panel installation. The first route
+	// matching a login is used, and logins that match none use the Panel in the configuration.
+	PanelRoutes []PanelRoute
+	// A file defining the users and servers that logins are validated against in place of the
+	// Panel, so that the server can be used without one.
+	StandaloneFile string
+	// The directory served to anyone logging in with the development credentials, which is only
+	// set in development mode. Every other login is rejected.
+	DevDirectory string
+	// How long after a successful login a user can keep logging in with the same credentials
+	// while the Panel is unreachable. Zero disables logging in while the Panel is down.
+	OfflineAuthTTL time.Duration
+	// The message shown to users before they log in, and the template for the message shown to
+	// them once they have logged in. Neither is shown if they are not set.
+	Banner string
+	MOTD   *template.Template
+	// The version string sent to clients when they connect, which defaults to the one used by
+	// the ssh package when empty.
+	ServerVersion string
+	// The ciphers, key exchanges and MAC algorithms that clients are allowed to use, in order
+	// of preference. The defaults of the ssh package are used for any that are not set.
+	Ciphers      []string
+	KeyExchanges []string
+	MACs         []string
+	// The number of bytes sent or received on a connection after which new keys are negotiated
+	// with the client. The default of the ssh package for the cipher is used when zero.
+	RekeyThreshold uint64
+	// How often the host key files are checked for changes, with the keys reloaded for new
+	// connections whenever they have been replaced. They are never checked when zero.
+	HostKeyWatchInterval time.Duration
+	// The certificate authorities trusted to sign certificates that users can log in with.
+	// Public key authentication is disabled when there are none.
+	CertificateAuthorities []ssh.PublicKey
+	// When enabled users can log in with a token generated by the Panel in place of their
+	// password, which is validated against the Panel separately.
+	LoginTokens bool
+	// A file that a line is written to for every failed login, in a format that tools such as
+	// fail2ban can parse.
+	AuthLogPath string
+	// A MaxMind DB file used to look up the country of each connection, and the countries that
+	// connections are accepted or rejected from. When any countries are allowed connections from
+	// every other country are rejected.
+	GeoIPDatabase  string
+	AllowCountries []string
+	DenyCountries  []string
+	// What happens to open sessions for a server once it is suspended. Sessions are either
+	// disconnected, made read-only, or left alone when this is "none".
+	SuspensionAction string
+	// Switches to running as the daemon user once the server has started, so that everything
+	// done while handling sessions is done without root.
+	DropPrivileges bool
+	// Restricts the files the process can access to the data directory and the few other
+	// paths it needs once the server has started, along with any extra paths listed here.
+	Sandbox           bool
+	SandboxReadPaths  []string
+	SandboxWritePaths []string
+	// The Sentry DSN and webhook URL that errors logged by the server are reported to, such as
+	// panics recovered while handling a request, along with the session they happened in.
+	// Webhook requests are signed with the webhook secret. Errors are not reported if neither
+	// is set. The environment is sent with every error so that groups of nodes can be told
+	// apart.
+	ErrorReportingDSN         string
+	ErrorReportingURL         string
+	ErrorReportingEnvironment string
+	// The version of the server, which is sent along with reported errors.
+	Version string
 }
 
 type SftpUser struct {
@@ -49,73 +245,864 @@ type Configuration struct {
 	Cache    *cache.Cache
 	Settings Settings
 	User     SftpUser
-}
 
-type AuthenticationResponse struct {
-	Server      string   `json:"server"`
-	Token       string   `json:"token"`
-	Permissions []string `json:"permissions"`
+	// The backend server files are stored on. If this is not set files are read from and
+	// written to the local disk.
+	Backend Backend
 }
 
 // Initalize the SFTP server and add a persistent listener to handle inbound SFTP connections.
+// This blocks for as long as the server is running.
 func (c Configuration) Initalize() error {
+	s := New(c)
+	if err := s.Start(); err != nil {
+		return err
+	}
+
+	s.Wait()
+
+	return nil
+}
+
+// A Server accepts inbound SSH connections and serves SFTP and SCP sessions for them. Servers
+// are created with New and can be started and stopped, which allows them to be embedded in
+// other processes rather than being run as a separate binary.
+type Server struct {
+	config     Configuration
+	addresses  []string
+	auth       Authenticator
+	newHandler HandlerFactory
+	limiter    *connectionLimiter
+	throttle   *loginThrottle
+	authLog    *authLog
+	geoip      *geoip.Reader
+	transfers  *transferStats
+	latency    *latencyMetrics
+	tracer     *tracer
+	webhooks   []*webhook
+	activity   *activityReporter
+	statistics *statisticsReporter
+	scanner    *malwareScanner
+	managed    *managedFiles
+	panels     *panelRouter
+	debug      *debugTargets
+	errors     *errorReporter
+
+	// The handshake used to authenticate requests to the Panel, which is nil unless it is
+	// enabled.
+	handshake *panelHandshake
+
+	// Looks up the current permissions of users with open sessions. This is nil unless the
+	// permissions are being refreshed.
+	permissions PermissionsProvider
+
+	// Looks up the permissions of users as they are checked. This is nil unless permissions
+	// are being looked up for every operation.
+	lookups *permissionsCache
+
+	// Lists the servers defined by the authenticator. This is nil when the servers are the
+	// directories within the data path, as they are for the Panel.
+	directories serverDirectoryProvider
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	sessions  map[string]*session
+	readOnly  map[string]bool
+	suspended map[string]bool
+	admin     *http.Server
+	pprof     *http.Server
+	wg        sync.WaitGroup
+	done      chan struct{}
+
+	// The listeners of the admin API and pprof endpoints, which are handed over along with the
+	// others when restarting.
+	adminListener net.Listener
+	pprofListener net.Listener
+
+	// The listeners handed over by the process that restarted into this one, by their names, and
+	// the pipe used to tell it once we have started. These are only used while starting.
+	inherited map[string][]net.Listener
+	ready     *os.File
+
+	// The configuration new connections are accepted using, which holds a *ssh.ServerConfig and
+	// is replaced whenever the host keys are reloaded, along with the keys it uses and the
+	// configuration it was created from before any keys were added.
+	sshConfig atomic.Value
+	sshBase   *ssh.ServerConfig
+	hostKeys  []ssh.Signer
+
+	// Closed once the server stops accepting connections, and once it has stopped completely.
+	draining chan struct{}
+	stopped  chan struct{}
+}
+
+// Creates the handler used to serve files for an authenticated connection.
+type HandlerFactory func(perm *ssh.Permissions) *FileSystem
+
+// An Option configures a Server when it is created.
+type Option func(s *Server)
+
+// Sets the address the server listens on. This defaults to the bind addresses in the server
+// settings.
+func WithAddress(address string) Option {
+	return WithAddresses(address)
+}
+
+// Sets the addresses the server listens on, all of which serve the same files using the same
+// host key.
+func WithAddresses(addresses ...string) Option {
+	return func(s *Server) {
+		s.addresses = addresses
+	}
+}
+
+// Sets the authenticator used to validate credentials. By default credentials are validated
+// against the Panel.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+// Sets the function used to create the file handler for each authenticated connection.
+func WithHandlerFactory(fn HandlerFactory) Option {
+	return func(s *Server) {
+		s.newHandler = fn
+	}
+}
+
+// Sets the logger used by the server. The logger is shared by the entire package, so this
+// replaces the logger for every server in the process.
+func WithLogger(l *zap.SugaredLogger) Option {
+	return func(s *Server) {
+		logger.Set(l)
+	}
+}
+
+// Creates a new server using the given configuration. The server does not begin accepting
+// connections until it is started.
+func New(c Configuration, opts ...Option) *Server {
+	if c.Cache == nil {
+		c.Cache = cache.New(5*time.Minute, 10*time.Minute)
+	}
+
+	var handshake *panelHandshake
+	if c.Settings.PanelHandshake {
+		handshake = newPanelHandshake(c.Data, c.Settings.PanelTLS)
+	}
+
+	s := &Server{
+		config:     c,
+		handshake:  handshake,
+		addresses:  c.Settings.BindAddresses,
+		auth:       newDefaultAuthenticator(c, handshake),
+		newHandler: c.createHandler,
+		limiter:    newConnectionLimiter(c.Settings.MaxConnectionsPerIP, c.Settings.MaxSessionsPerUser, c.Settings.MaxSessions),
+		throttle:   newLoginThrottle(),
+		transfers:  newTransferStats(),
+		latency:    newLatencyMetrics(),
+		debug:      newDebugTargets(),
+		conns:      make(map[net.Conn]struct{}),
+		sessions:   make(map[string]*session),
+		readOnly:   make(map[string]bool),
+		suspended:  make(map[string]bool),
+		done:       make(chan struct{}),
+		draining:   make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	if len(s.addresses) == 0 {
+		s.addresses = []string{net.JoinHostPort(c.Settings.BindAddress, strconv.Itoa(c.Settings.BindPort))}
+	}
+
+	for _, url := range c.Settings.WebhookURLs {
+		s.webhooks = append(s.webhooks, newWebhook(url, c.Settings.WebhookSecret))
+	}
+
+	if c.Settings.PanelActivity {
+		s.activity = newActivityReporter(c.Data, c.Settings.PanelTLS, handshake)
+	}
+
+	if c.Settings.ErrorReportingDSN != "" || c.Settings.ErrorReportingURL != "" {
+		s.errors = newErrorReporter(c.Settings)
+	}
+
+	if c.Settings.TracingEndpoint != "" {
+		s.tracer = newTracer(c.Settings.TracingEndpoint, c.Settings.TracingServiceName, c.Settings.TracingHashPaths)
+	}
+
+	if c.Settings.MalwareScanAddress != "" {
+		quarantine := c.Settings.MalwareQuarantine
+		if quarantine == "" {
+			quarantine = filepath.Join(c.dataPath(), ".quarantine")
+		}
+
+		s.scanner = newMalwareScanner(c.Settings.MalwareScanAddress, quarantine)
+	}
+
+	if c.Settings.PanelStatisticsInterval > 0 {
+		s.statistics = newStatisticsReporter(c.Data, c.Settings.PanelTLS, c.Settings.PanelStatisticsInterval, handshake)
+	}
+
+	if c.Settings.StandaloneFile != "" {
+		s.auth = newStandaloneAuthenticator(c.Settings.StandaloneFile)
+	}
+
+	if c.Settings.DevDirectory != "" {
+		s.auth = devAuthenticator{directory: c.Settings.DevDirectory}
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// Routes only apply to the Panel, and not to an authenticator passed in place of it.
+	if len(c.Settings.PanelRoutes) > 0 {
+		if p, ok := s.auth.(*PanelAuthenticator); ok {
+			s.panels = newPanelRouter(p, c.Settings.PanelRoutes, c)
+			s.auth = s.panels
+		} else {
+			logger.Get().Warnw("panel routes are configured but the authenticator is not the panel")
+		}
+	}
+
+	// Managed files are fetched using the authenticator before it is wrapped for offline logins,
+	// since there is nothing to fall back to for them in the credentials cache.
+	if c.Settings.ManagedFiles {
+		if p, ok := s.auth.(ManagedFilesProvider); ok {
+			s.managed = newManagedFiles(p)
+		} else {
+			logger.Get().Warnw("managed files are enabled but the authenticator does not support them")
+		}
+	}
+
+	if c.Settings.PermissionsTTL > 0 {
+		if p, ok := s.auth.(PermissionsProvider); ok {
+			s.lookups = newPermissionsCache(p, c.Settings.PermissionsTTL)
+		} else {
+			logger.Get().Warnw("permission lookups are enabled but the authenticator does not support them")
+		}
+	} else if c.Settings.PermissionsRefresh > 0 {
+		if p, ok := s.auth.(PermissionsProvider); ok {
+			s.permissions = p
+		} else {
+			logger.Get().Warnw("permission refreshing is enabled but the authenticator does not support it")
+		}
+	}
+
+	if p, ok := s.auth.(serverDirectoryProvider); ok {
+		s.directories = p
+	}
+
+	if c.Settings.OfflineAuthTTL > 0 {
+		s.auth = newOfflineAuthenticator(s.auth, c.Settings.OfflineAuthTTL)
+	}
+
+	return s
+}
+
+// Validates the password provided by a user when connecting, returning the name the user is
+// logged in as. This is the username of the connection, apart from token logins, which can use
+// any username and are known by the Panel user the token belongs to instead, so that the session
+// limit and throttling apply to them however many usernames are tried.
+func (s *Server) authenticate(conn ssh.ConnMetadata, pass []byte) (*AuthenticationResponse, string, error) {
+	// Don't bother asking the Panel about the credentials if this user is already at their
+	// session limit, the connection would just be dropped anyways.
+	if !s.limiter.userAllowed(conn.User(), 0) {
+		return nil, "", errors.New("too many active sessions for user")
+	}
+
+	if !s.waitForLogin(conn.User()) {
+		return nil, "", errors.New("server is stopping")
+	}
+
+	id := correlationID(conn)
+	auth := s.authenticatorFor(conn)
+
+	// Tokens generated by the Panel are validated separately from passwords, and identify the
+	// server themselves.
+	method, validate := "password", auth.Authenticate
+	if s.config.Settings.LoginTokens && isToken(pass) {
+		method, validate = "token", func(user string, pass []byte) (*AuthenticationResponse, error) {
+			return authenticateToken(auth, user, pass)
+		}
+	}
+
+	resp, err := validate(conn.User(), pass)
+	if err != nil {
+		logger.Get().Debugw("failed to validate credentials", zap.String("session", id), zap.String("user", conn.User()), zap.String("method", method), zap.Error(err))
+		s.loginFailed(conn, method, err)
+		return nil, "", errors.New("could not validate credentials")
+	}
+
+	user := conn.User()
+	if method == "token" {
+		if resp.User == "" {
+			logger.Get().Warnw("rejecting token login since the panel did not return the user it belongs to", zap.String("session", id), zap.String("user", conn.User()))
+			return nil, "", errors.New("could not validate credentials")
+		}
+
+		user = resp.User
+	}
+
+	if err := checkAllowedIPs(conn, resp); err != nil {
+		return nil, "", err
+	}
+
+	// The Panel can give the user a session limit of their own, which can only be checked once
+	// it has responded.
+	if !s.limiter.userAllowed(user, resp.MaxSessions) {
+		logger.Get().Infow("rejecting login due to per-user session limit",
+			zap.String("session", id),
+			zap.String("user", user),
+			zap.Int("limit", resp.MaxSessions),
+		)
+		return nil, "", errors.New("too many active sessions for user")
+	}
+
+	if !resp.TwoFactor {
+		s.throttle.success(user)
+	}
+
+	return resp, user, nil
+}
+
+// Rejects a login if the server does not allow access from the address of the connection, even
+// though the credentials were valid.
+func checkAllowedIPs(conn ssh.ConnMetadata, resp *AuthenticationResponse) error {
+	ip := remoteIP(conn.RemoteAddr())
+	if !resp.allowsIP(ip) {
+		logger.Get().Infow("rejecting login from an address the server does not allow",
+			zap.String("session", correlationID(conn)),
+			zap.String("user", conn.User()),
+			zap.String("ip", ip),
+		)
+		return errors.New("address is not allowed to access this server")
+	}
+
+	return nil
+}
+
+// Starts listening for connections. This returns once the listener has been registered, and
+// connections are then accepted in the background until the server is stopped.
+func (s *Server) Start() error {
 	serverConfig := &ssh.ServerConfig{
-		NoClientAuth: false,
-		MaxAuthTries: 6,
+		Config: ssh.Config{
+			Ciphers:        s.config.Settings.Ciphers,
+			KeyExchanges:   s.config.Settings.KeyExchanges,
+			MACs:           s.config.Settings.MACs,
+			RekeyThreshold: s.config.Settings.RekeyThreshold,
+		},
+		NoClientAuth:  false,
+		MaxAuthTries:  6,
+		ServerVersion: s.config.Settings.ServerVersion,
+		BannerCallback: func(conn ssh.ConnMetadata) string {
+			if a, ok := s.auth.(interface{ unavailable() bool }); ok && a.unavailable() {
+				return "Authentication is temporarily unavailable, please try again shortly.\n"
+			}
+
+			if !s.limiter.userAllowed(conn.User(), 0) {
+				return "Too many active sessions for this account, please close an existing session and try again.\n"
+			}
+
+			return withTrailingNewline(s.config.Settings.Banner)
+		},
 		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-			sp, err := c.validateCredentials(conn.User(), pass)
+			resp, user, err := s.authenticate(conn, pass)
 			if err != nil {
-				return nil, errors.New("could not validate credentials")
+				return nil, err
 			}
 
-			return sp, nil
+			// Users with two factor authentication enabled have to log in using
+			// keyboard-interactive authentication so that they can be asked for a code.
+			if resp.TwoFactor {
+				logger.Get().Debugw("rejecting password login for user with two factor authentication", zap.String("session", correlationID(conn)), zap.String("user", user))
+				return nil, errTwoFactorRequired
+			}
+
+			return resp.permissions(user), nil
 		},
+		KeyboardInteractiveCallback: s.keyboardInteractive,
+	}
+
+	if len(s.config.Settings.CertificateAuthorities) > 0 {
+		serverConfig.PublicKeyCallback = s.authenticateCertificate
+	}
+
+	c := s.config
+	keys, err := c.hostKeys()
+	if err != nil {
+		return err
 	}
 
-	if _, err := os.Stat(path.Join(c.Settings.BasePath, ".sftp/id_rsa")); os.IsNotExist(err) {
-		logger.Get().Info("creating new private key for server")
-		if err := c.generatePrivateKey(); err != nil {
+	// Add our private keys to the server configuration.
+	s.sshBase = serverConfig
+	s.setHostKeys(keys)
+
+	if err := s.loadGeoIP(); err != nil {
+		return err
+	}
+
+	if c.Backend == nil && beneathSupported() {
+		logger.Get().Info("confining file access to server directories using openat2")
+	}
+
+	if c.Settings.AuthLogPath != "" {
+		if s.authLog, err = openAuthLog(c.Settings.AuthLogPath); err != nil {
 			return err
 		}
-	} else if err != nil {
+	}
+
+	// When restarting, or when started by systemd socket activation, the sockets are already
+	// bound and passed to us, so there is nothing for us to listen on ourselves.
+	if s.inherited, s.ready, err = inheritListeners(); err != nil {
 		return err
 	}
 
-	privateBytes, err := ioutil.ReadFile(path.Join(c.Settings.BasePath, ".sftp/id_rsa"))
-	if err != nil {
+	listeners := s.inherited[handoverSFTP]
+	delete(s.inherited, handoverSFTP)
+
+	if len(listeners) > 0 {
+		for _, l := range listeners {
+			logger.Get().Infow("using listener handed over by the previous process", zap.String("address", l.Addr().String()))
+		}
+	} else if listeners, err = activationListeners(); err != nil {
+		return err
+	} else if len(listeners) > 0 {
+		for _, l := range listeners {
+			logger.Get().Infow("using listener passed by systemd", zap.String("address", l.Addr().String()))
+		}
+	} else if listeners, err = s.listen(); err != nil {
 		return err
 	}
 
-	private, err := ssh.ParsePrivateKey(privateBytes)
+	closeListeners := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	s.mu.Lock()
+	s.listeners = listeners
+	s.mu.Unlock()
+
+	if c.Settings.TrashEnabled {
+		go s.purgeTrash(s.done)
+	}
+
+	if c.Settings.AtomicUploads && c.Settings.PartialUploads {
+		go c.purgePartialUploads(s.done)
+	}
+
+	if c.Settings.SuspensionAction != SuspensionIgnore {
+		go s.watchSuspensions(s.done)
+	}
+
+	if c.Settings.HostKeyWatchInterval > 0 {
+		go s.watchHostKeys(c.Settings.HostKeyWatchInterval, s.done)
+	}
+
+	for _, w := range s.webhooks {
+		go w.run(s.done)
+	}
+
+	if s.scanner != nil {
+		go s.scanner.run(s.done)
+	}
+
+	if s.statistics != nil {
+		go s.statistics.run(s, s.done)
+	}
+
+	// Errors are reported until the server is stopped, at which point any that have not been
+	// sent yet are sent before Stop returns.
+	if s.errors != nil {
+		logger.SetReporter(s.errors.report)
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.errors.run(s.done)
+		}()
+	}
+
+	// Like activity, any spans that have not been exported yet are sent when the server is
+	// stopped.
+	if s.tracer != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.tracer.run(s.done)
+		}()
+	}
+
+	// Anything still waiting to be sent to the Panel is sent when the server is stopped, so
+	// wait for that to happen before Stop returns.
+	if s.activity != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.activity.run(s.done)
+		}()
+	}
+
+	if c.Settings.AdminAddress != "" {
+		if err := s.startAdmin(); err != nil {
+			closeListeners()
+			return err
+		}
+	}
+
+	if c.Settings.PprofPort > 0 {
+		if err := s.startPprof(); err != nil {
+			closeListeners()
+			return err
+		}
+	}
+
+	// Everything that needs root, like binding to the ports and reading the host key, has been
+	// done by now, so there is no reason to keep running as root while handling sessions.
+	if c.Settings.DropPrivileges {
+		if err := dropPrivileges(c.User); err != nil {
+			closeListeners()
+			return errors.Wrap(err, "failed to drop privileges")
+		}
+
+		logger.Get().Infow("dropped privileges to the daemon user", zap.Int("uid", c.User.Uid), zap.Int("gid", c.User.Gid))
+	}
+
+	if c.Settings.Sandbox {
+		read, write, execute := s.sandboxPaths()
+		if err := applySandbox(read, write, execute); err != nil {
+			closeListeners()
+			return errors.Wrap(err, "failed to apply sandbox")
+		}
+
+		logger.Get().Infow("restricted file access using landlock", zap.Strings("read", read), zap.Strings("write", write), zap.Strings("execute", execute))
+	}
+
+	for _, listener := range listeners {
+		s.wg.Add(1)
+		go s.serve(listener)
+	}
+
+	s.finishHandover()
+
+	return nil
+}
+
+// Stops the server, closing the listeners and disconnecting every active session. This waits
+// for all of the connections to be cleaned up before returning.
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return nil
+	default:
+		close(s.done)
+	}
+
+	var err error
+	for _, l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	if s.admin != nil {
+		s.admin.Close()
+	}
+
+	if s.pprof != nil {
+		s.pprof.Close()
+	}
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	s.authLog.Close()
+
+	if s.errors != nil {
+		logger.SetReporter(nil)
+	}
+
+	close(s.stopped)
+
+	return err
+}
+
+// Stops accepting new connections and waits for the active sessions to end by themselves before
+// stopping the server. Any sessions still connected once the timeout has passed are disconnected,
+// and a timeout of zero waits for as long as it takes.
+func (s *Server) Shutdown(timeout time.Duration) error {
+	s.mu.Lock()
+	select {
+	case <-s.draining:
+		s.mu.Unlock()
+		return nil
+	default:
+		close(s.draining)
+	}
+
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.listeners = nil
+
+	if s.admin != nil {
+		s.admin.Close()
+	}
+
+	if s.pprof != nil {
+		s.pprof.Close()
+	}
+	s.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		expired = t.C
+	}
+
+	logger.Get().Infow("waiting for active sessions to end before stopping", zap.Int("connections", s.activeConnections()), zap.Duration("timeout", timeout))
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for s.activeConnections() > 0 {
+		select {
+		case <-s.done:
+			return nil
+		case <-expired:
+			logger.Get().Warnw("disconnecting sessions that did not end before the drain timeout", zap.Int("connections", s.activeConnections()))
+			return s.Stop()
+		case <-ticker.C:
+		}
+	}
+
+	return s.Stop()
+}
+
+// Blocks until the server has been stopped.
+func (s *Server) Wait() {
+	<-s.stopped
+}
+
+// Returns the number of connections that are still open.
+func (s *Server) activeConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// Returns the address the server is listening on, or nil if it has not been started. If the
+// server is listening on more than one address this is the first of them.
+func (s *Server) Addr() net.Addr {
+	if addrs := s.Addrs(); len(addrs) > 0 {
+		return addrs[0]
+	}
+
+	return nil
+}
+
+// Returns all of the addresses the server is listening on.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var addrs []net.Addr
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+
+	return addrs
+}
+
+// Listens on all of the configured addresses, along with the unix socket if one is set.
+func (s *Server) listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	fail := func(err error) ([]net.Listener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+
+		return nil, err
+	}
+
+	for _, address := range s.addresses {
+		network := "tcp"
+		if len(s.addresses) > 1 {
+			network = listenNetwork(address)
+		}
+
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, listener)
+
+		logger.Get().Infow("server listener registered", zap.String("address", listener.Addr().String()))
+	}
+
+	if p := s.config.Settings.UnixSocket; p != "" {
+		listener, err := listenUnix(p, s.config.Settings.UnixSocketMode)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, listener)
+
+		logger.Get().Infow("server listener registered", zap.String("address", p))
+	}
+
+	return listeners, nil
+}
+
+// Listens on a unix socket at the given path, replacing any socket left behind by a previous
+// run of the server, and restricts access to it using the mode.
+func listenUnix(p string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(p); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s already exists and is not a socket", p)
+		}
+
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", p)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := os.Chmod(p, mode); err != nil {
+		listener.Close()
+		return nil, err
 	}
 
-	// Add our private key to the server configuration.
-	serverConfig.AddHostKey(private)
+	return listener, nil
+}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.Settings.BindAddress, c.Settings.BindPort))
+// Returns the network to listen on for an address when listening on several of them. Wildcard
+// addresses would otherwise accept connections for both IPv4 and IPv6, which prevents listening
+// on "0.0.0.0" and "[::]" at the same time, so IP addresses are only bound for their own family.
+func listenNetwork(address string) string {
+	host, _, err := net.SplitHostPort(address)
 	if err != nil {
-		return err
+		return "tcp"
 	}
 
-	logger.Get().Infow("server listener registered", zap.String("address", listener.Addr().String()))
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return "tcp6"
+		}
+
+		return "tcp4"
+	}
+
+	return "tcp"
+}
+
+// Accepts connections from the listener until the server is stopped.
+func (s *Server) serve(listener net.Listener) {
+	defer s.wg.Done()
 
 	for {
-		conn, _ := listener.Accept()
-		if conn != nil {
-			go c.AcceptInboundConnection(conn, serverConfig)
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			case <-s.draining:
+				return
+			default:
+			}
+
+			logger.Get().Warnw("failed to accept connection", zap.Error(err))
+			time.Sleep(100 * time.Millisecond)
+			continue
 		}
-	}
 
-	return nil
+		// The server could have been stopped while we were accepting this connection, in which
+		// case it will never be closed by Stop, so don't bother handling it.
+		s.mu.Lock()
+		select {
+		case <-s.done:
+			s.mu.Unlock()
+			conn.Close()
+			return
+		default:
+			s.conns[conn] = struct{}{}
+		}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+
+			s.AcceptInboundConnection(conn, s.currentSSHConfig())
+		}()
+	}
 }
 
 // Handles an inbound connection to the instance and determines if we should serve the request
 // or not.
-func (c Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig) {
+func (s *Server) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig) {
 	defer conn.Close()
 
+	// Anything that panics while handling the connection, including authenticating it, only
+	// disconnects it rather than every other connection along with it.
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(logger.Get().With(zap.String("ip", remoteIP(conn.RemoteAddr()))), r)
+		}
+	}()
+
+	if s.expectsProxyHeader(conn) {
+		pconn, err := readProxyHeader(conn)
+		if err != nil {
+			logger.Get().Warnw("failed to read proxy protocol header",
+				zap.String("address", conn.RemoteAddr().String()),
+				zap.Error(err),
+			)
+			return
+		}
+		conn = pconn
+	}
+
+	ip := remoteIP(conn.RemoteAddr())
+	if country, ok := s.countryAllowed(ip); !ok {
+		logger.Get().Debugw("rejecting connection from a country that is not allowed", zap.String("ip", ip), zap.String("country", country))
+		return
+	}
+
+	if !s.limiter.acquireIP(ip) {
+		logger.Get().Infow("rejecting connection due to per-ip connection limit", zap.String("ip", ip))
+		rejectConnection(conn, config, "Too many connections from your IP address, please try again later.\n")
+		return
+	}
+	defer s.limiter.releaseIP(ip)
+
+	if !s.limiter.acquireSlot(s.config.Settings.SessionQueueTimeout, s.done) {
+		logger.Get().Infow("rejecting connection due to maximum session limit", zap.String("ip", ip))
+		rejectConnection(conn, config, "The server is at capacity, please try again later.\n")
+		return
+	}
+	defer s.limiter.releaseSlot()
+
 	// Before beginning a handshake must be performed on the incoming net.Conn
 	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
 	if err != nil {
@@ -124,11 +1111,70 @@ func (c Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Server
 	}
 	defer sconn.Close()
 
-	logger.Get().Debugw("accepted inbound connection",
-		zap.String("ip", conn.RemoteAddr().String()),
-		zap.String("user", sconn.Permissions.Extensions["user"]),
-		zap.String("uuid", sconn.Permissions.Extensions["uuid"]),
-	)
+	// The user was allowed through during authentication, but another session could have
+	// been opened for them in the meantime, so check again now that we're registering it.
+	user := loginUser(sconn)
+	maxSessions, _ := strconv.Atoi(sconn.Permissions.Extensions["max_sessions"])
+	if !s.limiter.acquireUser(user, maxSessions) {
+		logger.Get().Infow("rejecting connection due to per-user session limit",
+			zap.String("ip", ip),
+			zap.String("user", user),
+		)
+		return
+	}
+	defer s.limiter.releaseUser(user)
+
+	sess := newSession(sconn)
+	sess.log = logger.WithDebug(sess.log, func() bool {
+		return s.debug.matches(sess)
+	})
+	sess.transfer = s.transfers.forServer(sess.server)
+	sess.budget = newSessionBudget(s.config.Settings.MaxOpenFiles, s.config.Settings.MaxSessionMemory)
+	atomic.AddInt64(&sess.transfer.sessions, 1)
+
+	// A server could have been suspended without the Panel knowing about it yet, so check with
+	// the Daemon before letting the session continue.
+	if action := s.config.Settings.SuspensionAction; action != SuspensionIgnore {
+		if s.updateSuspension(sess.server) && action != SuspensionReadOnly {
+			sess.log.Infow("rejecting connection since the server is suspended")
+			return
+		}
+	}
+
+	s.addSession(sess)
+	defer s.removeSession(sess)
+
+	sess.startSpan(s.tracer)
+	defer sess.finishSpan()
+
+	if s.config.Settings.IdleTimeout > 0 {
+		done := make(chan struct{})
+		defer close(done)
+
+		go watchIdle(sess, s.config.Settings.IdleTimeout, done)
+	}
+
+	if s.config.Settings.MaxSessionLifetime > 0 {
+		done := make(chan struct{})
+		defer close(done)
+
+		go watchLifetime(sess, s.config.Settings.MaxSessionLifetime, done)
+	}
+
+	if s.permissions != nil && sess.userUUID != "" {
+		done := make(chan struct{})
+		defer close(done)
+
+		go s.refreshPermissions(sess, s.config.Settings.PermissionsRefresh, done)
+	}
+
+	// The permissions returned for the login were only just fetched, so there is no need to
+	// look them up again for the first operations of the session.
+	if s.lookups != nil && sess.userUUID != "" {
+		s.lookups.set(sess.userUUID, sess.server, strings.Split(sess.conn.Permissions.Extensions["permissions"], ","))
+	}
+
+	sess.log.Debugw("accepted inbound connection", zap.String("address", conn.RemoteAddr().String()))
 
 	go ssh.DiscardRequests(reqs)
 
@@ -136,163 +1182,288 @@ func (c Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Server
 		// If its not a session channel we just move on because its not something we
 		// know how to handle at this point.
 		if newChannel.ChannelType() != "session" {
-			logger.Get().Debugw("received an unknown channel type", zap.String("channel", newChannel.ChannelType()))
+			sess.log.Debugw("received an unknown channel type", zap.String("channel", newChannel.ChannelType()))
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 			continue
 		}
 
 		channel, requests, err := newChannel.Accept()
 		if err != nil {
-			logger.Get().Warnw("could not accept a channel", zap.Error(err))
+			sess.log.Warnw("could not accept a channel", zap.Error(err))
 			continue
 		}
 
-		// Channels have a type that is dependent on the protocol. For SFTP this is "subsystem"
-		// with a payload that (should) be "sftp". Discard anything else we receive ("pty", "shell", etc)
-		go func(in <-chan *ssh.Request) {
-			for req := range in {
-				ok := false
+		// Configure the user's home folder for the rest of the request cycle.
+		if sconn.Permissions.Extensions["uuid"] == "" {
+			sess.log.Errorw("got a server connection with no uuid")
+			channel.Close()
+			continue
+		}
 
-				switch req.Type {
-				case "subsystem":
-					if string(req.Payload[4:]) == "sftp" {
-						ok = true
-					}
-				}
+		s.handleChannel(sess, trackedChannel{Channel: channel, session: sess}, requests)
+	}
+}
 
-				req.Reply(ok, nil)
-			}
-		}(requests)
+// Handles the requests made on a session channel. Channels have a type that is dependent on
+// the protocol. For SFTP this is "subsystem" with a payload that (should) be "sftp", and for
+// SCP it is "exec" with a payload of the scp command being run. A "shell" is only accepted to
+// show the message of the day to interactive clients, and anything else we receive ("pty",
+// "env", etc) is discarded.
+func (s *Server) handleChannel(sess *session, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	defer sess.addChannel(channel)()
 
-		// Configure the user's home folder for the rest of the request cycle.
-		if sconn.Permissions.Extensions["uuid"] == "" {
-			logger.Get().Errorw("got a server connection with no uuid")
+	for req := range requests {
+		if req.Type == "shell" && s.config.Settings.MOTD != nil {
+			req.Reply(true, nil)
+			go discardChannelRequests(requests)
+
+			s.serveShell(sess, channel)
+			return
+		}
+
+		name, _, err := unmarshalString(req.Payload)
+		if err != nil {
+			req.Reply(false, nil)
 			continue
 		}
 
-		// Create a new handler for the currently logged in user's server.
-		fs := c.createHandler(sconn.Permissions)
+		switch req.Type {
+		case "subsystem":
+			if name != "sftp" {
+				break
+			}
+
+			req.Reply(true, nil)
+			go discardChannelRequests(requests)
+
+			s.serveSFTP(sess, channel)
+			return
+		case "exec":
+			cmd, ok := parseSCPCommand(name)
+			if !ok {
+				break
+			}
 
-		// Create the server instance for the channel using the filesystem we created above.
-		server := sftp.NewRequestServer(channel, fs)
+			req.Reply(true, nil)
+			go discardChannelRequests(requests)
 
-		if err := server.Serve(); err == io.EOF {
-			server.Close()
-		} else if err != nil {
-			logger.Get().Errorw("sftp server closed with error", zap.Error(err))
+			status := cmd.run(channel, s.sessionHandler(sess))
+			channel.SendRequest("exit-status", false, marshalUint32(nil, status))
+			return
 		}
+
+		req.Reply(false, nil)
 	}
 }
 
-// Creates a new SFTP handler for a given server. The directory argument should
-// be the base directory for a server. All actions done on the server will be
-// relative to that directory, and the user will not be able to escape out of it.
-func (c Configuration) createHandler(perm *ssh.Permissions) sftp.Handlers {
-	base, err := jsonparser.GetString(c.Data, "sftp", "path")
-	if err != nil || base == "" {
-		base = "/srv/daemon-data"
+// Creates the file handler for a session, which logs everything with the session details and
+// sends events for any changes made to files during the session.
+func (s *Server) sessionHandler(sess *session) *FileSystem {
+	fs := s.newHandler(sess.conn.Permissions)
+	fs.logger = sess.log
+	fs.events = func(e FileEvent) {
+		s.publish(sess, e)
+	}
+	fs.readOnly = func() bool {
+		return s.serverReadOnly(fs.UUID)
+	}
+	fs.permissions = sess.refreshedPermissions
+	if s.lookups != nil && sess.userUUID != "" {
+		fs.permissions = func() ([]string, bool) {
+			return s.lookupPermissions(sess)
+		}
+	}
+	fs.operations = func() {
+		if sess.transfer != nil {
+			atomic.AddInt64(&sess.transfer.operations, 1)
+		}
 	}
+	fs.scanner = s.scanner
+	fs.ProtectedPaths = append(fs.ProtectedPaths, normalizeProtectedPaths(s.managed.forServer(sess, fs.UUID))...)
+	fs.primeDiskUsage()
+
+	return fs
+}
 
-	p := FileSystem{
-		ServerConfig:     path.Join(c.Settings.ServerDataFolder, perm.Extensions["uuid"], "server.json"),
-		Directory:        path.Join(base, perm.Extensions["uuid"]),
-		UUID:             perm.Extensions["uuid"],
-		Permissions:      strings.Split(perm.Extensions["permissions"], ","),
-		ReadOnly:         c.Settings.ReadOnly,
-		Cache:            c.Cache,
-		DisableDiskCheck: c.Settings.DisableDiskCheck,
-		User:             c.User,
+// Rejects any additional requests made on a channel once it is already being served.
+func discardChannelRequests(in <-chan *ssh.Request) {
+	for req := range in {
+		req.Reply(false, nil)
 	}
+}
 
-	return sftp.Handlers{
-		FileGet:  p,
-		FilePut:  p,
-		FileCmd:  p,
-		FileList: p,
+// Serves the SFTP subsystem for a channel until the client disconnects.
+func (s *Server) serveSFTP(sess *session, channel ssh.Channel) {
+	// Create a new handler for the currently logged in user's server.
+	fs := s.sessionHandler(sess)
+	s.writeMOTD(sess, fs, channel.Stderr())
+	timed := timedHandlers{fs: fs, sess: sess, metrics: s.latency}
+	budgeted := budgetedHandlers{timed}
+	handlers := sftp.Handlers{
+		FileGet:  budgeted,
+		FilePut:  budgeted,
+		FileCmd:  timed,
+		FileList: timed,
+	}
+
+	// Create the server instance for the channel using the filesystem we created above. Any
+	// extended requests are handled by the extended channel before reaching the server.
+	server := sftp.NewRequestServer(newExtendedChannel(channel, fs), handlers)
+
+	if err := server.Serve(); err == io.EOF {
+		server.Close()
+	} else if err != nil {
+		sess.log.Errorw("sftp server closed with error", zap.Error(err))
 	}
 }
 
-// Validates a set of credentials for a SFTP login aganist Pterodactyl Panel and returns
-// the server's UUID if the credentials were valid.
-func (c Configuration) validateCredentials(user string, pass []byte) (*ssh.Permissions, error) {
-	data, _ := json.Marshal(AuthenticationRequest{User: user, Pass: string(pass)})
+// Performs a handshake with a connection that we have already decided to reject so that the
+// client is shown a banner explaining why, rather than having the socket closed on them with
+// no context. Every authentication attempt made on the connection will fail.
+func rejectConnection(conn net.Conn, config *ssh.ServerConfig, message string) {
+	rc := *config
+	rc.MaxAuthTries = 1
+	rc.BannerCallback = func(_ ssh.ConnMetadata) string {
+		return message
+	}
+	rc.PasswordCallback = func(_ ssh.ConnMetadata, _ []byte) (*ssh.Permissions, error) {
+		return nil, errors.New("connection rejected")
+	}
+	rc.KeyboardInteractiveCallback = nil
+	rc.PublicKeyCallback = nil
 
-	url, err := jsonparser.GetString(c.Data, "remote", "base")
-	if err != nil {
-		return nil, err
+	if sconn, _, _, err := ssh.NewServerConn(conn, &rc); err == nil {
+		sconn.Close()
 	}
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/remote/sftp", url), bytes.NewBuffer(data))
-	if err != nil {
-		return nil, err
+// Creates a new SFTP handler for a given server. The directory argument should
+// be the base directory for a server. All actions done on the server will be
+// relative to that directory, and the user will not be able to escape out of it.
+func (c Configuration) createHandler(perm *ssh.Permissions) *FileSystem {
+	directory := perm.Extensions["directory"]
+	if directory == "" {
+		directory = filepath.Join(c.dataPath(), perm.Extensions["uuid"])
 	}
 
-	token, err := jsonparser.GetString(c.Data, "keys", "[0]")
-	if err != nil {
-		return nil, err
+	return &FileSystem{
+		ServerConfig:            c.serverConfigPath(perm.Extensions["uuid"]),
+		Directory:               directory,
+		Backend:                 c.Backend,
+		UUID:                    perm.Extensions["uuid"],
+		Permissions:             strings.Split(perm.Extensions["permissions"], ","),
+		ProtectedPaths:          normalizeProtectedPaths(append(strings.Split(perm.Extensions["protected_paths"], "\n"), c.Settings.ProtectedPaths...)),
+		HideFiles:               parseHideFiles(perm.Extensions["hide_files"], c.Settings.HideFiles),
+		BlockedFiles:            normalizeBlockedFiles(append(strings.Split(perm.Extensions["blocked_files"], "\n"), c.Settings.BlockedFiles...)),
+		FileMode:                ParseFileMode(perm.Extensions["file_mode"], c.Settings.FileMode),
+		DirectoryMode:           ParseFileMode(perm.Extensions["directory_mode"], c.Settings.DirectoryMode),
+		AtomicUploads:           c.Settings.AtomicUploads,
+		PartialUploads:          c.Settings.PartialUploads,
+		TransferBufferSize:      c.Settings.TransferBufferSize,
+		ReadAhead:               c.Settings.ReadAhead,
+		Trash:                   c.Settings.TrashEnabled,
+		MaxVersions:             c.Settings.MaxVersions,
+		MaxFileSize:             c.Settings.MaxFileSize,
+		MaxFiles:                parseFileLimit(perm.Extensions["file_limit"], c.Settings.MaxFiles),
+		RestrictRecursiveDelete: c.Settings.RestrictRecursiveDelete,
+		RestrictDownloads:       c.Settings.RestrictDownloads,
+		AllowedOperations:       c.Settings.AllowedOperations,
+		DeniedOperations:        c.Settings.DeniedOperations,
+		FileNamePolicy:          c.Settings.FileNamePolicy,
+		ReadOnly:                c.Settings.ReadOnly || perm.Extensions["read_only"] == "true",
+		Cache:                   c.Cache,
+		DisableDiskCheck:        c.Settings.DisableDiskCheck,
+		User:                    c.serverUser(perm),
 	}
+}
 
-	req.Header.Set("Accept", "application/vnd.pterodactyl.v1+json")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+// Returns the path of the configuration file the node keeps for a server.
+func (c Configuration) serverConfigPath(uuid string) string {
+	return filepath.Join(c.Settings.ServerDataFolder, uuid, "server.json")
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// Returns the directory containing the data directories for all of the servers on the node.
+func (c Configuration) dataPath() string {
+	base, err := jsonparser.GetString(c.Data, "sftp", "path")
+	if err != nil || base == "" {
+		base = "/srv/daemon-data"
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		s, _ := ioutil.ReadAll(resp.Body)
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, fmt.Errorf("bad credentials provided: %s", string(s))
-		}
+	return base
+}
+
+// Returns the directory of every server on the node by its UUID. These are the directories within
+// the data path, unless the authenticator defines the servers itself.
+func (s *Server) serverDirectories() (map[string]string, error) {
+	base := s.config.dataPath()
+	directories := make(map[string]string)
+
+	if s.directories != nil {
+		for uuid, directory := range s.directories.serverDirectories() {
+			if directory == "" {
+				directory = filepath.Join(base, uuid)
+			}
 
-		if resp.StatusCode == http.StatusBadRequest {
-			return nil, fmt.Errorf("server in bad state, SFTP denied, %s", string(s))
+			directories[uuid] = directory
 		}
 
-		return nil, fmt.Errorf("error response from server: %s", string(s))
+		return directories, nil
 	}
 
-	j := &AuthenticationResponse{}
-	json.NewDecoder(resp.Body).Decode(j)
+	servers, err := ioutil.ReadDir(base)
+	if err != nil {
+		return nil, err
+	}
 
-	p := &ssh.Permissions{}
-	p.Extensions = make(map[string]string)
-	p.Extensions["uuid"] = j.Server
-	p.Extensions["user"] = user
-	p.Extensions["permissions"] = strings.Join(j.Permissions, ",")
+	for _, e := range servers {
+		if e.IsDir() {
+			directories[e.Name()] = filepath.Join(base, e.Name())
+		}
+	}
 
-	return p, nil
+	return directories, nil
 }
 
-// Generates a private key that will be used by the SFTP server.
-func (c Configuration) generatePrivateKey() error {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return err
+// Parses an octal file mode string (such as "0644"), returning the default mode if the string
+// is empty or not a valid mode.
+func ParseFileMode(mode string, def os.FileMode) os.FileMode {
+	if mode == "" {
+		return def
 	}
 
-	if err := os.MkdirAll(path.Join(c.Settings.BasePath, ".sftp"), 0755); err != nil {
-		return err
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		logger.Get().Warnw("ignoring invalid file mode", zap.String("mode", mode))
+		return def
 	}
 
-	o, err := os.OpenFile(path.Join(c.Settings.BasePath, ".sftp/id_rsa"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
+	return os.FileMode(m)
+}
+
+// Parses the version string the server identifies itself with when a client connects. The
+// "SSH-2.0-" prefix required by the protocol is added if it is missing, so that just the name
+// of the software can be configured. An empty string keeps the default of the ssh package.
+func ParseServerVersion(version string) (string, error) {
+	if version == "" {
+		return "", nil
 	}
-	defer o.Close()
 
-	pkey := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	if !strings.HasPrefix(version, "SSH-2.0-") {
+		version = "SSH-2.0-" + version
 	}
 
-	if err := pem.Encode(o, pkey); err != nil {
-		return err
+	// The identification line, including the trailing CRLF, can be at most 255 characters and
+	// may only contain printable ASCII characters.
+	if len(version) > 253 {
+		return "", errors.New("server version is longer than 253 characters")
 	}
 
-	return nil
+	for _, r := range version {
+		if r < 0x20 || r > 0x7e {
+			return "", errors.New("server version may only contain printable ASCII characters")
+		}
+	}
+
+	return version, nil
 }
@@ -0,0 +1,149 @@
+// Package servertest runs an SFTP server within the test process, listening on an ephemeral port
+// of the loopback interface, so that tests can connect to it with a real SFTP client and cover
+// everything from the login to the handlers.
+package servertest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/server"
+	"golang.org/x/crypto/ssh"
+)
+
+// A server started for a test, which is stopped once the test ends.
+type Server struct {
+	*server.Server
+
+	// The directory the host keys, server files and server configurations are kept within.
+	Dir string
+
+	hostKey ssh.PublicKey
+}
+
+// Returns a configuration for a server that keeps everything within the directory, using the
+// same defaults as the Daemon configuration would. The files for each server are kept in the
+// "data" directory, and the configurations the Daemon writes for them in "servers".
+func Configuration(dir string) server.Configuration {
+	data, _ := json.Marshal(map[string]interface{}{
+		"sftp": map[string]string{"path": filepath.Join(dir, "data")},
+	})
+
+	return server.Configuration{
+		Data: data,
+		User: server.SftpUser{Uid: os.Getuid(), Gid: os.Getgid()},
+		Settings: server.Settings{
+			BasePath:         dir,
+			ServerDataFolder: filepath.Join(dir, "servers"),
+			DisableDiskCheck: true,
+			FileMode:         0644,
+			DirectoryMode:    0755,
+			SuspensionAction: server.SuspensionDisconnect,
+		},
+	}
+}
+
+// Starts a server that validates logins using the authenticator, with everything it keeps in
+// a temporary directory for the test.
+func NewServer(t testing.TB, auth server.Authenticator) *Server {
+	t.Helper()
+
+	return Start(t, Configuration(t.TempDir()), server.WithAuthenticator(auth))
+}
+
+// Starts a server using the configuration, listening on an ephemeral port of the loopback
+// interface unless the options set another address. An ed25519 host key is generated if the
+// server doesn't already have one, since it is the quickest to create.
+func Start(t testing.TB, c server.Configuration, opts ...server.Option) *Server {
+	t.Helper()
+
+	keyPath := filepath.Join(c.Settings.BasePath, ".sftp", "id_ed25519")
+	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		if _, err := server.GenerateHostKey(c.Settings.BasePath, "ed25519"); err != nil {
+			t.Fatalf("failed to generate host key: %s", err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		t.Fatalf("failed to read host key: %s", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		t.Fatalf("failed to parse host key: %s", err)
+	}
+
+	s := &Server{
+		Server:  server.New(c, append([]server.Option{server.WithAddress("127.0.0.1:0")}, opts...)...),
+		Dir:     c.Settings.BasePath,
+		hostKey: signer.PublicKey(),
+	}
+
+	if err := s.Server.Start(); err != nil {
+		t.Fatalf("failed to start server: %s", err)
+	}
+	t.Cleanup(func() { s.Stop() })
+
+	return s
+}
+
+// Returns the directory the files for a server are kept in, as used by the default handler
+// when the authenticator doesn't set another.
+func (s *Server) ServerDirectory(uuid string) string {
+	return filepath.Join(s.Dir, "data", uuid)
+}
+
+// An SFTP client connected to the server.
+type Client struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+// Closes the SFTP session along with the connection it was opened on.
+func (c *Client) Close() error {
+	err := c.Client.Close()
+	c.conn.Close()
+
+	return err
+}
+
+// Connects to the server and logs in with the username and password, returning an error if the
+// login is rejected. The host key of the server is verified.
+func (s *Server) Dial(user string, password string) (*Client, error) {
+	conn, err := ssh.Dial("tcp", s.Addr().String(), &ssh.ClientConfig{
+		User:              user,
+		Auth:              []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback:   ssh.FixedHostKey(s.hostKey),
+		HostKeyAlgorithms: []string{s.hostKey.Type()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Client{Client: client, conn: conn}, nil
+}
+
+// Connects to the server as the user, failing the test if the login is rejected. The
+// connection is closed once the test ends.
+func (s *Server) Client(t testing.TB, user string, password string) *Client {
+	t.Helper()
+
+	client, err := s.Dial(user, password)
+	if err != nil {
+		t.Fatalf("failed to log in as %s: %s", user, err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// A session is a single authenticated connection to the server, which may have any number of
+// SFTP or SCP channels open on it.
+type session struct {
+	id        string
+	user      string
+	userUUID  string
+	server    string
+	ip        string
+	connected time.Time
+	conn      *ssh.ServerConn
+	activity  *activityTracker
+
+	// The logger for everything that happens during this session, which includes the session
+	// ID along with who the session belongs to in every entry.
+	log *zap.SugaredLogger
+
+	// The number of bytes received from and sent to the client across all of the channels
+	// for this session. These must be accessed atomically.
+	received int64
+	sent     int64
+
+	// The totals for the server the session belongs to, which are added to along with the
+	// totals for the session.
+	transfer *serverTransfer
+
+	// The limits on the files the session can have open at once, which is nil if there are
+	// none.
+	budget *sessionBudget
+
+	// The permissions of the user once they have been refreshed from the Panel, which replace
+	// those returned when they logged in. This holds a []string, and is empty until the
+	// permissions are first refreshed.
+	permissions atomic.Value
+
+	// The stderr streams of the channels currently open on the session, which messages for the
+	// user are written to.
+	mu     sync.Mutex
+	stderr map[io.Writer]struct{}
+
+	// The root span for the session when tracing is enabled, which every request made during
+	// the session is a child of.
+	span *span
+}
+
+// The details of a session that are exposed through the admin API.
+type sessionInfo struct {
+	ID            string    `json:"id"`
+	User          string    `json:"user"`
+	Server        string    `json:"server"`
+	IP            string    `json:"ip"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesSent     int64     `json:"bytes_sent"`
+	// The last time anything was received from the client, which includes every chunk of a
+	// transfer, so a session with a large transfer in progress keeps this up to date.
+	LastActivityAt time.Time `json:"last_activity_at"`
+}
+
+// Creates the session for a connection. The ID of the session is the correlation ID that was
+// used while the user was logging in, so the attempts to log in can be found from the session.
+func newSession(sconn *ssh.ServerConn) *session {
+	s := &session{
+		id:        correlationID(sconn),
+		user:      loginUser(sconn),
+		userUUID:  sconn.Permissions.Extensions["user_uuid"],
+		server:    sconn.Permissions.Extensions["uuid"],
+		ip:        remoteIP(sconn.RemoteAddr()),
+		connected: time.Now(),
+		conn:      sconn,
+		activity:  newActivityTracker(),
+		stderr:    make(map[io.Writer]struct{}),
+	}
+
+	s.log = logger.Get().With(
+		zap.String("session", s.id),
+		zap.String("user", s.user),
+		zap.String("server", s.server),
+		zap.String("ip", s.ip),
+	)
+
+	return s
+}
+
+// Returns the name the user of a connection logged in as, which is only different from the
+// username of the connection for token logins.
+func loginUser(sconn *ssh.ServerConn) string {
+	if user := sconn.Permissions.Extensions["user"]; user != "" {
+		return user
+	}
+
+	return sconn.User()
+}
+
+// Returns a snapshot of the session details.
+func (s *session) info() sessionInfo {
+	return sessionInfo{
+		ID:             s.id,
+		User:           s.user,
+		Server:         s.server,
+		IP:             s.ip,
+		ConnectedAt:    s.connected,
+		BytesReceived:  atomic.LoadInt64(&s.received),
+		BytesSent:      atomic.LoadInt64(&s.sent),
+		LastActivityAt: s.activity.lastActive(),
+	}
+}
+
+// Registers a channel as being open on the session, returning a function that removes it once
+// the channel is closed.
+func (s *session) addChannel(channel ssh.Channel) func() {
+	w := channel.Stderr()
+
+	s.mu.Lock()
+	s.stderr[w] = struct{}{}
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.stderr, w)
+		s.mu.Unlock()
+	}
+}
+
+// Registers a session as being active on the server.
+func (s *Server) addSession(sess *session) {
+	s.mu.Lock()
+	s.sessions[sess.id] = sess
+	s.mu.Unlock()
+}
+
+// Removes a session from the server once it has disconnected.
+func (s *Server) removeSession(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess.id)
+	s.mu.Unlock()
+}
+
+// Returns the details of all of the sessions currently active on the server.
+func (s *Server) activeSessions() []sessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]sessionInfo, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess.info())
+	}
+
+	return sessions
+}
+
+// Disconnects the session with the given ID, returning false if there is no such session.
+func (s *Server) terminateSession(id string) bool {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	sess.conn.Close()
+
+	return true
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// The types of file that are never read, written or listed over SFTP. None of these can be
+// created by a user, but they can end up in the server directory from an archive extracted by
+// the Daemon or from the game server itself, and opening one can block the session forever or
+// reach a device on the node.
+const specialFileModes = os.ModeNamedPipe | os.ModeSocket | os.ModeDevice | os.ModeCharDevice
+
+var errSpecialFile = newStatusError(fxPermissionDenied, "special files cannot be accessed")
+
+// Determines if the file is a FIFO, socket or device file.
+func isSpecialFile(info os.FileInfo) bool {
+	return info.Mode()&specialFileModes != 0
+}
+
+// Opens a file with the backend, refusing to open special files. The type of the file is
+// checked before it is opened, since just opening a device can have side effects, and again
+// once it is open in case it was replaced in between. Files are opened without blocking so
+// that a FIFO swapped in at the last moment can't hang the session waiting for the other end.
+func (fs *FileSystem) openFile(p string, flag int, perm os.FileMode) (File, error) {
+	if info, err := fs.backend().Stat(p); err == nil && isSpecialFile(info) {
+		fs.log().Warnw("refusing to open special file", zap.String("source", p), zap.Stringer("mode", info.Mode()))
+		return nil, errSpecialFile
+	}
+
+	f, err := fs.backend().OpenFile(p, flag|openNonblock, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if isSpecialFile(info) {
+		f.Close()
+		fs.log().Warnw("refusing to open special file", zap.String("source", p), zap.Stringer("mode", info.Mode()))
+		return nil, errSpecialFile
+	}
+
+	return f, nil
+}
+
+// Returns the entries of a directory that are not special files.
+func filterSpecial(files []os.FileInfo) []os.FileInfo {
+	regular := files[:0]
+	for _, f := range files {
+		if !isSpecialFile(f) {
+			regular = append(regular, f)
+		}
+	}
+
+	return regular
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"github.com/pterodactyl/sftp-server/src/yaml"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Validates credentials against a local file defining the users and servers, rather than the
+// Panel, so that the server can be developed, tested or used without one. Users log in the same
+// way they would with the Panel, as "username.server". The file is read again whenever it
+// changes, so users can be added or have their permissions changed without a restart.
+type standaloneAuthenticator struct {
+	path string
+
+	mu       sync.Mutex
+	file     *standaloneFile
+	modified time.Time
+	size     int64
+}
+
+// The users and servers defined by the standalone file.
+type standaloneFile struct {
+	servers map[string]standaloneServer
+	users   map[string]standaloneUser
+}
+
+type standaloneServer struct {
+	name      string
+	directory string
+	readOnly  bool
+}
+
+type standaloneUser struct {
+	password []byte
+	// The permissions the user has on each of the servers they can access.
+	servers map[string][]string
+}
+
+// Compared against when a login is for a user or server that doesn't exist, so that it takes as
+// long to reject as a login with the wrong password.
+var (
+	standaloneDummyHash []byte
+	standaloneDummyOnce sync.Once
+)
+
+func newStandaloneAuthenticator(path string) *standaloneAuthenticator {
+	a := &standaloneAuthenticator{path: path}
+	if _, err := a.current(); err != nil {
+		logger.Get().Errorw("failed to read standalone file", zap.String("path", path), zap.Error(err))
+	}
+
+	return a
+}
+
+// Checks that the standalone file can be read, and that everything it defines is valid.
+func ValidateStandaloneFile(path string) error {
+	_, err := readStandaloneFile(path)
+
+	return err
+}
+
+// Returns the users and servers in the file, reading it again if it has changed since it was
+// last read. If the file can't be read the definitions from the last time it was are kept.
+func (a *standaloneAuthenticator) current() (*standaloneFile, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.path)
+	if err == nil && a.file != nil && info.ModTime().Equal(a.modified) && info.Size() == a.size {
+		return a.file, nil
+	}
+
+	if err == nil {
+		var f *standaloneFile
+		if f, err = readStandaloneFile(a.path); err == nil {
+			if a.file != nil {
+				logger.Get().Infow("reloaded standalone file", zap.String("path", a.path))
+			}
+
+			a.file, a.modified, a.size = f, info.ModTime(), info.Size()
+			return f, nil
+		}
+	}
+
+	if a.file == nil {
+		return nil, err
+	}
+
+	// The file isn't read again until it changes, rather than failing the same way for every
+	// login until it is fixed.
+	logger.Get().Errorw("failed to reload standalone file, using the previous definitions", zap.String("path", a.path), zap.Error(err))
+	if info != nil {
+		a.modified, a.size = info.ModTime(), info.Size()
+	}
+
+	return a.file, nil
+}
+
+func (a *standaloneAuthenticator) Authenticate(user string, pass []byte) (*AuthenticationResponse, error) {
+	f, err := a.current()
+	if err != nil {
+		return nil, err
+	}
+
+	name, server := user, ""
+	if i := strings.LastIndex(user, "."); i >= 0 {
+		name, server = user[:i], user[i+1:]
+	}
+
+	u, ok := f.users[name]
+	permissions, access := u.servers[server]
+	if !ok || !access {
+		standaloneDummyOnce.Do(func() {
+			standaloneDummyHash, _ = bcrypt.GenerateFromPassword([]byte("standalone"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(standaloneDummyHash, pass)
+
+		return nil, errors.New("bad credentials provided")
+	}
+
+	if bcrypt.CompareHashAndPassword(u.password, pass) != nil {
+		return nil, errors.New("bad credentials provided")
+	}
+
+	s := f.servers[server]
+
+	return &AuthenticationResponse{
+		Server:      server,
+		User:        name,
+		Permissions: permissions,
+		ServerName:  s.name,
+		ReadOnly:    s.readOnly,
+		Directory:   s.directory,
+	}, nil
+}
+
+// Returns the directory of every server in the file.
+func (a *standaloneAuthenticator) serverDirectories() map[string]string {
+	f, err := a.current()
+	if err != nil {
+		return nil
+	}
+
+	directories := make(map[string]string)
+	for id, s := range f.servers {
+		directories[id] = s.directory
+	}
+
+	return directories
+}
+
+// Returns the permissions the user currently has on the server in the file, so that changes
+// to it apply to sessions that are already open.
+func (a *standaloneAuthenticator) Permissions(user string, server string) ([]string, error) {
+	f, err := a.current()
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, ok := f.users[user].servers[server]
+	if !ok {
+		return nil, errAccessRevoked
+	}
+
+	return permissions, nil
+}
+
+// Reads the standalone file, which looks like the following. The directory of a server defaults
+// to the directory for it within the data path, as it would be for a server from the Panel.
+//
+//	servers:
+//	  survival:
+//	    name: Survival
+//	    directory: /srv/survival
+//	    read_only: false
+//	users:
+//	  dane:
+//	    password: $2y$10$...
+//	    servers:
+//	      survival: ["*"]
+func readStandaloneFile(path string) (*standaloneFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := yaml.Unmarshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := standaloneMapping(v, "the file")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &standaloneFile{servers: make(map[string]standaloneServer), users: make(map[string]standaloneUser)}
+
+	servers, err := standaloneMapping(root["servers"], "servers")
+	if err != nil {
+		return nil, err
+	}
+
+	for id, v := range servers {
+		if id == "" || strings.ContainsAny(id, `./\`) {
+			return nil, fmt.Errorf("server %q must not contain \".\" or path separators", id)
+		}
+
+		fields, err := standaloneMapping(v, fmt.Sprintf("server %q", id))
+		if err != nil {
+			return nil, err
+		}
+
+		s := standaloneServer{}
+		if s.name, err = standaloneString(fields["name"], fmt.Sprintf("name of server %q", id)); err != nil {
+			return nil, err
+		}
+
+		if s.directory, err = standaloneString(fields["directory"], fmt.Sprintf("directory of server %q", id)); err != nil {
+			return nil, err
+		}
+
+		if s.directory != "" && !filepath.IsAbs(s.directory) {
+			return nil, fmt.Errorf("directory of server %q must be an absolute path", id)
+		}
+
+		readOnly, err := standaloneString(fields["read_only"], fmt.Sprintf("read_only of server %q", id))
+		if err != nil {
+			return nil, err
+		}
+
+		if readOnly != "" {
+			if s.readOnly, err = strconv.ParseBool(readOnly); err != nil {
+				return nil, fmt.Errorf("read_only of server %q must be true or false", id)
+			}
+		}
+
+		f.servers[id] = s
+	}
+
+	users, err := standaloneMapping(root["users"], "users")
+	if err != nil {
+		return nil, err
+	}
+
+	for name, v := range users {
+		if name == "" {
+			return nil, errors.New("usernames cannot be empty")
+		}
+
+		fields, err := standaloneMapping(v, fmt.Sprintf("user %q", name))
+		if err != nil {
+			return nil, err
+		}
+
+		password, err := standaloneString(fields["password"], fmt.Sprintf("password of user %q", name))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := bcrypt.Cost([]byte(password)); err != nil {
+			return nil, fmt.Errorf("password of user %q must be a bcrypt hash", name)
+		}
+
+		access, err := standaloneMapping(fields["servers"], fmt.Sprintf("servers of user %q", name))
+		if err != nil {
+			return nil, err
+		}
+
+		u := standaloneUser{password: []byte(password), servers: make(map[string][]string)}
+		for id, v := range access {
+			if _, ok := f.servers[id]; !ok {
+				return nil, fmt.Errorf("user %q has access to server %q, which is not defined", name, id)
+			}
+
+			list, ok := v.([]interface{})
+			if !ok && v != nil {
+				return nil, fmt.Errorf("permissions of user %q on server %q must be a list", name, id)
+			}
+
+			permissions := []string{}
+			for _, p := range list {
+				s, ok := p.(string)
+				if !ok {
+					return nil, fmt.Errorf("permissions of user %q on server %q must be strings", name, id)
+				}
+				permissions = append(permissions, s)
+			}
+
+			u.servers[id] = permissions
+		}
+
+		f.users[name] = u
+	}
+
+	return f, nil
+}
+
+// Returns the value as a mapping, which is empty if it was not set.
+func standaloneMapping(v interface{}, what string) (map[string]interface{}, error) {
+	if v == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a mapping", what)
+	}
+
+	return m, nil
+}
+
+// Returns the value as a string, which is empty if it was not set.
+func standaloneString(v interface{}, what string) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", what)
+	}
+
+	return s, nil
+}
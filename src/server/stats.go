@@ -0,0 +1,168 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// The statistics for a single server over one reporting interval, in the format expected by
+// the Panel.
+type statisticsEntry struct {
+	Server         string `json:"server"`
+	ActiveSessions int    `json:"active_sessions"`
+	Sessions       int64  `json:"sessions"`
+	BytesReceived  int64  `json:"bytes_received"`
+	BytesSent      int64  `json:"bytes_sent"`
+	Operations     int64  `json:"operations"`
+	// The details of each of the active sessions, including when they were last active.
+	Active []sessionInfo `json:"active,omitempty"`
+}
+
+// Sends the SFTP usage of each server to the Panel periodically, so that it can be shown
+// alongside the other resource usage of the server. Each report contains what has changed
+// since the last report that was accepted, so nothing is lost if the Panel can't be reached
+// for a while.
+type statisticsReporter struct {
+	url      string
+	token    string
+	client   *http.Client
+	breaker  *circuitBreaker
+	interval time.Duration
+
+	// Authenticates requests when the handshake with the Panel is enabled.
+	handshake *panelHandshake
+
+	// The totals for each server as of the last report the Panel accepted.
+	reported map[string]transferInfo
+}
+
+// Creates a reporter for the Panel defined in the Daemon configuration.
+func newStatisticsReporter(config []byte, tlsConfig *tls.Config, interval time.Duration, handshake *panelHandshake) *statisticsReporter {
+	url, _ := jsonparser.GetString(config, "remote", "base")
+	token, _ := jsonparser.GetString(config, "keys", "[0]")
+
+	return &statisticsReporter{
+		url:       url,
+		token:     token,
+		client:    newPanelClient(tlsConfig, 10*time.Second),
+		breaker:   newCircuitBreaker(),
+		interval:  interval,
+		handshake: handshake,
+		reported:  make(map[string]transferInfo),
+	}
+}
+
+// Sends the statistics for the server to the Panel on every interval until the done channel is
+// closed.
+func (r *statisticsReporter) run(s *Server, done <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			r.report(s)
+		}
+	}
+}
+
+// Sends a report for every server that has been active since the last report.
+func (r *statisticsReporter) report(s *Server) {
+	active := make(map[string][]sessionInfo)
+	for _, info := range s.activeSessions() {
+		active[info.Server] = append(active[info.Server], info)
+	}
+
+	totals := s.transfers.snapshot()
+
+	var entries []statisticsEntry
+	for uuid, t := range totals {
+		// Like activity, statistics are only sent for the servers of the Panel in the Daemon
+		// configuration.
+		if s.panelOf(uuid) != "" {
+			continue
+		}
+
+		last := r.reported[uuid]
+		e := statisticsEntry{
+			Server:         uuid,
+			ActiveSessions: len(active[uuid]),
+			Active:         active[uuid],
+			Sessions:       t.Sessions - last.Sessions,
+			BytesReceived:  t.BytesReceived - last.BytesReceived,
+			BytesSent:      t.BytesSent - last.BytesSent,
+			Operations:     t.Operations - last.Operations,
+		}
+
+		if e.ActiveSessions == 0 && e.Sessions == 0 && e.BytesReceived == 0 && e.BytesSent == 0 && e.Operations == 0 {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := r.send(entries); err != nil {
+		logger.Get().Warnw("failed to send sftp statistics to the panel", zap.Int("servers", len(entries)), zap.Error(err))
+		return
+	}
+
+	r.reported = totals
+}
+
+func (r *statisticsReporter) send(entries []statisticsEntry) error {
+	if r.url == "" || r.token == "" {
+		return fmt.Errorf("no panel url or token is configured")
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"interval": int(r.interval.Seconds()),
+		"data":     entries,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/remote/sftp/statistics", r.url), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.pterodactyl.v1+json")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.handshake.do(r.client, r.breaker, req, data, r.token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error response from panel: %s", string(s))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+// Counts a file operation performed with the handler.
+func (fs *FileSystem) countOperation() {
+	if fs.operations != nil {
+		fs.operations()
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/sftp"
+)
+
+// The SSH_FX_QUOTA_EXCEEDED status code from later versions of the protocol. Clients that only
+// understand version 3 treat it the same as a generic failure.
+const fxQuotaExceeded = 15
+
+// A statusError is returned to the client with a status code along with a message describing
+// why the request failed, which clients show to the user in place of the generic message for
+// the code.
+type statusError struct {
+	code    uint32
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// The status errors that exist, keyed by their message. pkg/sftp only sends the message of an
+// error with the SSH_FX_FAILURE code, so the code of status packets sent by the request server
+// with one of these messages is replaced as they pass through the extended channel.
+var statusErrors = make(map[string]*statusError)
+
+func newStatusError(code uint32, message string) *statusError {
+	e := &statusError{code: code, message: message}
+	statusErrors[message] = e
+
+	return e
+}
+
+var (
+	errReadOnly          = newStatusError(fxOpUnsupported, "the server is in read-only mode")
+	errFileProtected     = newStatusError(fxPermissionDenied, "file is protected")
+	errFileBlocked       = newStatusError(fxPermissionDenied, "file name is blocked")
+	errFileExists        = newStatusError(fxFailure, "file already exists")
+	errFileTooLarge      = newStatusError(fxFailure, "file is larger than the maximum file size")
+	errQuotaExceeded     = newStatusError(fxQuotaExceeded, "disk quota exceeded")
+	errFileLimitExceeded = newStatusError(fxQuotaExceeded, "file limit exceeded")
+	errNoSpace           = newStatusError(fxQuotaExceeded, "no space left on device")
+	errDirectoryNotEmpty = newStatusError(fxFailure, "directory is not empty")
+	errNameTooLong       = newStatusError(fxFailure, "file name is too long")
+)
+
+// The errors returned when a user is missing a permission, keyed by the permission. Both names
+// of the permission are included, since the user may only know the one used by their Panel.
+var permissionErrors = make(map[string]*statusError)
+
+func init() {
+	for p, scoped := range scopedPermissions {
+		permissionErrors[p] = newStatusError(fxPermissionDenied, "missing the "+p+" ("+scoped+") permission")
+	}
+}
+
+// Returns the error to respond with when the user is missing a permission.
+func errMissingPermission(permission string) error {
+	if e, ok := permissionErrors[permission]; ok {
+		return e
+	}
+
+	return sftp.ErrSshFxPermissionDenied
+}
+
+// Replaces the code of a status packet sent by the request server for one of the status
+// errors, returning the packet unchanged for anything else. The packet includes its length.
+func correctStatus(p []byte) []byte {
+	// The length, type, request ID and status code come before the message.
+	if len(p) < 17 || binary.BigEndian.Uint32(p[9:]) != fxFailure {
+		return p
+	}
+
+	message, _, err := unmarshalString(p[13:])
+	if err != nil {
+		return p
+	}
+
+	e, ok := statusErrors[message]
+	if !ok || e.code == fxFailure {
+		return p
+	}
+
+	b := append([]byte{}, p...)
+	binary.BigEndian.PutUint32(b[9:], e.code)
+
+	return b
+}
+
+// Returns the status code to respond with for a status error, if err is one.
+func statusErrorCode(err error) (uint32, bool) {
+	var s *statusError
+	if errors.As(err, &s) {
+		return s.code, true
+	}
+
+	return 0, false
+}
+
+// Translates an error from the filesystem into the status to respond to the client with. Errors
+// without a matching status are a generic failure, since they include the path of the file on
+// the node, which is never sent to clients. Statuses are returned as they are.
+func translateError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	if _, ok := statusErrorCode(err); ok {
+		return err
+	}
+
+	switch err {
+	case sftp.ErrSshFxOk, sftp.ErrSshFxEof, sftp.ErrSshFxNoSuchFile, sftp.ErrSshFxPermissionDenied,
+		sftp.ErrSshFxFailure, sftp.ErrSshFxBadMessage, sftp.ErrSshFxOpUnsupported:
+		return err
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if status, ok := errnoStatus(errno); ok {
+			return status
+		}
+	}
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return sftp.ErrSshFxNoSuchFile
+	case errors.Is(err, os.ErrPermission):
+		return sftp.ErrSshFxPermissionDenied
+	case errors.Is(err, os.ErrExist):
+		return errFileExists
+	}
+
+	return sftp.ErrSshFxFailure
+}
+
+// Wraps a file being read by the request server so that errors reading it are translated the
+// same as the errors of any other request, rather than pkg/sftp sending them as they are.
+type translatedReader struct {
+	io.ReaderAt
+}
+
+func translateReader(r io.ReaderAt) io.ReaderAt {
+	if r == nil {
+		return nil
+	}
+
+	return &translatedReader{ReaderAt: r}
+}
+
+func (r *translatedReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+
+	return n, translateError(err)
+}
+
+func (r *translatedReader) Close() error {
+	if c, ok := r.ReaderAt.(io.Closer); ok {
+		return translateError(c.Close())
+	}
+
+	return nil
+}
+
+// Wraps a file being written by the request server so that errors writing it, such as the disk
+// being full, are translated the same as the errors of any other request.
+type translatedWriter struct {
+	io.WriterAt
+}
+
+func translateWriter(w io.WriterAt) io.WriterAt {
+	if w == nil {
+		return nil
+	}
+
+	return &translatedWriter{WriterAt: w}
+}
+
+func (w *translatedWriter) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.WriterAt.WriteAt(p, off)
+
+	return n, translateError(err)
+}
+
+func (w *translatedWriter) Close() error {
+	if c, ok := w.WriterAt.(io.Closer); ok {
+		return translateError(c.Close())
+	}
+
+	return nil
+}
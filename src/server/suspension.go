@@ -0,0 +1,101 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// The ways sessions for a server can be handled once the server is suspended.
+const (
+	SuspensionDisconnect = "disconnect"
+	SuspensionReadOnly   = "read-only"
+	SuspensionIgnore     = "none"
+)
+
+// How often the servers with active sessions are checked to see if they have been suspended.
+const suspensionCheckInterval = 10 * time.Second
+
+// Determines if the Daemon has marked a server as suspended. If the configuration for the
+// server can't be read it is treated as not being suspended, the Panel will still refuse any
+// new logins for it.
+func (c Configuration) serverSuspended(uuid string) bool {
+	b, err := ioutil.ReadFile(filepath.Join(c.Settings.ServerDataFolder, uuid, "server.json"))
+	if err != nil {
+		return false
+	}
+
+	suspended, _ := jsonparser.GetBoolean(b, "suspended")
+	return suspended
+}
+
+// Periodically checks if any of the servers with active sessions have been suspended, since a
+// session that was opened before the server was suspended would otherwise be able to continue
+// modifying files. This runs until the done channel is closed.
+func (s *Server) watchSuspensions(done <-chan struct{}) {
+	ticker := time.NewTicker(suspensionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.checkSuspensions()
+		}
+	}
+}
+
+// Checks each of the servers with active sessions, disconnecting the sessions for any server
+// that has been suspended unless they are only being made read-only.
+func (s *Server) checkSuspensions() {
+	s.mu.Lock()
+	servers := make(map[string][]*session)
+	for _, sess := range s.sessions {
+		servers[sess.server] = append(servers[sess.server], sess)
+	}
+	// Servers without any sessions don't need to be tracked, they are checked again when a
+	// new session is opened for them.
+	for uuid := range s.suspended {
+		if _, ok := servers[uuid]; !ok {
+			delete(s.suspended, uuid)
+		}
+	}
+	s.mu.Unlock()
+
+	for uuid, sessions := range servers {
+		if !s.updateSuspension(uuid) || s.config.Settings.SuspensionAction == SuspensionReadOnly {
+			continue
+		}
+
+		for _, sess := range sessions {
+			sess.log.Infow("disconnecting session since the server has been suspended")
+			sess.conn.Close()
+		}
+	}
+}
+
+// Checks if a server is suspended and records the result, so that sessions for the server are
+// made read-only while it is suspended. Returns whether or not the server is suspended.
+func (s *Server) updateSuspension(uuid string) bool {
+	suspended := s.config.serverSuspended(uuid)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if suspended != s.suspended[uuid] {
+		logger.Get().Infow("server suspension status changed", zap.String("server", uuid), zap.Bool("suspended", suspended))
+	}
+
+	if suspended {
+		s.suspended[uuid] = true
+	} else {
+		delete(s.suspended, uuid)
+	}
+
+	return suspended
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// The number of failed logins in a row a user can have before their logins start being
+	// slowed down, and the delay added after the first failure past that. The delay doubles
+	// for each failure after that, up to the maximum.
+	loginFailuresAllowed = 3
+	loginBackoffDelay    = time.Second
+	loginBackoffMax      = 30 * time.Second
+
+	// How long after their last failed login the failures for a user are forgotten.
+	loginFailureWindow = 15 * time.Minute
+)
+
+// Slows down logins for a user after repeated failed attempts to log in as them, no matter
+// which address they come from. Attackers guessing the password for a user tend to spread
+// their attempts across many addresses, so limits on each address alone don't stop them.
+type loginThrottle struct {
+	mu       sync.Mutex
+	failures map[string]loginFailures
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{failures: make(map[string]loginFailures)}
+}
+
+// Returns how long a login for the user should be delayed before the credentials are checked.
+func (t *loginThrottle) delay(user string) time.Duration {
+	t.mu.Lock()
+	f, ok := t.failures[user]
+	t.mu.Unlock()
+
+	if !ok || time.Since(f.last) > loginFailureWindow {
+		return 0
+	}
+
+	return loginBackoff(f.count)
+}
+
+// Records a failed login for the user, logging a security event once their logins are being
+// slowed down.
+func (t *loginThrottle) failure(user string, ip string) {
+	t.mu.Lock()
+	f := t.failures[user]
+	if time.Since(f.last) > loginFailureWindow {
+		f.count = 0
+	}
+	f.count++
+	f.last = time.Now()
+	t.failures[user] = f
+
+	// Drop anything that has expired so that usernames that are never seen again are not kept
+	// around for the lifetime of the process.
+	for u, e := range t.failures {
+		if time.Since(e.last) > loginFailureWindow {
+			delete(t.failures, u)
+		}
+	}
+	t.mu.Unlock()
+
+	if f.count >= loginFailuresAllowed {
+		logger.Get().Warnw("throttling logins for user after repeated failures",
+			zap.String("event", "login_throttled"),
+			zap.String("user", user),
+			zap.String("ip", ip),
+			zap.Int("failures", f.count),
+			zap.Duration("delay", loginBackoff(f.count)),
+		)
+	}
+}
+
+// Forgets the failed logins for a user once they have logged in successfully.
+func (t *loginThrottle) success(user string) {
+	t.mu.Lock()
+	delete(t.failures, user)
+	t.mu.Unlock()
+}
+
+// Returns the delay for a user with the given number of failed logins in a row.
+func loginBackoff(failures int) time.Duration {
+	if failures < loginFailuresAllowed {
+		return 0
+	}
+
+	d := float64(loginBackoffDelay) * math.Pow(2, float64(failures-loginFailuresAllowed))
+	if d > float64(loginBackoffMax) {
+		return loginBackoffMax
+	}
+
+	return time.Duration(d)
+}
+
+// Waits out the delay for a user before their credentials are checked, returning false if the
+// server was stopped while waiting.
+func (s *Server) waitForLogin(user string) bool {
+	d := s.throttle.delay(user)
+	if d == 0 {
+		return true
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Records a failed login for the user of a connection, unless it failed because the
+// credentials could not be checked at all.
+func (s *Server) loginFailed(conn ssh.ConnMetadata, method string, err error) {
+	var unavailable *UnavailableError
+	if errors.As(err, &unavailable) {
+		return
+	}
+
+	ip := remoteIP(conn.RemoteAddr())
+	s.throttle.failure(conn.User(), ip)
+	s.authLog.failure(ip, conn.User(), method)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Creates the TLS configuration used when making requests to the Panel. The CA bundle is added
+// to the system roots so that Panels using a certificate from an internal CA are trusted, and
+// the client certificate is presented to Panels that require one. Skipping verification should
+// only ever be used for testing, since it allows anything to impersonate the Panel.
+func LoadPanelTLS(caFile string, certFile string, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read panel ca bundle: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in panel ca bundle %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load panel client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// Returns a client for making requests to the Panel using the TLS configuration, or the default
+// configuration if it is nil.
+func newPanelClient(cfg *tls.Config, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if cfg != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg
+		client.Transport = transport
+	}
+
+	return client
+}
+
+// Adds some context to errors caused by the Panel certificate not being trusted, since the
+// errors returned for these are not very helpful on their own.
+func panelRequestError(err error) error {
+	var unknown x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &unknown):
+		return fmt.Errorf("the panel certificate is signed by an unknown authority, a ca bundle for the panel may need to be configured: %w", err)
+	case errors.As(err, &hostname):
+		return fmt.Errorf("the panel certificate does not match the panel url: %w", err)
+	case errors.As(err, &invalid):
+		return fmt.Errorf("the panel certificate is not valid: %w", err)
+	}
+
+	return err
+}
+
+// Determines if a request failed because the Panel certificate was rejected.
+func isCertificateError(err error) bool {
+	var unknown x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+
+	return errors.As(err, &unknown) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// The prefix of tokens generated by the Panel for logging in over SFTP. Passwords starting with
+// this are validated as tokens rather than as the password of the user.
+const tokenPrefix = "ptsftp_"
+
+// A TokenAuthenticator is implemented by authenticators that can validate the short lived or
+// one time tokens the Panel generates for users to log in with in place of their password. The
+// token identifies the server being accessed, so the username can be anything and does not need
+// to include the server identifier.
+type TokenAuthenticator interface {
+	AuthenticateToken(user string, token string) (*AuthenticationResponse, error)
+}
+
+type TokenRequest struct {
+	User  string `json:"username"`
+	Token string `json:"token"`
+}
+
+// Determines if the password provided by a user is a token generated by the Panel.
+func isToken(pass []byte) bool {
+	return bytes.HasPrefix(pass, []byte(tokenPrefix)) && len(pass) > len(tokenPrefix)
+}
+
+// Validates a token provided as the password for a login.
+func authenticateToken(auth Authenticator, user string, pass []byte) (*AuthenticationResponse, error) {
+	a, ok := auth.(TokenAuthenticator)
+	if !ok {
+		return nil, errors.New("authenticator does not support tokens")
+	}
+
+	return a.AuthenticateToken(user, string(pass))
+}
+
+// Validates a token for a SFTP login against the Panel, which responds in the same way as it
+// does for a password. Tokens that have expired or have already been used are rejected.
+func (a *PanelAuthenticator) AuthenticateToken(user string, token string) (*AuthenticationResponse, error) {
+	if a.URL == "" || a.Token == "" {
+		return nil, fmt.Errorf("no panel url or token is configured")
+	}
+
+	resp, err := a.post("/api/remote/sftp/token", TokenRequest{User: user, Token: token})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return decodeAuthenticationResponse(resp)
+}
+
+// Passes the token along to the wrapped authenticator. Tokens are short lived, and may only be
+// usable once, so they are never checked against the cache while the Panel is unavailable.
+func (o *offlineAuthenticator) AuthenticateToken(user string, token string) (*AuthenticationResponse, error) {
+	a, ok := o.Authenticator.(TokenAuthenticator)
+	if !ok {
+		return nil, errors.New("authenticator does not support tokens")
+	}
+
+	return a.AuthenticateToken(user, token)
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// How often finished spans are exported, and the number of finished spans that causes them
+	// to be exported straight away.
+	traceExportInterval = 5 * time.Second
+	traceBatchSize      = 512
+
+	// The most finished spans that are kept waiting to be exported. If the collector can't be
+	// reached for long enough to fill this new spans are dropped.
+	maxPendingSpans = 4096
+)
+
+// The span kinds and status codes used by OTLP.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	spanStatusOk    = 1
+	spanStatusError = 2
+)
+
+// Exports traces to an OpenTelemetry collector using OTLP over HTTP with JSON encoding. Each
+// session is a root span, and every SFTP request made during it is a child of that span.
+type tracer struct {
+	endpoint    string
+	serviceName string
+	hashPaths   bool
+	client      *http.Client
+
+	mu      sync.Mutex
+	pending []*span
+	flush   chan struct{}
+}
+
+// A single operation within a trace.
+type span struct {
+	tracer  *tracer
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   map[string]interface{}
+	err     string
+}
+
+func newTracer(endpoint string, serviceName string, hashPaths bool) *tracer {
+	if serviceName == "" {
+		serviceName = "pterodactyl-sftp"
+	}
+
+	return &tracer{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		hashPaths:   hashPaths,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flush:       make(chan struct{}, 1),
+	}
+}
+
+// Starts a new root span. This returns nil if tracing is not enabled, and every method on a nil
+// span does nothing.
+func (t *tracer) start(name string, kind int) *span {
+	if t == nil {
+		return nil
+	}
+
+	s := &span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: make(map[string]interface{})}
+	rand.Read(s.traceID[:])
+	rand.Read(s.spanID[:])
+
+	return s
+}
+
+// Starts a span that is a child of this one.
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+
+	c := &span{
+		tracer:  s.tracer,
+		traceID: s.traceID,
+		parent:  s.spanID,
+		name:    name,
+		kind:    spanKindInternal,
+		start:   time.Now(),
+		attrs:   make(map[string]interface{}),
+	}
+	rand.Read(c.spanID[:])
+
+	return c
+}
+
+func (s *span) set(key string, value interface{}) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// Sets the path attribute of the span, which is hashed when configured so that file names are
+// not sent to the collector.
+func (s *span) setPath(key string, p string) {
+	if s == nil || p == "" {
+		return
+	}
+
+	if s.tracer.hashPaths {
+		sum := sha256.Sum256([]byte(p))
+		p = hex.EncodeToString(sum[:])
+	}
+
+	s.attrs[key] = p
+}
+
+// Ends the span, marking it as failed if an error is given, and queues it to be exported.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	if err != nil && err != sftp.ErrSshFxOk {
+		s.err = err.Error()
+	}
+	s.end = time.Now()
+
+	t := s.tracer
+	t.mu.Lock()
+	t.pending = append(t.pending, s)
+	if len(t.pending) > maxPendingSpans {
+		t.pending = t.pending[len(t.pending)-maxPendingSpans:]
+	}
+	full := len(t.pending) >= traceBatchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Exports finished spans periodically until the done channel is closed, at which point
+// anything still pending is exported.
+func (t *tracer) run(done <-chan struct{}) {
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			t.export()
+			return
+		case <-ticker.C:
+			t.export()
+		case <-t.flush:
+			t.export()
+		}
+	}
+}
+
+// Sends all of the pending spans to the collector. Spans that fail to send are dropped, since
+// traces are only useful while they are recent.
+func (t *tracer) export() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := t.send(spans); err != nil {
+		logger.Get().Warnw("failed to export traces", zap.Int("spans", len(spans)), zap.Error(err))
+	}
+}
+
+func (t *tracer) send(spans []*span) error {
+	encoded := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.encode())
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": encodeAttributes(map[string]interface{}{"service.name": t.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/pterodactyl/sftp-server"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error response from collector: %s", string(s))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+// Encodes the span in the OTLP JSON format, where IDs are hex encoded and timestamps are
+// strings of nanoseconds since the epoch.
+func (s *span) encode() map[string]interface{} {
+	e := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        encodeAttributes(s.attrs),
+		"status":            map[string]interface{}{"code": spanStatusOk},
+	}
+
+	if s.parent != [8]byte{} {
+		e["parentSpanId"] = hex.EncodeToString(s.parent[:])
+	}
+
+	if s.err != "" {
+		e["status"] = map[string]interface{}{"code": spanStatusError, "message": s.err}
+	}
+
+	return e
+}
+
+func encodeAttributes(attrs map[string]interface{}) []interface{} {
+	encoded := make([]interface{}, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+
+		encoded = append(encoded, map[string]interface{}{"key": k, "value": value})
+	}
+
+	return encoded
+}
+
+// Starts the root span for a session.
+func (sess *session) startSpan(t *tracer) {
+	sess.span = t.start("sftp.session", spanKindServer)
+	sess.span.set("sftp.server", sess.server)
+	sess.span.set("sftp.user", sess.user)
+	sess.span.set("sftp.session", sess.id)
+	sess.span.set("net.peer.ip", sess.ip)
+}
+
+// Finishes the root span for a session once it has disconnected, recording the bytes that were
+// transferred during it.
+func (sess *session) finishSpan() {
+	sess.span.set("sftp.bytes_received", atomic.LoadInt64(&sess.received))
+	sess.span.set("sftp.bytes_sent", atomic.LoadInt64(&sess.sent))
+	sess.span.finish(nil)
+}
+
+// Starts the span for an SFTP request made during a session.
+func (sess *session) requestSpan(request *sftp.Request) *span {
+	s := sess.span.child("sftp." + strings.ToLower(request.Method))
+	if s == nil {
+		return nil
+	}
+
+	s.set("sftp.server", sess.server)
+	s.set("sftp.method", request.Method)
+	s.setPath("sftp.path", request.Filepath)
+	s.setPath("sftp.target", request.Target)
+
+	return s
+}
+
+// Wraps a file that was opened for reading, counting the bytes read from it and finishing the
+// span for the request once it is closed.
+type tracedReader struct {
+	io.ReaderAt
+	span  *span
+	bytes int64
+}
+
+func (r *tracedReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+	atomic.AddInt64(&r.bytes, int64(n))
+
+	return n, err
+}
+
+func (r *tracedReader) Close() error {
+	var err error
+	if c, ok := r.ReaderAt.(io.Closer); ok {
+		err = c.Close()
+	}
+
+	r.span.set("sftp.bytes_read", atomic.LoadInt64(&r.bytes))
+	r.span.finish(err)
+
+	return err
+}
+
+// Wraps a file that was opened for writing, counting the bytes written to it and finishing the
+// span for the request once it is closed.
+type tracedWriter struct {
+	io.WriterAt
+	span  *span
+	bytes int64
+}
+
+func (w *tracedWriter) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.WriterAt.WriteAt(p, off)
+	atomic.AddInt64(&w.bytes, int64(n))
+
+	return n, err
+}
+
+func (w *tracedWriter) Close() error {
+	var err error
+	if c, ok := w.WriterAt.(io.Closer); ok {
+		err = c.Close()
+	}
+
+	w.span.set("sftp.bytes_written", atomic.LoadInt64(&w.bytes))
+	w.span.finish(err)
+
+	return err
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Keeps count of the bytes received from and sent to clients for each server, across every
+// session for it, so that the servers responsible for most of the traffic on a node can be
+// found. The totals are kept in memory and reset when the process is restarted.
+type transferStats struct {
+	mu      sync.Mutex
+	servers map[string]*serverTransfer
+}
+
+// The totals for a single server, including the number of sessions that have been opened for
+// it and the file operations performed in them. These must be accessed atomically.
+type serverTransfer struct {
+	received   int64
+	sent       int64
+	sessions   int64
+	operations int64
+}
+
+// The totals for a server that are exposed through the admin API.
+type transferInfo struct {
+	BytesReceived int64 `json:"bytes_received"`
+	BytesSent     int64 `json:"bytes_sent"`
+	Sessions      int64 `json:"sessions"`
+	Operations    int64 `json:"operations"`
+}
+
+func newTransferStats() *transferStats {
+	return &transferStats{servers: make(map[string]*serverTransfer)}
+}
+
+// Returns the totals for a server, creating them if this is the first session for it.
+func (t *transferStats) forServer(uuid string) *serverTransfer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.servers[uuid]
+	if !ok {
+		st = &serverTransfer{}
+		t.servers[uuid] = st
+	}
+
+	return st
+}
+
+// Returns a snapshot of the totals for every server that has had a session.
+func (t *transferStats) snapshot() map[string]transferInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	totals := make(map[string]transferInfo, len(t.servers))
+	for uuid, st := range t.servers {
+		totals[uuid] = st.info()
+	}
+
+	return totals
+}
+
+func (st *serverTransfer) info() transferInfo {
+	return transferInfo{
+		BytesReceived: atomic.LoadInt64(&st.received),
+		BytesSent:     atomic.LoadInt64(&st.sent),
+		Sessions:      atomic.LoadInt64(&st.sessions),
+		Operations:    atomic.LoadInt64(&st.operations),
+	}
+}
+
+// Handles GET /servers/transfer, returning the totals for every server.
+func (s *Server) handleListTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"servers": s.transfers.snapshot()})
+}
+
+// Handles GET /servers/<uuid>/transfer, returning the totals for a single server. Servers that
+// have not had any sessions have totals of zero.
+func (s *Server) handleServerTransfer(w http.ResponseWriter, r *http.Request) {
+	uuid := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/servers/"), "/transfer")
+	if uuid == "" || strings.Contains(uuid, "/") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.transfers.snapshot()[uuid])
+}
+
+// Handles GET /metrics, returning the active sessions, the totals for every server and the time
+// taken by SFTP operations in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	s.mu.Lock()
+	sessions := len(s.sessions)
+	s.mu.Unlock()
+
+	totals := s.transfers.snapshot()
+	servers := make([]string, 0, len(totals))
+	for uuid := range totals {
+		servers = append(servers, uuid)
+	}
+	sort.Strings(servers)
+
+	var b strings.Builder
+	b.WriteString("# HELP sftp_sessions The number of active sessions.\n")
+	b.WriteString("# TYPE sftp_sessions gauge\n")
+	fmt.Fprintf(&b, "sftp_sessions %d\n", sessions)
+
+	b.WriteString("# HELP sftp_server_received_bytes_total The bytes received from clients for a server.\n")
+	b.WriteString("# TYPE sftp_server_received_bytes_total counter\n")
+	for _, uuid := range servers {
+		fmt.Fprintf(&b, "sftp_server_received_bytes_total{server=%q} %d\n", uuid, totals[uuid].BytesReceived)
+	}
+
+	b.WriteString("# HELP sftp_server_sent_bytes_total The bytes sent to clients for a server.\n")
+	b.WriteString("# TYPE sftp_server_sent_bytes_total counter\n")
+	for _, uuid := range servers {
+		fmt.Fprintf(&b, "sftp_server_sent_bytes_total{server=%q} %d\n", uuid, totals[uuid].BytesSent)
+	}
+
+	s.latency.write(&b)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/pterodactyl/sftp-server/src/containment"
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// The directory, relative to the root of a server, that deleted files are moved into when
+// the trash is enabled.
+const trashDirectory = ".trash"
+
+// How often the trash directories for all servers are checked for expired entries.
+const trashPurgeInterval = time.Hour
+
+// Determines if the given path is the trash directory, or something within it.
+func (fs *FileSystem) inTrash(p string) bool {
+	return containment.Within(p, filepath.Join(fs.Directory, trashDirectory))
+}
+
+// Moves a file or directory into the trash rather than removing it. Each deletion is placed into
+// its own directory named after the time it was deleted, with the original path of the file
+// recreated beneath it so that it is obvious where things should be restored to.
+func (fs *FileSystem) moveToTrash(p string) error {
+	root := filepath.Join(fs.Directory, trashDirectory)
+	if err := fs.backend().MkdirAll(root, fs.DirectoryMode); err != nil {
+		fs.log().Errorw("failed to create trash directory", zap.String("path", root), zap.Error(err))
+		return translateError(err)
+	}
+
+	entry, err := createTempDir(fs.backend(), root, fmt.Sprintf("%d-", time.Now().Unix()), 0700)
+	if err != nil {
+		fs.log().Errorw("failed to create trash entry", zap.String("path", root), zap.Error(err))
+		return translateError(err)
+	}
+
+	target := filepath.Join(entry, fs.relativePath(p))
+	if err := fs.backend().MkdirAll(filepath.Dir(target), fs.DirectoryMode); err != nil {
+		fs.log().Errorw("failed to create trash entry", zap.String("path", target), zap.Error(err))
+		return translateError(err)
+	}
+
+	// Make sure everything we just created is owned by the server user so that they are able
+	// to restore files out of the trash themselves.
+	for d := filepath.Dir(target); containment.Within(d, root); d = filepath.Dir(d) {
+		if err := fs.chown(d); err != nil {
+			fs.log().Warnw("error chowning file", zap.String("file", d), zap.Error(err))
+		}
+	}
+
+	if err := fs.backend().Rename(p, target); err != nil {
+		fs.log().Errorw("failed to move file to trash",
+			zap.String("source", p),
+			zap.String("target", target),
+			zap.Error(err),
+		)
+		return translateError(err)
+	}
+
+	fs.notify(EventDelete, p, "")
+	return sftp.ErrSshFxOk
+}
+
+// Periodically removes trash entries that are older than the retention period for every server
+// on the node. This runs until the done channel is closed.
+func (s *Server) purgeTrash(done <-chan struct{}) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		s.purgeExpiredTrash()
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Removes all of the trash entries for all servers that are older than the retention period.
+func (s *Server) purgeExpiredTrash() {
+	c := s.config
+
+	servers, err := s.serverDirectories()
+	if err != nil {
+		logger.Get().Errorw("error reading server data directory", zap.String("directory", c.dataPath()), zap.Error(err))
+		return
+	}
+
+	cutoff := time.Now().Add(-c.Settings.TrashRetention)
+	for uuid, directory := range servers {
+		fs := &FileSystem{Directory: directory, Backend: c.Backend, UUID: uuid, Cache: c.Cache}
+
+		// The disk usage and file count for the server no longer reflect what is on the disk, so
+		// have them calculated again the next time they are needed.
+		if fs.purgeExpiredTrash(cutoff) {
+			c.Cache.Delete("used:" + uuid)
+			c.Cache.Delete("files:" + uuid)
+		}
+	}
+}
+
+// Removes the trash entries for the server that were deleted before the cutoff, returning true
+// if anything was removed.
+func (fs *FileSystem) purgeExpiredTrash(cutoff time.Time) bool {
+	// The trash directory is within the server directory, so the user could have replaced it
+	// with a symlink to somewhere else to have whatever it points to purged.
+	root := filepath.Join(fs.Directory, trashDirectory)
+	if info, err := fs.backend().Lstat(root); err != nil || !info.IsDir() {
+		return false
+	}
+
+	entries, err := fs.backend().ReadDir(root)
+	if err != nil {
+		return false
+	}
+
+	purged := false
+	for _, e := range entries {
+		// Entries are named after the unix timestamp they were deleted at, followed by a
+		// random suffix. Anything not matching that format was not created by us.
+		ts, err := strconv.ParseInt(strings.SplitN(e.Name(), "-", 2)[0], 10, 64)
+		if err != nil || time.Unix(ts, 0).After(cutoff) {
+			continue
+		}
+
+		p := filepath.Join(root, e.Name())
+		if err := fs.backend().RemoveAll(p); err != nil {
+			fs.log().Warnw("failed to purge trash entry", zap.String("path", p), zap.Error(err))
+			continue
+		}
+
+		fs.log().Debugw("purged expired trash entry", zap.String("entry", e.Name()))
+		purged = true
+	}
+
+	return purged
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	fs := newTestFileSystem(t)
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	writeTestFile(t, fs, filepath.Join(trashDirectory, formatTrashEntry(old), "server.jar"), 0)
+	writeTestFile(t, fs, filepath.Join(trashDirectory, formatTrashEntry(time.Now().Unix()), "server.properties"), 0)
+
+	s := New(Configuration{Settings: Settings{DevDirectory: fs.Directory, TrashRetention: 24 * time.Hour}})
+	s.purgeExpiredTrash()
+
+	entries, err := filepath.Glob(filepath.Join(fs.Directory, trashDirectory, "*", "*"))
+	if err != nil || len(entries) != 1 || filepath.Base(entries[0]) != "server.properties" {
+		t.Errorf("trash = %v, %v, want only the entry that has not expired", entries, err)
+	}
+}
+
+func TestPurgeTrashSymlink(t *testing.T) {
+	fs := newTestFileSystem(t)
+	outside := newTestFileSystem(t)
+	writeTestFile(t, outside, filepath.Join(formatTrashEntry(1), "world.dat"), 0)
+
+	// A trash directory that was replaced with a symlink is never purged, since it could point
+	// anywhere on the node.
+	if err := os.Symlink(outside.Directory, filepath.Join(fs.Directory, trashDirectory)); err != nil {
+		t.Fatal(err)
+	}
+
+	if fs.purgeExpiredTrash(time.Now()) {
+		t.Error("purged a trash directory that is a symlink")
+	}
+
+	if _, err := os.Stat(filepath.Join(outside.Directory, formatTrashEntry(1), "world.dat")); err != nil {
+		t.Errorf("file outside of the server was removed: %s", err)
+	}
+}
+
+// Returns the name of a trash entry for something deleted at the given unix timestamp.
+func formatTrashEntry(ts int64) string {
+	return fmt.Sprintf("%d-test", ts)
+}
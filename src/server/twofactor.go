@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// Returned when a user with two factor authentication enabled tries to log in with just their
+// password, since that would allow logging in without the second factor.
+var errTwoFactorRequired = errors.New("two factor authentication is required")
+
+// A TwoFactorVerifier is implemented by authenticators that support users who have two factor
+// authentication enabled. Users who have it enabled can only log in using keyboard-interactive
+// authentication, where they are asked for a code after their password has been validated.
+type TwoFactorVerifier interface {
+	// Validates the code provided by a user, returning an error if it is not valid.
+	VerifyTwoFactor(user string, code string) error
+}
+
+type TwoFactorRequest struct {
+	User string `json:"username"`
+	Code string `json:"code"`
+}
+
+// Validates a two factor authentication code for a user against the Panel.
+func (a *PanelAuthenticator) VerifyTwoFactor(user string, code string) error {
+	if a.URL == "" || a.Token == "" {
+		return fmt.Errorf("no panel url or token is configured")
+	}
+
+	resp, err := a.post("/api/remote/sftp/two-factor", TwoFactorRequest{User: user, Code: code})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s, _ := ioutil.ReadAll(resp.Body)
+
+		err := fmt.Errorf("error response from server: %s", string(s))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return &UnavailableError{Err: err}
+		}
+
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest {
+			return fmt.Errorf("bad two factor code provided: %s", string(s))
+		}
+
+		return err
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+// Passes the code along to the wrapped authenticator. Codes are never checked against the
+// cache, so users with two factor authentication enabled are unable to log in while the Panel
+// is unavailable.
+func (o *offlineAuthenticator) VerifyTwoFactor(user string, code string) error {
+	return verifyTwoFactor(o.Authenticator, user, code)
+}
+
+func verifyTwoFactor(a Authenticator, user string, code string) error {
+	v, ok := a.(TwoFactorVerifier)
+	if !ok {
+		return errors.New("authenticator does not support two factor authentication")
+	}
+
+	return v.VerifyTwoFactor(user, code)
+}
+
+// Handles keyboard-interactive authentication, which asks for the password of the user and
+// then, if they have two factor authentication enabled, for a code from their authenticator.
+func (s *Server) keyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	answers, err := client("", "", []string{"Password: "}, []bool{false})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(answers) != 1 {
+		return nil, errors.New("expected a password")
+	}
+
+	resp, user, err := s.authenticate(conn, []byte(answers[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.TwoFactor {
+		answers, err := client("", "Two factor authentication is enabled for this account.", []string{"Authentication code: "}, []bool{true})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(answers) != 1 || answers[0] == "" {
+			return nil, errors.New("expected a two factor code")
+		}
+
+		if err := verifyTwoFactor(s.authenticatorFor(conn), conn.User(), answers[0]); err != nil {
+			logger.Get().Debugw("failed to validate two factor code", zap.String("session", correlationID(conn)), zap.String("user", conn.User()), zap.Error(err))
+			s.loginFailed(conn, "two-factor", err)
+			return nil, errors.New("could not validate two factor code")
+		}
+
+		s.throttle.success(user)
+	}
+
+	return resp.permissions(user), nil
+}
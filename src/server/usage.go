@@ -0,0 +1,134 @@
+package server
+
+import (
+	"strconv"
+
+	cache "github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+)
+
+// Returns the amount of disk space in bytes being used by the server. The usage is calculated
+// once by walking the server directory, and is then kept up to date by adjusting it as files
+// are written and removed rather than walking the directory again for every operation.
+//
+// The calculated usage is kept in the cache shared by every session for the server, and expires
+// along with everything else in the cache. This corrects for any changes made to the files
+// outside of SFTP, such as by the server process itself.
+func (fs *FileSystem) diskUsage() int64 {
+	if x, exists := fs.Cache.Get("used:" + fs.UUID); exists {
+		return x.(int64)
+	}
+
+	size, _ := fs.calculateUsage()
+	return size
+}
+
+// Returns the number of files and directories within the server, which is calculated and kept
+// up to date the same way as the disk usage.
+func (fs *FileSystem) fileCount() int64 {
+	if x, exists := fs.Cache.Get("files:" + fs.UUID); exists {
+		return x.(int64)
+	}
+
+	_, count := fs.calculateUsage()
+	return count
+}
+
+// Walks the server directory to determine the disk usage and number of files for the server,
+// and stores them in the cache.
+func (fs *FileSystem) calculateUsage() (int64, int64) {
+	size, count := fs.directorySize(fs.Directory)
+	fs.log().Debugw("got directory size from taxing operation", zap.Int64("size", size), zap.Int64("files", count))
+
+	// Another session could have finished calculating the usage and started adjusting it while
+	// we were walking the directory, in which case its values are kept.
+	if err := fs.Cache.Add("used:"+fs.UUID, size, cache.DefaultExpiration); err != nil {
+		if x, exists := fs.Cache.Get("used:" + fs.UUID); exists {
+			size = x.(int64)
+		}
+	}
+
+	if err := fs.Cache.Add("files:"+fs.UUID, count, cache.DefaultExpiration); err != nil {
+		if x, exists := fs.Cache.Get("files:" + fs.UUID); exists {
+			count = x.(int64)
+		}
+	}
+
+	return size, count
+}
+
+// Adjusts the cached disk usage for the server by the given number of bytes. If the usage has
+// not been calculated yet there is nothing to adjust, it will include the change once it is.
+func (fs *FileSystem) trackUsage(delta int64) {
+	if delta == 0 || fs.Cache == nil {
+		return
+	}
+
+	fs.Cache.IncrementInt64("used:"+fs.UUID, delta)
+}
+
+// Adjusts the cached number of files for the server, in the same way as the disk usage.
+func (fs *FileSystem) trackFiles(delta int64) {
+	if delta == 0 || fs.Cache == nil {
+		return
+	}
+
+	fs.Cache.IncrementInt64("files:"+fs.UUID, delta)
+}
+
+// Determines if the given number of files or directories can be created without the server
+// going over its file limit.
+func (fs *FileSystem) hasFilesFor(n int64) bool {
+	if fs.DisableDiskCheck || fs.MaxFiles <= 0 {
+		return true
+	}
+
+	return fs.fileCount()+n <= fs.MaxFiles
+}
+
+// Calculates the disk usage for the server in the background if it has a disk or file limit,
+// so that the first write of a session doesn't have to wait for the directory to be walked.
+func (fs *FileSystem) primeDiskUsage() {
+	if fs.DisableDiskCheck || (fs.diskLimit() <= 0 && fs.MaxFiles <= 0) {
+		return
+	}
+
+	go fs.diskUsage()
+}
+
+// Returns the size in bytes of the file at the given path, or zero if it does not exist. This
+// is used to determine how much a change to a file affected the disk usage of the server.
+func (fs *FileSystem) fileSize(p string) int64 {
+	st, err := fs.backend().Stat(p)
+	if err != nil || st.IsDir() {
+		return 0
+	}
+
+	return st.Size()
+}
+
+// Returns the size in bytes of everything at the given path, which may be a file or a
+// directory, along with the number of files and directories it accounts for.
+func (fs *FileSystem) pathSize(p string) (int64, int64) {
+	st, err := fs.backend().Stat(p)
+	if err != nil {
+		return 0, 0
+	}
+
+	if st.IsDir() {
+		size, count := fs.directorySize(p)
+		return size, count + 1
+	}
+
+	return st.Size(), 1
+}
+
+// Parses the file limit returned by the Panel for a server, falling back to the default if the
+// Panel did not provide one.
+func parseFileLimit(v string, def int64) int64 {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+		return n
+	}
+
+	return def
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/containment"
+	"go.uber.org/zap"
+)
+
+// The directory, relative to the root of a server, that previous versions of overwritten files
+// are stored in when versioning is enabled.
+const versionsDirectory = ".versions"
+
+// The format of the timestamp appended to the name of each saved version of a file.
+const versionTimeFormat = "20060102T150405.000000000"
+
+// Determines if the given path is the versions directory, or something within it.
+func (fs *FileSystem) inVersions(p string) bool {
+	return containment.Within(p, filepath.Join(fs.Directory, versionsDirectory))
+}
+
+// Copies the current contents of a file that is about to be overwritten into the versions
+// directory, and then removes the oldest versions of the file if there are more than the
+// configured maximum. Versions are stored at the same relative path as the original file
+// with the time they were saved appended to the name.
+//
+// Failing to save a version is logged but does not prevent the file from being written.
+func (fs *FileSystem) saveVersion(p string) {
+	if fs.MaxVersions <= 0 || fs.inVersions(p) || fs.inTrash(p) {
+		return
+	}
+
+	base := filepath.Join(fs.Directory, versionsDirectory, fs.relativePath(p))
+	target := fmt.Sprintf("%s.%s", base, time.Now().UTC().Format(versionTimeFormat))
+
+	if err := fs.copyRegularFile(p, target); err != nil {
+		fs.log().Warnw("failed to save previous version of file", zap.String("source", p), zap.Error(err))
+		return
+	}
+
+	// Make sure the directories we just created belong to the server user.
+	root := filepath.Join(fs.Directory, versionsDirectory)
+	for d := filepath.Dir(target); containment.Within(d, root); d = filepath.Dir(d) {
+		if err := fs.chown(d); err != nil {
+			fs.log().Warnw("error chowning file", zap.String("file", d), zap.Error(err))
+		}
+	}
+
+	// The timestamp format sorts lexically, so the oldest versions come first.
+	files, err := fs.backend().ReadDir(filepath.Dir(base))
+	if err != nil {
+		return
+	}
+
+	var versions []string
+	for _, f := range files {
+		// Only look at versions of this exact file, not other files that happen to start
+		// with the same name.
+		ts := strings.TrimPrefix(f.Name(), filepath.Base(base)+".")
+		if _, err := time.Parse(versionTimeFormat, ts); err == nil && ts != f.Name() {
+			versions = append(versions, f.Name())
+		}
+	}
+
+	if len(versions) <= fs.MaxVersions {
+		return
+	}
+	sort.Strings(versions)
+
+	for _, v := range versions[:len(versions)-fs.MaxVersions] {
+		p := filepath.Join(filepath.Dir(base), v)
+
+		size := fs.fileSize(p)
+		if err := fs.backend().Remove(p); err != nil {
+			fs.log().Warnw("failed to remove old version of file", zap.String("source", v), zap.Error(err))
+			continue
+		}
+		fs.trackUsage(-size)
+		fs.trackFiles(-1)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pterodactyl/sftp-server/src/logger"
+	"go.uber.org/zap"
+)
+
+// The number of times sending an event to a webhook is attempted before it is dropped.
+const webhookAttempts = 5
+
+// The number of events that can be waiting to be sent to a single webhook. Once the queue is
+// full new events are dropped, rather than holding up the file operations that caused them.
+const webhookQueueSize = 256
+
+// A webhook receives file events as JSON in a POST request. Events are sent in the background
+// in the order they happened, and failed requests are retried with an increasing delay.
+//
+// When a secret is configured every request includes an X-Sftp-Signature header containing
+// the hex encoded HMAC-SHA256 of the request body, which receivers should use to verify that
+// the event came from this server.
+type webhook struct {
+	url    string
+	secret string
+	client *http.Client
+	queue  chan FileEvent
+}
+
+func newWebhook(url string, secret string) *webhook {
+	return &webhook{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan FileEvent, webhookQueueSize),
+	}
+}
+
+// Queues an event to be sent to the webhook.
+func (w *webhook) send(e FileEvent) {
+	select {
+	case w.queue <- e:
+	default:
+		logger.Get().Warnw("dropping webhook event since the queue is full",
+			zap.String("url", w.url),
+			zap.String("event", e.Event),
+		)
+	}
+}
+
+// Sends queued events to the webhook until the done channel is closed.
+func (w *webhook) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case e := <-w.queue:
+			w.deliver(e, done)
+		}
+	}
+}
+
+// Sends a single event, retrying if the request fails or the receiver responds with a server
+// error. Any other response from the receiver is final, since retrying it would not change the
+// outcome.
+func (w *webhook) deliver(e FileEvent, done <-chan struct{}) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		logger.Get().Errorw("failed to encode webhook event", zap.String("event", e.Event), zap.Error(err))
+		return
+	}
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(e.Event, body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt == webhookAttempts {
+			logger.Get().Warnw("failed to send webhook event",
+				zap.String("url", w.url),
+				zap.String("event", e.Event),
+				zap.Int("attempts", attempt),
+				zap.Error(err),
+			)
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+// Makes the request for an event, returning an error if it was not accepted along with whether
+// or not it is worth trying again.
+func (w *webhook) post(event string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sftp-Event", event)
+	if w.secret != "" {
+		req.Header.Set("X-Sftp-Signature", "sha256="+signPayload(w.secret, body))
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	// Read the rest of the response so that the connection can be reused.
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests,
+		fmt.Errorf("received unexpected status code %d", res.StatusCode)
+}
+
+// Returns the hex encoded HMAC-SHA256 of the payload using the secret.
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,328 @@
+// Package yaml decodes the subset of YAML used by the files the server reads, such as the users
+// file for standalone mode. Block mappings and sequences, flow sequences of scalars, plain and
+// quoted scalars, and comments are supported. Anchors, tags, multi-line scalars, flow mappings
+// and multiple documents are not.
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A line of the document, with comments and the indentation removed.
+type line struct {
+	number int
+	indent int
+	text   string
+}
+
+type parser struct {
+	lines []line
+	pos   int
+}
+
+// Decodes a document into the value it contains. Mappings are decoded as a map[string]interface{},
+// sequences as a []interface{} and scalars as strings, which are left for the caller to parse
+// since it knows what type each is meant to be. Empty values and nulls are nil.
+func Unmarshal(data []byte) (interface{}, error) {
+	lines, err := splitLines(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	p := &parser{lines: lines}
+	v, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+
+	return v, nil
+}
+
+// Splits the document into the lines that have any content, removing comments.
+func splitLines(data string) ([]line, error) {
+	var lines []line
+	for i, text := range strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n") {
+		text = strings.TrimRight(stripComment(text), " \t")
+		content := strings.TrimLeft(text, " ")
+		if content == "" || (len(lines) == 0 && content == "---") {
+			continue
+		}
+
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot be used for indentation", i+1)
+		}
+
+		lines = append(lines, line{number: i + 1, indent: len(text) - len(content), text: content})
+	}
+
+	return lines, nil
+}
+
+// Removes the comment from a line, which starts at a "#" that is at the start of the line or
+// follows whitespace, and is not within a quoted scalar.
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+
+	return text
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	number := p.lines[len(p.lines)-1].number
+	if p.pos < len(p.lines) {
+		number = p.lines[p.pos].number
+	}
+
+	return fmt.Errorf("line %d: %s", number, fmt.Sprintf(format, args...))
+}
+
+// Parses the mapping or sequence starting at the current line.
+func (p *parser) parseBlock() (interface{}, error) {
+	l := p.lines[p.pos]
+	if isSequenceItem(l.text) {
+		return p.parseSequence(l.indent)
+	}
+
+	return p.parseMapping(l.indent)
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *parser) parseSequence(indent int) ([]interface{}, error) {
+	values := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text) {
+		l := p.lines[p.pos]
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+
+		// A mapping can begin on the same line as the item, in which case the rest of the line
+		// is treated as its first line, indented to where it starts.
+		if rest != "" && splitKey(rest) >= 0 {
+			p.lines[p.pos] = line{number: l.number, indent: indent + len(l.text) - len(rest), text: rest}
+			v, err := p.parseMapping(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			continue
+		}
+
+		p.pos++
+		v, err := p.parseValue(rest, indent, false)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *parser) parseMapping(indent int) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		if isSequenceItem(l.text) {
+			return nil, p.errorf("expected a key but found a sequence item")
+		}
+
+		i := splitKey(l.text)
+		if i < 0 {
+			return nil, p.errorf("expected a key followed by \":\"")
+		}
+
+		key, err := parseKey(l.text[:i])
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+
+		if _, ok := values[key]; ok {
+			return nil, p.errorf("key %q is defined more than once", key)
+		}
+
+		p.pos++
+		v, err := p.parseValue(strings.TrimLeft(l.text[i+1:], " "), indent, true)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = v
+	}
+
+	return values, nil
+}
+
+// Parses the value following a key or sequence item at the indentation given. If there is
+// nothing after it on the same line the value is the block on the lines after it, if there is
+// one. A sequence can be at the same indentation as the key it belongs to.
+func (p *parser) parseValue(text string, indent int, key bool) (interface{}, error) {
+	if text != "" {
+		v, err := parseScalar(text)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+
+		return v, nil
+	}
+
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+
+	next := p.lines[p.pos]
+	if next.indent > indent || (key && next.indent == indent && isSequenceItem(next.text)) {
+		return p.parseBlock()
+	}
+
+	return nil, nil
+}
+
+// Returns the position of the ":" separating the key from the value on a line, or -1 if the line
+// is not a key. The ":" must be followed by a space or end the line.
+func splitKey(text string) int {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return i
+		}
+	}
+
+	return -1
+}
+
+func parseKey(text string) (string, error) {
+	text = strings.TrimRight(text, " ")
+	if text == "" {
+		return "", fmt.Errorf("keys cannot be empty")
+	}
+
+	if text[0] == '"' || text[0] == '\'' {
+		return unquote(text)
+	}
+
+	return text, nil
+}
+
+// Parses a scalar, or a flow sequence of scalars.
+func parseScalar(text string) (interface{}, error) {
+	switch text[0] {
+	case '"', '\'':
+		return unquote(text)
+	case '[':
+		return parseFlowSequence(text)
+	case '{':
+		return nil, fmt.Errorf("flow mappings are not supported")
+	case '&', '*', '!', '|', '>':
+		return nil, fmt.Errorf("unsupported value %q", text)
+	}
+
+	if text == "~" || text == "null" {
+		return nil, nil
+	}
+
+	return text, nil
+}
+
+func parseFlowSequence(text string) ([]interface{}, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("flow sequence is missing a closing \"]\"")
+	}
+
+	values := []interface{}{}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	if inner == "" {
+		return values, nil
+	}
+
+	var quote byte
+	start := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+			switch {
+			case quote == '"' && c == '\\':
+				i++
+				continue
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c == '[' || c == ']':
+				return nil, fmt.Errorf("nested flow sequences are not supported")
+			case c != ',':
+				continue
+			}
+		}
+
+		item := strings.TrimSpace(inner[start:i])
+		if item == "" {
+			return nil, fmt.Errorf("flow sequence has an empty item")
+		}
+
+		v, err := parseScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		start = i + 1
+	}
+
+	return values, nil
+}
+
+// Removes the quotes from a quoted scalar. Double quoted scalars can contain the same escape
+// sequences as a Go string, while single quoted scalars only escape a quote by doubling it.
+func unquote(text string) (string, error) {
+	if len(text) < 2 || text[len(text)-1] != text[0] {
+		return "", fmt.Errorf("unterminated quoted value %s", text)
+	}
+
+	if text[0] == '\'' {
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+
+	s, err := strconv.Unquote(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted value %s", text)
+	}
+
+	return s, nil
+}
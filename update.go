@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	// The GitHub API endpoint returning the latest release of the server.
+	releasesURL = "https://api.github.com/repos/pterodactyl/sftp-server/releases/latest"
+
+	// The assets attached to every release listing the SHA-256 checksum of each binary, and the
+	// base64 encoded ed25519 signature of the tag of the release followed by a newline and that
+	// list, so that the checksums of one release can't be passed off as those of another.
+	checksumsAsset = "checksums.txt"
+	signatureAsset = "checksums.txt.sig"
+)
+
+// The base64 encoded ed25519 public key releases are signed with, which is set when building a
+// release with -ldflags "-X main.releasePublicKey=...". Builds without one can only be updated
+// by passing the key with --public-key.
+var releasePublicKey = ""
+
+// A release of the server, as returned by the GitHub API.
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// Returns the download URL of the named asset of the release.
+func (r *release) asset(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+
+	return "", fmt.Errorf("release %s has no %s asset", r.Tag, name)
+}
+
+// Replaces the running binary with the latest release, after verifying the signature of the
+// release checksums and the checksum of the downloaded binary. Nothing is replaced if either
+// of them does not match.
+func runUpdate(args []string) {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	url := fs.String("url", releasesURL, "the url of the release to update to, in the format returned by the GitHub API")
+	publicKey := fs.String("public-key", releasePublicKey, "the base64 encoded ed25519 public key releases are signed with")
+	check := fs.Bool("check", false, "only check if an update is available, without installing it")
+	force := fs.Bool("force", false, "install the release even if it is the version already running")
+	restart := fs.String("restart", "", "the name of the systemd service to restart once the update is installed")
+	fs.Parse(args)
+
+	if err := update(*url, *publicKey, *check, *force, *restart); err != nil {
+		fmt.Fprintf(os.Stderr, "could not update: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func update(url string, publicKey string, check bool, force bool, restart string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("a valid release public key is required to verify updates, pass one with --public-key")
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+
+	var r release
+	b, err := download(client, url)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		return fmt.Errorf("could not parse release: %w", err)
+	}
+
+	if r.Tag == version && !force {
+		fmt.Printf("already running the latest release, %s\n", version)
+		return nil
+	}
+
+	// Older releases are still validly signed, so one could be passed off as the latest release
+	// to downgrade the server to a version with known issues.
+	if older, ok := olderVersion(r.Tag, version); ok && older && !force {
+		return fmt.Errorf("release %s is older than the running version %s, pass --force to install it anyway", r.Tag, version)
+	}
+
+	if check {
+		fmt.Printf("release %s is available, currently running %s\n", r.Tag, version)
+		return nil
+	}
+
+	name := fmt.Sprintf("sftp-server_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	checksum, err := releaseChecksum(client, &r, key, name)
+	if err != nil {
+		return err
+	}
+
+	binaryURL, err := r.asset(name)
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return err
+	}
+
+	// The new binary is written next to the current one so that it can be renamed into place,
+	// which replaces the binary without there ever being a partially written one at its path.
+	tmp, err := ioutil.TempFile(filepath.Dir(executable), ".sftp-server-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	err = downloadTo(client, binaryURL, io.MultiWriter(tmp, h))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(h.Sum(nil), checksum) {
+		return fmt.Errorf("checksum of %s does not match the signed checksum", name)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if err := replaceExecutable(executable, tmp.Name()); err != nil {
+		return err
+	}
+
+	fmt.Printf("updated %s from %s to %s\n", executable, version, r.Tag)
+
+	if restart != "" {
+		if out, err := exec.Command("systemctl", "restart", restart).CombinedOutput(); err != nil {
+			return fmt.Errorf("could not restart %s: %s", restart, strings.TrimSpace(string(out)))
+		}
+
+		fmt.Printf("restarted %s\n", restart)
+	}
+
+	return nil
+}
+
+// Returns the checksum of the named binary from the checksums of the release, once the signature
+// of the checksums has been verified.
+func releaseChecksum(client *http.Client, r *release, key ed25519.PublicKey, name string) ([]byte, error) {
+	checksumsURL, err := r.asset(checksumsAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureURL, err := r.asset(signatureAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums, err := download(client, checksumsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := download(client, signatureURL)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := append([]byte(r.Tag+"\n"), checksums...)
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || !ed25519.Verify(key, signed, signature) {
+		return nil, fmt.Errorf("signature of the checksums for release %s is not valid", r.Tag)
+	}
+
+	// The checksums are in the format output by sha256sum, which is the hex encoded checksum
+	// followed by the name of the file.
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return hex.DecodeString(fields[0])
+		}
+	}
+
+	return nil, fmt.Errorf("release %s has no checksum for %s", r.Tag, name)
+}
+
+// Determines if the first version is older than the second, for versions in the format of the
+// release tags, such as v1.0.5. False is returned for the second value if either can't be
+// compared, such as for development builds.
+func olderVersion(a string, b string) (bool, bool) {
+	va, ok := parseVersion(a)
+	if !ok {
+		return false, false
+	}
+
+	vb, ok := parseVersion(b)
+	if !ok {
+		return false, false
+	}
+
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] < vb[i], true
+		}
+	}
+
+	return false, true
+}
+
+// Parses the major, minor and patch numbers of a version, ignoring any pre-release suffix.
+func parseVersion(v string) ([3]int, bool) {
+	var out [3]int
+
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i != -1 {
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) != len(out) {
+		return out, false
+	}
+
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return out, false
+		}
+		out[i] = n
+	}
+
+	return out, true
+}
+
+// Moves the new binary into place of the current one. Windows does not allow a running binary to
+// be replaced, but does allow it to be renamed, so it is moved out of the way first and left to
+// be removed by the next update.
+func replaceExecutable(executable string, replacement string) error {
+	if runtime.GOOS == "windows" {
+		old := executable + ".old"
+		os.Remove(old)
+
+		if err := os.Rename(executable, old); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(replacement, executable)
+}
+
+// Downloads the body of a URL into memory.
+func download(client *http.Client, url string) ([]byte, error) {
+	var b bytes.Buffer
+	if err := downloadTo(client, url, &b); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// Downloads the body of a URL, writing it to w.
+func downloadTo(client *http.Client, url string, w io.Writer) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "pterodactyl-sftp-server/"+version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d downloading %s", resp.StatusCode, url)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+
+	return err
+}